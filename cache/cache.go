@@ -5,9 +5,10 @@ import (
 	"cobranca-bmp/helpers"
 	"cobranca-bmp/models"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"log/slog"
 	"time"
@@ -19,24 +20,23 @@ type PropostaService interface {
 	UpdateAssync(data models.UpdateDbData) (bool, error)
 }
 
-type FileProducer interface {
-	Produce(path string, data any) error
-}
-
 type RedisCache struct {
 	appTerminated   bool
 	client          *redis.Client
 	ctx             context.Context
 	loc             *time.Location
 	logger          *slog.Logger
-	dbCh            string
-	sub             *redis.PubSub
-	dbMsgs          <-chan *redis.Message
-	fileProducer    FileProducer
+	stream          string
+	group           string
+	consumer        string
+	stop            chan struct{}
+	stopOnce        sync.Once
+	consuming       atomic.Bool
+	done            chan struct{}
 	propostaService PropostaService
 }
 
-func NewRedis(url string, logger *slog.Logger, ctx context.Context, loc *time.Location, propostaService PropostaService, dbCH string, fileProducer FileProducer) (*RedisCache, error) {
+func NewRedis(url string, logger *slog.Logger, ctx context.Context, loc *time.Location, propostaService PropostaService, stream string) (*RedisCache, error) {
 	opt, err := redis.ParseURL(url)
 	if err != nil {
 		return nil, err
@@ -54,27 +54,17 @@ func NewRedis(url string, logger *slog.Logger, ctx context.Context, loc *time.Lo
 		ctx:             ctx,
 		logger:          logger,
 		propostaService: propostaService,
-		dbCh:            dbCH,
-		loc:             loc,
-		fileProducer:    fileProducer}
+		stream:          stream,
+		group:           config.REDIS_DB_GROUP,
+		consumer:        config.REDIS_DB_CONSUMER,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+		loc:             loc}
 
 	return cache, nil
 
 }
 
-func (r *RedisCache) SetSubscriber() error {
-	sub := r.client.Subscribe(r.ctx, r.dbCh)
-	_, err := sub.Receive(r.ctx)
-	if err != nil {
-		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao configurar pubsub", err.Error(), nil)
-		return err
-	}
-	r.sub = sub
-	r.dbMsgs = sub.Channel()
-	return nil
-
-}
-
 func (r *RedisCache) SetToken(key, token string) error {
 
 	exp := time.Now().Add(config.REDIS_TOKEN_EXP)
@@ -241,66 +231,15 @@ func (r *RedisCache) GetTTL(key string) time.Duration {
 
 }
 
-func (r *RedisCache) Publish(payload models.UpdateDbData) error {
-	payloadBytes, err := json.Marshal(payload)
-	key := fmt.Sprintf("%v", payload)
-	if err != nil {
-		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao serializar mensagem para publicar no canal", err.Error(), payload)
-		return err
-	}
-	for try := range config.DB_UPDATE_MAX_RETRIES {
-		err = r.client.Publish(r.ctx, r.dbCh, payloadBytes).Err()
-		if err != nil {
-			message := fmt.Sprintf("Erro ao publicar no canal, tentativa %d", try+1)
-			helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", message, err.Error(), payload)
-
-		} else {
-
-			if err := r.SaveData(key, key, payload); err != nil {
-				return err
-			}
-			return nil
-		}
-	}
-	path := fmt.Sprintf("%s.json", key)
-	r.fileProducer.Produce(path, payload)
-	return err
-}
-
-func (r *RedisCache) Consume() {
-	for msg := range r.dbMsgs {
-		var payload models.UpdateDbData
-		if err := json.Unmarshal([]byte(msg.Payload), &payload); err != nil {
-			helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao desserializar payload", err.Error(), msg.Payload)
-		}
-		helpers.LogInfo(r.ctx, r.logger, r.loc, "redis", "", "Payload recebido no Redis", msg.Payload)
-
-		noConn, err := r.propostaService.UpdateAssync(payload)
-		if err != nil {
-			if noConn {
-				time.Sleep(config.DB_QUEUE_DELAY)
-				r.Publish(payload)
-			}
-		}
-		key := fmt.Sprintf("%v", payload)
-		err = r.client.Expire(r.ctx, key, 0).Err()
-		if err != nil {
-			helpers.LogError(r.ctx, r.logger, r.loc, "redis", key, "Erro ao deletar payload processado no Redis", err, msg.Payload)
-			continue
-		}
-
-	}
-
-}
-
 func (r *RedisCache) Check() (map[string]any, error) {
 	var info = make(map[string]any)
 	pingResult := r.client.Ping(r.ctx)
 	if pingResult.Val() != "PONG" {
 		info["erro"] = pingResult.Err().Error()
-	} else {
-		info["status"] = "ok"
+		return info, nil
 	}
+	info["status"] = "ok"
+	info["stream_atualizacao"] = r.streamBacklog()
 
 	return info, nil
 
@@ -310,23 +249,8 @@ func (r *RedisCache) GetServiceName() string {
 	return "redis"
 }
 
-func (r *RedisCache) ClosePubSub() error {
-	if r.sub != nil {
-		if err := r.sub.Close(); err != nil {
-			helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Falha ao fechar pubsub", err.Error(), nil)
-			return err
-		}
-	}
-	return nil
-}
-
 func (r *RedisCache) Close() error {
 	r.appTerminated = true
-	if err := r.ClosePubSub(); err != nil {
-		return err
-	}
-
-	helpers.LogInfo(r.ctx, r.logger, r.loc, "redis", "", "pubsub fechado", nil)
 
 	if err := r.client.Close(); err != nil {
 		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Falha ao fechar cliente", err.Error(), nil)
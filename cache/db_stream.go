@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Quantidade de atualizações lidas do stream por vez e tempo máximo de espera por novas entradas em cada leitura.
+const (
+	streamReadCount = 10
+	streamReadBlock = 2 * time.Second
+)
+
+// SetConsumerGroup cria o stream de atualizações e o grupo de consumo, caso ainda não existam.
+// Um grupo recém-criado começa do início do stream, então as atualizações gravadas antes da criação também são processadas.
+func (r *RedisCache) SetConsumerGroup() error {
+	err := r.client.XGroupCreateMkStream(r.ctx, r.stream, r.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao criar grupo de consumo do stream", err.Error(), map[string]string{"stream": r.stream, "grupo": r.group})
+		return err
+	}
+	return nil
+}
+
+// Publish grava a atualização no stream, fallback da fila de atualização quando o RabbitMQ está indisponível.
+func (r *RedisCache) Publish(payload models.UpdateDbData) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao serializar atualização para gravar no stream", err.Error(), payload)
+		return err
+	}
+
+	var args = &redis.XAddArgs{
+		Stream: r.stream,
+		Values: map[string]any{
+			"payload":           payloadBytes,
+			"action":            payload.Action,
+			"idPropostaParcela": payload.IdPropostaParcela,
+		},
+	}
+	for try := range config.DB_UPDATE_MAX_RETRIES {
+		var id string
+		id, err = r.client.XAdd(r.ctx, args).Result()
+		if err == nil {
+			helpers.LogInfo(r.ctx, r.logger, r.loc, "redis", "", "Atualização gravada no stream", map[string]any{"id": id, "stream": r.stream})
+			return nil
+		}
+		message := fmt.Sprintf("Erro ao gravar atualização no stream, tentativa %d", try+1)
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", message, err.Error(), payload)
+	}
+	return err
+}
+
+// Consume lê as atualizações do stream pelo grupo de consumo até StopConsuming ser chamado.
+// A cada REDIS_DB_CLAIM_IDLE as entradas pendentes há mais tempo que esse limite são reivindicadas, o que inclui as
+// entregues a uma instância que caiu antes de confirmá-las e as que falharam por falta de conexão com o banco.
+func (r *RedisCache) Consume() {
+	r.consuming.Store(true)
+	defer close(r.done)
+
+	var lastClaim time.Time
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.ctx.Done():
+			return
+		default:
+		}
+
+		if time.Since(lastClaim) >= config.REDIS_DB_CLAIM_IDLE {
+			r.reclaim()
+			lastClaim = time.Now()
+		}
+
+		streams, err := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
+			Group:    r.group,
+			Consumer: r.consumer,
+			Streams:  []string{r.stream, ">"},
+			Count:    streamReadCount,
+			Block:    streamReadBlock,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || r.ctx.Err() != nil {
+				continue
+			}
+			helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao ler atualizações do stream", err.Error(), map[string]string{"stream": r.stream})
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(config.DB_QUEUE_DELAY):
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				r.processEntry(msg)
+			}
+		}
+	}
+}
+
+// reclaim reivindica para este consumer as entradas pendentes há mais de REDIS_DB_CLAIM_IDLE e as processa.
+func (r *RedisCache) reclaim() {
+	var start = "0-0"
+	for {
+		messages, next, err := r.client.XAutoClaim(r.ctx, &redis.XAutoClaimArgs{
+			Stream:   r.stream,
+			Group:    r.group,
+			Consumer: r.consumer,
+			MinIdle:  config.REDIS_DB_CLAIM_IDLE,
+			Start:    start,
+			Count:    streamReadCount,
+		}).Result()
+		if err != nil {
+			if r.ctx.Err() == nil {
+				helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao reivindicar atualizações pendentes do stream", err.Error(), map[string]string{"stream": r.stream})
+			}
+			return
+		}
+		if len(messages) > 0 {
+			helpers.LogWarn(r.ctx, r.logger, r.loc, "redis", "", "Atualizações pendentes reivindicadas do stream", nil, map[string]any{"stream": r.stream, "quantidade": len(messages)})
+		}
+		for _, msg := range messages {
+			r.processEntry(msg)
+		}
+		if next == "0-0" || next == "" {
+			return
+		}
+		start = next
+	}
+}
+
+// processEntry executa a atualização e confirma a entrada. Sem conexão com o banco a entrada continua pendente e será
+// reivindicada após REDIS_DB_CLAIM_IDLE. Entradas malformadas ou com erro que não seja de conexão são descartadas.
+func (r *RedisCache) processEntry(msg redis.XMessage) {
+	raw, _ := msg.Values["payload"].(string)
+
+	var payload models.UpdateDbData
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", msg.ID, "Erro ao desserializar atualização do stream, entrada descartada", err.Error(), msg.Values)
+		r.ackEntry(msg.ID)
+		return
+	}
+	helpers.LogInfo(r.ctx, r.logger, r.loc, "redis", msg.ID, "Atualização recebida do stream", payload)
+
+	noConn, err := r.propostaService.UpdateAssync(payload)
+	if err != nil {
+		if noConn {
+			helpers.LogWarn(r.ctx, r.logger, r.loc, "redis", msg.ID, "Sem conexão com o banco, atualização continua pendente no stream", err.Error(), payload)
+			return
+		}
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", msg.ID, "Erro ao processar atualização do stream, entrada descartada", err.Error(), payload)
+	}
+	r.ackEntry(msg.ID)
+}
+
+// ackEntry confirma a entrada no grupo e a remove do stream, para que o tamanho do stream reflita o backlog.
+func (r *RedisCache) ackEntry(id string) {
+	_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(r.ctx, r.stream, r.group, id)
+		pipe.XDel(r.ctx, r.stream, id)
+		return nil
+	})
+	if err != nil {
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", id, "Erro ao confirmar atualização do stream", err.Error(), nil)
+	}
+}
+
+// StopConsuming interrompe a leitura do stream e aguarda a atualização em andamento terminar.
+func (r *RedisCache) StopConsuming(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	if !r.consuming.Load() {
+		return nil
+	}
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// streamBacklog retorna o tamanho do stream de atualizações e a situação do grupo de consumo, exibidos no health check do Redis.
+func (r *RedisCache) streamBacklog() map[string]any {
+	var info = map[string]any{
+		"stream": r.stream,
+		"grupo":  r.group,
+	}
+
+	length, err := r.client.XLen(r.ctx, r.stream).Result()
+	if err != nil {
+		info["erro"] = err.Error()
+		return info
+	}
+	info["mensagens"] = length
+
+	groups, err := r.client.XInfoGroups(r.ctx, r.stream).Result()
+	if err != nil {
+		if length > 0 {
+			info["erro"] = err.Error()
+		}
+		return info
+	}
+	for _, group := range groups {
+		if group.Name != r.group {
+			continue
+		}
+		info["pendentes"] = group.Pending
+		info["lag"] = group.Lag
+		info["consumers"] = group.Consumers
+	}
+	return info
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"cobranca-bmp/helpers"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLockOcupado é retornado quando o lock continua com outra operação após o tempo de espera.
+var ErrLockOcupado = errors.New("lock ocupado por outra operação")
+
+// Intervalo inicial e máximo entre as tentativas de obter um lock ocupado.
+const (
+	lockRetryInterval    = 50 * time.Millisecond
+	lockRetryMaxInterval = 500 * time.Millisecond
+)
+
+// Remove o lock apenas se ele ainda pertencer ao token informado. Um lock expirado e obtido por outra operação é mantido.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lock obtém o lock da chave(SET NX), aguardando até wait enquanto ele estiver com outra operação. O lock expira após ttl,
+// para não ficar preso caso a instância caia antes de liberá-lo. Retorna o token a ser informado em Unlock.
+func (r *RedisCache) Lock(key string, ttl, wait time.Duration) (string, error) {
+	var buf = make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	deadline := time.Now().Add(wait)
+	interval := lockRetryInterval
+	for {
+		ok, err := r.client.SetNX(r.ctx, key, token, ttl).Result()
+		if err != nil {
+			helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao obter lock", err.Error(), key)
+			return "", err
+		}
+		if ok {
+			return token, nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return "", ErrLockOcupado
+		}
+		time.Sleep(interval)
+		interval = min(interval*2, lockRetryMaxInterval)
+	}
+}
+
+// Unlock libera o lock obtido por Lock.
+func (r *RedisCache) Unlock(key, token string) error {
+	err := unlockScript.Run(r.ctx, r.client, []string{key}, token).Err()
+	if err != nil {
+		helpers.LogError(r.ctx, r.logger, r.loc, "redis", "", "Erro ao liberar lock", err.Error(), key)
+		return err
+	}
+	return nil
+}
@@ -4,15 +4,21 @@ import (
 	"cobranca-bmp/auth"
 	"cobranca-bmp/config"
 	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"cobranca-bmp/webhooksig"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"time"
 )
 
+// Tamanho máximo do trecho da resposta do webhook que será registrado.
+const webhookResponseExcerptSize = 1024
+
 type WebhookClient struct {
 	APIClient
 }
@@ -22,37 +28,82 @@ func NewWebhookClient(ctx context.Context, loc *time.Location, logger *slog.Logg
 
 }
 
-func (w *WebhookClient) RequestToWebhook(data any, url string) error {
-	req, err := w.newRequest(http.MethodPost, url, data)
+// RequestToWebhook envia os dados para o webhook e retorna o registro da tentativa(status, latência, hash do payload e trecho da resposta).
+// Com scheme vazio é utilizado o esquema de autenticação configurado para o destino.
+func (w *WebhookClient) RequestToWebhook(data any, url string, scheme string) (models.WebhookDelivery, error) {
+	var delivery = models.WebhookDelivery{Url: url}
+
+	body, err := w.serialize(data)
 	if err != nil {
-		return err
+		delivery.Erro = err.Error()
+		return delivery, err
 	}
-	key, err := auth.EncryptHash(config.WEBHOOK_HASH, config.WEBHOOK_KEY)
+	hash := sha256.Sum256(body)
+	delivery.Payload = body
+	delivery.PayloadHash = hex.EncodeToString(hash[:])
+
+	req, err := w.newRequest(http.MethodPost, url, json.RawMessage(body))
 	if err != nil {
-		helpers.LogError(w.ctx, w.logger, w.loc, "webhook client", "", "Erro ao criptografar apikey", err.Error(), data)
-		return err
+		delivery.Erro = err.Error()
+		return delivery, err
+	}
+	if err := w.authenticate(req, url, scheme, body); err != nil {
+		helpers.LogError(w.ctx, w.logger, w.loc, "webhook client", "", "Erro ao autenticar requisição para o webhook", err.Error(), data)
+		delivery.Erro = err.Error()
+		return delivery, err
 	}
-	req.Header.Set("X-API-Key", key)
 	req.Header.Set("Content-Type", "application/json")
 
+	start := time.Now()
 	resp, err := w.doRequest(req)
+	delivery.LatenciaMs = time.Since(start).Milliseconds()
 	if err != nil {
-		return err
+		delivery.Erro = err.Error()
+		return delivery, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		var response json.RawMessage
-		response, _ = io.ReadAll(resp.Body)
-		err := fmt.Errorf("Erro ao requisitar para o webhook, status code %d", resp.StatusCode)
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseExcerptSize))
+	delivery.StatusCode = resp.StatusCode
+	delivery.Resposta = string(response)
+
+	//Qualquer resposta 2xx(200, 201, 202, 204...) confirma o recebimento do webhook
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := models.WebhookError{StatusCode: resp.StatusCode, Url: url, Response: string(response)}
 		helpers.LogError(w.ctx, w.logger, w.loc, "webhook client", resp.Status, err.Error(), err.Error(), map[string]any{
 			"header":   req.Header,
 			"body":     data,
 			"response": string(response),
 		})
-		return err
+		delivery.Erro = err.Error()
+		return delivery, err
 	}
 
-	return nil
+	return delivery, nil
 
 }
+
+// Adiciona os headers de autenticação de acordo com o esquema configurado para o destino.
+// No esquema hmac o body é assinado com a chave primária; no esquema legado é enviado o X-API-Key criptografado.
+func (w *WebhookClient) authenticate(req *http.Request, url string, scheme string, body []byte) error {
+	if scheme == "" {
+		scheme = config.GetWebhookAuthScheme(url)
+	}
+
+	switch scheme {
+	case config.WEBHOOK_AUTH_HMAC:
+		keyId, secret, err := config.GetPrimaryWebhookSigningKey()
+		if err != nil {
+			return err
+		}
+		webhooksig.SetHeaders(req.Header, keyId, secret, body, time.Now())
+
+	default:
+		key, err := auth.EncryptHash(config.WEBHOOK_HASH, config.WEBHOOK_KEY)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-API-Key", key)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 
@@ -24,6 +25,8 @@ type EnvVars struct {
 	RateLimitDelay         int           `json:"rateLimitDelay"`          //Delay entre uma tentativa e outra na fila,em caso de rate limit
 	WebhookRetries         int           `json:"webhookRetries"`          //Número de tentativas em caso de falha no webhook
 	WebhookDelay           int           `json:"webhookDelay"`            //Delay entre uma tentativa e outra na fila,em caso de falha no webhook
+	WebhookMaxDelay        int           `json:"webhookMaxDelay"`         //Delay máximo(em segundos) do backoff exponencial dos webhooks
+	WebhookMaxAge          int           `json:"webhookMaxAge"`           //Tempo máximo(em segundos) de retentativas de um webhook antes do envio para a DLQ
 	WebhookKey             string        `json:"webhookKey"`              //Chave de autenticação do webhook do Confiapay
 	WebhookHash            string        `json:"webhookHash"`             //Hash passado no webhook do Confiapay
 	WebhookUrl             string        `json:"webhookUrl"`              //URL do webhook do Confiapay
@@ -66,6 +69,8 @@ var (
 	DB_QUERY_TIMEOUT                  time.Duration
 	DB_POOL_MONITORING_MAX_ALERTS     int
 	DB_POOL_MONITORING_ALERT_INTERVAL time.Duration
+	DB_MIGRATE_ON_STARTUP             bool
+	DB_MIGRATION_TIMEOUT              time.Duration
 )
 
 var (
@@ -85,6 +90,32 @@ var (
 	GERAR_MULTIPLAS_COBRANCAS = true
 )
 
+// Tipos de evento que podem ser assinados pelos convênios
+var (
+	WEBHOOK_EVENTO_COBRANCA_REGISTRADA = "cobranca.registrada"
+	WEBHOOK_EVENTO_COBRANCA_CANCELADA  = "cobranca.cancelada"
+	WEBHOOK_EVENTO_PARCELA_LANCAMENTO  = "parcela.lancamento"
+	WEBHOOK_EVENTO_COBRANCA_ERRO       = "cobranca.erro"
+)
+
+// Versões do schema dos payloads de webhook(docs/webhook-events.schema.json)
+const (
+	WEBHOOK_SCHEMA_VERSION_LEGADA = 1 //Payload sem envelope
+	WEBHOOK_SCHEMA_VERSION_ATUAL  = 2 //Envelope com dados tipados
+)
+
+const (
+	QUEUE_BACKEND_RABBITMQ = "rabbitmq"
+	QUEUE_BACKEND_MEMORY   = "memory"
+)
+
+// Prioridades das mensagens das filas de cobrança e consulta. Só têm efeito com RABBITMQ_MAX_PRIORITY maior que zero.
+const (
+	MESSAGE_PRIORITY_BAIXA  uint8 = 1 //Reentregas
+	MESSAGE_PRIORITY_NORMAL uint8 = 5 //Primeira tentativa de cancelamento e lançamento
+	MESSAGE_PRIORITY_ALTA   uint8 = 9 //Primeira tentativa de geração e de consulta, aguardadas pelo cliente
+)
+
 var (
 	RABBITMQ_URL           string
 	RABBITMQ_QOS           int
@@ -92,6 +123,19 @@ var (
 	RABBITMQ_GLOBAL_QOS    bool
 	WEBHOOK_QUEUE          string
 
+	RABBITMQ_CONFIRM_TIMEOUT time.Duration
+	RABBITMQ_SPOOL_PATH      string
+	RABBITMQ_RETRY_TOPOLOGY  string
+	RABBITMQ_RETRY_TIERS     []time.Duration
+	RABBITMQ_MAX_PRIORITY    int
+
+	SHUTDOWN_TIMEOUT time.Duration
+
+	QUEUE_BACKEND string
+
+	POISON_MAX_DELIVERIES int
+	POISON_WINDOW         time.Duration
+
 	DB_QUEUE     string
 	BMP_EXCHANGE string
 	DLQ_EXCHANGE string
@@ -109,17 +153,38 @@ var (
 	DB_UPDATE_MAX_RETRIES  int64
 	BASE_URL               string
 
-	AUTH_URL   string
-	REDIS_URL  string
-	REDIS_DBCH string
-	API_KEY    string
-
-	WEBHOOK_KEY           string
-	WEBHOOK_HASH          string
-	WEBHOOK_RETRIES       int64
-	WEBHOOK_DELAY         time.Duration
-	WEBHOOK_DIGITACAO_URL string
-	WEBHOOK_LIBERACAO_URL string
+	AUTH_URL  string
+	REDIS_URL string
+	API_KEY   string
+
+	REDIS_DB_STREAM     string
+	REDIS_DB_GROUP      string
+	REDIS_DB_CONSUMER   string
+	REDIS_DB_CLAIM_IDLE time.Duration
+
+	PARCELA_LOCK_TTL  time.Duration
+	PARCELA_LOCK_WAIT time.Duration
+
+	OUTBOX_POLL_INTERVAL time.Duration
+	OUTBOX_BATCH_SIZE    int
+	OUTBOX_LEASE         time.Duration
+	OUTBOX_RETRY_DELAY   time.Duration
+	OUTBOX_RETENTION     time.Duration
+
+	RECONCILIACAO_INTERVAL   time.Duration
+	RECONCILIACAO_BATCH_SIZE int
+	RECONCILIACAO_RATE_LIMIT int
+	RECONCILIACAO_CORRIGIR   bool
+
+	WEBHOOK_KEY            string
+	WEBHOOK_HASH           string
+	WEBHOOK_RETRIES        int64
+	WEBHOOK_DELAY          time.Duration
+	WEBHOOK_MAX_DELAY      time.Duration
+	WEBHOOK_MAX_AGE        time.Duration
+	WEBHOOK_SCHEMA_VERSION int
+	WEBHOOK_DIGITACAO_URL  string
+	WEBHOOK_LIBERACAO_URL  string
 )
 
 // Status de erro padrão JRPC
@@ -157,7 +222,6 @@ func InitializeEnvVar() error {
 	AUTH_URL = getEnv("AUTH_URL")
 	RABBITMQ_URL = getEnv("RABBITMQ_URL")
 	REDIS_URL = getEnv("REDIS_URL")
-	REDIS_DBCH = getEnv("REDIS_DBCH")
 	API_KEY = getEnv("API_KEY")
 	WEBHOOK_KEY = getEnv("WEBHOOK_KEY")
 	WEBHOOK_HASH = getEnv("WEBHOOK_HASH")
@@ -236,6 +300,41 @@ func InitializeEnvVar() error {
 	}
 	WEBHOOK_DELAY = time.Duration(delay) * time.Second
 
+	delay, err = strconv.ParseInt(getEnvOrDefault("WEBHOOK_MAX_DELAY", "3600"), 10, 64)
+	if err != nil {
+		return err
+	}
+	WEBHOOK_MAX_DELAY = time.Duration(delay) * time.Second
+
+	delay, err = strconv.ParseInt(getEnvOrDefault("WEBHOOK_MAX_AGE", "86400"), 10, 64)
+	if err != nil {
+		return err
+	}
+	WEBHOOK_MAX_AGE = time.Duration(delay) * time.Second
+
+	//Versão do schema enviada aos destinos que não fixaram uma versão na assinatura
+	WEBHOOK_SCHEMA_VERSION, err = strconv.Atoi(getEnvOrDefault("WEBHOOK_SCHEMA_VERSION", strconv.Itoa(WEBHOOK_SCHEMA_VERSION_LEGADA)))
+	if err != nil {
+		return err
+	}
+
+	if err := loadWebhookRetryPolicies(getEnvOrDefault("WEBHOOK_RETRY_POLICIES", "")); err != nil {
+		return err
+	}
+
+	if err := loadWebhookSigning(
+		getEnvOrDefault("WEBHOOK_AUTH_SCHEME", WEBHOOK_AUTH_LEGACY),
+		getEnvOrDefault("WEBHOOK_SIGNING_KEYS", ""),
+		getEnvOrDefault("WEBHOOK_SIGNING_KEY_ID", ""),
+		getEnvOrDefault("WEBHOOK_AUTH_SCHEMES", ""),
+	); err != nil {
+		return err
+	}
+
+	if err := loadDLQOperadores(getEnvOrDefault("DLQ_OPERADORES", "")); err != nil {
+		return err
+	}
+
 	WEBHOOK_DIGITACAO_URL = getEnv("WEBHOOK_URL")
 
 	DB_URL = fmt.Sprintf("host=%s port=%d user=%s "+
@@ -269,6 +368,19 @@ func InitializeEnvVar() error {
 	}
 	DB_QUERY_TIMEOUT = time.Duration(delay) * time.Second
 
+	//Aplica as migrations pendentes na inicialização. Com false, o schema é atualizado apenas pelo subcomando migrate
+	DB_MIGRATE_ON_STARTUP, err = strconv.ParseBool(getEnvOrDefault("DB_MIGRATE_ON_STARTUP", "true"))
+	if err != nil {
+		return err
+	}
+
+	//Prazo para aplicar as migrations, incluindo a espera pelo lock de outra instância
+	delay, err = strconv.ParseInt(getEnvOrDefault("DB_MIGRATION_TIMEOUT", "300"), 10, 64)
+	if err != nil {
+		return err
+	}
+	DB_MIGRATION_TIMEOUT = time.Duration(delay) * time.Second
+
 	delay, err = strconv.ParseInt(getEnv("DB_POOL_MONITORING_INTERVAL"), 10, 64)
 	if err != nil {
 		return err
@@ -299,6 +411,174 @@ func InitializeEnvVar() error {
 	}
 	RABBITMQ_SIMULACAO_QOS = int(simulacaooQos)
 
+	//Workers e prefetch por fila
+	if err := loadConsumerPoolConfigs(getEnvOrDefault("RABBITMQ_CONSUMERS", "")); err != nil {
+		return err
+	}
+
+	//Tempo máximo de espera pela confirmação(ack/nack) do broker ao publicar uma mensagem
+	delay, err = strconv.ParseInt(getEnvOrDefault("RABBITMQ_CONFIRM_TIMEOUT", "5"), 10, 64)
+	if err != nil {
+		return err
+	}
+	RABBITMQ_CONFIRM_TIMEOUT = time.Duration(delay) * time.Second
+
+	//Arquivo onde são gravadas as mensagens que não puderam ser publicadas nem na DLQ
+	RABBITMQ_SPOOL_PATH = getEnvOrDefault("RABBITMQ_SPOOL_PATH", "spool/rabbitmq.jsonl")
+
+	//Topologia utilizada nas mensagens com delay: delayed(plugin x-delayed-message) ou ttl(filas de espera por nível)
+	RABBITMQ_RETRY_TOPOLOGY = getEnvOrDefault("RABBITMQ_RETRY_TOPOLOGY", RETRY_TOPOLOGY_DELAYED)
+	if RABBITMQ_RETRY_TOPOLOGY != RETRY_TOPOLOGY_DELAYED && RABBITMQ_RETRY_TOPOLOGY != RETRY_TOPOLOGY_TTL {
+		return fmt.Errorf("RABBITMQ_RETRY_TOPOLOGY inválido: %s", RABBITMQ_RETRY_TOPOLOGY)
+	}
+
+	//Níveis das filas de espera, em segundos. O delay de cada mensagem é arredondado para o nível mais próximo
+	RABBITMQ_RETRY_TIERS, err = loadRetryTiers(getEnvOrDefault("RABBITMQ_RETRY_TIERS", "1,5,15,30,60,300,900,3600"))
+	if err != nil {
+		return err
+	}
+
+	//Prioridade máxima(x-max-priority) das filas de cobrança e consulta. Zero desativa a prioridade.
+	//Os argumentos de uma fila existente não podem ser alterados, então ao ativar ou alterar o valor as filas precisam ser recriadas
+	maxPriority, err := strconv.ParseInt(getEnvOrDefault("RABBITMQ_MAX_PRIORITY", "0"), 10, 64)
+	if err != nil {
+		return err
+	}
+	if maxPriority < 0 || maxPriority > 255 {
+		return fmt.Errorf("RABBITMQ_MAX_PRIORITY deve estar entre 0 e 255: %d", maxPriority)
+	}
+	RABBITMQ_MAX_PRIORITY = int(maxPriority)
+
+	//Backend de filas: rabbitmq ou memory(modo local, sem broker e sem persistência das mensagens)
+	QUEUE_BACKEND = getEnvOrDefault("QUEUE_BACKEND", QUEUE_BACKEND_RABBITMQ)
+	if QUEUE_BACKEND != QUEUE_BACKEND_RABBITMQ && QUEUE_BACKEND != QUEUE_BACKEND_MEMORY {
+		return fmt.Errorf("QUEUE_BACKEND inválido: %s", QUEUE_BACKEND)
+	}
+
+	//Entregas da mesma mensagem(message id) a partir das quais ela é considerada venenosa e enviada para a quarentena
+	poisonMax, err := strconv.ParseInt(getEnvOrDefault("POISON_MAX_DELIVERIES", "5"), 10, 64)
+	if err != nil {
+		return err
+	}
+	POISON_MAX_DELIVERIES = int(poisonMax)
+
+	//Tempo em que as entregas de uma mensagem são contadas
+	delay, err = strconv.ParseInt(getEnvOrDefault("POISON_WINDOW", "3600"), 10, 64)
+	if err != nil {
+		return err
+	}
+	POISON_WINDOW = time.Duration(delay) * time.Second
+
+	//Stream do Redis utilizado como fallback da fila de atualização, consumido pelo grupo REDIS_DB_GROUP
+	REDIS_DB_STREAM = getEnvOrDefault("REDIS_DB_STREAM", "bmp_db_stream")
+	REDIS_DB_GROUP = getEnvOrDefault("REDIS_DB_GROUP", "cobranca-bmp")
+
+	//Nome do consumer no grupo, deve ser único por instância. Por padrão é o hostname
+	hostname, _ := os.Hostname()
+	REDIS_DB_CONSUMER = getEnvOrDefault("REDIS_DB_CONSUMER", hostname)
+	if REDIS_DB_CONSUMER == "" {
+		return fmt.Errorf("REDIS_DB_CONSUMER não informado")
+	}
+
+	//Tempo sem confirmação após o qual uma atualização pendente de outro consumer(ex: instância que caiu) é reivindicada
+	delay, err = strconv.ParseInt(getEnvOrDefault("REDIS_DB_CLAIM_IDLE", "60"), 10, 64)
+	if err != nil {
+		return err
+	}
+	REDIS_DB_CLAIM_IDLE = time.Duration(delay) * time.Second
+
+	//Tempo máximo em que uma operação mantém o lock da parcela. Deve ser maior que a duração de uma chamada ao BMP
+	delay, err = strconv.ParseInt(getEnvOrDefault("PARCELA_LOCK_TTL", "120"), 10, 64)
+	if err != nil {
+		return err
+	}
+	PARCELA_LOCK_TTL = time.Duration(delay) * time.Second
+
+	//Tempo de espera pelo lock da parcela ocupado por outra operação, antes de a operação ser rejeitada ou reagendada
+	delay, err = strconv.ParseInt(getEnvOrDefault("PARCELA_LOCK_WAIT", "5"), 10, 64)
+	if err != nil {
+		return err
+	}
+	PARCELA_LOCK_WAIT = time.Duration(delay) * time.Second
+
+	//Intervalo entre as leituras das mensagens pendentes do outbox
+	delay, err = strconv.ParseInt(getEnvOrDefault("OUTBOX_POLL_INTERVAL", "1"), 10, 64)
+	if err != nil {
+		return err
+	}
+	OUTBOX_POLL_INTERVAL = time.Duration(delay) * time.Second
+
+	//Quantidade máxima de mensagens do outbox publicadas por leitura
+	batchSize, err := strconv.ParseInt(getEnvOrDefault("OUTBOX_BATCH_SIZE", "100"), 10, 64)
+	if err != nil {
+		return err
+	}
+	if batchSize <= 0 {
+		return fmt.Errorf("OUTBOX_BATCH_SIZE deve ser maior que zero: %d", batchSize)
+	}
+	OUTBOX_BATCH_SIZE = int(batchSize)
+
+	//Tempo em que uma mensagem lida fica reservada para a instância. Sem confirmação nesse prazo, ela é publicada novamente
+	delay, err = strconv.ParseInt(getEnvOrDefault("OUTBOX_LEASE", "60"), 10, 64)
+	if err != nil {
+		return err
+	}
+	OUTBOX_LEASE = time.Duration(delay) * time.Second
+
+	//Delay inicial entre as tentativas de publicação de uma mensagem do outbox, dobrado a cada falha
+	delay, err = strconv.ParseInt(getEnvOrDefault("OUTBOX_RETRY_DELAY", "5"), 10, 64)
+	if err != nil {
+		return err
+	}
+	OUTBOX_RETRY_DELAY = time.Duration(delay) * time.Second
+
+	//Tempo em que as mensagens já publicadas são mantidas no outbox
+	delay, err = strconv.ParseInt(getEnvOrDefault("OUTBOX_RETENTION", "604800"), 10, 64)
+	if err != nil {
+		return err
+	}
+	OUTBOX_RETENTION = time.Duration(delay) * time.Second
+
+	//Intervalo entre as execuções agendadas da reconciliação das cobranças em aberto com o BMP. 0 desativa o agendamento
+	delay, err = strconv.ParseInt(getEnvOrDefault("RECONCILIACAO_INTERVAL", "3600"), 10, 64)
+	if err != nil {
+		return err
+	}
+	RECONCILIACAO_INTERVAL = time.Duration(delay) * time.Second
+
+	//Quantidade de parcelas lidas por vez na reconciliação
+	batchSize, err = strconv.ParseInt(getEnvOrDefault("RECONCILIACAO_BATCH_SIZE", "100"), 10, 64)
+	if err != nil {
+		return err
+	}
+	if batchSize <= 0 {
+		return fmt.Errorf("RECONCILIACAO_BATCH_SIZE deve ser maior que zero: %d", batchSize)
+	}
+	RECONCILIACAO_BATCH_SIZE = int(batchSize)
+
+	//Quantidade máxima de consultas ao BMP por segundo na reconciliação
+	rateLimit, err := strconv.ParseInt(getEnvOrDefault("RECONCILIACAO_RATE_LIMIT", "2"), 10, 64)
+	if err != nil {
+		return err
+	}
+	if rateLimit <= 0 {
+		return fmt.Errorf("RECONCILIACAO_RATE_LIMIT deve ser maior que zero: %d", rateLimit)
+	}
+	RECONCILIACAO_RATE_LIMIT = int(rateLimit)
+
+	//Corrige a base local e emite os webhooks não enviados. Se false, a reconciliação apenas registra as divergências
+	RECONCILIACAO_CORRIGIR, err = strconv.ParseBool(getEnvOrDefault("RECONCILIACAO_CORRIGIR", "true"))
+	if err != nil {
+		return err
+	}
+
+	//Prazo para o trabalho em andamento terminar no encerramento do serviço
+	delay, err = strconv.ParseInt(getEnvOrDefault("SHUTDOWN_TIMEOUT", "30"), 10, 64)
+	if err != nil {
+		return err
+	}
+	SHUTDOWN_TIMEOUT = time.Duration(delay) * time.Second
+
 	if HOMOLOG_LOCAL {
 		setLocalHomologVars()
 	}
@@ -342,7 +622,7 @@ func SetEnvVars(env EnvVars) map[string]any {
 	}
 
 	if env.WebhookDelay > 0 {
-		WEBHOOK_DELAY = time.Duration(env.WebhookDelay)
+		WEBHOOK_DELAY = time.Duration(env.WebhookDelay) * time.Second
 		changed["WebhookDelay"] = WEBHOOK_DELAY.String()
 	}
 
@@ -351,6 +631,16 @@ func SetEnvVars(env EnvVars) map[string]any {
 		changed["WebhookRetries"] = WEBHOOK_RETRIES
 	}
 
+	if env.WebhookMaxDelay > 0 {
+		WEBHOOK_MAX_DELAY = time.Duration(env.WebhookMaxDelay) * time.Second
+		changed["WebhookMaxDelay"] = WEBHOOK_MAX_DELAY.String()
+	}
+
+	if env.WebhookMaxAge > 0 {
+		WEBHOOK_MAX_AGE = time.Duration(env.WebhookMaxAge) * time.Second
+		changed["WebhookMaxAge"] = WEBHOOK_MAX_AGE.String()
+	}
+
 	if env.DbMonitoringInterval != 0 {
 		DB_POOL_MONITORING_INTERVAL = time.Duration(env.DbMonitoringInterval) * time.Second
 		changed["DbMonitoringInterval"] = DB_POOL_MONITORING_INTERVAL.String()
@@ -3,7 +3,7 @@ package config
 import (
 	//"time"
 
-	_ "cobranca-bmp/docs"
+	"cobranca-bmp/docs"
 	"cobranca-bmp/helpers"
 	"context"
 	"encoding/json"
@@ -73,6 +73,10 @@ func configSwagger(app *fiber.App) {
 	//FilePath: "./docs/swagger.json",
 	//URL: "/docs/swagger.json",
 	//}
+	app.Get("/docs/webhook-events.schema.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "application/schema+json")
+		return c.Send(docs.WebhookEventsSchema)
+	})
 	app.Get("/docs/*", swagger.HandlerDefault)
 
 	//app.Use(swagger.New(cfg))
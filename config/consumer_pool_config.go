@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Nomes dos consumers, utilizados na configuração dos workers e do prefetch de cada fila.
+const (
+	CONSUMER_COBRANCA = "cobranca"
+	CONSUMER_CONSULTA = "consulta"
+	CONSUMER_WEBHOOK  = "webhook"
+	CONSUMER_DB       = "db"
+	CONSUMER_DLQ      = "dlq"
+)
+
+// Representa a configuração de consumo de uma fila.
+// Campos zerados assumem 1 worker e o prefetch global(RABBITMQ_DIGITACAO_QOS).
+type ConsumerPoolConfig struct {
+	Workers  int `json:"workers"`  //Quantidade de workers processando mensagens da fila em paralelo
+	Prefetch int `json:"prefetch"` //Quantidade máxima de mensagens entregues e ainda não confirmadas no canal(QoS)
+}
+
+// Configurações lidas de RABBITMQ_CONSUMERS. Os valores alterados em tempo de execução ficam nos consumers do RabbitMQ.
+var consumerPoolConfigs = map[string]ConsumerPoolConfig{}
+
+// GetConsumerPoolConfig retorna a configuração inicial do consumer, preenchendo os campos não informados com os valores padrão.
+func GetConsumerPoolConfig(consumer string) ConsumerPoolConfig {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	cfg := consumerPoolConfigs[consumer]
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = RABBITMQ_QOS
+	}
+	return cfg
+}
+
+// Carrega as configurações a partir de um json no formato {"cobranca": {"workers": 4, "prefetch": 8}, ...}.
+func loadConsumerPoolConfigs(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	var configs map[string]ConsumerPoolConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return err
+	}
+	for consumer := range configs {
+		switch consumer {
+		case CONSUMER_COBRANCA, CONSUMER_CONSULTA, CONSUMER_WEBHOOK, CONSUMER_DB, CONSUMER_DLQ:
+		default:
+			return fmt.Errorf("consumer inválido em RABBITMQ_CONSUMERS: %s", consumer)
+		}
+	}
+	consumerPoolConfigs = configs
+	return nil
+}
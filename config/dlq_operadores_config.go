@@ -0,0 +1,42 @@
+package config
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+// Operadores autorizados a reprocessar mensagens da DLQ, por usuário e chave de API. O usuário registrado na auditoria
+// do reprocessamento é o da chave enviada no header Api-Key, e não um valor informado no body.
+var dlqOperadores = map[string]string{}
+
+// GetDLQOperador retorna o usuário da chave de API informada, caso ela pertença a um operador da DLQ.
+func GetDLQOperador(apiKey string) (string, bool) {
+	if apiKey == "" {
+		return "", false
+	}
+	for usuario, chave := range dlqOperadores {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(chave)) == 1 {
+			return usuario, true
+		}
+	}
+	return "", false
+}
+
+// Carrega os operadores da DLQ no formato "usuario1:chave1,usuario2:chave2".
+func loadDLQOperadores(operadores string) error {
+	var carregados = make(map[string]string)
+	for _, pair := range strings.Split(operadores, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		usuario, chave, ok := strings.Cut(pair, ":")
+		if !ok || usuario == "" || chave == "" {
+			return errors.New("operador da DLQ inválido em DLQ_OPERADORES, formato esperado usuario:chave")
+		}
+		carregados[usuario] = chave
+	}
+	dlqOperadores = carregados
+	return nil
+}
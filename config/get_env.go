@@ -13,3 +13,12 @@ func getEnv(name string) string {
 	}
 	return envVar
 }
+
+// Retorna o valor da variável de ambiente ou defaultValue, caso não tenha sido definida.
+func getEnvOrDefault(name, defaultValue string) string {
+	envVar := os.Getenv(name)
+	if envVar == "" {
+		return defaultValue
+	}
+	return envVar
+}
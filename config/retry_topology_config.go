@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Topologias de reentrega com delay.
+const (
+	RETRY_TOPOLOGY_DELAYED = "delayed" //Exchange x-delayed-message, requer o plugin rabbitmq_delayed_message_exchange
+	RETRY_TOPOLOGY_TTL     = "ttl"     //Filas de espera com TTL e dead-letter de volta para o exchange das filas, sem plugin
+)
+
+// loadRetryTiers converte a lista de segundos de RABBITMQ_RETRY_TIERS(ex.: "5,30,60") em durações ordenadas e sem repetição.
+func loadRetryTiers(raw string) ([]time.Duration, error) {
+	var tiers = make([]time.Duration, 0)
+	for _, value := range strings.Split(raw, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("RABBITMQ_RETRY_TIERS inválido: %s", value)
+		}
+		tiers = append(tiers, time.Duration(seconds)*time.Second)
+	}
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("RABBITMQ_RETRY_TIERS deve ter ao menos um nível")
+	}
+	slices.Sort(tiers)
+	return slices.Compact(tiers), nil
+}
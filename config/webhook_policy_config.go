@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var webhookPoliciesMu sync.RWMutex
+
+// Políticas de retentativa por destino(host ou URL completa do webhook).
+var webhookRetryPolicies = map[string]WebhookRetryPolicy{}
+
+// Representa a política de retentativas na entrega de webhooks para um destino.
+// Campos zerados assumem os valores globais(WEBHOOK_RETRIES, WEBHOOK_DELAY, WEBHOOK_MAX_DELAY e WEBHOOK_MAX_AGE).
+type WebhookRetryPolicy struct {
+	MaxRetries int64 `json:"maxRetries"` //Número máximo de tentativas de entrega
+	BaseDelay  int   `json:"baseDelay"`  //Delay base(em segundos) do backoff exponencial
+	MaxDelay   int   `json:"maxDelay"`   //Delay máximo(em segundos) entre uma tentativa e outra
+	MaxAge     int   `json:"maxAge"`     //Tempo máximo(em segundos) desde a primeira tentativa até o envio para a DLQ
+}
+
+// Representa a política de retentativas de um destino enviada via API.
+type APIWebhookRetryPolicy struct {
+	Destino string `json:"destino"` //Host ou URL completa do webhook
+	WebhookRetryPolicy
+}
+
+// DefaultWebhookRetryPolicy retorna a política global de retentativas de webhooks.
+func DefaultWebhookRetryPolicy() WebhookRetryPolicy {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return WebhookRetryPolicy{
+		MaxRetries: WEBHOOK_RETRIES,
+		BaseDelay:  int(WEBHOOK_DELAY.Seconds()),
+		MaxDelay:   int(WEBHOOK_MAX_DELAY.Seconds()),
+		MaxAge:     int(WEBHOOK_MAX_AGE.Seconds()),
+	}
+}
+
+// GetWebhookRetryPolicy retorna a política de retentativas do destino.
+// A busca é feita pela URL completa e, caso não exista, pelo host. Não havendo política para o destino, retorna a global.
+func GetWebhookRetryPolicy(rawUrl string) WebhookRetryPolicy {
+	var defaultPolicy = DefaultWebhookRetryPolicy()
+
+	webhookPoliciesMu.RLock()
+	defer webhookPoliciesMu.RUnlock()
+
+	policy, ok := lookupDestino(webhookRetryPolicies, rawUrl)
+	if !ok {
+		return defaultPolicy
+	}
+
+	return policy.merge(defaultPolicy)
+}
+
+// Busca a configuração de um destino pela URL completa e, caso não exista, pelo host.
+func lookupDestino[T any](configs map[string]T, rawUrl string) (T, bool) {
+	if value, ok := configs[rawUrl]; ok {
+		return value, true
+	}
+	var zero T
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return zero, false
+	}
+	value, ok := configs[parsed.Host]
+	return value, ok
+}
+
+// SetWebhookRetryPolicy define a política de retentativas de um destino(host ou URL completa).
+func SetWebhookRetryPolicy(destino string, policy WebhookRetryPolicy) {
+	webhookPoliciesMu.Lock()
+	defer webhookPoliciesMu.Unlock()
+	webhookRetryPolicies[destino] = policy
+}
+
+// DeleteWebhookRetryPolicy remove a política de um destino, que voltará a utilizar a política global.
+func DeleteWebhookRetryPolicy(destino string) {
+	webhookPoliciesMu.Lock()
+	defer webhookPoliciesMu.Unlock()
+	delete(webhookRetryPolicies, destino)
+}
+
+// GetWebhookRetryPolicies retorna uma cópia das políticas configuradas por destino.
+func GetWebhookRetryPolicies() map[string]WebhookRetryPolicy {
+	webhookPoliciesMu.RLock()
+	defer webhookPoliciesMu.RUnlock()
+	var policies = make(map[string]WebhookRetryPolicy, len(webhookRetryPolicies))
+	for destino, policy := range webhookRetryPolicies {
+		policies[destino] = policy
+	}
+	return policies
+}
+
+// Carrega as políticas por destino a partir de um json no formato {"destino": {"maxRetries": 5, ...}}.
+func loadWebhookRetryPolicies(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	var policies map[string]WebhookRetryPolicy
+	if err := json.Unmarshal([]byte(raw), &policies); err != nil {
+		return err
+	}
+	for destino, policy := range policies {
+		SetWebhookRetryPolicy(destino, policy)
+	}
+	return nil
+}
+
+// Preenche os campos não informados com os valores da política padrão.
+func (p WebhookRetryPolicy) merge(defaultPolicy WebhookRetryPolicy) WebhookRetryPolicy {
+	if p.MaxRetries <= 0 {
+		p.MaxRetries = defaultPolicy.MaxRetries
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultPolicy.MaxDelay
+	}
+	if p.MaxAge <= 0 {
+		p.MaxAge = defaultPolicy.MaxAge
+	}
+	return p
+}
+
+// Backoff retorna o delay até a próxima tentativa: BaseDelay*2^(attempt-1), limitado a MaxDelay, com jitter de até 20%.
+func (p WebhookRetryPolicy) Backoff(attempt int64) time.Duration {
+	base := time.Duration(p.BaseDelay) * time.Second
+	maxDelay := time.Duration(p.MaxDelay) * time.Second
+	if base <= 0 {
+		base = time.Second
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := base
+	for i := int64(1); i < attempt; i++ {
+		delay *= 2
+		if maxDelay > 0 && delay >= maxDelay {
+			delay = maxDelay
+			break
+		}
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	jitter := time.Duration(rand.Int64N(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+// Exhausted informa se a entrega atingiu o número máximo de tentativas ou a idade máxima.
+func (p WebhookRetryPolicy) Exhausted(attempts int64, firstAttempt, now time.Time) bool {
+	if p.MaxRetries > 0 && attempts >= p.MaxRetries {
+		return true
+	}
+	if p.MaxAge > 0 && !firstAttempt.IsZero() && now.Sub(firstAttempt) >= time.Duration(p.MaxAge)*time.Second {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Esquemas de autenticação dos webhooks enviados.
+const (
+	WEBHOOK_AUTH_LEGACY = "legacy" //X-API-Key com hash criptografado(AES-CBC)
+	WEBHOOK_AUTH_HMAC   = "hmac"   //X-Signature com HMAC-SHA256 sobre timestamp+body
+)
+
+var webhookSigningMu sync.RWMutex
+
+var (
+	WEBHOOK_AUTH_SCHEME     = WEBHOOK_AUTH_LEGACY
+	webhookAuthSchemes      = map[string]string{}
+	webhookSigningKeys      = map[string]string{}
+	webhookPrimarySigningId string
+)
+
+// Representa uma chave de assinatura de webhooks. O segredo nunca é retornado pela API.
+type WebhookSigningKey struct {
+	Id      string `json:"id"`
+	Secret  string `json:"secret,omitempty"`
+	Primary bool   `json:"primary"`
+}
+
+// Representa o esquema de autenticação de um destino enviado via API.
+type APIWebhookAuthScheme struct {
+	Destino string `json:"destino"` //Host ou URL completa do webhook
+	Scheme  string `json:"scheme"`  //legacy ou hmac
+}
+
+func validateWebhookAuthScheme(scheme string) error {
+	if scheme != WEBHOOK_AUTH_LEGACY && scheme != WEBHOOK_AUTH_HMAC {
+		return fmt.Errorf("esquema de autenticação inválido: %s", scheme)
+	}
+	return nil
+}
+
+// GetWebhookAuthScheme retorna o esquema de autenticação do destino, ou o esquema padrão(WEBHOOK_AUTH_SCHEME).
+func GetWebhookAuthScheme(rawUrl string) string {
+	webhookSigningMu.RLock()
+	defer webhookSigningMu.RUnlock()
+	if scheme, ok := lookupDestino(webhookAuthSchemes, rawUrl); ok {
+		return scheme
+	}
+	return WEBHOOK_AUTH_SCHEME
+}
+
+// SetWebhookAuthScheme define o esquema de autenticação de um destino(host ou URL completa).
+func SetWebhookAuthScheme(destino, scheme string) error {
+	if err := validateWebhookAuthScheme(scheme); err != nil {
+		return err
+	}
+	webhookSigningMu.Lock()
+	defer webhookSigningMu.Unlock()
+	webhookAuthSchemes[destino] = scheme
+	return nil
+}
+
+// DeleteWebhookAuthScheme remove o esquema de um destino, que voltará a utilizar o esquema padrão.
+func DeleteWebhookAuthScheme(destino string) {
+	webhookSigningMu.Lock()
+	defer webhookSigningMu.Unlock()
+	delete(webhookAuthSchemes, destino)
+}
+
+// GetWebhookAuthSchemes retorna uma cópia dos esquemas configurados por destino.
+func GetWebhookAuthSchemes() map[string]string {
+	webhookSigningMu.RLock()
+	defer webhookSigningMu.RUnlock()
+	var schemes = make(map[string]string, len(webhookAuthSchemes))
+	for destino, scheme := range webhookAuthSchemes {
+		schemes[destino] = scheme
+	}
+	return schemes
+}
+
+// AddWebhookSigningKey adiciona uma chave ativa de assinatura. Caso primary seja true, ela passa a ser utilizada para assinar.
+// As chaves não são persistidas: a origem delas é WEBHOOK_SIGNING_KEYS, e as adicionadas via API valem apenas na instância até o reinício.
+func AddWebhookSigningKey(id, secret string, primary bool) error {
+	if id == "" || secret == "" {
+		return errors.New("id e secret da chave são obrigatórios")
+	}
+	webhookSigningMu.Lock()
+	defer webhookSigningMu.Unlock()
+	webhookSigningKeys[id] = secret
+	if primary || webhookPrimarySigningId == "" {
+		webhookPrimarySigningId = id
+	}
+	return nil
+}
+
+// SetPrimaryWebhookSigningKey define qual das chaves ativas será utilizada para assinar.
+func SetPrimaryWebhookSigningKey(id string) error {
+	webhookSigningMu.Lock()
+	defer webhookSigningMu.Unlock()
+	if _, ok := webhookSigningKeys[id]; !ok {
+		return fmt.Errorf("chave %s não encontrada", id)
+	}
+	webhookPrimarySigningId = id
+	return nil
+}
+
+// RemoveWebhookSigningKey desativa uma chave. A chave primária não pode ser removida.
+func RemoveWebhookSigningKey(id string) error {
+	webhookSigningMu.Lock()
+	defer webhookSigningMu.Unlock()
+	if id == webhookPrimarySigningId {
+		return errors.New("não é possível remover a chave primária")
+	}
+	delete(webhookSigningKeys, id)
+	return nil
+}
+
+// GetPrimaryWebhookSigningKey retorna o id e o segredo da chave utilizada para assinar.
+func GetPrimaryWebhookSigningKey() (string, []byte, error) {
+	webhookSigningMu.RLock()
+	defer webhookSigningMu.RUnlock()
+	secret, ok := webhookSigningKeys[webhookPrimarySigningId]
+	if !ok {
+		return "", nil, errors.New("nenhuma chave de assinatura de webhook configurada")
+	}
+	return webhookPrimarySigningId, []byte(secret), nil
+}
+
+// ListWebhookSigningKeys retorna as chaves ativas, sem os segredos.
+func ListWebhookSigningKeys() []WebhookSigningKey {
+	webhookSigningMu.RLock()
+	defer webhookSigningMu.RUnlock()
+	var keys = make([]WebhookSigningKey, 0, len(webhookSigningKeys))
+	for id := range webhookSigningKeys {
+		keys = append(keys, WebhookSigningKey{Id: id, Primary: id == webhookPrimarySigningId})
+	}
+	return keys
+}
+
+// Carrega as configurações de assinatura de webhooks.
+// keys tem o formato "id1:secret1,id2:secret2"; primaryId define a chave primária(padrão: a primeira).
+// schemes é um json no formato {"destino": "hmac"}.
+func loadWebhookSigning(defaultScheme, keys, primaryId, schemes string) error {
+	if err := validateWebhookAuthScheme(defaultScheme); err != nil {
+		return err
+	}
+	WEBHOOK_AUTH_SCHEME = defaultScheme
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok {
+			return errors.New("chave de assinatura de webhook inválida em WEBHOOK_SIGNING_KEYS, formato esperado id:secret")
+		}
+		if err := AddWebhookSigningKey(id, secret, false); err != nil {
+			return err
+		}
+	}
+
+	if primaryId != "" {
+		if err := SetPrimaryWebhookSigningKey(primaryId); err != nil {
+			return err
+		}
+	}
+
+	if schemes == "" {
+		return nil
+	}
+	var destinos map[string]string
+	if err := json.Unmarshal([]byte(schemes), &destinos); err != nil {
+		return err
+	}
+	for destino, scheme := range destinos {
+		if err := SetWebhookAuthScheme(destino, scheme); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Chave do advisory lock que impede que duas instâncias apliquem as migrations ao mesmo tempo.
+const migrationLockKey int64 = 0x626d705f6d6967
+
+// Representa uma migration embutida no binário, composta pelos scripts <versão>_<nome>.up.sql e <versão>_<nome>.down.sql.
+type Migration struct {
+	Version int
+	Nome    string
+	Up      string
+	Down    string
+}
+
+// Representa a situação de uma migration no banco, retornada em Status.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Nome      string     `json:"nome"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Aplica e reverte as migrations embutidas, registrando as versões aplicadas em bmp_schema_migrations.
+type Migrator struct {
+	ctx        context.Context
+	db         *sql.DB
+	logger     *slog.Logger
+	location   *time.Location
+	migrations []Migration
+}
+
+func NewMigrator(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		ctx:        ctx,
+		db:         db,
+		logger:     logger,
+		location:   location,
+		migrations: migrations,
+	}, nil
+}
+
+// LoadMigrations lê as migrations embutidas, ordenadas pela versão. Toda migration precisa dos scripts up e down.
+func LoadMigrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var byVersion = make(map[int]*Migration)
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		direction := ""
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s sem direção(.up.sql ou .down.sql)", name)
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+
+		prefix, nome, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s fora do padrão <versão>_<nome>", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("versão inválida na migration %s", name)
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Nome: nome}
+			byVersion[version] = migration
+		}
+		if migration.Nome != nome {
+			return nil, fmt.Errorf("versão %d utilizada pelas migrations %s e %s", version, migration.Nome, nome)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	var migrations = make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s sem script up ou down", migration.Version, migration.Nome)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up aplica as migrations pendentes e retorna a quantidade aplicada.
+func (m *Migrator) Up() (int, error) {
+	var applied int
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverte as últimas "steps" migrations aplicadas e retorna a quantidade revertida.
+func (m *Migrator) Down(steps int) (int, error) {
+	var reverted int
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lista as migrations embutidas e a data em que cada uma foi aplicada.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var status = make([]MigrationStatus, 0, len(m.migrations))
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			item := MigrationStatus{Version: migration.Version, Nome: migration.Nome}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				item.AppliedAt = &appliedAt
+			}
+			status = append(status, item)
+		}
+		return nil
+	})
+	return status, err
+}
+
+// withLock executa fn em uma conexão dedicada segurando o advisory lock das migrations.
+// O lock é de sessão, então é liberado também se a conexão cair.
+func (m *Migrator) withLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_MIGRATION_TIMEOUT)
+	defer cancel()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao obter conexão para as migrations", err.Error(), nil)
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao obter lock das migrations", err.Error(), nil)
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao liberar lock das migrations", err.Error(), nil)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS bmp_schema_migrations (
+	    version    INTEGER PRIMARY KEY,
+	    nome       TEXT NOT NULL,
+	    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao criar tabela bmp_schema_migrations", err.Error(), nil)
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// appliedVersions retorna as versões aplicadas e a data de aplicação de cada uma.
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM bmp_schema_migrations`)
+	if err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao listar bmp_schema_migrations", err.Error(), nil)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions = make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao ler bmp_schema_migrations", err.Error(), nil)
+			return nil, err
+		}
+		versions[version] = appliedAt.In(m.location)
+	}
+	return versions, rows.Err()
+}
+
+// apply executa o script e registra(up) ou remove(down) a versão na mesma transação.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, script string, up bool) error {
+	var logData = map[string]any{"version": migration.Version, "nome": migration.Nome, "up": up}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao iniciar transação da migration", err.Error(), logData)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao executar migration", err.Error(), logData)
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Nome, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO bmp_schema_migrations (version, nome, applied_at) VALUES ($1, $2, $3)`,
+			migration.Version, migration.Nome, time.Now().In(m.location))
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM bmp_schema_migrations WHERE version=$1`, migration.Version)
+	}
+	if err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao registrar versão da migration", err.Error(), logData)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		helpers.LogError(m.ctx, m.logger, m.location, "migrations", "", "Erro ao confirmar migration", err.Error(), logData)
+		return err
+	}
+	helpers.LogInfo(m.ctx, m.logger, m.location, "migrations", "", "Migration executada", logData)
+	return nil
+}
@@ -0,0 +1,3 @@
+-- bmp_cobrancas existia antes das migrations e é apenas adotada pelo up(CREATE TABLE IF NOT EXISTS): o down não a remove,
+-- para que reverter as migrations não apague as cobranças. Os índices e colunas adicionados são removidos pelas migrations seguintes.
+SELECT 1;
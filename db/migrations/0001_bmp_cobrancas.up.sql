@@ -0,0 +1,21 @@
+-- Schema atual de bmp_cobrancas. IF NOT EXISTS permite adotar as migrations em bancos criados antes delas.
+-- numero_ccb é texto: as buscas por código de liquidação, parcela e data de vencimento o comparam com uma string.
+CREATE TABLE IF NOT EXISTS bmp_cobrancas (
+    id                    BIGSERIAL PRIMARY KEY,
+    id_proposta           INTEGER NOT NULL,
+    id_securitizadora     INTEGER,
+    id_convenio           INTEGER,
+    numero_acompanhamento TEXT,
+    numero_ccb            TEXT NOT NULL,
+    url_webhook           TEXT,
+    id_proposta_parcela   INTEGER NOT NULL,
+    data_vencimento       DATE,
+    data_expiracao        DATE,
+    parcela               INTEGER,
+    id_forma_cobranca     INTEGER,
+    codigo_liquidacao     TEXT,
+    numero_boleto         BIGINT,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at            TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT bmp_cobrancas_id_proposta_parcela_key UNIQUE (id_proposta_parcela)
+);
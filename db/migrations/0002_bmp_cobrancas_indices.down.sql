@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS bmp_cobrancas_numero_ccb_data_vencimento_idx;
+DROP INDEX IF EXISTS bmp_cobrancas_numero_ccb_parcela_idx;
+DROP INDEX IF EXISTS bmp_cobrancas_codigo_liquidacao_idx;
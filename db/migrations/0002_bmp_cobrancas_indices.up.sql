@@ -0,0 +1,4 @@
+-- Índices das buscas de ParcelaRepo: FindByCodLiquidacao, FindByNumParcela e FindByDataVencimento.
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_codigo_liquidacao_idx ON bmp_cobrancas (codigo_liquidacao);
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_numero_ccb_parcela_idx ON bmp_cobrancas (numero_ccb, parcela);
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_numero_ccb_data_vencimento_idx ON bmp_cobrancas (numero_ccb, data_vencimento);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bmp_webhook_assinaturas;
+DROP TABLE IF EXISTS bmp_webhook_entregas;
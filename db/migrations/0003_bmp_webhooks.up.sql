@@ -0,0 +1,35 @@
+-- Histórico de entregas de webhook.
+CREATE TABLE IF NOT EXISTS bmp_webhook_entregas (
+    id                  BIGSERIAL PRIMARY KEY,
+    id_proposta_parcela INTEGER NOT NULL DEFAULT 0,
+    url                 TEXT NOT NULL,
+    contexto            TEXT NOT NULL DEFAULT '',
+    payload             JSONB,
+    payload_hash        TEXT NOT NULL DEFAULT '',
+    status_code         INTEGER NOT NULL DEFAULT 0,
+    latencia_ms         BIGINT NOT NULL DEFAULT 0,
+    resposta            TEXT,
+    tentativa           BIGINT NOT NULL DEFAULT 0,
+    erro                TEXT,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bmp_webhook_entregas_id_proposta_parcela_idx ON bmp_webhook_entregas (id_proposta_parcela, created_at DESC);
+CREATE INDEX IF NOT EXISTS bmp_webhook_entregas_url_idx ON bmp_webhook_entregas (url, created_at DESC);
+CREATE INDEX IF NOT EXISTS bmp_webhook_entregas_created_at_idx ON bmp_webhook_entregas (created_at DESC);
+
+-- Assinaturas de webhook por convênio e securitizadora. eventos vazio assina todos os eventos.
+CREATE TABLE IF NOT EXISTS bmp_webhook_assinaturas (
+    id                BIGSERIAL PRIMARY KEY,
+    id_convenio       INTEGER NOT NULL,
+    id_securitizadora INTEGER NOT NULL DEFAULT 0,
+    url               TEXT NOT NULL,
+    eventos           TEXT[] NOT NULL DEFAULT '{}',
+    auth_scheme       TEXT,
+    schema_version    INTEGER NOT NULL DEFAULT 0,
+    ativo             BOOLEAN NOT NULL DEFAULT true,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bmp_webhook_assinaturas_convenio_idx ON bmp_webhook_assinaturas (id_convenio, id_securitizadora);
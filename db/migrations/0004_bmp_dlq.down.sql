@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bmp_dlq_replays;
+DROP TABLE IF EXISTS bmp_dlq;
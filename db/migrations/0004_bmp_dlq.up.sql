@@ -0,0 +1,31 @@
+-- Mensagens da DLQ persistidas para consulta e reprocessamento. headers guarda as propriedades da mensagem em quarentena.
+CREATE TABLE IF NOT EXISTS bmp_dlq (
+    id                  BIGSERIAL PRIMARY KEY,
+    contexto            TEXT NOT NULL,
+    mensagem            TEXT,
+    erro                TEXT,
+    id_proposta_parcela INTEGER NOT NULL DEFAULT 0,
+    payload             JSONB,
+    headers             JSONB,
+    time                TIMESTAMPTZ NOT NULL,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE bmp_dlq ADD COLUMN IF NOT EXISTS headers JSONB;
+
+CREATE INDEX IF NOT EXISTS bmp_dlq_contexto_idx ON bmp_dlq (contexto, time DESC);
+CREATE INDEX IF NOT EXISTS bmp_dlq_id_proposta_parcela_idx ON bmp_dlq (id_proposta_parcela, time DESC);
+CREATE INDEX IF NOT EXISTS bmp_dlq_time_idx ON bmp_dlq (time DESC);
+
+-- Auditoria dos reprocessamentos da DLQ.
+CREATE TABLE IF NOT EXISTS bmp_dlq_replays (
+    id         BIGSERIAL PRIMARY KEY,
+    id_dlq     BIGINT NOT NULL REFERENCES bmp_dlq (id) ON DELETE CASCADE,
+    fila       TEXT NOT NULL,
+    usuario    TEXT NOT NULL,
+    editado    BOOLEAN NOT NULL DEFAULT false,
+    payload    JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bmp_dlq_replays_id_dlq_idx ON bmp_dlq_replays (id_dlq, created_at DESC);
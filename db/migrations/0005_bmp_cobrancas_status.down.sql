@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS bmp_cobrancas_status_idx;
+ALTER TABLE bmp_cobrancas DROP CONSTRAINT IF EXISTS bmp_cobrancas_status_check;
+ALTER TABLE bmp_cobrancas DROP COLUMN IF EXISTS status;
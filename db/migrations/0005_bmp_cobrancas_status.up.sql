@@ -0,0 +1,17 @@
+-- Status do ciclo de vida da cobrança. As linhas existentes são classificadas pelo código de liquidação e pelo número do boleto.
+ALTER TABLE bmp_cobrancas ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'pendente';
+
+UPDATE bmp_cobrancas
+SET
+    status = CASE
+        WHEN numero_boleto IS NOT NULL AND numero_boleto > 0 THEN 'boleto_emitido'
+        ELSE 'registrada'
+    END
+WHERE
+    status = 'pendente' AND COALESCE(codigo_liquidacao, '') <> '';
+
+ALTER TABLE bmp_cobrancas ADD CONSTRAINT bmp_cobrancas_status_check CHECK (status IN (
+    'pendente', 'gerando', 'registrada', 'boleto_emitido', 'paga_parcial', 'paga', 'cancelada', 'falha'
+));
+
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_status_idx ON bmp_cobrancas (status);
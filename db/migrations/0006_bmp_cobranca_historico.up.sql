@@ -0,0 +1,17 @@
+-- Histórico append-only de cada parcela: operações solicitadas, chamadas ao BMP, eventos recebidos do BMP, webhooks enviados
+-- e alterações gravadas em bmp_cobrancas. Os registros não são alterados nem removidos pela aplicação.
+CREATE TABLE IF NOT EXISTS bmp_cobranca_historico (
+    id                  BIGSERIAL PRIMARY KEY,
+    id_proposta_parcela INTEGER NOT NULL,
+    tipo                TEXT NOT NULL,
+    ator                TEXT NOT NULL,
+    operacao            TEXT,
+    idempotency_key     TEXT,
+    correlation_id      TEXT,
+    status_code         INTEGER,
+    mensagem            TEXT,
+    payload             JSONB,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bmp_cobranca_historico_id_proposta_parcela_idx ON bmp_cobranca_historico (id_proposta_parcela, created_at, id);
@@ -0,0 +1,18 @@
+-- Mensagens gravadas na mesma transação das alterações em bmp_cobrancas e publicadas nas filas pelo relay do outbox.
+-- Uma mensagem fica pendente até published_at ser preenchido; disponivel_em controla o lease e o backoff das tentativas.
+CREATE TABLE IF NOT EXISTS bmp_outbox (
+    id                  BIGSERIAL PRIMARY KEY,
+    message_id          TEXT NOT NULL,
+    fila                TEXT NOT NULL,
+    payload             JSONB NOT NULL,
+    delay_ms            BIGINT NOT NULL DEFAULT 0,
+    id_proposta_parcela INTEGER NOT NULL DEFAULT 0,
+    tentativas          INTEGER NOT NULL DEFAULT 0,
+    erro                TEXT,
+    disponivel_em       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at        TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bmp_outbox_pendentes_idx ON bmp_outbox (disponivel_em, id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS bmp_outbox_published_at_idx ON bmp_outbox (published_at) WHERE published_at IS NOT NULL;
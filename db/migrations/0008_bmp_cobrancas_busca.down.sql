@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS bmp_cobrancas_data_vencimento_idx;
+DROP INDEX IF EXISTS bmp_cobrancas_created_at_idx;
+DROP INDEX IF EXISTS bmp_cobrancas_id_convenio_created_at_idx;
+DROP INDEX IF EXISTS bmp_cobrancas_id_proposta_idx;
+ALTER TABLE bmp_cobrancas DROP COLUMN IF EXISTS pagamento;
@@ -0,0 +1,8 @@
+-- Últimos dados de pagamento(boletos e pix) retornados pelo BMP nas consultas, exibidos na listagem de cobranças.
+ALTER TABLE bmp_cobrancas ADD COLUMN IF NOT EXISTS pagamento JSONB;
+
+-- Índices dos filtros e ordenações da listagem de cobranças(GET /cobrancas).
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_id_proposta_idx ON bmp_cobrancas (id_proposta);
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_id_convenio_created_at_idx ON bmp_cobrancas (id_convenio, created_at, id);
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_created_at_idx ON bmp_cobrancas (created_at, id);
+CREATE INDEX IF NOT EXISTS bmp_cobrancas_data_vencimento_idx ON bmp_cobrancas ((COALESCE(data_vencimento, DATE '0001-01-01')), id);
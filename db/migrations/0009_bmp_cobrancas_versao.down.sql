@@ -0,0 +1 @@
+ALTER TABLE bmp_cobrancas DROP COLUMN IF EXISTS version;
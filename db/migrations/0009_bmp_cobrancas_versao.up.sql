@@ -0,0 +1,4 @@
+-- Versão da linha, incrementada a cada gravação das operações e eventos. As atualizações condicionadas à versão lida
+-- (compare-and-swap) são descartadas se outra operação gravou a parcela nesse intervalo. As linhas começam na versão 1:
+-- a versão 0 indica uma atualização sem condição.
+ALTER TABLE bmp_cobrancas ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
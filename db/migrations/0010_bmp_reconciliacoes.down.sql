@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bmp_reconciliacao_divergencias;
+DROP TABLE IF EXISTS bmp_reconciliacoes;
@@ -0,0 +1,33 @@
+-- Execuções da reconciliação das cobranças em aberto com o BMP.
+CREATE TABLE IF NOT EXISTS bmp_reconciliacoes (
+    id                   BIGSERIAL PRIMARY KEY,
+    status               TEXT NOT NULL,
+    origem               TEXT NOT NULL,
+    parcelas_verificadas INTEGER NOT NULL DEFAULT 0,
+    divergencias         INTEGER NOT NULL DEFAULT 0,
+    corrigidas           INTEGER NOT NULL DEFAULT 0,
+    erros                INTEGER NOT NULL DEFAULT 0,
+    erro                 TEXT,
+    iniciada_em          TIMESTAMPTZ NOT NULL,
+    finalizada_em        TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS bmp_reconciliacoes_iniciada_em_idx ON bmp_reconciliacoes (iniciada_em DESC);
+
+-- Divergências entre a base local e o BMP encontradas em cada execução.
+CREATE TABLE IF NOT EXISTS bmp_reconciliacao_divergencias (
+    id                  BIGSERIAL PRIMARY KEY,
+    id_reconciliacao    BIGINT NOT NULL REFERENCES bmp_reconciliacoes (id) ON DELETE CASCADE,
+    id_proposta_parcela INTEGER NOT NULL,
+    id_proposta         INTEGER NOT NULL DEFAULT 0,
+    numero_parcela      INTEGER NOT NULL DEFAULT 0,
+    tipo                TEXT NOT NULL,
+    local               TEXT,
+    bmp                 TEXT,
+    corrigida           BOOLEAN NOT NULL DEFAULT false,
+    acao                TEXT,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS bmp_reconciliacao_divergencias_id_reconciliacao_idx ON bmp_reconciliacao_divergencias (id_reconciliacao, id);
+CREATE INDEX IF NOT EXISTS bmp_reconciliacao_divergencias_id_proposta_parcela_idx ON bmp_reconciliacao_divergencias (id_proposta_parcela, created_at DESC);
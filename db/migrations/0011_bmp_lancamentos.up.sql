@@ -0,0 +1,25 @@
+-- Lançamentos nas parcelas(pagamentos, descontos e ajustes de saldo) recebidos nos eventos do BMP. chave_evento identifica
+-- o evento de origem: eventos reentregues são gravados uma única vez. numero_ccb é texto, como em bmp_cobrancas.
+CREATE TABLE IF NOT EXISTS bmp_lancamentos (
+    id                  BIGSERIAL PRIMARY KEY,
+    id_proposta_parcela INTEGER NOT NULL,
+    id_proposta         INTEGER NOT NULL,
+    numero_ccb          TEXT NOT NULL,
+    numero_parcela      INTEGER NOT NULL,
+    chave_evento        TEXT NOT NULL,
+    tipo                TEXT NOT NULL,
+    valor_pagamento     NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    valor_encargos      NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    valor_desconto      NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    valor_abatimento    NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    valor_excedente     NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    saldo_anterior      NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    saldo_atual         NUMERIC(15, 2) NOT NULL DEFAULT 0,
+    parcela_liquidada   BOOLEAN NOT NULL DEFAULT false,
+    data_evento         TEXT,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT bmp_lancamentos_chave_evento_key UNIQUE (chave_evento)
+);
+
+CREATE INDEX IF NOT EXISTS bmp_lancamentos_id_proposta_parcela_idx ON bmp_lancamentos (id_proposta_parcela, data_evento, id);
+CREATE INDEX IF NOT EXISTS bmp_lancamentos_id_proposta_idx ON bmp_lancamentos (id_proposta, data_evento, id);
@@ -0,0 +1,54 @@
+-- Histórico dos códigos de liquidação e boletos emitidos para cada parcela. bmp_cobrancas guarda apenas a liquidação atual:
+-- as anteriores são mantidas aqui para que os eventos recebidos depois da reemissão ainda encontrem a parcela.
+-- Um código de liquidação sem boleto é gravado com numero_boleto 0, preenchido quando o boleto é emitido.
+CREATE TABLE IF NOT EXISTS bmp_liquidacoes (
+    id                  BIGSERIAL PRIMARY KEY,
+    id_proposta_parcela INTEGER NOT NULL,
+    numero_ccb          TEXT NOT NULL,
+    parcela             INTEGER,
+    codigo_liquidacao   TEXT NOT NULL DEFAULT '',
+    numero_boleto       BIGINT NOT NULL DEFAULT 0,
+    status              TEXT NOT NULL,
+    data_vencimento     DATE,
+    data_expiracao      DATE,
+    emitida_em          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    encerrada_em        TIMESTAMPTZ,
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT bmp_liquidacoes_emissao_key UNIQUE (id_proposta_parcela, codigo_liquidacao, numero_boleto)
+);
+
+CREATE INDEX IF NOT EXISTS bmp_liquidacoes_codigo_liquidacao_idx ON bmp_liquidacoes (codigo_liquidacao, numero_ccb);
+CREATE INDEX IF NOT EXISTS bmp_liquidacoes_numero_boleto_idx ON bmp_liquidacoes (numero_boleto, numero_ccb);
+CREATE INDEX IF NOT EXISTS bmp_liquidacoes_numero_ccb_idx ON bmp_liquidacoes (numero_ccb, parcela, id);
+
+-- Liquidação atual das parcelas gravadas antes do histórico.
+INSERT INTO bmp_liquidacoes (
+    id_proposta_parcela,
+    numero_ccb,
+    parcela,
+    codigo_liquidacao,
+    numero_boleto,
+    status,
+    data_vencimento,
+    data_expiracao,
+    emitida_em,
+    encerrada_em,
+    updated_at
+)
+SELECT
+    id_proposta_parcela,
+    numero_ccb,
+    parcela,
+    COALESCE(codigo_liquidacao, ''),
+    COALESCE(numero_boleto, 0),
+    CASE status WHEN 'cancelada' THEN 'cancelada' WHEN 'paga' THEN 'paga' ELSE 'ativa' END,
+    data_vencimento,
+    data_expiracao,
+    created_at,
+    CASE WHEN status IN ('cancelada', 'paga') THEN updated_at END,
+    updated_at
+FROM
+    bmp_cobrancas
+WHERE
+    COALESCE(codigo_liquidacao, '') <> '' OR COALESCE(numero_boleto, 0) <> 0
+ON CONFLICT (id_proposta_parcela, codigo_liquidacao, numero_boleto) DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE bmp_webhook_entregas DROP COLUMN IF EXISTS schema_version;
+ALTER TABLE bmp_webhook_entregas DROP COLUMN IF EXISTS auth_scheme;
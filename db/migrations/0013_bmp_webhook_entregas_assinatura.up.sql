@@ -0,0 +1,3 @@
+-- Esquema de autenticação e versão do schema de cada entrega, para que o reenvio manual use os mesmos da entrega original.
+ALTER TABLE bmp_webhook_entregas ADD COLUMN IF NOT EXISTS auth_scheme TEXT NOT NULL DEFAULT '';
+ALTER TABLE bmp_webhook_entregas ADD COLUMN IF NOT EXISTS schema_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+package docs
+
+import _ "embed"
+
+// Schema dos eventos enviados aos webhooks, servido em /docs/webhook-events.schema.json.
+//
+//go:embed webhook-events.schema.json
+var WebhookEventsSchema []byte
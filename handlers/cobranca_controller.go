@@ -6,6 +6,7 @@ import (
 	"cobranca-bmp/helpers"
 	"cobranca-bmp/models"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,17 +17,30 @@ type CobrancaCreditoPessoalController struct {
 	cobrancaService CobrancaService
 	loc             *time.Location
 	webhookService  WebhookService
+	tasks           TaskRunner
 }
 
-func NewCobrancaCreditoPessoalController(cache *cache.RedisCache, service CobrancaService, webhookService WebhookService, loc *time.Location) *CobrancaCreditoPessoalController {
+func NewCobrancaCreditoPessoalController(cache *cache.RedisCache, service CobrancaService, webhookService WebhookService, tasks TaskRunner, loc *time.Location) *CobrancaCreditoPessoalController {
 	return &CobrancaCreditoPessoalController{
 		cache:           cache,
 		cobrancaService: service,
 		loc:             loc,
 		webhookService:  webhookService,
+		tasks:           tasks,
 	}
 }
 
+// Processa a operação em segundo plano. Caso não termine até o prazo do encerramento do serviço, ela é enviada para a fila.
+func (a *CobrancaCreditoPessoalController) process(name string, payload *models.CobrancaTaskData) {
+	var snapshot = *payload
+	a.cobrancaService.RecordOperacao(payload)
+	a.tasks.Go(name, func() {
+		a.cobrancaService.Cobranca(payload)
+	}, func() {
+		a.cobrancaService.Enqueue(&snapshot)
+	})
+}
+
 func (a *CobrancaCreditoPessoalController) GetPrefix() string {
 	return "/"
 }
@@ -37,6 +51,7 @@ func (a *CobrancaCreditoPessoalController) Route(r fiber.Router) {
 	r.Post("/cancelamento", a.CancelarCobranca())
 	r.Post("/geracao", a.GerarCobranca())
 	r.Post("/lancamento", a.LancamentoParcela())
+	r.Get("/cobrancas", a.ListarCobrancas())
 
 }
 
@@ -49,6 +64,7 @@ func (a *CobrancaCreditoPessoalController) Route(r fiber.Router) {
 //	@Produce		json
 //	@Param			body	body	models.GerarCobrancaFrontendInput	true  "Dados da cobrança."
 //	@Success		200		{object}	models.APIResponse
+//	@Failure		409		{object}	models.APIError
 //	@Failure		422		{object}	models.APIError
 //	@Router			/geracao [post]
 func (a *CobrancaCreditoPessoalController) GerarCobranca() fiber.Handler {
@@ -83,7 +99,11 @@ func (a *CobrancaCreditoPessoalController) GerarCobranca() fiber.Handler {
 			return c.Status(422).JSON(err)
 		}
 
-		go a.cobrancaService.Cobranca(payload)
+		if err := a.cobrancaService.ValidateOperacao(input.IdPropostaParcela, config.STATUS_GERAR_COBRANCA); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(models.NewAPIError("", "Impossível gerar cobrança: "+err.Error(), strconv.Itoa(input.IdPropostaParcela)))
+		}
+
+		a.process("geração de cobrança", payload)
 		var resp = models.NewAPIError("", "A geração de cobranças entrou em processamento. Aguarde!", strconv.Itoa(input.IdPropostaParcela))
 		resp.HasError = false
 		return c.JSON(resp)
@@ -99,6 +119,7 @@ func (a *CobrancaCreditoPessoalController) GerarCobranca() fiber.Handler {
 //	@Produce		json
 //	@Param			body	body	models.CancelarCobrancaFrontendInput	true  "Dados da cobrança."
 //	@Success		200		{object}	models.APIResponse
+//	@Failure		409		{object}	models.APIError
 //	@Failure		422		{object}	models.APIError
 //	@Router			/cancelamento [post]
 func (a *CobrancaCreditoPessoalController) CancelarCobranca() fiber.Handler {
@@ -128,6 +149,13 @@ func (a *CobrancaCreditoPessoalController) CancelarCobranca() fiber.Handler {
 
 		}
 
+		if propostaInfo.Status == models.COBRANCA_STATUS_CANCELADA {
+			return c.Status(fiber.StatusConflict).JSON(models.NewAPIError("", "Impossível cancelar:cobrança já cancelada.", strconv.Itoa(input.IdPropostaParcela)))
+		}
+		if err := a.cobrancaService.ValidateOperacao(input.IdPropostaParcela, config.STATUS_CANCELAR_COBRANCA); err != nil {
+			return c.Status(fiber.StatusConflict).JSON(models.NewAPIError("", "Impossível cancelar: "+err.Error(), strconv.Itoa(input.IdPropostaParcela)))
+		}
+
 		var bmpPayload = models.CancelarCobrancaInput{
 			DTO: models.DtoCobranca{
 				CodigoProposta: input.NumeroAcompanhamento,
@@ -154,7 +182,7 @@ func (a *CobrancaCreditoPessoalController) CancelarCobranca() fiber.Handler {
 
 		var resp = models.NewAPIError("", "O cancelamento de cobranças entrou em processamento. Aguarde!", strconv.Itoa(input.IdProposta))
 		resp.HasError = false
-		go a.cobrancaService.Cobranca(cancelamentoTaskData)
+		a.process("cancelamento de cobrança", cancelamentoTaskData)
 		return c.Status(fiber.StatusOK).JSON(resp)
 	}
 }
@@ -195,7 +223,7 @@ func (a *CobrancaCreditoPessoalController) LancamentoParcela() fiber.Handler {
 		lancamentoTaskData.WebhookUrl = input.UrlWebhook
 		lancamentoTaskData.CalledAssync = true
 
-		go a.cobrancaService.Cobranca(lancamentoTaskData)
+		a.process("lançamento de parcela", lancamentoTaskData)
 		var resp = models.NewAPIError("", "O lançamento na parcela entrou em processamento. Aguarde!", strconv.Itoa(input.IdProposta))
 		resp.HasError = false
 		return c.JSON(resp)
@@ -261,3 +289,67 @@ func (a *CobrancaCreditoPessoalController) ConsultarCobrancas() fiber.Handler {
 	}
 
 }
+
+// Listar Cobrancas godoc
+//
+//	@Summary		Listar Cobranças.
+//	@Description	Lista as cobranças gravadas, com paginação por cursor. Para a próxima página, repita a requisição com o next_cursor retornado.
+//	@Tags			CreditoPessoal
+//	@Produce		json
+//	@Param			id_proposta			query		int		false	"Id da proposta."
+//	@Param			numero_ccb			query		int		false	"Número da CCB."
+//	@Param			id_convenio			query		int		false	"Id do convênio."
+//	@Param			id_securitizadora	query		int		false	"Id da securitizadora."
+//	@Param			id_forma_cobranca	query		int		false	"Forma de cobrança."
+//	@Param			status				query		string	false	"Status, separados por vírgula."
+//	@Param			vencimento_inicio	query		string	false	"Data de vencimento inicial(2006-01-02)."
+//	@Param			vencimento_fim		query		string	false	"Data de vencimento final(2006-01-02)."
+//	@Param			criado_inicio		query		string	false	"Data/hora de criação inicial(RFC3339 ou 2006-01-02)."
+//	@Param			criado_fim			query		string	false	"Data/hora de criação final(RFC3339 ou 2006-01-02)."
+//	@Param			incluir_pagamento	query		bool	false	"Inclui os últimos boletos/pix retornados pelo BMP."
+//	@Param			sort				query		string	false	"Ordenação: created_at(padrão), updated_at, data_vencimento ou id_proposta_parcela."
+//	@Param			order				query		string	false	"Direção: asc ou desc(padrão)."
+//	@Param			limit				query		int		false	"Quantidade máxima de registros(padrão 50, máximo 500)."
+//	@Param			cursor				query		string	false	"Cursor da página."
+//	@Success		200					{object}	models.CobrancaPage
+//	@Failure		422					{object}	models.APIError
+//	@Failure		500					{object}	models.APIError
+//	@Router			/cobrancas [get]
+func (a *CobrancaCreditoPessoalController) ListarCobrancas() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter models.CobrancaFilter
+		if err := c.QueryParser(&filter); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Parâmetros inválidos: "+err.Error(), ""))
+		}
+
+		if status := c.Query("status"); status != "" {
+			filter.Status = strings.Split(status, ",")
+		}
+
+		var err error
+		var datas = []struct {
+			param string
+			dest  *time.Time
+		}{
+			{"vencimento_inicio", &filter.VencimentoInicio},
+			{"vencimento_fim", &filter.VencimentoFim},
+			{"criado_inicio", &filter.CriadoInicio},
+			{"criado_fim", &filter.CriadoFim},
+		}
+		for _, data := range datas {
+			if *data.dest, err = parseQueryTime(c.Query(data.param), a.loc); err != nil {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data inválida em "+data.param, ""))
+			}
+		}
+
+		if err := filter.Validate(); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", err.Error(), ""))
+		}
+
+		page, err := a.cobrancaService.SearchCobrancas(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar cobranças", ""))
+		}
+		return c.JSON(page)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 type QOSSetter interface {
 	SetQOS(qos int) error
+	SetQueueQOS(fila string, workers, prefetch int) error
 	GetQOS() map[string]any
 }
 
@@ -40,22 +41,66 @@ func (cc *ConfigController) GetPrefix() string {
 }
 
 func (cc *ConfigController) Route(r fiber.Router) {
+	r.Get("/qos", cc.GetQOS())
 	r.Post("/qos", cc.SetQOS())
 	r.Post("/db/set", cc.ReconfigDB())
 	r.Post(("/env"), cc.SetGlobalEnvVars())
+	r.Get("/webhook-policy", cc.GetWebhookRetryPolicies())
+	r.Post("/webhook-policy", cc.SetWebhookRetryPolicy())
+	r.Delete("/webhook-policy", cc.DeleteWebhookRetryPolicy())
+	r.Get("/webhook-auth", cc.GetWebhookAuth())
+	r.Post("/webhook-auth", cc.SetWebhookAuthScheme())
+	r.Delete("/webhook-auth", cc.DeleteWebhookAuthScheme())
+	r.Post("/webhook-keys", cc.AddWebhookSigningKey())
+	r.Post("/webhook-keys/:id/primary", cc.SetPrimaryWebhookSigningKey())
+	r.Delete("/webhook-keys/:id", cc.RemoveWebhookSigningKey())
 
 }
 
+// GetQOS godoc
+//
+//	@Summary		Listar configuração dos consumers.
+//	@Description	Retorna os workers, o prefetch e as mensagens em processamento de cada fila.
+//	@Tags			Config
+//	@Produce		json
+//	@Success		200		{object}	map[string]any
+//	@Router			/config/qos [get]
+func (cc *ConfigController) GetQOS() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(cc.qosSetter.GetQOS())
+	}
+}
+
+// SetQOS godoc
+//
+//	@Summary		Configurar consumers.
+//	@Description	Altera os workers e/ou o prefetch de uma fila sem reconectar ao RabbitMQ. Sem fila informada, QOS é aplicado como prefetch de todas as filas.
+//	@Tags			Config
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.SetQOS	true	"Fila, workers e prefetch."
+//	@Success		200		{object}	map[string]any
+//	@Failure		422		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/config/qos [post]
 func (cc *ConfigController) SetQOS() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var settings models.SetQOS
 		if err := c.BodyParser(&settings); err != nil {
-			return c.Status(fiber.StatusUnprocessableEntity).JSON(err.Error())
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": helpers.ParseJsonError(err.Error())})
+		}
+		if err := helpers.StructValidate(settings); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		if err := cc.qosSetter.SetQOS(settings.QOS); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(err)
-
+		var err error
+		if settings.Fila != "" {
+			err = cc.qosSetter.SetQueueQOS(settings.Fila, settings.Workers, settings.Prefetch)
+		} else {
+			err = cc.qosSetter.SetQOS(settings.QOS)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 		}
 
 		return c.JSON(cc.qosSetter.GetQOS())
@@ -130,3 +175,199 @@ func (cc *ConfigController) SetGlobalEnvVars() fiber.Handler {
 	}
 
 }
+
+// GetWebhookRetryPolicies godoc
+//
+//	@Summary		Listar políticas de retentativa de webhooks.
+//	@Description	Retorna a política global e as políticas configuradas por destino.
+//	@Tags			Config
+//	@Produce		json
+//	@Success		200		{object}	map[string]any
+//	@Router			/config/webhook-policy [get]
+func (cc *ConfigController) GetWebhookRetryPolicies() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"default":  config.DefaultWebhookRetryPolicy(),
+			"destinos": config.GetWebhookRetryPolicies(),
+		})
+	}
+}
+
+// SetWebhookRetryPolicy godoc
+//
+//	@Summary		Configurar política de retentativa de webhooks.
+//	@Description	Define a política de retentativas(backoff exponencial, tentativas e idade máxima) de um destino.
+//	@Tags			Config
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		config.APIWebhookRetryPolicy	true	"Destino e política."
+//	@Success		200		{object}	map[string]any
+//	@Failure		422		{object}	map[string]string
+//	@Router			/config/webhook-policy [post]
+func (cc *ConfigController) SetWebhookRetryPolicy() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input config.APIWebhookRetryPolicy
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": helpers.ParseJsonError(err.Error())})
+		}
+		if input.Destino == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "destino é obrigatório"})
+		}
+
+		config.SetWebhookRetryPolicy(input.Destino, input.WebhookRetryPolicy)
+		return c.JSON(fiber.Map{input.Destino: config.GetWebhookRetryPolicy(input.Destino)})
+	}
+}
+
+// DeleteWebhookRetryPolicy godoc
+//
+//	@Summary		Remover política de retentativa de webhooks.
+//	@Description	Remove a política de um destino, que voltará a utilizar a política global.
+//	@Tags			Config
+//	@Produce		json
+//	@Param			destino	query		string	true	"Host ou URL completa do webhook."
+//	@Success		200		{object}	map[string]any
+//	@Failure		422		{object}	map[string]string
+//	@Router			/config/webhook-policy [delete]
+func (cc *ConfigController) DeleteWebhookRetryPolicy() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		destino := c.Query("destino")
+		if destino == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "destino é obrigatório"})
+		}
+
+		config.DeleteWebhookRetryPolicy(destino)
+		return c.JSON(config.GetWebhookRetryPolicies())
+	}
+}
+
+// GetWebhookAuth godoc
+//
+//	@Summary		Listar autenticação de webhooks.
+//	@Description	Retorna o esquema padrão, os esquemas por destino e as chaves de assinatura ativas(sem os segredos).
+//	@Tags			Config
+//	@Produce		json
+//	@Success		200		{object}	map[string]any
+//	@Router			/config/webhook-auth [get]
+func (cc *ConfigController) GetWebhookAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"default":  config.WEBHOOK_AUTH_SCHEME,
+			"destinos": config.GetWebhookAuthSchemes(),
+			"chaves":   config.ListWebhookSigningKeys(),
+		})
+	}
+}
+
+// SetWebhookAuthScheme godoc
+//
+//	@Summary		Configurar autenticação de webhooks por destino.
+//	@Description	Define o esquema de autenticação(legacy ou hmac) utilizado nos webhooks enviados a um destino.
+//	@Tags			Config
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		config.APIWebhookAuthScheme	true	"Destino e esquema."
+//	@Success		200		{object}	map[string]string
+//	@Failure		422		{object}	map[string]string
+//	@Router			/config/webhook-auth [post]
+func (cc *ConfigController) SetWebhookAuthScheme() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input config.APIWebhookAuthScheme
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": helpers.ParseJsonError(err.Error())})
+		}
+		if input.Destino == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "destino é obrigatório"})
+		}
+		if err := config.SetWebhookAuthScheme(input.Destino, input.Scheme); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(config.GetWebhookAuthSchemes())
+	}
+}
+
+// DeleteWebhookAuthScheme godoc
+//
+//	@Summary		Remover autenticação de webhooks por destino.
+//	@Description	Remove o esquema de um destino, que voltará a utilizar o esquema padrão.
+//	@Tags			Config
+//	@Produce		json
+//	@Param			destino	query		string	true	"Host ou URL completa do webhook."
+//	@Success		200		{object}	map[string]string
+//	@Failure		422		{object}	map[string]string
+//	@Router			/config/webhook-auth [delete]
+func (cc *ConfigController) DeleteWebhookAuthScheme() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		destino := c.Query("destino")
+		if destino == "" {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "destino é obrigatório"})
+		}
+		config.DeleteWebhookAuthScheme(destino)
+		return c.JSON(config.GetWebhookAuthSchemes())
+	}
+}
+
+// AddWebhookSigningKey godoc
+//
+//	@Summary		Adicionar chave de assinatura de webhooks.
+//	@Description	Adiciona uma chave ativa de assinatura HMAC. Com primary=true, ela passa a ser utilizada para assinar.
+//	@Description	A chave é mantida apenas em memória nesta instância: para sobreviver a reinícios e valer nas demais instâncias, ela deve ser incluída em WEBHOOK_SIGNING_KEYS.
+//	@Tags			Config
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		config.WebhookSigningKey	true	"Chave."
+//	@Success		200		{array}		config.WebhookSigningKey
+//	@Failure		422		{object}	map[string]string
+//	@Router			/config/webhook-keys [post]
+func (cc *ConfigController) AddWebhookSigningKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input config.WebhookSigningKey
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": helpers.ParseJsonError(err.Error())})
+		}
+		if err := config.AddWebhookSigningKey(input.Id, input.Secret, input.Primary); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(config.ListWebhookSigningKeys())
+	}
+}
+
+// SetPrimaryWebhookSigningKey godoc
+//
+//	@Summary		Definir chave primária de assinatura de webhooks.
+//	@Description	Define qual das chaves ativas será utilizada para assinar os webhooks.
+//	@Description	A alteração vale apenas nesta instância até o reinício, quando volta a valer WEBHOOK_SIGNING_KEY_ID.
+//	@Tags			Config
+//	@Produce		json
+//	@Param			id	path		string	true	"Id da chave."
+//	@Success		200	{array}		config.WebhookSigningKey
+//	@Failure		422	{object}	map[string]string
+//	@Router			/config/webhook-keys/{id}/primary [post]
+func (cc *ConfigController) SetPrimaryWebhookSigningKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := config.SetPrimaryWebhookSigningKey(c.Params("id")); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(config.ListWebhookSigningKeys())
+	}
+}
+
+// RemoveWebhookSigningKey godoc
+//
+//	@Summary		Remover chave de assinatura de webhooks.
+//	@Description	Desativa uma chave de assinatura. A chave primária não pode ser removida.
+//	@Description	A remoção vale apenas nesta instância até o reinício: a chave também deve ser retirada de WEBHOOK_SIGNING_KEYS.
+//	@Tags			Config
+//	@Produce		json
+//	@Param			id	path		string	true	"Id da chave."
+//	@Success		200	{array}		config.WebhookSigningKey
+//	@Failure		422	{object}	map[string]string
+//	@Router			/config/webhook-keys/{id} [delete]
+func (cc *ConfigController) RemoveWebhookSigningKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := config.RemoveWebhookSigningKey(c.Params("id")); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(config.ListWebhookSigningKeys())
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DLQController struct {
+	loc        *time.Location
+	dlqService DLQService
+}
+
+func NewDLQController(loc *time.Location, dlqService DLQService) *DLQController {
+	return &DLQController{
+		loc:        loc,
+		dlqService: dlqService,
+	}
+}
+
+func (d *DLQController) GetPrefix() string {
+	return "dlq"
+}
+
+func (d *DLQController) Route(r fiber.Router) {
+	r.Get("/", d.ListEntries())
+	r.Get("/:id", d.GetEntry())
+	r.Get("/:id/reprocessamentos", d.ListReplays())
+	r.Post("/:id/reprocessamento", d.Replay())
+}
+
+// ListEntries godoc
+//
+//	@Summary		Listar mensagens da DLQ.
+//	@Description	Lista as mensagens da DLQ por contexto, parcela e/ou intervalo de tempo.
+//	@Tags			DLQ
+//	@Produce		json
+//	@Param			contexto			query		string	false	"Contexto da mensagem."
+//	@Param			id_proposta_parcela	query		int		false	"Id da proposta parcela."
+//	@Param			inicio				query		string	false	"Data/hora inicial(RFC3339 ou 2006-01-02)."
+//	@Param			fim					query		string	false	"Data/hora final(RFC3339 ou 2006-01-02)."
+//	@Param			limit				query		int		false	"Quantidade máxima de registros(padrão 100)."
+//	@Param			offset				query		int		false	"Deslocamento."
+//	@Success		200					{array}		models.DLQEntry
+//	@Failure		422					{object}	models.APIError
+//	@Router			/dlq [get]
+func (d *DLQController) ListEntries() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter models.DLQEntryFilter
+		if err := c.QueryParser(&filter); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Parâmetros inválidos: "+err.Error(), ""))
+		}
+
+		inicio, err := parseQueryTime(c.Query("inicio"), d.loc)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data inicial inválida", ""))
+		}
+		fim, err := parseQueryTime(c.Query("fim"), d.loc)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data final inválida", ""))
+		}
+		filter.Inicio = inicio
+		filter.Fim = fim
+
+		entries, err := d.dlqService.FindEntries(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar mensagens da DLQ", ""))
+		}
+		return c.JSON(entries)
+	}
+}
+
+// GetEntry godoc
+//
+//	@Summary		Buscar mensagem da DLQ.
+//	@Description	Retorna uma mensagem da DLQ com o payload original.
+//	@Tags			DLQ
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da mensagem."
+//	@Success		200	{object}	models.DLQEntry
+//	@Failure		404	{object}	models.APIError
+//	@Router			/dlq/{id} [get]
+func (d *DLQController) GetEntry() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		entry, err := d.dlqService.FindEntry(id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Mensagem não encontrada", ""))
+		}
+		return c.JSON(entry)
+	}
+}
+
+// ListReplays godoc
+//
+//	@Summary		Listar reprocessamentos de mensagem da DLQ.
+//	@Description	Retorna a auditoria dos reprocessamentos de uma mensagem da DLQ: quem reprocessou, para qual fila e com qual payload.
+//	@Tags			DLQ
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da mensagem."
+//	@Success		200	{array}		models.DLQReplay
+//	@Failure		422	{object}	models.APIError
+//	@Router			/dlq/{id}/reprocessamentos [get]
+func (d *DLQController) ListReplays() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		replays, err := d.dlqService.FindReplays(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar reprocessamentos", ""))
+		}
+		return c.JSON(replays)
+	}
+}
+
+// Replay godoc
+//
+//	@Summary		Reprocessar mensagem da DLQ.
+//	@Description	Republica o payload(original ou editado) de uma mensagem da DLQ na fila de cobrança, consulta, webhook ou db. Sem fila informada, ela é inferida pelo contexto da mensagem.
+//	@Tags			DLQ
+//	@Accept			json
+//	@Produce		json
+//	@Param			Api-Key	header		string					true	"Chave de API do operador(DLQ_OPERADORES)."
+//	@Param			id		path		int						true	"Id da mensagem."
+//	@Param			body	body		models.DLQReplayInput	true	"Dados do reprocessamento."
+//	@Success		200		{object}	models.DLQReplay
+//	@Failure		401		{object}	models.APIError
+//	@Failure		404		{object}	models.APIError
+//	@Failure		422		{object}	models.APIError
+//	@Router			/dlq/{id}/reprocessamento [post]
+func (d *DLQController) Replay() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		usuario, ok := config.GetDLQOperador(c.Get("Api-Key"))
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.NewAPIError("", "Api-Key ausente ou inválida", ""))
+		}
+
+		var input models.DLQReplayInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError(config.ERR_PARSE_STR, helpers.ParseJsonError(err.Error()), ""))
+		}
+		if err := helpers.StructValidate(input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", err.Error(), ""))
+		}
+		//O usuário da auditoria é sempre o operador autenticado
+		input.Usuario = usuario
+
+		if _, err := d.dlqService.FindEntry(id); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Mensagem não encontrada", ""))
+		}
+
+		replay, err := d.dlqService.Replay(id, input)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Não foi possível reprocessar a mensagem: "+err.Error(), ""))
+		}
+		return c.JSON(replay)
+	}
+}
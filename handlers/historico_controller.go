@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"cobranca-bmp/models"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type HistoricoController struct {
+	historicoService HistoricoService
+}
+
+func NewHistoricoController(historicoService HistoricoService) *HistoricoController {
+	return &HistoricoController{
+		historicoService: historicoService,
+	}
+}
+
+func (h *HistoricoController) GetPrefix() string {
+	return "parcelas"
+}
+
+func (h *HistoricoController) Route(r fiber.Router) {
+	r.Get("/:id/historico", h.GetHistorico())
+}
+
+// GetHistorico godoc
+//
+//	@Summary		Histórico da parcela.
+//	@Description	Retorna em ordem cronológica as operações solicitadas, as chamadas ao BMP, os eventos recebidos do BMP, os webhooks enviados e as alterações gravadas na parcela.
+//	@Tags			Parcelas
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da proposta parcela."
+//	@Success		200	{array}		models.CobrancaHistorico
+//	@Failure		422	{object}	models.APIError
+//	@Failure		500	{object}	models.APIError
+//	@Router			/parcelas/{id}/historico [get]
+func (h *HistoricoController) GetHistorico() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil || id <= 0 {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		historico, err := h.historicoService.FindHistorico(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao buscar histórico da parcela", c.Params("id")))
+		}
+		return c.JSON(historico)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"cobranca-bmp/models"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type LancamentoController struct {
+	lancamentoService LancamentoService
+}
+
+func NewLancamentoController(lancamentoService LancamentoService) *LancamentoController {
+	return &LancamentoController{
+		lancamentoService: lancamentoService,
+	}
+}
+
+func (l *LancamentoController) GetPrefix() string {
+	return "lancamentos"
+}
+
+func (l *LancamentoController) Route(r fiber.Router) {
+	r.Get("/parcelas/:id", l.GetLancamentosParcela())
+	r.Get("/propostas/:id", l.GetLancamentosProposta())
+}
+
+// GetLancamentosParcela godoc
+//
+//	@Summary		Lançamentos da parcela.
+//	@Description	Retorna o total pago, o saldo em aberto e o histórico de pagamentos, descontos e ajustes de saldo da parcela, calculados a partir dos eventos de lançamento recebidos do BMP.
+//	@Tags			Lancamentos
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da proposta parcela."
+//	@Success		200	{object}	models.LancamentosParcela
+//	@Failure		422	{object}	models.APIError
+//	@Failure		500	{object}	models.APIError
+//	@Router			/lancamentos/parcelas/{id} [get]
+func (l *LancamentoController) GetLancamentosParcela() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil || id <= 0 {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		lancamentos, err := l.lancamentoService.FindLancamentosParcela(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao buscar lançamentos da parcela", c.Params("id")))
+		}
+		return c.JSON(lancamentos)
+	}
+}
+
+// GetLancamentosProposta godoc
+//
+//	@Summary		Lançamentos da proposta.
+//	@Description	Retorna o total pago e o saldo em aberto da proposta e de cada parcela com lançamentos, e o histórico de pagamentos, descontos e ajustes de saldo, calculados a partir dos eventos de lançamento recebidos do BMP.
+//	@Tags			Lancamentos
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da proposta."
+//	@Success		200	{object}	models.LancamentosProposta
+//	@Failure		422	{object}	models.APIError
+//	@Failure		500	{object}	models.APIError
+//	@Router			/lancamentos/propostas/{id} [get]
+func (l *LancamentoController) GetLancamentosProposta() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil || id <= 0 {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		lancamentos, err := l.lancamentoService.FindLancamentosProposta(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao buscar lançamentos da proposta", c.Params("id")))
+		}
+		return c.JSON(lancamentos)
+	}
+}
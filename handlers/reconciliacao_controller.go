@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"cobranca-bmp/models"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReconciliacaoController struct {
+	loc                  *time.Location
+	reconciliacaoService ReconciliacaoService
+}
+
+func NewReconciliacaoController(loc *time.Location, reconciliacaoService ReconciliacaoService) *ReconciliacaoController {
+	return &ReconciliacaoController{
+		loc:                  loc,
+		reconciliacaoService: reconciliacaoService,
+	}
+}
+
+func (r *ReconciliacaoController) GetPrefix() string {
+	return "reconciliacoes"
+}
+
+func (r *ReconciliacaoController) Route(router fiber.Router) {
+	router.Get("/", r.ListReconciliacoes())
+	router.Post("/", r.IniciarReconciliacao())
+	router.Get("/:id", r.GetReconciliacao())
+	router.Get("/:id/divergencias", r.ListDivergencias())
+}
+
+// ListReconciliacoes godoc
+//
+//	@Summary		Listar execuções da reconciliação.
+//	@Description	Lista as execuções da reconciliação das cobranças em aberto com o BMP, da mais recente para a mais antiga.
+//	@Tags			Reconciliacao
+//	@Produce		json
+//	@Param			status	query		string	false	"Status da execução(executando, concluida ou falha)."
+//	@Param			inicio	query		string	false	"Início mínimo da execução(RFC3339 ou 2006-01-02)."
+//	@Param			fim		query		string	false	"Início máximo da execução(RFC3339 ou 2006-01-02)."
+//	@Param			limit	query		int		false	"Quantidade máxima de registros(padrão 100)."
+//	@Param			offset	query		int		false	"Deslocamento."
+//	@Success		200		{array}		models.Reconciliacao
+//	@Failure		422		{object}	models.APIError
+//	@Router			/reconciliacoes [get]
+func (r *ReconciliacaoController) ListReconciliacoes() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter models.ReconciliacaoFilter
+		if err := c.QueryParser(&filter); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Parâmetros inválidos: "+err.Error(), ""))
+		}
+
+		inicio, err := parseQueryTime(c.Query("inicio"), r.loc)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data inicial inválida", ""))
+		}
+		fim, err := parseQueryTime(c.Query("fim"), r.loc)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data final inválida", ""))
+		}
+		filter.Inicio = inicio
+		filter.Fim = fim
+
+		reconciliacoes, err := r.reconciliacaoService.FindReconciliacoes(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar execuções da reconciliação", ""))
+		}
+		return c.JSON(reconciliacoes)
+	}
+}
+
+// IniciarReconciliacao godoc
+//
+//	@Summary		Iniciar reconciliação.
+//	@Description	Inicia uma execução manual da reconciliação, realizada em segundo plano. Acompanhe o andamento e o relatório de divergências pelo id retornado.
+//	@Tags			Reconciliacao
+//	@Produce		json
+//	@Success		202	{object}	models.Reconciliacao
+//	@Failure		409	{object}	models.APIError
+//	@Router			/reconciliacoes [post]
+func (r *ReconciliacaoController) IniciarReconciliacao() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		reconciliacao, err := r.reconciliacaoService.Iniciar(models.RECONCILIACAO_ORIGEM_MANUAL)
+		if errors.Is(err, models.ErrReconciliacaoEmAndamento) {
+			return c.Status(fiber.StatusConflict).JSON(models.NewAPIError("", "Já existe uma reconciliação em andamento", ""))
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao iniciar a reconciliação: "+err.Error(), ""))
+		}
+		return c.Status(fiber.StatusAccepted).JSON(reconciliacao)
+	}
+}
+
+// GetReconciliacao godoc
+//
+//	@Summary		Buscar execução da reconciliação.
+//	@Description	Retorna uma execução da reconciliação com os totais de parcelas verificadas, divergências e correções.
+//	@Tags			Reconciliacao
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da execução."
+//	@Success		200	{object}	models.Reconciliacao
+//	@Failure		404	{object}	models.APIError
+//	@Router			/reconciliacoes/{id} [get]
+func (r *ReconciliacaoController) GetReconciliacao() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		reconciliacao, err := r.reconciliacaoService.FindReconciliacao(id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Execução não encontrada", ""))
+		}
+		return c.JSON(reconciliacao)
+	}
+}
+
+// ListDivergencias godoc
+//
+//	@Summary		Listar divergências da reconciliação.
+//	@Description	Relatório das divergências entre a base local e o BMP encontradas em uma execução, com a correção aplicada em cada uma.
+//	@Tags			Reconciliacao
+//	@Produce		json
+//	@Param			id					path		int		true	"Id da execução."
+//	@Param			id_proposta_parcela	query		int		false	"Id da proposta parcela."
+//	@Param			tipo				query		string	false	"Tipo da divergência(pagamento, boleto, cobranca_ausente ou parcela_ausente)."
+//	@Param			pendentes			query		bool	false	"Apenas as divergências não corrigidas."
+//	@Param			limit				query		int		false	"Quantidade máxima de registros(padrão 100)."
+//	@Param			offset				query		int		false	"Deslocamento."
+//	@Success		200					{array}		models.ReconciliacaoDivergencia
+//	@Failure		422					{object}	models.APIError
+//	@Router			/reconciliacoes/{id}/divergencias [get]
+func (r *ReconciliacaoController) ListDivergencias() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		var filter models.ReconciliacaoDivergenciaFilter
+		if err := c.QueryParser(&filter); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Parâmetros inválidos: "+err.Error(), ""))
+		}
+		filter.IdReconciliacao = id
+
+		divergencias, err := r.reconciliacaoService.FindDivergencias(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar divergências", ""))
+		}
+		return c.JSON(divergencias)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 type WebhookService interface {
 	RequestToWebhook(data models.WebhookTaskData) error
+	Outbox(destino models.WebhookDestino, event models.WebhookEvent, contexto string) ([]models.OutboxMessage, error)
 	SendToDLQ(data any)
 }
 
@@ -19,10 +20,64 @@ type CobrancaService interface {
 	Cobranca(payload *models.CobrancaTaskData) (any, string, int, error)
 	//CancelarCobranca(payload *models.CobrancaTaskData) (any, string, int, error)
 	FindByCodLiquidacao(codigoLiquidacao string, numeroCCB int) (models.CobrancaBMP, error)
+	FindByNumeroBoleto(numeroBoleto int, numeroCCB int) (models.CobrancaBMP, error)
 	FindByNumParcela(numParcela int, numeroCCB int) (models.CobrancaBMP, error)
 	FindByDataVencimento(dataExpiracao string, numeroCCB int) (models.CobrancaBMP, error)
-	UpdateCodLiquidacao(idPropostaParcela int, codigoLiquidacao string) (bool, error)
-	UpdateNumeroBoleto(idPropostaParcela, numeroBoleto int) (bool, error)
+	UpdateCodLiquidacao(idPropostaParcela int, codigoLiquidacao string, version int64) (bool, error)
+	UpdateNumeroBoleto(idPropostaParcela, numeroBoleto int, version int64) (bool, error)
+	UpdateLiquidacaoStatus(idPropostaParcela int, codigoLiquidacao string, numeroBoleto int, status string) error
 	FindByIdPropostaParcela(idPropostaParcela int) (models.CobrancaBMP, error)
 	SendToDLQ(data any) error
+	Enqueue(payload *models.CobrancaTaskData) error
+	ValidateOperacao(idPropostaParcela int, operacao string) error
+	TransitionStatus(cobranca models.CobrancaBMP, to string, origem string, outbox ...models.OutboxMessage) error
+	WithParcelaLock(idPropostaParcela int, fn func()) error
+	SaveOutbox(mensagens []models.OutboxMessage)
+	RecordOperacao(payload *models.CobrancaTaskData)
+	RecordHistorico(entry models.CobrancaHistorico, payload any)
+	RecordLancamento(cobranca models.CobrancaBMP, evento models.EventoLancamentoParcela) error
+	SearchCobrancas(filter models.CobrancaFilter) (models.CobrancaPage, error)
+}
+
+// TaskRunner executa tarefas em segundo plano acompanhadas pelo encerramento do serviço.
+// requeue devolve o trabalho caso a tarefa não tenha iniciado até o prazo do encerramento.
+type TaskRunner interface {
+	Go(name string, fn func(), requeue func())
+}
+
+type WebhookDeliveryService interface {
+	FindDeliveries(filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+	FindDelivery(id int64) (models.WebhookDelivery, error)
+	ResendDelivery(id int64) error
+}
+
+type WebhookSubscriptionService interface {
+	FindSubscriptions(filter models.WebhookSubscriptionFilter) ([]models.WebhookSubscription, error)
+	FindSubscription(id int64) (models.WebhookSubscription, error)
+	CreateSubscription(data models.WebhookSubscription) (models.WebhookSubscription, error)
+	UpdateSubscription(data models.WebhookSubscription) (models.WebhookSubscription, error)
+	DeleteSubscription(id int64) error
+}
+
+type HistoricoService interface {
+	FindHistorico(idPropostaParcela int) ([]models.CobrancaHistorico, error)
+}
+
+type DLQService interface {
+	FindEntries(filter models.DLQEntryFilter) ([]models.DLQEntry, error)
+	FindEntry(id int64) (models.DLQEntry, error)
+	FindReplays(id int64) ([]models.DLQReplay, error)
+	Replay(id int64, input models.DLQReplayInput) (models.DLQReplay, error)
+}
+
+type ReconciliacaoService interface {
+	Iniciar(origem string) (models.Reconciliacao, error)
+	FindReconciliacao(id int64) (models.Reconciliacao, error)
+	FindReconciliacoes(filter models.ReconciliacaoFilter) ([]models.Reconciliacao, error)
+	FindDivergencias(filter models.ReconciliacaoDivergenciaFilter) ([]models.ReconciliacaoDivergencia, error)
+}
+
+type LancamentoService interface {
+	FindLancamentosParcela(idPropostaParcela int) (models.LancamentosParcela, error)
+	FindLancamentosProposta(idProposta int) (models.LancamentosProposta, error)
 }
@@ -6,12 +6,90 @@ import (
 	"cobranca-bmp/models"
 	"context"
 	"encoding/json"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// Descrição dos eventos do BMP registrados no histórico da parcela.
+var eventosCobranca = map[int]string{
+	3: "lançamento na parcela",
+	5: "geração de boleto",
+	6: "cancelamento de boleto",
+	8: "registro de cobrança",
+	9: "cancelamento de pix",
+}
+
+// recordEvento registra no histórico da parcela o evento recebido do BMP.
+func (w *WebhookController) recordEvento(cobrancaInfo models.CobrancaBMP, tipoEvento int, body any) {
+	w.cobrancaService.RecordHistorico(models.CobrancaHistorico{
+		IdPropostaParcela: cobrancaInfo.IdPropostaParcela,
+		Tipo:              models.HISTORICO_EVENTO_BMP,
+		Ator:              models.HISTORICO_ATOR_BMP,
+		Operacao:          eventosCobranca[tipoEvento],
+		Mensagem:          "Evento " + strconv.Itoa(tipoEvento) + " recebido do BMP",
+	}, body)
+}
+
+// findByBoleto busca a parcela pelo boleto do evento, inclusive por um boleto substituído do histórico de liquidações, e,
+// sem boleto ou sem resultado, pelo número da parcela. substituido indica que o boleto do evento não é mais o boleto atual da parcela.
+func (w *WebhookController) findByBoleto(numeroBoleto, numParcela, numeroCCB int) (models.CobrancaBMP, bool, error) {
+	if numeroBoleto > 0 {
+		if cobranca, err := w.cobrancaService.FindByNumeroBoleto(numeroBoleto, numeroCCB); err == nil {
+			return cobranca, cobranca.NumeroBoleto != numeroBoleto, nil
+		}
+	}
+	cobranca, err := w.cobrancaService.FindByNumParcela(numParcela, numeroCCB)
+	return cobranca, false, err
+}
+
+// findByCodLiquidacao busca a parcela pelo código de liquidação do evento como findByBoleto.
+func (w *WebhookController) findByCodLiquidacao(codigoLiquidacao string, numParcela, numeroCCB int) (models.CobrancaBMP, bool, error) {
+	if codigoLiquidacao != "" {
+		if cobranca, err := w.cobrancaService.FindByCodLiquidacao(codigoLiquidacao, numeroCCB); err == nil {
+			return cobranca, cobranca.CodigoLiquidacao != codigoLiquidacao, nil
+		}
+	}
+	cobranca, err := w.cobrancaService.FindByNumParcela(numParcela, numeroCCB)
+	return cobranca, false, err
+}
+
+// Número de vezes que a gravação do boleto é repetida quando outra operação grava a parcela entre a leitura e a gravação.
+const tentativasVersaoBoleto = 3
+
+// updateNumeroBoleto grava o boleto do evento condicionado à versão lida da parcela. Se outra operação gravou a parcela
+// depois da leitura, a parcela é lida novamente e a gravação repetida. Retorna a parcela lida na última tentativa e
+// models.ErrVersaoConcorrente se o boleto não pôde ser gravado.
+func (w *WebhookController) updateNumeroBoleto(cobrancaInfo models.CobrancaBMP, numeroBoleto int) (models.CobrancaBMP, error) {
+	for tentativa := 1; ; tentativa++ {
+		_, err := w.cobrancaService.UpdateNumeroBoleto(cobrancaInfo.IdPropostaParcela, numeroBoleto, cobrancaInfo.Version)
+		if !errors.Is(err, models.ErrVersaoConcorrente) || tentativa >= tentativasVersaoBoleto {
+			return cobrancaInfo, err
+		}
+		atual, findErr := w.cobrancaService.FindByIdPropostaParcela(cobrancaInfo.IdPropostaParcela)
+		if findErr != nil {
+			return cobrancaInfo, err
+		}
+		cobrancaInfo = atual
+	}
+}
+
+// withParcelaLock executa fn com o lock da parcela, para que o evento não seja processado durante uma geração, um cancelamento
+// ou um lançamento da mesma parcela. O evento que não obtém o lock vai para a DLQ, para ser reprocessado.
+func (w *WebhookController) withParcelaLock(idPropostaParcela int, body any, fn func()) {
+	if err := w.cobrancaService.WithParcelaLock(idPropostaParcela, fn); err != nil {
+		w.webhookService.SendToDLQ(models.DLQData{
+			Payload:  body,
+			Mensagem: "Parcela com outra operação em andamento ao processar o evento",
+			Erro:     err.Error(),
+			Contexto: "webhook cobranças",
+			Time:     time.Now().In(w.location),
+		})
+	}
+}
+
 func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 	return func(c *fiber.Ctx) error {
@@ -37,7 +115,7 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 		}
 
-		go func() {
+		w.tasks.Go("webhook cobranças", func() {
 			var input *models.EventoCobranca
 			var bodyProcessed bool = false
 
@@ -131,24 +209,76 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					}
 
-					var whData = make(map[string]any)
+					w.recordEvento(cobrancaInfo, input.TipoEvento, resp)
 
-					if lancamento.LancamentoParcela.VlrDesconto >= 0 {
+					//O lançamento, o status e o webhook são gravados com o lock da parcela, com a parcela lida novamente após obtê-lo
+					w.withParcelaLock(cobrancaInfo.IdPropostaParcela, resp, func() {
+						if atual, err := w.cobrancaService.FindByNumParcela(lancamento.LancamentoParcela.NroParcela, input.NroProposta); err == nil {
+							cobrancaInfo = atual
+						}
 
-						whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
-						whData["data_pagamento"] = lancamento.DtEvento
-						whData["valor_pago"] = lancamento.LancamentoParcela.VlrPagamento
-						whData["valor_encargo"] = lancamento.LancamentoParcela.VlrEncargos
-						whData["valor_desconto"] = lancamento.LancamentoParcela.VlrDesconto
-						whData["operacao"] = "P"
-					} else {
-						whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
-						whData["saldo"] = lancamento.LancamentoParcela.VlrSaldo
-						whData["operacao"] = "S"
+						//O lançamento é gravado uma única vez por evento; o evento reentregue segue o processamento normalmente
+						if err := w.cobrancaService.RecordLancamento(cobrancaInfo, lancamento); err != nil && !errors.Is(err, models.ErrLancamentoDuplicado) {
+							var dlqData = models.DLQData{
+								Payload:  resp,
+								Mensagem: "Erro ao gravar lançamento da parcela",
+								Erro:     err.Error(),
+								Contexto: "webhook cobranças",
+								Time:     time.Now().In(w.location),
+							}
+
+							w.webhookService.SendToDLQ(dlqData)
+						}
 
-					}
+						var whData = make(map[string]any)
+						var eventData models.ParcelaLancamentoData
+
+						if lancamento.LancamentoParcela.VlrDesconto >= 0 {
+
+							whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
+							whData["data_pagamento"] = lancamento.DtEvento
+							whData["valor_pago"] = lancamento.LancamentoParcela.VlrPagamento
+							whData["valor_encargo"] = lancamento.LancamentoParcela.VlrEncargos
+							whData["valor_desconto"] = lancamento.LancamentoParcela.VlrDesconto
+							whData["operacao"] = "P"
+							eventData = models.ParcelaLancamentoData{
+								Operacao:      "P",
+								DataPagamento: lancamento.DtEvento,
+								ValorPago:     lancamento.LancamentoParcela.VlrPagamento,
+								ValorEncargo:  lancamento.LancamentoParcela.VlrEncargos,
+								ValorDesconto: lancamento.LancamentoParcela.VlrDesconto,
+								Saldo:         lancamento.LancamentoParcela.VlrSaldoAtual,
+							}
+						} else {
+							whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
+							whData["saldo"] = lancamento.LancamentoParcela.VlrSaldo
+							whData["operacao"] = "S"
+							eventData = models.ParcelaLancamentoData{
+								Operacao: "S",
+								Saldo:    lancamento.LancamentoParcela.VlrSaldo,
+							}
 
-					w.webhookService.RequestToWebhook(models.NewWebhookTaskData(cobrancaInfo.UrlWebhook, whData, "cancelamento-cobranca"))
+						}
+
+						//O webhook é gravado no outbox na mesma transação do status
+						event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_PARCELA_LANCAMENTO, cobrancaInfo.IdPropostaParcela, eventData, whData)
+						outbox, _ := w.webhookService.Outbox(cobrancaInfo.WebhookDestino(), event, "cancelamento-cobranca")
+
+						if eventData.Operacao == "P" && lancamento.LancamentoParcela.VlrPagamento > 0 {
+							//O boleto pago, que pode já ter sido substituído, é encerrado como pago no histórico de liquidações
+							if nroBoleto := lancamento.LancamentoParcela.Boleto.NroBoleto; nroBoleto != nil && *nroBoleto > 0 {
+								w.cobrancaService.UpdateLiquidacaoStatus(cobrancaInfo.IdPropostaParcela, "", *nroBoleto, models.LIQUIDACAO_STATUS_PAGA)
+							}
+							//A quitação da parcela é a informada pelo BMP no evento, e não a inferida do saldo
+							status := models.COBRANCA_STATUS_PAGA_PARCIAL
+							if lancamento.LancamentoParcela.ParcelaLiquidada {
+								status = models.COBRANCA_STATUS_PAGA
+							}
+							w.cobrancaService.TransitionStatus(cobrancaInfo, status, "evento de lançamento na parcela", outbox...)
+						} else {
+							w.cobrancaService.SaveOutbox(outbox)
+						}
+					})
 
 					return
 
@@ -182,6 +312,8 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					}
 
+					w.recordEvento(cobrancaInfo, input.TipoEvento, resp)
+
 					var authPAyload = models.AuthPayload{
 						IdConvenio:       cobrancaInfo.IdConvenio,
 						IdSecuritizadora: cobrancaInfo.IdSecuritizadora,
@@ -189,6 +321,7 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					cobrancaPayload := models.NewCobrancaTastkData(w.cache.GenID(), config.STATUS_CONSULTAR_COBRANCA, cobrancaInfo.IdProposta, cobrancaInfo.NumeroAcompanhamento, authPAyload)
 					cobrancaPayload.CobrancaDBInfo = cobrancaInfo
+					cobrancaPayload.Origem = models.HISTORICO_ATOR_BMP
 					cobrancaPayload.IdPropostaParcela = cobrancaInfo.IdPropostaParcela
 					cobrancaPayload.ConsultarCobrancaInput = models.ConsultarDetalhesInput{
 						DTO: models.DtoCobranca{
@@ -201,12 +334,24 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 						},
 					}
 
-					cobrancaPayload.WebhookUrl = cobrancaInfo.UrlWebhook
 					cobrancaPayload.CalledAssync = true
 					cobrancaPayload.NumeroBoleto = geracaoBoleto.GeracaoBoleto.NroBoleto
 
 					cobrancaPayload.SwitchCobrancaMode()
-					w.cobrancaService.UpdateNumeroBoleto(cobrancaInfo.IdPropostaParcela, geracaoBoleto.GeracaoBoleto.NroBoleto)
+					//Condicionado à versão lida, para não sobrescrever uma operação gravada depois da busca
+					cobrancaInfo, err = w.updateNumeroBoleto(cobrancaInfo, geracaoBoleto.GeracaoBoleto.NroBoleto)
+					if errors.Is(err, models.ErrVersaoConcorrente) {
+						w.webhookService.SendToDLQ(models.DLQData{
+							Payload:  resp,
+							Mensagem: "Parcela alterada por outra operação ao gravar o boleto gerado",
+							Erro:     err.Error(),
+							Contexto: "webhook cobranças",
+							Time:     time.Now().In(w.location),
+						})
+						return
+					}
+					cobrancaPayload.CobrancaDBInfo = cobrancaInfo
+					w.cobrancaService.TransitionStatus(cobrancaInfo, models.COBRANCA_STATUS_BOLETO_EMITIDO, "evento de geração de boleto")
 					w.cobrancaService.Cobranca(cobrancaPayload)
 
 					return
@@ -215,7 +360,7 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 					var cancelamento models.EventoCancelamentoBoleto
 					json.Unmarshal(rawBody, &cancelamento)
 					bodyProcessed = true
-					cobrancaInfo, err := w.cobrancaService.FindByNumParcela(cancelamento.CancelamentoBoleto.Parcelas[0], input.NroProposta)
+					cobrancaInfo, substituido, err := w.findByBoleto(cancelamento.CancelamentoBoleto.NroBoleto, cancelamento.CancelamentoBoleto.Parcelas[0], input.NroProposta)
 					if err != nil {
 						var dlqData = models.DLQData{
 							Payload:  resp,
@@ -229,12 +374,30 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					}
 
-					var whData = make(map[string]any)
-					whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
-					whData["codigo_liquidacao"] = cobrancaInfo.CodigoLiquidacao
-					whData["operacao"] = "C"
-					w.webhookService.RequestToWebhook(models.NewWebhookTaskData(cobrancaInfo.UrlWebhook, whData, "cancelamento-cobranca"))
-					w.cobrancaService.UpdateCodLiquidacao(cobrancaInfo.IdPropostaParcela, "")
+					w.recordEvento(cobrancaInfo, input.TipoEvento, resp)
+
+					//O cancelamento é gravado com o lock da parcela, com a parcela lida novamente após obtê-lo
+					w.withParcelaLock(cobrancaInfo.IdPropostaParcela, resp, func() {
+						if atual, atualSubstituido, err := w.findByBoleto(cancelamento.CancelamentoBoleto.NroBoleto, cancelamento.CancelamentoBoleto.Parcelas[0], input.NroProposta); err == nil {
+							cobrancaInfo, substituido = atual, atualSubstituido
+						}
+
+						//Cancelamento de um boleto já substituído: apenas o histórico de liquidações é atualizado e a cobrança atual segue ativa
+						if substituido {
+							w.cobrancaService.UpdateLiquidacaoStatus(cobrancaInfo.IdPropostaParcela, "", cancelamento.CancelamentoBoleto.NroBoleto, models.LIQUIDACAO_STATUS_CANCELADA)
+							return
+						}
+
+						var whData = make(map[string]any)
+						whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
+						whData["codigo_liquidacao"] = cobrancaInfo.CodigoLiquidacao
+						whData["operacao"] = "C"
+						event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_COBRANCA_CANCELADA, cobrancaInfo.IdPropostaParcela, models.CobrancaCanceladaData{
+							CodigoLiquidacao: cobrancaInfo.CodigoLiquidacao,
+						}, whData)
+						outbox, _ := w.webhookService.Outbox(cobrancaInfo.WebhookDestino(), event, "cancelamento-cobranca")
+						w.cobrancaService.TransitionStatus(cobrancaInfo, models.COBRANCA_STATUS_CANCELADA, "evento de cancelamento", outbox...)
+					})
 
 					return
 
@@ -280,6 +443,8 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					}
 
+					w.recordEvento(cobrancaInfo, input.TipoEvento, resp)
+
 					var authPAyload = models.AuthPayload{
 						IdConvenio:       cobrancaInfo.IdConvenio,
 						IdSecuritizadora: cobrancaInfo.IdSecuritizadora,
@@ -287,6 +452,7 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					cobrancaPayload := models.NewCobrancaTastkData(w.cache.GenID(), config.STATUS_CONSULTAR_COBRANCA, cobrancaInfo.IdProposta, cobrancaInfo.NumeroAcompanhamento, authPAyload)
 					cobrancaPayload.CobrancaDBInfo = cobrancaInfo
+					cobrancaPayload.Origem = models.HISTORICO_ATOR_BMP
 					cobrancaPayload.IdPropostaParcela = cobrancaInfo.IdPropostaParcela
 					cobrancaPayload.ConsultarCobrancaInput = models.ConsultarDetalhesInput{
 						DTO: models.DtoCobranca{
@@ -299,16 +465,26 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 						},
 					}
 
-					cobrancaPayload.WebhookUrl = cobrancaInfo.UrlWebhook
-
 					if registroCobranca.GeracaoCobranca.NroBoleto != nil {
-						w.cobrancaService.UpdateNumeroBoleto(cobrancaInfo.IdPropostaParcela, *registroCobranca.GeracaoCobranca.NroBoleto)
+						cobrancaInfo, err = w.updateNumeroBoleto(cobrancaInfo, *registroCobranca.GeracaoCobranca.NroBoleto)
+						if errors.Is(err, models.ErrVersaoConcorrente) {
+							w.webhookService.SendToDLQ(models.DLQData{
+								Payload:  resp,
+								Mensagem: "Parcela alterada por outra operação ao gravar o boleto registrado",
+								Erro:     err.Error(),
+								Contexto: "webhook cobranças",
+								Time:     time.Now().In(w.location),
+							})
+							return
+						}
+						cobrancaPayload.CobrancaDBInfo = cobrancaInfo
 						cobrancaPayload.NumeroBoleto = *registroCobranca.GeracaoCobranca.NroBoleto
 					}
 
 					cobrancaPayload.SwitchCobrancaMode()
 					cobrancaPayload.CalledAssync = true
 
+					w.cobrancaService.TransitionStatus(cobrancaInfo, models.COBRANCA_STATUS_REGISTRADA, "evento de registro de cobrança")
 					w.cobrancaService.Cobranca(cobrancaPayload)
 
 				case 9: //Cancelamento de pix
@@ -316,7 +492,7 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 					json.Unmarshal(rawBody, &cancelamento)
 					SearchMode = "parcelas"
 					bodyProcessed = true
-					cobrancaInfo, err := w.cobrancaService.FindByNumParcela(cancelamento.CancelamentoCobranca.Parcelas[0], input.NroProposta)
+					cobrancaInfo, substituida, err := w.findByCodLiquidacao(cancelamento.CancelamentoCobranca.CodigoLiquidacao, cancelamento.CancelamentoCobranca.Parcelas[0], input.NroProposta)
 					if err != nil {
 						var dlqData = models.DLQData{
 							Payload:  resp,
@@ -331,13 +507,31 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 					}
 
-					var whData = make(map[string]any)
-					whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
-					whData["codigo_liquidacao"] = cobrancaInfo.CodigoLiquidacao
-					whData["operacao"] = "C"
+					w.recordEvento(cobrancaInfo, input.TipoEvento, resp)
 
-					w.webhookService.RequestToWebhook(models.NewWebhookTaskData(cobrancaInfo.UrlWebhook, whData, "cancelamento-cobranca"))
-					w.cobrancaService.UpdateCodLiquidacao(cobrancaInfo.IdPropostaParcela, "")
+					//O cancelamento é gravado com o lock da parcela, com a parcela lida novamente após obtê-lo
+					w.withParcelaLock(cobrancaInfo.IdPropostaParcela, resp, func() {
+						if atual, atualSubstituida, err := w.findByCodLiquidacao(cancelamento.CancelamentoCobranca.CodigoLiquidacao, cancelamento.CancelamentoCobranca.Parcelas[0], input.NroProposta); err == nil {
+							cobrancaInfo, substituida = atual, atualSubstituida
+						}
+
+						//Cancelamento de uma liquidação já substituída: apenas o histórico de liquidações é atualizado
+						if substituida {
+							w.cobrancaService.UpdateLiquidacaoStatus(cobrancaInfo.IdPropostaParcela, cancelamento.CancelamentoCobranca.CodigoLiquidacao, 0, models.LIQUIDACAO_STATUS_CANCELADA)
+							return
+						}
+
+						var whData = make(map[string]any)
+						whData["id_proposta_parcela"] = cobrancaInfo.IdPropostaParcela
+						whData["codigo_liquidacao"] = cobrancaInfo.CodigoLiquidacao
+						whData["operacao"] = "C"
+
+						event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_COBRANCA_CANCELADA, cobrancaInfo.IdPropostaParcela, models.CobrancaCanceladaData{
+							CodigoLiquidacao: cobrancaInfo.CodigoLiquidacao,
+						}, whData)
+						outbox, _ := w.webhookService.Outbox(cobrancaInfo.WebhookDestino(), event, "cancelamento-cobranca")
+						w.cobrancaService.TransitionStatus(cobrancaInfo, models.COBRANCA_STATUS_CANCELADA, "evento de cancelamento", outbox...)
+					})
 
 					return
 
@@ -397,7 +591,19 @@ func (w *WebhookController) WebhookCobranca() fiber.Handler {
 
 			}
 
-		}()
+		}, func() {
+			//Evento não iniciado até o prazo do encerramento: enviado para a DLQ para reprocessamento
+			var payload any = string(rawBody)
+			if json.Valid(rawBody) {
+				payload = json.RawMessage(rawBody)
+			}
+			w.webhookService.SendToDLQ(models.DLQData{
+				Payload:  payload,
+				Mensagem: "Evento não processado até o encerramento do serviço",
+				Contexto: "webhook cobranças",
+				Time:     time.Now().In(w.location),
+			})
+		})
 
 		return c.JSON(fiber.Map{"success": "payload recebido"})
 
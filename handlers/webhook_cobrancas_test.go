@@ -0,0 +1,508 @@
+package handlers
+
+import (
+	"cobranca-bmp/cache"
+	"cobranca-bmp/config"
+	"cobranca-bmp/models"
+	"cobranca-bmp/repository"
+	"cobranca-bmp/service"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Executa as tarefas imediatamente, para que o evento esteja processado quando a resposta for recebida.
+type syncTasks struct{}
+
+func (syncTasks) Go(name string, fn func(), requeue func()) { fn() }
+
+// Producer que registra as mensagens enviadas para as filas.
+type fakeQueue struct {
+	mu       sync.Mutex
+	produced map[string][]any
+}
+
+func (q *fakeQueue) Produce(queue string, data any, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.produced == nil {
+		q.produced = make(map[string][]any)
+	}
+	q.produced[queue] = append(q.produced[queue], data)
+	return nil
+}
+
+func (q *fakeQueue) count(queue string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.produced[queue])
+}
+
+// Repositório de lançamentos em memória, com a mesma unicidade da chave do evento do banco.
+type fakeLancamentoRepo struct {
+	lancamentos []models.Lancamento
+}
+
+func (r *fakeLancamentoRepo) Insert(data models.Lancamento) (bool, error) {
+	for _, l := range r.lancamentos {
+		if l.ChaveEvento == data.ChaveEvento {
+			return false, models.ErrLancamentoDuplicado
+		}
+	}
+	data.Id = int64(len(r.lancamentos) + 1)
+	r.lancamentos = append(r.lancamentos, data)
+	return false, nil
+}
+
+func (r *fakeLancamentoRepo) FindByIdPropostaParcela(idPropostaParcela int) ([]models.Lancamento, error) {
+	var lancamentos []models.Lancamento
+	for _, l := range r.lancamentos {
+		if l.IdPropostaParcela == idPropostaParcela {
+			lancamentos = append(lancamentos, l)
+		}
+	}
+	return lancamentos, nil
+}
+
+func (r *fakeLancamentoRepo) FindByIdProposta(idProposta int) ([]models.Lancamento, error) {
+	var lancamentos []models.Lancamento
+	for _, l := range r.lancamentos {
+		if l.IdProposta == idProposta {
+			lancamentos = append(lancamentos, l)
+		}
+	}
+	return lancamentos, nil
+}
+
+// Repositório em memória que simula gravações de outras operações entre a leitura e a gravação do boleto.
+type concorrenteParcelaRepo struct {
+	*repository.MemoryParcelaRepo
+	conflitos int
+}
+
+func (r *concorrenteParcelaRepo) UpdateNumeroBoleto(idPropostaParcela, numeroBoleto int, version int64) (bool, error) {
+	if r.conflitos > 0 {
+		r.conflitos--
+		if _, err := r.MemoryParcelaRepo.UpdateCodLiquidacao(idPropostaParcela, "COD-CONCORRENTE", version); err != nil {
+			return false, err
+		}
+	}
+	return r.MemoryParcelaRepo.UpdateNumeroBoleto(idPropostaParcela, numeroBoleto, version)
+}
+
+// Lock em memória com as chaves em uso por outras operações.
+type fakeLocker struct {
+	mu       sync.Mutex
+	held     map[string]string
+	acquired int
+}
+
+func (l *fakeLocker) Lock(key string, ttl, wait time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = make(map[string]string)
+	}
+	if _, ok := l.held[key]; ok {
+		return "", cache.ErrLockOcupado
+	}
+	l.acquired++
+	l.held[key] = key
+	return key, nil
+}
+
+func (l *fakeLocker) Unlock(key, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[key] == token {
+		delete(l.held, key)
+	}
+	return nil
+}
+
+type webhookCobrancasTest struct {
+	app         *fiber.App
+	controller  *WebhookController
+	cobrancas   *service.CobrancalService
+	repo        *repository.MemoryParcelaRepo
+	parcelas    *concorrenteParcelaRepo
+	queue       *fakeQueue
+	lancamentos *service.LancamentoService
+}
+
+// newWebhookCobrancasTest monta o webhook de cobranças com os services reais sobre o repositório em memória.
+func newWebhookCobrancasTest(t *testing.T) webhookCobrancasTest {
+	t.Helper()
+	config.API_KEY = "api-key-teste"
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := repository.NewMemoryParcelaRepo(time.UTC)
+	parcelas := &concorrenteParcelaRepo{MemoryParcelaRepo: repo}
+	queue := &fakeQueue{}
+
+	updateService := service.NewUpdateService(logger, time.UTC, parcelas)
+	updateService.SetProducer(queue)
+	webhookService := service.NewWebhookService(nil, nil, nil, logger, time.UTC)
+	webhookService.SetProducer(queue)
+	cobrancaService := service.NewCobrancaService(context.Background(), logger, time.UTC, nil, webhookService, nil, parcelas, updateService)
+	cobrancaService.SetProducer(queue)
+	lancamentoService := service.NewLancamentoService(&fakeLancamentoRepo{}, logger, time.UTC)
+	cobrancaService.SetLancamentos(lancamentoService)
+
+	controller := NewWebhookController(logger, nil, time.UTC, webhookService, cobrancaService, syncTasks{})
+	app := fiber.New()
+	controller.Route(app.Group(controller.GetPrefix()))
+
+	_, err := repo.UpdateGeracaoCobranca(models.GerarCobrancaFrontendInput{
+		IdPropostaParcela:    1001,
+		IdProposta:           10,
+		NumeroAcompanhamento: "ACP-10",
+		NumeroCCB:            555,
+		NumeroParcela:        1,
+		DataVencimento:       "2025-01-10",
+		DataExpiracao:        "2025-01-10",
+		UrlWebhook:           "https://cliente/webhook",
+		TipoCobranca:         config.TIPO_COBRANCA_BOLETO,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGeracaoCobranca: %v", err)
+	}
+	for _, step := range [][2]string{
+		{models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO},
+		{models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO},
+	} {
+		_, version, _ := repo.FindStatus(1001)
+		if _, err := repo.UpdateStatus(1001, step[0], step[1], version); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+	}
+
+	return webhookCobrancasTest{app: app, controller: controller, cobrancas: cobrancaService, repo: repo, parcelas: parcelas, queue: queue, lancamentos: lancamentoService}
+}
+
+func (w webhookCobrancasTest) post(t *testing.T, apiKey string, body any) int {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(fiber.MethodPost, "/webhook", strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Api-Key", apiKey)
+	}
+	resp, err := w.app.Test(req)
+	if err != nil {
+		t.Fatalf("requisição ao webhook: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func (w webhookCobrancasTest) status(t *testing.T) string {
+	t.Helper()
+	status, _, err := w.repo.FindStatus(1001)
+	if err != nil {
+		t.Fatalf("FindStatus: %v", err)
+	}
+	return status
+}
+
+// webhookEnviado retorna o evento gravado no outbox junto com o status.
+func (w webhookCobrancasTest) webhookEnviado(t *testing.T) map[string]any {
+	t.Helper()
+	mensagens := w.repo.Outbox()
+	if len(mensagens) != 1 {
+		t.Fatalf("outbox = %d mensagens, esperada uma", len(mensagens))
+	}
+	var task models.WebhookTaskData
+	if err := json.Unmarshal(mensagens[0].Payload, &task); err != nil {
+		t.Fatalf("payload do outbox: %v", err)
+	}
+	if task.Url != "https://cliente/webhook" {
+		t.Fatalf("url do webhook = %q", task.Url)
+	}
+	data, ok := task.Data.(map[string]any)
+	if !ok {
+		t.Fatalf("dados do webhook = %T", task.Data)
+	}
+	return data
+}
+
+// lancamentoEvento monta um evento de lançamento. O BMP informa a parcela como liquidada quando o saldo é quitado.
+func lancamentoEvento(nroParcela int, pagamento, saldoAtual float64) map[string]any {
+	return lancamentoEventoLiquidada(nroParcela, pagamento, saldoAtual, saldoAtual <= 0)
+}
+
+func lancamentoEventoLiquidada(nroParcela int, pagamento, saldoAtual float64, liquidada bool) map[string]any {
+	return map[string]any{
+		"TipoEvento":  3,
+		"NomeEvento":  "LancamentoParcela",
+		"NroProposta": 555,
+		"DtEvento":    "2025-01-10",
+		"LancamentoParcela": map[string]any{
+			"NroParcela":       nroParcela,
+			"VlrPagamento":     pagamento,
+			"VlrSaldoAtual":    saldoAtual,
+			"ParcelaLiquidada": liquidada,
+		},
+	}
+}
+
+func TestWebhookCobrancaApiKey(t *testing.T) {
+	w := newWebhookCobrancasTest(t)
+	if status := w.post(t, "", lancamentoEvento(1, 100, 0)); status != fiber.StatusUnauthorized {
+		t.Fatalf("sem Api-Key: status %d", status)
+	}
+	if status := w.post(t, "outra", lancamentoEvento(1, 100, 0)); status != fiber.StatusUnauthorized {
+		t.Fatalf("Api-Key inválida: status %d", status)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_BOLETO_EMITIDO {
+		t.Fatalf("evento processado sem Api-Key válida: status %q", s)
+	}
+}
+
+func TestWebhookCobrancaLancamento(t *testing.T) {
+	var cases = []struct {
+		name       string
+		pagamento  float64
+		saldoAtual float64
+		liquidada  bool
+		want       string
+	}{
+		{"pagamento total", 100, 0, true, models.COBRANCA_STATUS_PAGA},
+		{"pagamento parcial", 40, 60, false, models.COBRANCA_STATUS_PAGA_PARCIAL},
+		{"saldo zerado sem liquidação", 100, 0, false, models.COBRANCA_STATUS_PAGA_PARCIAL},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newWebhookCobrancasTest(t)
+			if status := w.post(t, config.API_KEY, lancamentoEventoLiquidada(1, c.pagamento, c.saldoAtual, c.liquidada)); status != fiber.StatusOK {
+				t.Fatalf("status HTTP %d", status)
+			}
+			if s := w.status(t); s != c.want {
+				t.Fatalf("status = %q, esperado %q", s, c.want)
+			}
+			if evento := w.webhookEnviado(t); evento["id_proposta_parcela"] != float64(1001) {
+				t.Fatalf("webhook enviado = %v", evento)
+			}
+		})
+	}
+}
+
+func TestWebhookCobrancaLancamentoLedger(t *testing.T) {
+	w := newWebhookCobrancasTest(t)
+
+	//O pagamento parcial é reentregue pelo BMP e gravado uma única vez
+	for _, body := range []map[string]any{lancamentoEvento(1, 40, 60), lancamentoEvento(1, 40, 60), lancamentoEvento(1, 60, 0)} {
+		if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+			t.Fatalf("status HTTP %d", status)
+		}
+	}
+
+	parcela, err := w.lancamentos.FindLancamentosParcela(1001)
+	if err != nil {
+		t.Fatalf("FindLancamentosParcela: %v", err)
+	}
+	if parcela.Lancamentos != 2 || parcela.TotalPago != 100 || parcela.Saldo != 0 || parcela.Historico[0].Tipo != models.LANCAMENTO_TIPO_PAGAMENTO {
+		t.Fatalf("lançamentos da parcela = %+v", parcela)
+	}
+
+	proposta, err := w.lancamentos.FindLancamentosProposta(10)
+	if err != nil {
+		t.Fatalf("FindLancamentosProposta: %v", err)
+	}
+	if proposta.TotalPago != 100 || len(proposta.Parcelas) != 1 || len(proposta.Historico) != 2 {
+		t.Fatalf("lançamentos da proposta = %+v", proposta)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_PAGA {
+		t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_PAGA)
+	}
+}
+
+func TestWebhookCobrancaCancelamentoBoleto(t *testing.T) {
+	w := newWebhookCobrancasTest(t)
+	body := map[string]any{
+		"TipoEvento":         6,
+		"NomeEvento":         "CancelamentoBoleto",
+		"NroProposta":        555,
+		"DtEvento":           "2025-01-10",
+		"CancelamentoBoleto": map[string]any{"NroBoleto": 123, "Parcelas": []int{1}},
+	}
+	if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+		t.Fatalf("status HTTP %d", status)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_CANCELADA {
+		t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_CANCELADA)
+	}
+	if evento := w.webhookEnviado(t); evento["id_proposta_parcela"] != float64(1001) {
+		t.Fatalf("webhook enviado = %v", evento)
+	}
+
+	//Evento reentregue pelo BMP: o status não muda e o webhook é enviado novamente
+	if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+		t.Fatalf("status HTTP %d", status)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_CANCELADA {
+		t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_CANCELADA)
+	}
+	//Sem repositório de outbox configurado, o webhook é publicado diretamente na fila
+	if n := w.queue.count(config.WEBHOOK_QUEUE); n != 1 {
+		t.Fatalf("mensagens na fila de webhook = %d, esperada uma", n)
+	}
+}
+
+func TestWebhookCobrancaCancelamentoLock(t *testing.T) {
+	body := map[string]any{
+		"TipoEvento":         6,
+		"NomeEvento":         "CancelamentoBoleto",
+		"NroProposta":        555,
+		"DtEvento":           "2025-01-10",
+		"CancelamentoBoleto": map[string]any{"NroBoleto": 123, "Parcelas": []int{1}},
+	}
+
+	t.Run("lock obtido e liberado", func(t *testing.T) {
+		w := newWebhookCobrancasTest(t)
+		locker := &fakeLocker{}
+		w.cobrancas.SetLocker(locker)
+
+		if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+			t.Fatalf("status HTTP %d", status)
+		}
+		if s := w.status(t); s != models.COBRANCA_STATUS_CANCELADA {
+			t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_CANCELADA)
+		}
+		if locker.acquired != 1 || len(locker.held) != 0 {
+			t.Fatalf("lock obtido %d vezes, %d não liberados", locker.acquired, len(locker.held))
+		}
+	})
+
+	t.Run("parcela com outra operação em andamento", func(t *testing.T) {
+		w := newWebhookCobrancasTest(t)
+		w.cobrancas.SetLocker(&fakeLocker{held: map[string]string{"lock:parcela:1001": "outra"}})
+
+		if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+			t.Fatalf("status HTTP %d", status)
+		}
+		//O evento não altera a parcela e vai para a DLQ, para ser reprocessado após a operação em andamento
+		if s := w.status(t); s != models.COBRANCA_STATUS_BOLETO_EMITIDO {
+			t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+		}
+		if n := len(w.repo.Outbox()); n != 0 {
+			t.Fatalf("outbox = %d mensagens, esperada nenhuma", n)
+		}
+		if n := w.queue.count(config.DLQ_QUEUE); n != 1 {
+			t.Fatalf("mensagens na DLQ = %d, esperada uma", n)
+		}
+	})
+}
+
+func TestWebhookCobrancaCancelamentoBoletoSubstituido(t *testing.T) {
+	w := newWebhookCobrancasTest(t)
+	for _, emissao := range []struct {
+		codigo string
+		boleto int
+	}{{"LIQ-1", 123}, {"LIQ-2", 456}} {
+		if _, err := w.repo.UpdateCodLiquidacao(1001, emissao.codigo, 0); err != nil {
+			t.Fatalf("UpdateCodLiquidacao: %v", err)
+		}
+		if _, err := w.repo.UpdateNumeroBoleto(1001, emissao.boleto, 0); err != nil {
+			t.Fatalf("UpdateNumeroBoleto: %v", err)
+		}
+	}
+
+	//Cancelamento do boleto substituído recebido depois da reemissão: a cobrança atual segue ativa
+	body := map[string]any{
+		"TipoEvento":         6,
+		"NomeEvento":         "CancelamentoBoleto",
+		"NroProposta":        555,
+		"DtEvento":           "2025-01-10",
+		"CancelamentoBoleto": map[string]any{"NroBoleto": 123, "Parcelas": []int{1}},
+	}
+	if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+		t.Fatalf("status HTTP %d", status)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_BOLETO_EMITIDO {
+		t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+	}
+	if n := w.queue.count(config.WEBHOOK_QUEUE); n != 0 {
+		t.Fatalf("mensagens na fila de webhook = %d, esperada nenhuma", n)
+	}
+	liquidacoes, _ := w.repo.FindLiquidacoes(555)
+	if len(liquidacoes) != 2 || liquidacoes[0].Status != models.LIQUIDACAO_STATUS_CANCELADA || liquidacoes[1].Status != models.LIQUIDACAO_STATUS_ATIVA {
+		t.Fatalf("histórico de liquidações = %+v", liquidacoes)
+	}
+
+	//Cancelamento do boleto atual cancela a cobrança
+	body["CancelamentoBoleto"] = map[string]any{"NroBoleto": 456, "Parcelas": []int{1}}
+	if status := w.post(t, config.API_KEY, body); status != fiber.StatusOK {
+		t.Fatalf("status HTTP %d", status)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_CANCELADA {
+		t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_CANCELADA)
+	}
+	liquidacoes, _ = w.repo.FindLiquidacoes(555)
+	if len(liquidacoes) != 2 || liquidacoes[1].Status != models.LIQUIDACAO_STATUS_CANCELADA {
+		t.Fatalf("histórico de liquidações = %+v", liquidacoes)
+	}
+}
+
+func TestWebhookCobrancaParcelaNaoEncontrada(t *testing.T) {
+	w := newWebhookCobrancasTest(t)
+	if status := w.post(t, config.API_KEY, lancamentoEvento(9, 100, 0)); status != fiber.StatusOK {
+		t.Fatalf("status HTTP %d", status)
+	}
+	if n := w.queue.count(config.DLQ_QUEUE); n != 1 {
+		t.Fatalf("mensagens na DLQ = %d, esperada uma", n)
+	}
+	if s := w.status(t); s != models.COBRANCA_STATUS_BOLETO_EMITIDO {
+		t.Fatalf("status = %q, esperado %q", s, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+	}
+}
+
+func TestWebhookCobrancaNumeroBoletoVersaoConcorrente(t *testing.T) {
+	var cases = []struct {
+		name      string
+		conflitos int
+		wantErr   error
+		boleto    int
+	}{
+		{"sem concorrência", 0, nil, 777},
+		{"parcela relida após gravação concorrente", 1, nil, 777},
+		{"concorrência em todas as tentativas", tentativasVersaoBoleto, models.ErrVersaoConcorrente, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newWebhookCobrancasTest(t)
+			cobrancaInfo, err := w.repo.FindByIdPropostaParcela(1001)
+			if err != nil {
+				t.Fatalf("FindByIdPropostaParcela: %v", err)
+			}
+
+			w.parcelas.conflitos = c.conflitos
+			atual, err := w.controller.updateNumeroBoleto(cobrancaInfo, 777)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("updateNumeroBoleto = %v, esperado %v", err, c.wantErr)
+			}
+			if c.conflitos > 0 && c.wantErr == nil && atual.Version == cobrancaInfo.Version {
+				t.Fatalf("parcela não relida após a gravação concorrente: versão %d", atual.Version)
+			}
+
+			gravada, err := w.repo.FindByIdPropostaParcela(1001)
+			if err != nil {
+				t.Fatalf("FindByIdPropostaParcela: %v", err)
+			}
+			if gravada.NumeroBoleto != c.boleto {
+				t.Fatalf("numero_boleto = %d, esperado %d", gravada.NumeroBoleto, c.boleto)
+			}
+		})
+	}
+}
@@ -14,16 +14,18 @@ type WebhookController struct {
 	webhookService  WebhookService
 	cobrancaService CobrancaService
 	cache           *cache.RedisCache
+	tasks           TaskRunner
 }
 
 func NewWebhookController(logger *slog.Logger, cache *cache.RedisCache,
-	location *time.Location, webhookService WebhookService, cobrancaCreditoPessoalService CobrancaService,
+	location *time.Location, webhookService WebhookService, cobrancaCreditoPessoalService CobrancaService, tasks TaskRunner,
 ) *WebhookController {
 	return &WebhookController{
 		location:        location,
 		logger:          logger,
 		webhookService:  webhookService,
 		cobrancaService: cobrancaCreditoPessoalService,
+		tasks:           tasks,
 
 		cache: cache,
 	}
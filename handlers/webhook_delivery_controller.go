@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"cobranca-bmp/models"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookDeliveryController struct {
+	loc             *time.Location
+	deliveryService WebhookDeliveryService
+}
+
+func NewWebhookDeliveryController(loc *time.Location, deliveryService WebhookDeliveryService) *WebhookDeliveryController {
+	return &WebhookDeliveryController{
+		loc:             loc,
+		deliveryService: deliveryService,
+	}
+}
+
+func (w *WebhookDeliveryController) GetPrefix() string {
+	return "webhook-entregas"
+}
+
+func (w *WebhookDeliveryController) Route(r fiber.Router) {
+	r.Get("/", w.ListDeliveries())
+	r.Get("/:id", w.GetDelivery())
+	r.Post("/:id/reenvio", w.ResendDelivery())
+}
+
+// ListDeliveries godoc
+//
+//	@Summary		Listar entregas de webhook.
+//	@Description	Lista as tentativas de entrega de webhooks por parcela, URL e/ou intervalo de tempo.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id_proposta_parcela	query		int		false	"Id da proposta parcela."
+//	@Param			url					query		string	false	"URL do webhook."
+//	@Param			inicio				query		string	false	"Data/hora inicial(RFC3339 ou 2006-01-02)."
+//	@Param			fim					query		string	false	"Data/hora final(RFC3339 ou 2006-01-02)."
+//	@Param			limit				query		int		false	"Quantidade máxima de registros(padrão 100)."
+//	@Param			offset				query		int		false	"Deslocamento."
+//	@Success		200					{array}		models.WebhookDelivery
+//	@Failure		422					{object}	models.APIError
+//	@Router			/webhook-entregas [get]
+func (w *WebhookDeliveryController) ListDeliveries() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter models.WebhookDeliveryFilter
+		if err := c.QueryParser(&filter); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Parâmetros inválidos: "+err.Error(), ""))
+		}
+
+		inicio, err := parseQueryTime(c.Query("inicio"), w.loc)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data inicial inválida", ""))
+		}
+		fim, err := parseQueryTime(c.Query("fim"), w.loc)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Data final inválida", ""))
+		}
+		filter.Inicio = inicio
+		filter.Fim = fim
+
+		deliveries, err := w.deliveryService.FindDeliveries(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar entregas de webhook", ""))
+		}
+		return c.JSON(deliveries)
+	}
+}
+
+// GetDelivery godoc
+//
+//	@Summary		Buscar entrega de webhook.
+//	@Description	Retorna uma tentativa de entrega de webhook, incluindo o payload enviado.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da entrega."
+//	@Success		200	{object}	models.WebhookDelivery
+//	@Failure		404	{object}	models.APIError
+//	@Router			/webhook-entregas/{id} [get]
+func (w *WebhookDeliveryController) GetDelivery() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		delivery, err := w.deliveryService.FindDelivery(id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Entrega não encontrada", ""))
+		}
+		return c.JSON(delivery)
+	}
+}
+
+// ResendDelivery godoc
+//
+//	@Summary		Reenviar entrega de webhook.
+//	@Description	Reenvia manualmente o payload de uma entrega registrada para a mesma URL.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da entrega."
+//	@Success		200	{object}	models.APIError
+//	@Failure		404	{object}	models.APIError
+//	@Failure		502	{object}	models.APIError
+//	@Router			/webhook-entregas/{id}/reenvio [post]
+func (w *WebhookDeliveryController) ResendDelivery() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		if err := w.deliveryService.ResendDelivery(id); err != nil {
+			if errors.Is(err, models.ErrEntregaNaoEncontrada) {
+				return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Entrega não encontrada", ""))
+			}
+			return c.Status(fiber.StatusBadGateway).JSON(models.NewAPIError("", "Falha no reenvio, nova tentativa agendada: "+err.Error(), ""))
+		}
+
+		var resp = models.NewAPIError("", "Webhook reenviado com sucesso", "")
+		resp.HasError = false
+		return c.JSON(resp)
+	}
+}
+
+// Converte um parâmetro de data/hora(RFC3339 ou 2006-01-02) para time.Time. Parâmetros vazios retornam o valor zero.
+func parseQueryTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookSubscriptionController struct {
+	subscriptionService WebhookSubscriptionService
+}
+
+func NewWebhookSubscriptionController(subscriptionService WebhookSubscriptionService) *WebhookSubscriptionController {
+	return &WebhookSubscriptionController{
+		subscriptionService: subscriptionService,
+	}
+}
+
+func (w *WebhookSubscriptionController) GetPrefix() string {
+	return "webhook-assinaturas"
+}
+
+func (w *WebhookSubscriptionController) Route(r fiber.Router) {
+	r.Get("/", w.ListSubscriptions())
+	r.Get("/:id", w.GetSubscription())
+	r.Post("/", w.CreateSubscription())
+	r.Put("/:id", w.UpdateSubscription())
+	r.Delete("/:id", w.DeleteSubscription())
+}
+
+// ListSubscriptions godoc
+//
+//	@Summary		Listar assinaturas de webhook.
+//	@Description	Lista as assinaturas de webhook por convênio e/ou securitizadora.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id_convenio			query		int	false	"Id do convênio."
+//	@Param			id_securitizadora	query		int	false	"Id da securitizadora."
+//	@Success		200					{array}		models.WebhookSubscription
+//	@Failure		422					{object}	models.APIError
+//	@Router			/webhook-assinaturas [get]
+func (w *WebhookSubscriptionController) ListSubscriptions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter models.WebhookSubscriptionFilter
+		if err := c.QueryParser(&filter); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Parâmetros inválidos: "+err.Error(), ""))
+		}
+
+		subscriptions, err := w.subscriptionService.FindSubscriptions(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao listar assinaturas de webhook", ""))
+		}
+		return c.JSON(subscriptions)
+	}
+}
+
+// GetSubscription godoc
+//
+//	@Summary		Buscar assinatura de webhook.
+//	@Description	Retorna uma assinatura de webhook.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da assinatura."
+//	@Success		200	{object}	models.WebhookSubscription
+//	@Failure		404	{object}	models.APIError
+//	@Router			/webhook-assinaturas/{id} [get]
+func (w *WebhookSubscriptionController) GetSubscription() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		subscription, err := w.subscriptionService.FindSubscription(id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Assinatura não encontrada", ""))
+		}
+		return c.JSON(subscription)
+	}
+}
+
+// CreateSubscription godoc
+//
+//	@Summary		Criar assinatura de webhook.
+//	@Description	Cadastra a URL que receberá os eventos de um convênio(e opcionalmente de uma securitizadora). Sem eventos informados, todos os eventos são assinados.
+//	@Tags			Webhook
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.WebhookSubscription	true	"Dados da assinatura."
+//	@Success		201		{object}	models.WebhookSubscription
+//	@Failure		422		{object}	models.APIError
+//	@Router			/webhook-assinaturas [post]
+func (w *WebhookSubscriptionController) CreateSubscription() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.WebhookSubscription
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError(config.ERR_PARSE_STR, helpers.ParseJsonError(err.Error()), ""))
+		}
+		if err := input.Validate(); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(err)
+		}
+
+		subscription, err := w.subscriptionService.CreateSubscription(input)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao gravar assinatura de webhook", ""))
+		}
+		return c.Status(fiber.StatusCreated).JSON(subscription)
+	}
+}
+
+// UpdateSubscription godoc
+//
+//	@Summary		Atualizar assinatura de webhook.
+//	@Description	Atualiza a URL, os eventos, o esquema de autenticação ou o status de uma assinatura.
+//	@Tags			Webhook
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Id da assinatura."
+//	@Param			body	body		models.WebhookSubscription	true	"Dados da assinatura."
+//	@Success		200		{object}	models.WebhookSubscription
+//	@Failure		404		{object}	models.APIError
+//	@Failure		422		{object}	models.APIError
+//	@Router			/webhook-assinaturas/{id} [put]
+func (w *WebhookSubscriptionController) UpdateSubscription() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		var input models.WebhookSubscription
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError(config.ERR_PARSE_STR, helpers.ParseJsonError(err.Error()), ""))
+		}
+		if err := input.Validate(); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(err)
+		}
+		input.Id = id
+
+		if _, err := w.subscriptionService.FindSubscription(id); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Assinatura não encontrada", ""))
+		}
+
+		subscription, err := w.subscriptionService.UpdateSubscription(input)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewAPIError("", "Erro ao atualizar assinatura de webhook", ""))
+		}
+		return c.JSON(subscription)
+	}
+}
+
+// DeleteSubscription godoc
+//
+//	@Summary		Remover assinatura de webhook.
+//	@Description	Remove uma assinatura de webhook. Para suspender temporariamente o envio, prefira atualizar a assinatura com ativo=false.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Id da assinatura."
+//	@Success		200	{object}	models.APIError
+//	@Failure		404	{object}	models.APIError
+//	@Router			/webhook-assinaturas/{id} [delete]
+func (w *WebhookSubscriptionController) DeleteSubscription() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(models.NewAPIError("", "Id inválido", ""))
+		}
+
+		if err := w.subscriptionService.DeleteSubscription(id); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewAPIError("", "Assinatura não encontrada", ""))
+		}
+
+		var resp = models.NewAPIError("", "Assinatura removida com sucesso", "")
+		resp.HasError = false
+		return c.JSON(resp)
+	}
+}
@@ -0,0 +1,229 @@
+package lifecycle
+
+import (
+	"cobranca-bmp/helpers"
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Etapa do encerramento. fn recebe o contexto com o prazo do encerramento.
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Estados de uma tarefa iniciada por Go.
+const (
+	taskPendente   int32 = iota //Aguardando o início de fn
+	taskExecutando              //fn em execução
+	taskDevolvida               //Devolvida pelo encerramento antes do início de fn, que não será mais executada
+)
+
+// Tarefa em segundo plano iniciada por Go. requeue é chamado caso ela não tenha iniciado até o prazo do encerramento.
+type task struct {
+	name    string
+	requeue func()
+	state   *atomic.Int32
+}
+
+// Summary é o resumo do encerramento registrado no log.
+type Summary struct {
+	Duration        string   `json:"duration"`
+	TimedOut        bool     `json:"timed_out"`        //Prazo atingido antes do fim do trabalho em andamento
+	TasksDone       int64    `json:"tasks_done"`       //Tarefas em segundo plano concluídas durante o encerramento
+	TasksRequeued   int      `json:"tasks_requeued"`   //Tarefas não iniciadas no prazo e devolvidas para processamento
+	TasksUnrequeued []string `json:"tasks_unrequeued"` //Tarefas não concluídas no prazo e sem como serem devolvidas
+	TasksRunning    []string `json:"tasks_running"`    //Tarefas em execução no prazo, não devolvidas para não serem executadas duas vezes
+	Errors          []string `json:"errors,omitempty"`
+}
+
+// Coordinator controla o encerramento do serviço, nesta ordem: para de aceitar requisições HTTP, para de consumir as filas,
+// aguarda o trabalho em andamento até o prazo, devolve o que não terminou e só então fecha producers, Redis e banco de dados.
+type Coordinator struct {
+	ctx      context.Context
+	logger   *slog.Logger
+	loc      *time.Location
+	timeout  time.Duration
+	mu       sync.Mutex
+	pending  map[int64]task
+	nextId   int64
+	done     atomic.Int64
+	expired  atomic.Bool
+	stopping []hook
+	draining []hook
+	closing  []hook
+}
+
+func NewCoordinator(ctx context.Context, logger *slog.Logger, loc *time.Location, timeout time.Duration) *Coordinator {
+	return &Coordinator{
+		ctx:     ctx,
+		logger:  logger,
+		loc:     loc,
+		timeout: timeout,
+		pending: make(map[int64]task),
+	}
+}
+
+// OnStop registra uma etapa que interrompe a entrada de trabalho(servidor HTTP, consumers). As etapas são executadas na ordem de registro.
+func (c *Coordinator) OnStop(name string, fn func(ctx context.Context) error) {
+	c.stopping = append(c.stopping, hook{name: name, fn: fn})
+}
+
+// OnDrain registra uma espera pelo trabalho em andamento(ex.: mensagens em processamento). As esperas são executadas em paralelo e limitadas pelo prazo.
+func (c *Coordinator) OnDrain(name string, fn func(ctx context.Context) error) {
+	c.draining = append(c.draining, hook{name: name, fn: fn})
+}
+
+// OnClose registra o fechamento de um recurso. Os fechamentos são executados na ordem de registro, depois da espera.
+func (c *Coordinator) OnClose(name string, fn func() error) {
+	c.closing = append(c.closing, hook{name: name, fn: func(context.Context) error { return fn() }})
+}
+
+// Go executa fn em segundo plano e acompanha sua execução durante o encerramento.
+// Caso fn não tenha iniciado até o prazo, requeue é chamado para devolver o trabalho(fila, DLQ) antes de os recursos serem fechados
+// e fn não é mais executada. Uma fn já em execução não é devolvida, pois seria executada novamente a partir da fila.
+// Depois do prazo, fn não é mais executada e o trabalho é devolvido imediatamente.
+func (c *Coordinator) Go(name string, fn func(), requeue func()) {
+	if c.expired.Load() {
+		if requeue != nil {
+			requeue()
+		}
+		return
+	}
+
+	var state = new(atomic.Int32)
+	c.mu.Lock()
+	c.nextId++
+	id := c.nextId
+	c.pending[id] = task{name: name, requeue: requeue, state: state}
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+		}()
+		//A tarefa já devolvida pelo encerramento não é executada
+		if !state.CompareAndSwap(taskPendente, taskExecutando) {
+			return
+		}
+		fn()
+		c.done.Add(1)
+	}()
+}
+
+// Pending retorna a quantidade de tarefas em segundo plano em execução.
+func (c *Coordinator) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Shutdown executa o encerramento e retorna o resumo, que também é registrado no log.
+func (c *Coordinator) Shutdown() Summary {
+	var start = time.Now()
+	var summary = Summary{TasksUnrequeued: make([]string, 0), TasksRunning: make([]string, 0)}
+	var doneBefore = c.done.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	helpers.LogInfo(c.ctx, c.logger, c.loc, "shutdown", "", "Iniciando encerramento", map[string]any{"prazo": c.timeout.String(), "tarefas": c.Pending()})
+
+	for _, h := range c.stopping {
+		c.run(ctx, h, &summary)
+	}
+
+	//Aguardando as tarefas em segundo plano e os consumers até o prazo
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var drainErrs = make([]string, 0)
+	for _, h := range c.draining {
+		wg.Add(1)
+		go func(h hook) {
+			defer wg.Done()
+			if err := h.fn(ctx); err != nil {
+				errMu.Lock()
+				drainErrs = append(drainErrs, h.name+": "+err.Error())
+				errMu.Unlock()
+			}
+		}(h)
+	}
+	//Tarefas podem ser iniciadas por requisições ainda em andamento, por isso a espera é feita pela contagem
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for c.Pending() > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+
+	var drained = make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		summary.TimedOut = true
+	}
+	c.expired.Store(true)
+
+	errMu.Lock()
+	summary.Errors = append(summary.Errors, drainErrs...)
+	errMu.Unlock()
+
+	//Devolvendo as tarefas que não iniciaram no prazo. As que estão em execução seguem até o fechamento dos recursos
+	c.mu.Lock()
+	var unfinished = make([]task, 0, len(c.pending))
+	for _, t := range c.pending {
+		unfinished = append(unfinished, t)
+	}
+	c.mu.Unlock()
+
+	for _, t := range unfinished {
+		if t.requeue == nil {
+			summary.TasksUnrequeued = append(summary.TasksUnrequeued, t.name)
+			continue
+		}
+		if !t.state.CompareAndSwap(taskPendente, taskDevolvida) {
+			summary.TasksRunning = append(summary.TasksRunning, t.name)
+			continue
+		}
+		t.requeue()
+		summary.TasksRequeued++
+	}
+
+	for _, h := range c.closing {
+		c.run(context.Background(), h, &summary)
+	}
+
+	summary.TasksDone = c.done.Load() - doneBefore
+	summary.Duration = time.Since(start).String()
+
+	if len(summary.Errors) > 0 || summary.TimedOut || len(summary.TasksUnrequeued) > 0 || len(summary.TasksRunning) > 0 {
+		helpers.LogWarn(c.ctx, c.logger, c.loc, "shutdown", "", "Encerramento concluído com pendências", summary.Errors, summary)
+	} else {
+		helpers.LogInfo(c.ctx, c.logger, c.loc, "shutdown", "", "Encerramento concluído", summary)
+	}
+	return summary
+}
+
+func (c *Coordinator) run(ctx context.Context, h hook, summary *Summary) {
+	if err := h.fn(ctx); err != nil {
+		summary.Errors = append(summary.Errors, h.name+": "+err.Error())
+		helpers.LogError(c.ctx, c.logger, c.loc, "shutdown", "", "Erro no encerramento de "+h.name, err.Error(), nil)
+		return
+	}
+	helpers.LogInfo(c.ctx, c.logger, c.loc, "shutdown", "", h.name+" encerrado", nil)
+}
@@ -7,8 +7,7 @@ import (
 	"cobranca-bmp/db"
 	"cobranca-bmp/handlers"
 	"cobranca-bmp/helpers"
-	"cobranca-bmp/logfile"
-	"cobranca-bmp/models"
+	"cobranca-bmp/lifecycle"
 	"cobranca-bmp/monitoring"
 	"cobranca-bmp/queue"
 	"cobranca-bmp/repository"
@@ -19,6 +18,7 @@ import (
 	"os"
 	"os/signal"
 	"slices"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -95,7 +95,6 @@ func main() {
 	clientLogger := slog.New(helpers.NewECSJSONHandler(slog.LevelInfo, loc))
 	elegibilidadeServiceLogger := slog.New(helpers.NewECSJSONHandler(slog.LevelInfo, loc))
 	webhookLogger := slog.New(helpers.NewECSJSONHandler(slog.LevelDebug, loc))
-	logFileLogger := slog.New(helpers.NewECSJSONHandler(slog.LevelInfo, loc))
 	fiberLogger := slog.New(helpers.NewECSJSONHandler(slog.LevelInfo, loc))
 	slog.SetDefault(slog.New(helpers.NewECSJSONHandler(slog.LevelInfo, loc)))
 
@@ -104,18 +103,44 @@ func main() {
 		helpers.LogInfo(ctx, slog.Default(), loc, "main", "", "Programa encerrado", nil)
 	}()
 
+	//Instanciando o coordenador do encerramento, que acompanhará as tarefas em segundo plano e fechará os recursos na ordem correta
+	lifecycleCoordinator := lifecycle.NewCoordinator(ctx, slog.Default(), loc, config.SHUTDOWN_TIMEOUT)
+
 	//Se conectando ao banco de dados
 	database, err := db.Open(config.DB_URL)
 	if err != nil {
 		log.Fatalf("Erro ao se conectar com o banco de dados: %s", err.Error())
 	}
 
+	//Migrations do schema: subcomando "migrate [up|down <n>|status]" ou, com DB_MIGRATE_ON_STARTUP, aplicadas na inicialização
+	migrator, err := db.NewMigrator(ctx, database, dbLogger, loc)
+	if err != nil {
+		log.Fatalf("Erro ao carregar migrations: %s", err.Error())
+	}
+	if i := slices.Index(os.Args, "migrate"); i >= 0 {
+		runMigrate(migrator, os.Args[i+1:])
+		database.Close()
+		return
+	}
+	if config.DB_MIGRATE_ON_STARTUP {
+		if _, err := migrator.Up(); err != nil {
+			log.Fatalf("Erro ao aplicar migrations: %s", err.Error())
+		}
+	}
+
 	//Instanciando repositórios
 	parcelaRepo := repository.NewParcelaRepo(ctx, database, dbLogger, loc)
+	webhookDeliveryRepo := repository.NewWebhookDeliveryRepo(ctx, database, dbLogger, loc)
+	webhookSubscriptionRepo := repository.NewWebhookSubscriptionRepo(ctx, database, dbLogger, loc)
+	dlqRepo := repository.NewDLQRepo(ctx, database, dbLogger, loc)
+	historicoRepo := repository.NewHistoricoRepo(ctx, database, dbLogger, loc)
+	outboxRepo := repository.NewOutboxRepo(ctx, database, dbLogger, loc)
+	reconciliacaoRepo := repository.NewReconciliacaoRepo(ctx, database, dbLogger, loc)
+	lancamentoRepo := repository.NewLancamentoRepo(ctx, database, dbLogger, loc)
 
 	//Instanciando um objeto que gerenciará o banco de dados e o injetará nos repositórios em caso de reconexão.
 	dbManager := db.NewDBManager(ctx, database, "postgres_Confiapay", loc, dbLogger, config.NewDBPoolConfigFromEnv(),
-		parcelaRepo)
+		parcelaRepo, webhookDeliveryRepo, webhookSubscriptionRepo, dlqRepo, historicoRepo, outboxRepo, reconciliacaoRepo, lancamentoRepo)
 
 	var prometheusDbCollectors = monitoring.PrometheusCollectors{
 		UtilizationPercent: dbPoolUtilizationPercent,
@@ -127,46 +152,60 @@ func main() {
 	poolMonitor := monitoring.NewPoolMonitor(dbManager, loc, dbLogger, &prometheusDbCollectors)
 	go poolMonitor.Start(ctx, config.DB_POOL_MONITORING_INTERVAL)
 
-	//shutdown do banco de dados
-	defer dbManager.Close()
+	//Instanciando o histórico das parcelas
+	historicoService := service.NewHistoricoService(historicoRepo, dbLogger, loc)
+
+	//Instanciando o registro dos lançamentos nas parcelas
+	lancamentoService := service.NewLancamentoService(lancamentoRepo, dbLogger, loc)
 
 	//Instanciando um serviço de atualização de propostas
 	updateCreditoPessoalService := service.NewUpdateService(dbLogger, loc, parcelaRepo)
-
-	//Instanciando um handler de arquivos
-	fileLoggerChan := make(chan models.DbLogfileData)
-	logFileHandler := logfile.NewLogFileHandler(ctx, logFileLogger, fileLoggerChan, loc, updateCreditoPessoalService)
-
-	go logFileHandler.Consume()
-	defer logFileHandler.Close()
+	updateCreditoPessoalService.SetHistorico(historicoService)
+	updateCreditoPessoalService.SetOutbox(outboxRepo)
 
 	//Configurando e startando o redis
-	redis, err := cache.NewRedis(config.REDIS_URL, redisLogger, ctx, loc, nil, config.REDIS_DBCH, nil)
+	redis, err := cache.NewRedis(config.REDIS_URL, redisLogger, ctx, loc, updateCreditoPessoalService, config.REDIS_DB_STREAM)
 	if err != nil {
 		log.Fatalf("Erro ao se conectar ao Redis: %s", err.Error())
 	}
-	/*if err := redis.SetSubscriber(); err != nil {
-		log.Fatalf("Erro ao setar subscriber no Redis: %s", err.Error())
+	//O stream do Redis recebe as atualizações quando a fila de atualização está indisponível
+	if err := redis.SetConsumerGroup(); err != nil {
+		log.Fatalf("Erro ao criar grupo de consumo no Redis: %s", err.Error())
 
 	}
 
-	go redis.Consume()*/
-	defer redis.Close()
+	go redis.Consume()
 
 	//Instanciando serviços de webhook
 	webhookClient := client.NewWebhookClient(ctx, loc, clientLogger)
-	webhookService := service.NewWebhookService(webhookClient, loc)
+	webhookService := service.NewWebhookService(webhookClient, webhookDeliveryRepo, webhookSubscriptionRepo, webhookLogger, loc)
+	webhookService.SetHistorico(historicoService)
 
 	//Instanciando clients que serão utilizado pelos services que precisam chamar a API do BMP.
 	cobrancaClient := client.NewCobrancaClient(ctx, loc, redis, clientLogger, config.BASE_URL, config.AUTH_URL)
 
 	//Instanciando serviços
 	updateService := service.NewUpdateService(dbLogger, loc, parcelaRepo)
+	updateService.SetHistorico(historicoService)
+	updateService.SetOutbox(outboxRepo)
+	dlqService := service.NewDLQService(dlqRepo, rmqLogger, loc)
 	cobrancaService := service.NewCobrancaService(ctx, elegibilidadeServiceLogger, loc, cobrancaClient, webhookService, redis, parcelaRepo, updateService)
-	rmq, err := queue.NewRMQ(
-		ctx, loc, redis, rmqLogger,
-		webhookService, cobrancaService, updateService,
-		config.RABBITMQ_QOS)
+	cobrancaService.SetHistorico(historicoService)
+	cobrancaService.SetOutbox(outboxRepo)
+	cobrancaService.SetLancamentos(lancamentoService)
+	//O backend em memória permite executar o serviço localmente sem o RabbitMQ
+	var rmq queue.Backend
+	if config.QUEUE_BACKEND == config.QUEUE_BACKEND_MEMORY {
+		rmq, err = queue.NewMemoryBroker(
+			ctx, loc, rmqLogger,
+			webhookService, cobrancaService, updateService, dlqService,
+			config.RABBITMQ_QOS)
+	} else {
+		rmq, err = queue.NewRMQ(
+			ctx, loc, redis, rmqLogger,
+			webhookService, cobrancaService, updateService, dlqService,
+			config.RABBITMQ_QOS)
+	}
 
 	if err != nil {
 		log.Fatalf("erro ao se conectar com as filas: %s", err.Error())
@@ -175,14 +214,27 @@ func main() {
 	//Cada fila será consumida em uma goroutine diferente e nonitore server monitorará a conexão com o RabbitMQ.
 
 	go rmq.ConsumeQueues()
-	defer rmq.Close()
+
+	//O relay publica nas filas as mensagens gravadas no outbox junto com as alterações na base de dados
+	outboxRelay := queue.NewOutboxRelay(ctx, loc, rmqLogger, rmq, outboxRepo)
+	go outboxRelay.Run()
+
+	//A reconciliação corrige as cobranças que ficaram divergentes do BMP por eventos ou webhooks perdidos
+	reconciliacaoService := service.NewReconciliacaoService(ctx, elegibilidadeServiceLogger, loc, cobrancaService, reconciliacaoRepo)
+	go reconciliacaoService.Run()
 
 	//Instanciando os controllers
 	healthChecker := monitoring.NewServicesHealthChecker(rmq, redis)
-	cobrancaController := handlers.NewCobrancaCreditoPessoalController(redis, cobrancaService, webhookService, loc)
+	cobrancaController := handlers.NewCobrancaCreditoPessoalController(redis, cobrancaService, webhookService, lifecycleCoordinator, loc)
 	configController := handlers.NewConfigController(rmq, dbManager, poolMonitor)
-	webhookController := handlers.NewWebhookController(clientLogger, redis, loc, webhookService, cobrancaService)
+	webhookController := handlers.NewWebhookController(clientLogger, redis, loc, webhookService, cobrancaService, lifecycleCoordinator)
 	monitoringControllers := handlers.NewMonitoringController(loc, dbManager, poolMonitor, healthChecker, redis, rmq)
+	webhookDeliveryController := handlers.NewWebhookDeliveryController(loc, webhookService)
+	webhookSubscriptionController := handlers.NewWebhookSubscriptionController(webhookService)
+	dlqController := handlers.NewDLQController(loc, dlqService)
+	historicoController := handlers.NewHistoricoController(historicoService)
+	reconciliacaoController := handlers.NewReconciliacaoController(loc, reconciliacaoService)
+	lancamentoController := handlers.NewLancamentoController(lancamentoService)
 
 	//Configurando o app do Fiber e suas rotas
 	app := fiber.New(fiber.Config{EnablePrintRoutes: true,
@@ -190,7 +242,8 @@ func main() {
 	})
 	config.ConfigRoutes(app, fiberLogger,
 		configController,
-		cobrancaController, monitoringControllers, webhookController)
+		cobrancaController, monitoringControllers, webhookController, webhookDeliveryController, webhookSubscriptionController, dlqController,
+		historicoController, reconciliacaoController, lancamentoController)
 
 	app.Get("/metrics", adaptor.HTTPHandler(prometheusHandler))
 	//Executando o app em uma goroutine separada
@@ -200,16 +253,64 @@ func main() {
 			log.Fatalf("Erro ao inicializar app do Fiber: %s", err.Error())
 		}
 	}()
-	//shutdown do app
-	defer func() {
-		err := app.Shutdown()
-		if err != nil {
-			helpers.LogError(ctx, webhookLogger, loc, "fiber", "", "Erro ao realizar shutdown de servidor", err, nil)
-			return
-		}
-		helpers.LogInfo(ctx, webhookLogger, loc, "fiber", "", "Shutdown de servidor realizado com sucesso", nil)
-	}()
+	//Encerramento: para de aceitar requisições e de consumir as filas, aguarda o trabalho em andamento e só então fecha os recursos
+	lifecycleCoordinator.OnStop("servidor HTTP", app.ShutdownWithContext)
+	lifecycleCoordinator.OnStop("consumers", rmq.StopConsuming)
+	lifecycleCoordinator.OnStop("stream do redis", redis.StopConsuming)
+	lifecycleCoordinator.OnStop("reconciliação", reconciliacaoService.Stop)
+	lifecycleCoordinator.OnStop("relay do outbox", outboxRelay.Stop)
+	lifecycleCoordinator.OnDrain("consumers", rmq.WaitConsumers)
+	lifecycleCoordinator.OnClose("rabbitmq", rmq.Close)
+	lifecycleCoordinator.OnClose("redis", redis.Close)
+	lifecycleCoordinator.OnClose("banco de dados", dbManager.Close)
 
 	<-sig
 
+	lifecycleCoordinator.Shutdown()
+
+}
+
+// Executa o subcomando migrate: up(padrão) aplica as pendentes, down <n> reverte as n últimas(padrão 1) e status lista as versões.
+func runMigrate(migrator *db.Migrator, args []string) {
+	action := "up"
+	if len(args) > 0 && args[0] != "nonlocal" {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("Erro ao aplicar migrations: %s", err.Error())
+		}
+		log.Printf("%d migrations aplicadas", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				log.Fatalf("Quantidade de migrations inválida: %s", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			log.Fatalf("Erro ao reverter migrations: %s", err.Error())
+		}
+		log.Printf("%d migrations revertidas", reverted)
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("Erro ao consultar migrations: %s", err.Error())
+		}
+		for _, item := range status {
+			applied := "pendente"
+			if item.AppliedAt != nil {
+				applied = item.AppliedAt.Format(time.RFC3339)
+			}
+			log.Printf("%04d_%s: %s", item.Version, item.Nome, applied)
+		}
+	default:
+		log.Fatalf("Ação de migrate inválida: %s(utilize up, down <n> ou status)", action)
+	}
 }
@@ -8,7 +8,7 @@ type CancelarCobrancaFrontendInput struct {
 	NumeroAcompanhamento string `json:"numero_acompanhamento" validate:"required"`
 	CodigoLiquidacao     string `json:"codigo_liquidacao" validate:"required" `
 	NumeroCCB            int    `json:"numero_ccb" validate:"required"`
-	UrlWebhook           string `json:"url_webhook" validate:"required,url,ping"`
+	UrlWebhook           string `json:"url_webhook" validate:"omitempty,url,ping"`
 	NumeroParcela        int    `json:"parcela" validate:"required"`
 	IdConvenio           int    `json:"id_convenio"`
 	IdSecuritizadora     int    `json:"id_securitizadora"`
@@ -1,5 +1,7 @@
 package models
 
+import "errors"
+
 type CobrancaBMP struct {
 	NumeroAcompanhamento string  `json:"numero_acompanhamento" validate:"required"`
 	NumeroCCB            int     `json:"numero_ccb" validate:"required"`
@@ -16,4 +18,19 @@ type CobrancaBMP struct {
 	IdConvenio           int     `json:"id_convenio"`
 	IdSecuritizadora     int     `json:"id_securitizadora"`
 	IdFormaCobranca      int     `json:"idFormaCobranca"`
+	Status               string  `json:"status"`
+	Version              int64   `json:"version"`
+}
+
+// WebhookDestino retorna os dados para resolver os destinos dos eventos da cobrança, utilizando a URL gravada como fallback.
+func (c CobrancaBMP) WebhookDestino() WebhookDestino {
+	return WebhookDestino{
+		IdConvenio:       c.IdConvenio,
+		IdSecuritizadora: c.IdSecuritizadora,
+		UrlFallback:      c.UrlWebhook,
+	}
 }
+
+// ErrVersaoConcorrente é retornado pelas atualizações condicionadas à versão da parcela quando outra operação gravou a
+// parcela depois da leitura. A atualização é descartada, pois foi feita sobre dados desatualizados.
+var ErrVersaoConcorrente = errors.New("parcela alterada por outra operação")
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Tipos de registro do histórico da parcela.
+const (
+	HISTORICO_OPERACAO    = "operacao"    //Operação solicitada para a parcela
+	HISTORICO_CHAMADA_BMP = "chamada_bmp" //Resultado de uma chamada à API do BMP
+	HISTORICO_EVENTO_BMP  = "evento_bmp"  //Evento recebido do BMP no webhook de cobranças
+	HISTORICO_WEBHOOK     = "webhook"     //Webhook enviado ao cliente
+	HISTORICO_DB          = "db"          //Alteração gravada em bmp_cobrancas
+)
+
+// Atores que originam os registros do histórico.
+const (
+	HISTORICO_ATOR_API       = "api"       //Cliente da API
+	HISTORICO_ATOR_BMP       = "bmp"       //Eventos enviados pelo BMP
+	HISTORICO_ATOR_SCHEDULER = "scheduler" //Reentregas agendadas pelas filas
+	HISTORICO_ATOR_SISTEMA   = "sistema"   //Ações internas do serviço
+)
+
+// Representa um registro do histórico de uma parcela.
+type CobrancaHistorico struct {
+	Id                int64           `json:"id"`
+	IdPropostaParcela int             `json:"id_proposta_parcela"`
+	Tipo              string          `json:"tipo"`
+	Ator              string          `json:"ator"`
+	Operacao          string          `json:"operacao,omitempty"`
+	IdempotencyKey    string          `json:"idempotency_key,omitempty"`
+	CorrelationId     string          `json:"correlation_id,omitempty"`
+	StatusCode        int             `json:"status_code,omitempty"`
+	Mensagem          string          `json:"mensagem,omitempty"`
+	Payload           json.RawMessage `json:"payload,omitempty" swaggertype:"object"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
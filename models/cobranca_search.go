@@ -0,0 +1,182 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Campos de ordenação da listagem de cobranças.
+const (
+	COBRANCA_SORT_CREATED_AT          = "created_at"
+	COBRANCA_SORT_UPDATED_AT          = "updated_at"
+	COBRANCA_SORT_DATA_VENCIMENTO     = "data_vencimento"
+	COBRANCA_SORT_ID_PROPOSTA_PARCELA = "id_proposta_parcela"
+)
+
+// Limites da página da listagem de cobranças.
+const (
+	COBRANCA_SEARCH_LIMIT_PADRAO = 50
+	COBRANCA_SEARCH_LIMIT_MAXIMO = 500
+)
+
+// Data utilizada na ordenação das cobranças sem data de vencimento, que ficam antes das demais na ordem crescente.
+const COBRANCA_VENCIMENTO_VAZIO = "0001-01-01"
+
+// Representa os filtros, a ordenação e a paginação da listagem de cobranças.
+type CobrancaFilter struct {
+	IdProposta       int             `query:"id_proposta"`
+	NumeroCCB        int             `query:"numero_ccb"`
+	IdConvenio       int             `query:"id_convenio"`
+	IdSecuritizadora int             `query:"id_securitizadora"`
+	IdFormaCobranca  int             `query:"id_forma_cobranca"`
+	Status           []string        `query:"-"`
+	VencimentoInicio time.Time       `query:"-"`
+	VencimentoFim    time.Time       `query:"-"`
+	CriadoInicio     time.Time       `query:"-"`
+	CriadoFim        time.Time       `query:"-"`
+	IncluirPagamento bool            `query:"incluir_pagamento"`
+	Sort             string          `query:"sort"`
+	Order            string          `query:"order"`
+	Limit            int             `query:"limit"`
+	Cursor           string          `query:"cursor"`
+	After            *CobrancaCursor `query:"-"`
+}
+
+// Representa a posição da última cobrança retornada em uma página: o valor do campo de ordenação e o id da linha.
+type CobrancaCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	Id    int64  `json:"id"`
+}
+
+// Representa uma cobrança na listagem.
+type CobrancaResumo struct {
+	Id                   int64              `json:"id"`
+	IdPropostaParcela    int                `json:"id_proposta_parcela"`
+	IdProposta           int                `json:"id_proposta"`
+	NumeroAcompanhamento string             `json:"numero_acompanhamento"`
+	NumeroCCB            int                `json:"numero_ccb"`
+	NumeroParcela        int                `json:"numero_parcela"`
+	IdConvenio           int                `json:"id_convenio"`
+	IdSecuritizadora     int                `json:"id_securitizadora"`
+	IdFormaCobranca      int                `json:"id_forma_cobranca"`
+	DataVencimento       string             `json:"data_vencimento,omitempty"`
+	DataExpiracao        string             `json:"data_expiracao,omitempty"`
+	CodigoLiquidacao     string             `json:"codigo_liquidacao,omitempty"`
+	NumeroBoleto         int                `json:"numero_boleto,omitempty"`
+	Status               string             `json:"status"`
+	Version              int64              `json:"version"`
+	Pagamento            *CobrancaPagamento `json:"pagamento,omitempty"`
+	CreatedAt            time.Time          `json:"created_at"`
+	UpdatedAt            time.Time          `json:"updated_at"`
+}
+
+// Representa os últimos dados de pagamento(boletos e pix) retornados pelo BMP nas consultas da cobrança.
+type CobrancaPagamento struct {
+	Boletos      []WebhookBoletoData `json:"boletos,omitempty"`
+	Pix          []WebhookPixData    `json:"pix,omitempty"`
+	AtualizadoEm time.Time           `json:"atualizado_em"`
+}
+
+// Representa uma página da listagem de cobranças. NextCursor é vazio na última página.
+type CobrancaPage struct {
+	Items      []CobrancaResumo `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// Validate aplica os valores padrão da ordenação e do limite e decodifica o cursor, que deve ter sido gerado com a mesma ordenação.
+func (f *CobrancaFilter) Validate() error {
+	switch f.Sort {
+	case "":
+		f.Sort = COBRANCA_SORT_CREATED_AT
+	case COBRANCA_SORT_CREATED_AT, COBRANCA_SORT_UPDATED_AT, COBRANCA_SORT_DATA_VENCIMENTO, COBRANCA_SORT_ID_PROPOSTA_PARCELA:
+	default:
+		return errors.New("ordenação inválida: " + f.Sort)
+	}
+
+	switch f.Order {
+	case "":
+		f.Order = "desc"
+	case "asc", "desc":
+	default:
+		return errors.New("direção de ordenação inválida: " + f.Order)
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = COBRANCA_SEARCH_LIMIT_PADRAO
+	}
+	if f.Limit > COBRANCA_SEARCH_LIMIT_MAXIMO {
+		f.Limit = COBRANCA_SEARCH_LIMIT_MAXIMO
+	}
+
+	for _, status := range f.Status {
+		if !IsCobrancaStatus(status) {
+			return errors.New("status inválido: " + status)
+		}
+	}
+
+	if f.Cursor == "" {
+		f.After = nil
+		return nil
+	}
+	cursor, err := DecodeCobrancaCursor(f.Cursor)
+	if err != nil {
+		return err
+	}
+	if cursor.Sort != f.Sort || cursor.Order != f.Order {
+		return errors.New("cursor gerado com outra ordenação")
+	}
+	f.After = &cursor
+	return nil
+}
+
+// SortValue retorna o valor do campo de ordenação da cobrança no formato gravado no cursor.
+func (c CobrancaResumo) SortValue(sort string) string {
+	switch sort {
+	case COBRANCA_SORT_UPDATED_AT:
+		return c.UpdatedAt.Format(time.RFC3339Nano)
+	case COBRANCA_SORT_DATA_VENCIMENTO:
+		if c.DataVencimento == "" {
+			return COBRANCA_VENCIMENTO_VAZIO
+		}
+		return c.DataVencimento
+	case COBRANCA_SORT_ID_PROPOSTA_PARCELA:
+		return strconv.Itoa(c.IdPropostaParcela)
+	}
+	return c.CreatedAt.Format(time.RFC3339Nano)
+}
+
+// Encode serializa o cursor em base64, para ser repassado sem alterações na próxima requisição.
+func (c CobrancaCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCobrancaCursor decodifica um cursor gerado por CobrancaCursor.Encode.
+func DecodeCobrancaCursor(value string) (CobrancaCursor, error) {
+	var cursor CobrancaCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errors.New("cursor inválido")
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Id <= 0 {
+		return cursor, errors.New("cursor inválido")
+	}
+	return cursor, nil
+}
+
+// NewCobrancaPage monta a página a partir de até limit+1 cobranças: a cobrança excedente indica que há uma próxima página.
+func NewCobrancaPage(items []CobrancaResumo, filter CobrancaFilter) CobrancaPage {
+	if len(items) <= filter.Limit {
+		return CobrancaPage{Items: items}
+	}
+
+	items = items[:filter.Limit]
+	last := items[len(items)-1]
+	cursor := CobrancaCursor{Sort: filter.Sort, Order: filter.Order, Value: last.SortValue(filter.Sort), Id: last.Id}
+	return CobrancaPage{Items: items, NextCursor: cursor.Encode()}
+}
@@ -0,0 +1,24 @@
+package models
+
+// Status do ciclo de vida da cobrança de uma parcela, gravado em bmp_cobrancas.status.
+// As transições permitidas são validadas no service de cobranças.
+const (
+	COBRANCA_STATUS_PENDENTE       = "pendente"       //Parcela gravada, cobrança ainda não gerada
+	COBRANCA_STATUS_GERANDO        = "gerando"        //Geração solicitada ao BMP
+	COBRANCA_STATUS_REGISTRADA     = "registrada"     //Cobrança registrada no BMP, com código de liquidação
+	COBRANCA_STATUS_BOLETO_EMITIDO = "boleto_emitido" //Boleto gerado pelo BMP
+	COBRANCA_STATUS_PAGA_PARCIAL   = "paga_parcial"   //Pagamento recebido com saldo remanescente
+	COBRANCA_STATUS_PAGA           = "paga"           //Parcela quitada
+	COBRANCA_STATUS_CANCELADA      = "cancelada"      //Cobrança cancelada
+	COBRANCA_STATUS_FALHA          = "falha"          //Geração recusada pelo BMP ou tentativas esgotadas
+)
+
+// IsCobrancaStatus indica se status é um dos status do ciclo de vida da cobrança.
+func IsCobrancaStatus(status string) bool {
+	switch status {
+	case COBRANCA_STATUS_PENDENTE, COBRANCA_STATUS_GERANDO, COBRANCA_STATUS_REGISTRADA, COBRANCA_STATUS_BOLETO_EMITIDO,
+		COBRANCA_STATUS_PAGA_PARCIAL, COBRANCA_STATUS_PAGA, COBRANCA_STATUS_CANCELADA, COBRANCA_STATUS_FALHA:
+		return true
+	}
+	return false
+}
@@ -38,6 +38,11 @@ type CobrancaTaskData struct {
 	Updated                bool                           `json:"updated"`
 	WhData                 map[string]any
 	CobrancaDBInfo         CobrancaBMP `json:"cobrancaDBInfo"`
+	CorrelationId          string      `json:"correlationId,omitempty"` //Identificador que acompanha a cobrança em todas as reentregas
+	Attempt                int         `json:"attempt"`                 //Reentregas realizadas por SetTry
+	LastError              string      `json:"lastError,omitempty"`     //Erro que motivou a última reentrega
+	Reagendada             bool        `json:"-"`                       //Reentrega já publicada na fila pelo tratamento de erro
+	Origem                 string      `json:"origem,omitempty"`        //Ator que originou a operação no histórico da parcela. Vazio indica o cliente da API
 }
 
 func NewCobrancaTastkData(idempotencyKey int, status string, IdProposta int, numeroAcompanhamento string, authPayload AuthPayload) *CobrancaTaskData {
@@ -63,6 +68,7 @@ func NewCobrancaTastkData(idempotencyKey int, status string, IdProposta int, num
 
 // Seta as tentativas
 func (a *CobrancaTaskData) SetTry(interval time.Duration, status string) {
+	a.Attempt++
 
 	switch status {
 	case config.API_STATUS_RATE_LIMIT:
@@ -84,6 +90,19 @@ func (a *CobrancaTaskData) SetTry(interval time.Duration, status string) {
 	}
 }
 
+// Priority retorna a prioridade da mensagem na fila. A primeira tentativa de geração e de consulta tem prioridade alta,
+// pois o cliente aguarda o boleto, as demais operações têm prioridade normal e as reentregas têm prioridade baixa.
+func (a *CobrancaTaskData) Priority() uint8 {
+	if a.Attempt > 0 {
+		return config.MESSAGE_PRIORITY_BAIXA
+	}
+	switch a.Status {
+	case config.STATUS_GERAR_COBRANCA, config.STATUS_CONSULTAR_COBRANCA:
+		return config.MESSAGE_PRIORITY_ALTA
+	}
+	return config.MESSAGE_PRIORITY_NORMAL
+}
+
 func (a *CobrancaTaskData) SwitchCobrancaMode() error {
 	if a.ModoConsulta == config.CONSULTA_BOLETO {
 		a.ModoConsulta = config.CONSULTA_DETALHADA
@@ -144,3 +163,14 @@ func (c *CobrancaTaskData) FormatLancamento() LancamentoParcelaAPIInput {
 
 	return bmpPayload
 }
+
+// WebhookDestino retorna os dados para resolver os destinos dos eventos da cobrança.
+// A URL da requisição tem prioridade e a URL gravada na cobrança é utilizada como fallback.
+func (c *CobrancaTaskData) WebhookDestino() WebhookDestino {
+	return WebhookDestino{
+		IdConvenio:       c.AuthPayload.IdConvenio,
+		IdSecuritizadora: c.AuthPayload.IdSecuritizadora,
+		Url:              c.WebhookUrl,
+		UrlFallback:      c.CobrancaDBInfo.UrlWebhook,
+	}
+}
@@ -48,6 +48,8 @@ type ConsultaCobrancaParcela struct {
 	Boletos           []ConsultaBoleto             `json:"boletos"`
 	Pix               []ConsultaPix                `json:"pix"`
 	Lancamentos       []ConsultaCobrancaLancamento `json:"lancamentos"`
+	Status            string                       `json:"status,omitempty"`      //Status da cobrança da parcela na base local
+	Liquidacoes       []Liquidacao                 `json:"liquidacoes,omitempty"` //Histórico das liquidações e boletos emitidos para a parcela
 }
 
 type ConsultaBoleto struct {
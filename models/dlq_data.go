@@ -3,9 +3,10 @@ package models
 import "time"
 
 type DLQData struct {
-	Payload  any       `json:"payload"`
-	Contexto string    `json:"contexto"`
-	Mensagem string    `json:"mensagem"`
-	Erro     string    `json:"erro"`
-	Time     time.Time `json:"time"`
+	Payload  any            `json:"payload"`
+	Contexto string         `json:"contexto"`
+	Mensagem string         `json:"mensagem"`
+	Erro     string         `json:"erro"`
+	Time     time.Time      `json:"time"`
+	Headers  map[string]any `json:"headers,omitempty"` //Propriedades e headers da mensagem original, gravados na quarentena
 }
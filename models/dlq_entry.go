@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Representa uma mensagem da DLQ persistida para inspeção e reprocessamento.
+type DLQEntry struct {
+	Id                int64           `json:"id"`
+	Contexto          string          `json:"contexto"`
+	Mensagem          string          `json:"mensagem"`
+	Erro              string          `json:"erro"`
+	IdPropostaParcela int             `json:"id_proposta_parcela"`
+	Payload           json.RawMessage `json:"payload" swaggertype:"object"`
+	Headers           json.RawMessage `json:"headers,omitempty" swaggertype:"object"`
+	Time              time.Time       `json:"time"`
+	Replays           int             `json:"replays"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// Filtros da listagem de mensagens da DLQ.
+type DLQEntryFilter struct {
+	Contexto          string    `query:"contexto"`
+	IdPropostaParcela int       `query:"id_proposta_parcela"`
+	Inicio            time.Time `query:"-"`
+	Fim               time.Time `query:"-"`
+	Limit             int       `query:"limit"`
+	Offset            int       `query:"offset"`
+}
+
+// Representa o reprocessamento de uma mensagem da DLQ.
+type DLQReplayInput struct {
+	Fila    string          `json:"fila" validate:"omitempty,oneof=cobranca consulta webhook db"` //Vazio infere a fila pelo contexto da mensagem
+	Usuario string          `json:"-"`                                                            //Operador autenticado pelo header Api-Key
+	Payload json.RawMessage `json:"payload,omitempty" swaggertype:"object"`                       //Payload editado. Vazio reenvia o payload original
+}
+
+// Registro de auditoria de um reprocessamento da DLQ.
+type DLQReplay struct {
+	Id        int64           `json:"id"`
+	IdDLQ     int64           `json:"id_dlq"`
+	Fila      string          `json:"fila"`
+	Usuario   string          `json:"usuario"`
+	Editado   bool            `json:"editado"`
+	Payload   json.RawMessage `json:"payload" swaggertype:"object"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Retorna o id_proposta_parcela contido no payload de uma mensagem da DLQ, caso exista.
+// São considerados os payloads de cobrança/consulta(idPropostaParcela), de webhook(data.id_proposta_parcela) e de atualização(idPropostaParcela).
+func IdPropostaParcelaFromDLQPayload(payload json.RawMessage) int {
+	var data map[string]any
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return 0
+	}
+
+	for _, key := range []string{"idPropostaParcela", "id_proposta_parcela", "IdPropostaParcela"} {
+		if id, ok := data[key].(float64); ok && id > 0 {
+			return int(id)
+		}
+	}
+
+	return IdPropostaParcelaFromWebhookData(data["data"])
+}
@@ -8,7 +8,7 @@ type GerarCobrancaFrontendInput struct {
 	NumeroAcompanhamento string  `json:"numero_acompanhamento" validate:"required"`
 	NumeroCCB            int     `json:"numero_ccb" validate:"required"`
 	IdProposta           int     `json:"id_proposta" validate:"required"`
-	UrlWebhook           string  `json:"url_webhook" validate:"required,url,ping"`
+	UrlWebhook           string  `json:"url_webhook" validate:"omitempty,url,ping"`
 	DataVencimento       string  `json:"data_vencimento" validate:"required,datetime=2006-01-02"`
 	DataExpiracao        string  `json:"data_expiracao" validate:"required,datetime=2006-01-02"`
 	NumeroParcela        int     `json:"parcela" validate:"required"`
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Tipos dos lançamentos gravados a partir dos eventos de lançamento na parcela do BMP.
+const (
+	LANCAMENTO_TIPO_PAGAMENTO    = "pagamento"
+	LANCAMENTO_TIPO_DESCONTO     = "desconto"
+	LANCAMENTO_TIPO_AJUSTE_SALDO = "ajuste_saldo"
+)
+
+// ErrLancamentoDuplicado é retornado quando o evento de lançamento já foi gravado(evento reentregue pelo BMP).
+var ErrLancamentoDuplicado = errors.New("lançamento já registrado")
+
+// Representa um lançamento na parcela: pagamento, desconto ou ajuste de saldo informado pelo BMP no evento de lançamento.
+type Lancamento struct {
+	Id                int64     `json:"id"`
+	IdPropostaParcela int       `json:"id_proposta_parcela"`
+	IdProposta        int       `json:"id_proposta"`
+	NumeroCCB         int       `json:"numero_ccb"`
+	NumeroParcela     int       `json:"numero_parcela"`
+	ChaveEvento       string    `json:"chave_evento"` //Identifica o evento de origem. Eventos reentregues têm a mesma chave e são gravados uma única vez
+	Tipo              string    `json:"tipo"`
+	ValorPagamento    float64   `json:"valor_pagamento"`
+	ValorEncargos     float64   `json:"valor_encargos"`
+	ValorDesconto     float64   `json:"valor_desconto"`
+	ValorAbatimento   float64   `json:"valor_abatimento"`
+	ValorExcedente    float64   `json:"valor_excedente"`
+	SaldoAnterior     float64   `json:"saldo_anterior"`
+	SaldoAtual        float64   `json:"saldo_atual"`
+	ParcelaLiquidada  bool      `json:"parcela_liquidada"`
+	DataEvento        string    `json:"data_evento"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Representa os totais dos lançamentos de uma parcela. O saldo é o informado no último lançamento: sem lançamentos ele não
+// é conhecido localmente.
+type LancamentoResumo struct {
+	IdPropostaParcela int     `json:"id_proposta_parcela"`
+	NumeroParcela     int     `json:"numero_parcela"`
+	TotalPago         float64 `json:"total_pago"`
+	TotalEncargos     float64 `json:"total_encargos"`
+	TotalDesconto     float64 `json:"total_desconto"`
+	Saldo             float64 `json:"saldo"`
+	Liquidada         bool    `json:"liquidada"`
+	UltimoPagamento   string  `json:"ultimo_pagamento,omitempty"`
+	Lancamentos       int     `json:"lancamentos"`
+}
+
+// Representa os totais e o histórico dos lançamentos de uma parcela.
+type LancamentosParcela struct {
+	LancamentoResumo
+	Historico []Lancamento `json:"historico"`
+}
+
+// Representa os totais dos lançamentos de uma proposta, de cada parcela e o histórico.
+type LancamentosProposta struct {
+	IdProposta    int                `json:"id_proposta"`
+	TotalPago     float64            `json:"total_pago"`
+	TotalEncargos float64            `json:"total_encargos"`
+	TotalDesconto float64            `json:"total_desconto"`
+	Saldo         float64            `json:"saldo"` //Soma dos saldos das parcelas com lançamentos
+	Parcelas      []LancamentoResumo `json:"parcelas"`
+	Historico     []Lancamento       `json:"historico"`
+}
+
+// NewLancamento converte o evento de lançamento na parcela recebido do BMP.
+func NewLancamento(cobranca CobrancaBMP, evento EventoLancamentoParcela) Lancamento {
+	l := evento.LancamentoParcela
+
+	tipo := LANCAMENTO_TIPO_AJUSTE_SALDO
+	switch {
+	case l.VlrPagamento > 0:
+		tipo = LANCAMENTO_TIPO_PAGAMENTO
+	case l.VlrDesconto > 0:
+		tipo = LANCAMENTO_TIPO_DESCONTO
+	}
+
+	return Lancamento{
+		IdPropostaParcela: cobranca.IdPropostaParcela,
+		IdProposta:        cobranca.IdProposta,
+		NumeroCCB:         evento.NroProposta,
+		NumeroParcela:     l.NroParcela,
+		ChaveEvento:       chaveLancamento(evento),
+		Tipo:              tipo,
+		ValorPagamento:    l.VlrPagamento,
+		ValorEncargos:     l.VlrEncargos,
+		ValorDesconto:     l.VlrDesconto,
+		ValorAbatimento:   l.VlrAbatimento,
+		ValorExcedente:    l.VlrExcedente,
+		SaldoAnterior:     l.VlrSaldo,
+		SaldoAtual:        l.VlrSaldoAtual,
+		ParcelaLiquidada:  l.ParcelaLiquidada,
+		DataEvento:        evento.DtEvento,
+	}
+}
+
+// chaveLancamento gera a chave do evento a partir dos seus dados, pois os eventos do BMP não têm identificador.
+func chaveLancamento(evento EventoLancamentoParcela) string {
+	l := evento.LancamentoParcela
+	data := fmt.Sprintf("%d|%d|%s|%.2f|%.2f|%.2f|%.2f|%.2f|%.2f", evento.NroProposta, l.NroParcela, evento.DtEvento,
+		l.VlrPagamento, l.VlrEncargos, l.VlrDesconto, l.VlrAbatimento, l.VlrSaldo, l.VlrSaldoAtual)
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewLancamentosParcela calcula os totais da parcela a partir dos lançamentos em ordem cronológica.
+func NewLancamentosParcela(idPropostaParcela int, lancamentos []Lancamento) LancamentosParcela {
+	var resumo = LancamentoResumo{IdPropostaParcela: idPropostaParcela, Lancamentos: len(lancamentos)}
+	for _, l := range lancamentos {
+		resumo.NumeroParcela = l.NumeroParcela
+		resumo.TotalPago += l.ValorPagamento
+		resumo.TotalEncargos += l.ValorEncargos
+		resumo.TotalDesconto += l.ValorDesconto
+		resumo.Saldo = l.SaldoAtual
+		resumo.Liquidada = l.ParcelaLiquidada
+		if l.ValorPagamento > 0 {
+			resumo.UltimoPagamento = l.DataEvento
+		}
+	}
+	if lancamentos == nil {
+		lancamentos = make([]Lancamento, 0)
+	}
+	return LancamentosParcela{LancamentoResumo: resumo, Historico: lancamentos}
+}
+
+// NewLancamentosProposta calcula os totais da proposta e de cada parcela a partir dos lançamentos em ordem cronológica.
+func NewLancamentosProposta(idProposta int, lancamentos []Lancamento) LancamentosProposta {
+	var proposta = LancamentosProposta{IdProposta: idProposta, Parcelas: make([]LancamentoResumo, 0), Historico: lancamentos}
+	if proposta.Historico == nil {
+		proposta.Historico = make([]Lancamento, 0)
+	}
+
+	var porParcela = make(map[int][]Lancamento)
+	var ordem = make([]int, 0)
+	for _, l := range lancamentos {
+		if _, ok := porParcela[l.IdPropostaParcela]; !ok {
+			ordem = append(ordem, l.IdPropostaParcela)
+		}
+		porParcela[l.IdPropostaParcela] = append(porParcela[l.IdPropostaParcela], l)
+	}
+
+	for _, idPropostaParcela := range ordem {
+		resumo := NewLancamentosParcela(idPropostaParcela, porParcela[idPropostaParcela]).LancamentoResumo
+		proposta.TotalPago += resumo.TotalPago
+		proposta.TotalEncargos += resumo.TotalEncargos
+		proposta.TotalDesconto += resumo.TotalDesconto
+		proposta.Saldo += resumo.Saldo
+		proposta.Parcelas = append(proposta.Parcelas, resumo)
+	}
+	return proposta
+}
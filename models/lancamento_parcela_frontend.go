@@ -11,7 +11,7 @@ type LancamentoParcelaFrontendInput struct {
 	ValorLancamento      float64 `json:"valor_lancamento" validate:"required_if=Operacao P"`
 	ValorDesconto        float64 `json:"valor_desconto" validate:"required_if=Operacao S"`
 	NumeroCCB            int     `json:"numero_ccb" validate:"required"`
-	UrlWebhook           string  `json:"url_webhook" validate:"required,url,ping"`
+	UrlWebhook           string  `json:"url_webhook" validate:"omitempty,url,ping"`
 	NumeroParcela        int     `json:"parcela" validate:"required"`
 	IdConvenio           int     `json:"id_convenio"`
 	IdSecuritizadora     int     `json:"id_securitizadora"`
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Status de uma liquidação(código de liquidação e boleto) emitida para a parcela.
+const (
+	LIQUIDACAO_STATUS_ATIVA       = "ativa"       //Liquidação atual da parcela
+	LIQUIDACAO_STATUS_SUBSTITUIDA = "substituida" //Substituída por um novo código de liquidação ou boleto
+	LIQUIDACAO_STATUS_CANCELADA   = "cancelada"   //Cancelada no BMP
+	LIQUIDACAO_STATUS_PAGA        = "paga"        //Paga pelo cliente
+)
+
+// LiquidacaoStatus retorna o status que a liquidação ativa assume quando a cobrança passa para o status informado.
+// Os demais status da cobrança não encerram a liquidação e retornam vazio.
+func LiquidacaoStatus(cobrancaStatus string) string {
+	switch cobrancaStatus {
+	case COBRANCA_STATUS_CANCELADA:
+		return LIQUIDACAO_STATUS_CANCELADA
+	case COBRANCA_STATUS_PAGA:
+		return LIQUIDACAO_STATUS_PAGA
+	}
+	return ""
+}
+
+// Representa um código de liquidação, com o boleto emitido para ele, no histórico de emissões da parcela.
+// O vencimento e a expiração são os da parcela no momento da emissão; a liquidação vale de EmitidaEm até EncerradaEm.
+type Liquidacao struct {
+	Id                int64      `json:"id"`
+	IdPropostaParcela int        `json:"id_proposta_parcela"`
+	NumeroCCB         int        `json:"numero_ccb"`
+	NumeroParcela     int        `json:"numero_parcela"`
+	CodigoLiquidacao  string     `json:"codigo_liquidacao,omitempty"`
+	NumeroBoleto      int        `json:"numero_boleto,omitempty"`
+	Status            string     `json:"status"`
+	DataVencimento    string     `json:"data_vencimento,omitempty"`
+	DataExpiracao     string     `json:"data_expiracao,omitempty"`
+	EmitidaEm         time.Time  `json:"emitida_em"`
+	EncerradaEm       *time.Time `json:"encerrada_em,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Tipos das mensagens das filas, gravados na propriedade type da mensagem.
+const (
+	MESSAGE_TYPE_COBRANCA = "cobranca.task" //CobrancaTaskData, filas de cobrança e consulta
+	MESSAGE_TYPE_WEBHOOK  = "webhook.task"  //WebhookTaskData, fila de webhook
+	MESSAGE_TYPE_DB       = "db.update"     //UpdateDbData, fila de atualização
+	MESSAGE_TYPE_DLQ      = "dlq.message"   //DLQData, DLQ
+)
+
+// MessageEnvelope representa os metadados de uma mensagem das filas. É gravado nas propriedades e nos headers da mensagem,
+// sem alterar o corpo. Mensagens publicadas antes do envelope são lidas com Version 0 e atualizadas pelo consumer.
+type MessageEnvelope struct {
+	MessageId     string    `json:"message_id"`
+	Type          string    `json:"type"`
+	Version       int       `json:"version"`        //Versão do schema do corpo da mensagem
+	CorrelationId string    `json:"correlation_id"` //Mantido nas reentregas da mesma cobrança/webhook
+	CreatedAt     time.Time `json:"created_at"`
+	Attempt       int       `json:"attempt"`              //Tentativa atual, começando em 1
+	LastError     string    `json:"last_error,omitempty"` //Erro da tentativa anterior
+	Priority      uint8     `json:"priority"`             //Prioridade da mensagem na fila(AMQP priority)
+}
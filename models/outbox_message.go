@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Representa uma mensagem do outbox, gravada junto com a alteração que a originou e publicada na fila pelo relay.
+type OutboxMessage struct {
+	Id                int64           `json:"id"`
+	MessageId         string          `json:"message_id"` //Id da mensagem publicada, o mesmo em todas as tentativas
+	Fila              string          `json:"fila"`
+	Payload           json.RawMessage `json:"payload" swaggertype:"object"`
+	Delay             time.Duration   `json:"delay"`
+	IdPropostaParcela int             `json:"id_proposta_parcela"`
+	Tentativas        int             `json:"tentativas"`
+	Erro              string          `json:"erro,omitempty"`
+	DisponivelEm      time.Time       `json:"disponivel_em"`
+	PublishedAt       *time.Time      `json:"published_at,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// NewOutboxMessage serializa o dado que será publicado na fila.
+func NewOutboxMessage(fila string, data any, delay time.Duration, idPropostaParcela int) (OutboxMessage, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+	return OutboxMessage{
+		MessageId:         NewId(),
+		Fila:              fila,
+		Payload:           payload,
+		Delay:             delay,
+		IdPropostaParcela: idPropostaParcela,
+	}, nil
+}
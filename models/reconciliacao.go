@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Status de uma execução da reconciliação.
+const (
+	RECONCILIACAO_STATUS_EXECUTANDO = "executando"
+	RECONCILIACAO_STATUS_CONCLUIDA  = "concluida"
+	RECONCILIACAO_STATUS_FALHA      = "falha"
+)
+
+// Origem de uma execução da reconciliação.
+const (
+	RECONCILIACAO_ORIGEM_AGENDADA = "agendada"
+	RECONCILIACAO_ORIGEM_MANUAL   = "manual"
+)
+
+// Tipos de divergência entre a base local e o BMP.
+const (
+	DIVERGENCIA_PAGAMENTO        = "pagamento"        //Pagamento no BMP não refletido no status local
+	DIVERGENCIA_BOLETO           = "boleto"           //Boleto ou pix emitido no BMP não gravado ou diferente do gravado
+	DIVERGENCIA_COBRANCA_AUSENTE = "cobranca_ausente" //Cobrança ativa na base local sem boleto nem pix no BMP
+	DIVERGENCIA_PARCELA_AUSENTE  = "parcela_ausente"  //Parcela não retornada na consulta ao BMP
+)
+
+// ErrReconciliacaoEmAndamento é retornado quando uma execução da reconciliação já está em andamento nesta ou em outra instância.
+var ErrReconciliacaoEmAndamento = errors.New("reconciliação em andamento")
+
+// Representa uma execução da reconciliação das cobranças em aberto com o BMP.
+type Reconciliacao struct {
+	Id                  int64      `json:"id"`
+	Status              string     `json:"status"`
+	Origem              string     `json:"origem"`
+	ParcelasVerificadas int        `json:"parcelas_verificadas"`
+	Divergencias        int        `json:"divergencias"`
+	Corrigidas          int        `json:"corrigidas"`
+	Erros               int        `json:"erros"` //Consultas ao BMP que falharam
+	Erro                string     `json:"erro,omitempty"`
+	IniciadaEm          time.Time  `json:"iniciada_em"`
+	FinalizadaEm        *time.Time `json:"finalizada_em,omitempty"`
+}
+
+// Representa uma divergência encontrada em uma execução da reconciliação.
+type ReconciliacaoDivergencia struct {
+	Id                int64     `json:"id"`
+	IdReconciliacao   int64     `json:"id_reconciliacao"`
+	IdPropostaParcela int       `json:"id_proposta_parcela"`
+	IdProposta        int       `json:"id_proposta"`
+	NumeroParcela     int       `json:"numero_parcela"`
+	Tipo              string    `json:"tipo"`
+	Local             string    `json:"local"`          //Dados da base local
+	BMP               string    `json:"bmp"`            //Dados retornados pelo BMP
+	Corrigida         bool      `json:"corrigida"`      //A base local foi corrigida e os webhooks não enviados foram emitidos
+	Acao              string    `json:"acao,omitempty"` //Correção aplicada ou motivo de a divergência não ter sido corrigida
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Filtros da listagem de execuções da reconciliação.
+type ReconciliacaoFilter struct {
+	Status string    `query:"status"`
+	Inicio time.Time `query:"-"`
+	Fim    time.Time `query:"-"`
+	Limit  int       `query:"limit"`
+	Offset int       `query:"offset"`
+}
+
+// Filtros da listagem das divergências de uma execução da reconciliação.
+type ReconciliacaoDivergenciaFilter struct {
+	IdReconciliacao   int64  `query:"-"`
+	IdPropostaParcela int    `query:"id_proposta_parcela"`
+	Tipo              string `query:"tipo"`
+	Pendentes         bool   `query:"pendentes"` //Apenas as divergências não corrigidas
+	Limit             int    `query:"limit"`
+	Offset            int    `query:"offset"`
+}
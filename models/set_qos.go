@@ -1,5 +1,9 @@
 package models
 
+// Representa a reconfiguração dos consumers. Sem fila informada, QOS é aplicado como prefetch de todas as filas.
 type SetQOS struct {
-	QOS int `json:"QOS"`
+	QOS      int    `json:"QOS"`
+	Fila     string `json:"fila" validate:"omitempty,oneof=cobranca consulta webhook db dlq"` //Fila a ser reconfigurada
+	Workers  int    `json:"workers" validate:"gte=0"`                                         //Quantidade de workers da fila
+	Prefetch int    `json:"prefetch" validate:"gte=0"`                                        //Prefetch(QoS) do canal da fila
 }
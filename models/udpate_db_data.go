@@ -1,18 +1,28 @@
 package models
 
-import "os"
-
 type UpdateDbData struct {
 	IdPropostaParcela    int                             `json:"idPropostaParcela"`
 	CodigoLiquidacao     string                          `json:"codigoLiquidacao"`
 	NumeroBoleto         int                             `json:"numeroBoleto"`
 	Action               string                          `json:"action"`
+	Version              int64                           `json:"version,omitempty"` //Versão lida da parcela. Maior que zero, a atualização só ocorre se a parcela ainda estiver nela
 	GeracaoParcela       *GerarCobrancaFrontendInput     `json:"geracaoParcela"`
 	CancelamentoCobranca *CancelarCobrancaFrontendInput  `json:"cancelamentoCobranca"`
 	LancamentoParcela    *LancamentoParcelaFrontendInput `json:"lancamentoParcela"`
+	Outbox               []OutboxMessage                 `json:"outbox,omitempty"` //Mensagens gravadas no outbox na mesma transação da atualização
 }
 
-type DbLogfileData struct {
-	FilePath string   `json:"filePath"`
-	File     *os.File `json:"file"`
+// Parcela retorna o id_proposta_parcela da atualização, informado diretamente ou nos dados da operação.
+func (u UpdateDbData) Parcela() int {
+	switch {
+	case u.IdPropostaParcela > 0:
+		return u.IdPropostaParcela
+	case u.GeracaoParcela != nil:
+		return u.GeracaoParcela.IdPropostaParcela
+	case u.CancelamentoCobranca != nil:
+		return u.CancelamentoCobranca.IdPropostaParcela
+	case u.LancamentoParcela != nil:
+		return u.LancamentoParcela.IdPropostaParcela
+	}
+	return 0
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"cobranca-bmp/config"
+	"errors"
 	"time"
 )
 
@@ -41,27 +42,60 @@ type VinculoWebhookPayload struct {
 }
 
 type WebhookTaskData struct {
-	Data    any           `json:"data"`
-	Retries int64         `json:"retries"`
-	Delay   time.Duration `json:"delay"`
-	Url     string        `json:"url"`
-	Context string        `json:"context"`
+	Data      any              `json:"data"`
+	Retries   int64            `json:"retries"`
+	Delay     time.Duration    `json:"delay"`
+	Url       string           `json:"url"`
+	Context   string           `json:"context"`
+	Scheme    string           `json:"scheme,omitempty"`  //Esquema de autenticação da assinatura. Vazio utiliza o configurado para o destino
+	Version   int              `json:"version,omitempty"` //Versão do schema do payload
+	CreatedAt time.Time        `json:"createdAt"`
+	History   []WebhookAttempt `json:"history,omitempty"`
+	//Identificador que acompanha o webhook em todas as reentregas
+	CorrelationId string `json:"correlationId,omitempty"`
+}
+
+// Representa uma tentativa de entrega de um webhook que falhou.
+type WebhookAttempt struct {
+	Attempt    int64     `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Erro       string    `json:"erro"`
+	Permanent  bool      `json:"permanent"`
 }
 
 func NewWebhookTaskData(url string, data any, context string) WebhookTaskData {
 	return WebhookTaskData{
-		Data: data,
-		Url:  url,
-		//	Retries: config.WEBHOOK_RETRIES,
-		Retries: 0,
-		Delay:   0,
-		Context: context,
+		Data:      data,
+		Url:       url,
+		Retries:   0,
+		Delay:     0,
+		Context:   context,
+		CreatedAt: time.Now(),
 	}
 
 }
 
-func (w *WebhookTaskData) SetTry() {
-	w.Delay += config.WEBHOOK_DELAY
+// SetTry registra a tentativa que falhou no histórico e calcula o delay até a próxima, de acordo com a política do destino.
+func (w *WebhookTaskData) SetTry(policy config.WebhookRetryPolicy, err error, now time.Time) {
 	w.Retries++
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = now
+	}
+
+	var attempt = WebhookAttempt{
+		Attempt: w.Retries,
+		Time:    now,
+		Erro:    err.Error(),
+	}
+
+	var whErr WebhookError
+	if errors.As(err, &whErr) {
+		attempt.StatusCode = whErr.StatusCode
+		attempt.Permanent = whErr.IsPermanent()
+	}
+
+	w.History = append(w.History, attempt)
+	w.Delay = policy.Backoff(w.Retries)
 
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Retornado ao buscar uma entrega de webhook que não foi registrada.
+var ErrEntregaNaoEncontrada = errors.New("entrega de webhook não encontrada")
+
+// Representa uma tentativa de entrega de webhook persistida na tabela bmp_webhook_entregas.
+type WebhookDelivery struct {
+	Id                int64           `json:"id"`
+	IdPropostaParcela int             `json:"id_proposta_parcela"`
+	Url               string          `json:"url"`
+	Contexto          string          `json:"contexto"`
+	AuthScheme        string          `json:"auth_scheme,omitempty"` //Esquema de autenticação da assinatura. Vazio utiliza o configurado para o destino
+	SchemaVersion     int             `json:"schema_version"`        //Versão do schema do payload enviado
+	Payload           json.RawMessage `json:"payload" swaggertype:"object"`
+	PayloadHash       string          `json:"payload_hash"`
+	StatusCode        int             `json:"status_code"`
+	LatenciaMs        int64           `json:"latencia_ms"`
+	Resposta          string          `json:"resposta"`
+	Tentativa         int64           `json:"tentativa"`
+	Erro              string          `json:"erro,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// Representa os filtros da listagem de entregas de webhook.
+type WebhookDeliveryFilter struct {
+	IdPropostaParcela int       `query:"id_proposta_parcela"`
+	Url               string    `query:"url"`
+	Inicio            time.Time `query:"-"`
+	Fim               time.Time `query:"-"`
+	Limit             int       `query:"limit"`
+	Offset            int       `query:"offset"`
+}
+
+// Retorna o id_proposta_parcela contido nos dados de um webhook, caso exista.
+func IdPropostaParcelaFromWebhookData(data any) int {
+	if event, ok := data.(WebhookEvent); ok {
+		return event.IdPropostaParcela
+	}
+	whData, ok := data.(map[string]any)
+	if !ok {
+		return 0
+	}
+	switch id := whData["id_proposta_parcela"].(type) {
+	case int:
+		return id
+	case int64:
+		return int(id)
+	case float64:
+		return int(id)
+	case json.Number:
+		v, _ := id.Int64()
+		return int(v)
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Representa uma resposta de erro retornada pelo destino de um webhook.
+type WebhookError struct {
+	StatusCode int    `json:"statusCode"`
+	Url        string `json:"url"`
+	Response   string `json:"response"`
+}
+
+func (w WebhookError) Error() string {
+	return fmt.Sprintf("Erro ao requisitar para o webhook, status code %d", w.StatusCode)
+}
+
+// IsPermanent informa se o erro é definitivo(4xx, exceto 408 e 429), caso em que não há retentativas.
+func (w WebhookError) IsPermanent() bool {
+	if w.StatusCode == http.StatusRequestTimeout || w.StatusCode == http.StatusTooManyRequests {
+		return false
+	}
+	return w.StatusCode >= 400 && w.StatusCode < 500
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"cobranca-bmp/config"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Representa o envelope dos eventos enviados aos webhooks a partir da versão 2 do schema(docs/webhook-events.schema.json).
+// Na versão 1 é enviado apenas o payload legado, sem envelope.
+type WebhookEvent struct {
+	Id                string    `json:"id"`                  //Identificador único do evento
+	Type              string    `json:"type"`                //Tipo do evento(cobranca.registrada, cobranca.cancelada, parcela.lancamento ou cobranca.erro)
+	SchemaVersion     int       `json:"schema_version"`      //Versão do schema do payload
+	OccurredAt        time.Time `json:"occurred_at"`         //Data/hora em que o evento ocorreu
+	IdPropostaParcela int       `json:"id_proposta_parcela"` //Parcela a que o evento se refere
+	Data              any       `json:"data"`                //Dados do evento, de acordo com o tipo
+
+	legacy map[string]any
+}
+
+// Dados do evento cobranca.registrada: cobrança registrada no BMP, com os boletos e/ou pix gerados.
+type CobrancaRegistradaData struct {
+	CodigoLiquidacao string              `json:"codigo_liquidacao"`
+	Boletos          []WebhookBoletoData `json:"boletos,omitempty"`
+	Pix              []WebhookPixData    `json:"pix,omitempty"`
+}
+
+// Dados do evento cobranca.cancelada: cobrança(boleto ou pix) cancelada no BMP.
+type CobrancaCanceladaData struct {
+	CodigoLiquidacao string `json:"codigo_liquidacao"`
+}
+
+// Dados do evento parcela.lancamento: pagamento(operacao P) ou atualização de saldo(operacao S) da parcela.
+type ParcelaLancamentoData struct {
+	Operacao      string  `json:"operacao"`
+	DataPagamento string  `json:"data_pagamento,omitempty"`
+	ValorPago     float64 `json:"valor_pago"`
+	ValorEncargo  float64 `json:"valor_encargo"`
+	ValorDesconto float64 `json:"valor_desconto"`
+	Saldo         float64 `json:"saldo"`
+}
+
+// Dados do evento cobranca.erro: falha definitiva em uma operação solicitada.
+// A operação segue os códigos legados: R(geração), C(cancelamento), P(pagamento) e S(atualização de saldo).
+type CobrancaErroData struct {
+	Operacao string `json:"operacao"`
+	Mensagem string `json:"mensagem"`
+}
+
+// Representa um boleto nos eventos de webhook, independente da consulta que o originou.
+type WebhookBoletoData struct {
+	NumeroBoleto   int     `json:"numero_boleto"`
+	LinhaDigitavel string  `json:"linha_digitavel"`
+	UrlImpressao   string  `json:"url_impressao"`
+	DataVencimento string  `json:"data_vencimento,omitempty"`
+	DataExpiracao  string  `json:"data_expiracao,omitempty"`
+	Valor          float64 `json:"valor,omitempty"`
+	PixEmv         string  `json:"pix_emv,omitempty"`
+}
+
+// Representa um pix nos eventos de webhook.
+type WebhookPixData struct {
+	DataVencimento string `json:"data_vencimento,omitempty"`
+	DataExpiracao  string `json:"data_expiracao,omitempty"`
+	Emv            string `json:"emv"`
+	Imagem         string `json:"imagem"`
+}
+
+// NewWebhookEvent instancia um evento. O payload legado é enviado aos destinos fixados na versão 1.
+func NewWebhookEvent(tipo string, idPropostaParcela int, data any, legacy map[string]any) WebhookEvent {
+	return WebhookEvent{
+		Id:                NewId(),
+		Type:              tipo,
+		SchemaVersion:     config.WEBHOOK_SCHEMA_VERSION_ATUAL,
+		OccurredAt:        time.Now(),
+		IdPropostaParcela: idPropostaParcela,
+		Data:              data,
+		legacy:            legacy,
+	}
+}
+
+// Payload retorna o corpo que será enviado para um destino fixado na versão informada.
+func (e WebhookEvent) Payload(version int) any {
+	if version <= config.WEBHOOK_SCHEMA_VERSION_LEGADA {
+		return e.legacy
+	}
+	return e
+}
+
+// Converte os boletos retornados na consulta detalhada de cobranças.
+func WebhookBoletosFromConsulta(boletos []ConsultaBoleto) []WebhookBoletoData {
+	var data = make([]WebhookBoletoData, 0, len(boletos))
+	for _, b := range boletos {
+		data = append(data, WebhookBoletoData{
+			NumeroBoleto:   b.NumeroBoleto,
+			LinhaDigitavel: b.LinhaDigitavel,
+			UrlImpressao:   b.UrlImpressao,
+			DataExpiracao:  b.DtExpiracao,
+			PixEmv:         b.QrCode.Emv,
+		})
+	}
+	return data
+}
+
+// Converte um boleto retornado na consulta de boletos.
+func NewWebhookBoletoFromBoleto(b Boleto) WebhookBoletoData {
+	return WebhookBoletoData{
+		NumeroBoleto:   b.NumeroBoleto,
+		LinhaDigitavel: b.LinhaDigitavel,
+		UrlImpressao:   b.Impressao,
+		DataVencimento: b.DtVencimento,
+		DataExpiracao:  b.DtExpiracao,
+		Valor:          b.ValorBoleto,
+	}
+}
+
+// Converte os pix retornados na consulta detalhada de cobranças.
+func WebhookPixFromConsulta(pix []ConsultaPix) []WebhookPixData {
+	var data = make([]WebhookPixData, 0, len(pix))
+	for _, p := range pix {
+		data = append(data, WebhookPixData{
+			DataVencimento: p.DtVencimento,
+			DataExpiracao:  p.DtExpiracao,
+			Emv:            p.Emv,
+			Imagem:         p.Imagem,
+		})
+	}
+	return data
+}
+
+// Gera um identificador no formato UUID v4.
+func NewId() string {
+	return uuid.NewString()
+}
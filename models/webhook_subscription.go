@@ -0,0 +1,63 @@
+package models
+
+import (
+	"cobranca-bmp/helpers"
+	"slices"
+	"time"
+)
+
+// Representa a assinatura de webhooks de um convênio(e opcionalmente de uma securitizadora).
+type WebhookSubscription struct {
+	Id               int64     `json:"id"`
+	IdConvenio       int       `json:"id_convenio" validate:"required"`
+	IdSecuritizadora int       `json:"id_securitizadora"` //0 assina os eventos de todas as securitizadoras do convênio
+	Url              string    `json:"url" validate:"required,url"`
+	Eventos          []string  `json:"eventos" validate:"dive,oneof=cobranca.registrada cobranca.cancelada parcela.lancamento cobranca.erro"` //Vazio assina todos os eventos
+	AuthScheme       string    `json:"auth_scheme" validate:"omitempty,oneof=legacy hmac"`                                                    //Vazio utiliza o esquema configurado para o destino
+	SchemaVersion    int       `json:"schema_version" validate:"omitempty,oneof=1 2"`                                                         //Versão do schema fixada pelo destino. 0 utiliza WEBHOOK_SCHEMA_VERSION
+	Ativo            bool      `json:"ativo"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Filtros da listagem de assinaturas de webhook.
+type WebhookSubscriptionFilter struct {
+	IdConvenio       int `query:"id_convenio"`
+	IdSecuritizadora int `query:"id_securitizadora"`
+}
+
+// Representa os dados necessários para resolver os destinos de um evento.
+type WebhookDestino struct {
+	IdConvenio       int
+	IdSecuritizadora int
+	Url              string //URL informada na requisição. Tem prioridade sobre as assinaturas
+	UrlFallback      string //URL gravada na cobrança. Utilizada apenas quando não há assinatura para o evento
+}
+
+// Matches informa se a assinatura está ativa e assina o evento.
+func (s WebhookSubscription) Matches(evento string) bool {
+	return s.Ativo && (len(s.Eventos) == 0 || slices.Contains(s.Eventos, evento))
+}
+
+func (s WebhookSubscription) Validate() error {
+	var errAPI APIError
+	errAPI.HasError = true
+
+	messages := make([]APIMessage, 0)
+	if err := helpers.StructValidate(s); err != nil {
+		errValidation, ok := err.(*helpers.ErrValidation)
+		if !ok {
+			messages := append(messages, APIMessage{Description: err.Error()})
+			errAPI.Messages = messages
+			errAPI.Msg = messages[0].Description
+			return errAPI
+		}
+		for _, message := range errValidation.GetAllMessages() {
+			messages = append(messages, APIMessage{Description: message})
+			errAPI.Msg += message + "\n"
+		}
+		errAPI.Messages = messages
+		return errAPI
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"cobranca-bmp/models"
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Delivery é uma mensagem entregue pelo Broker. Ack confirma o processamento e Nack o rejeita, devolvendo a mensagem para a fila quando requeue for true.
+type Delivery interface {
+	Envelope() models.MessageEnvelope
+	Headers() map[string]any
+	Body() []byte
+	Ack() error
+	Nack(requeue bool) error
+}
+
+// Broker abstrai o backend de filas: publicação com envelope e delay e consumo com ack/nack.
+type Broker interface {
+	Publish(queue string, env models.MessageEnvelope, body []byte, delay time.Duration) error
+	Subscribe(queue string, workers int, handler func(Delivery)) error
+}
+
+// Backend reúne as operações do backend de filas utilizadas na inicialização, no monitoramento e no encerramento do serviço.
+// É implementado pelo RabbitMQ e pelo MemoryBroker(modo local, sem broker).
+type Backend interface {
+	Broker
+	ConsumeQueues()
+	Check() (map[string]any, error)
+	GetServiceName() string
+	SetQOS(qos int) error
+	SetQueueQOS(fila string, workers, prefetch int) error
+	GetQOS() map[string]any
+	QueueDepths() (map[string]any, error)
+	StopConsuming(ctx context.Context) error
+	WaitConsumers(ctx context.Context) error
+	Close() error
+}
+
+// Representa a profundidade de uma fila retornada em QueueDepths. Lanes é preenchido apenas no backend em memória,
+// que separa as mensagens por prioridade; no RabbitMQ a prioridade é aplicada dentro da própria fila(x-max-priority).
+type queueDepth struct {
+	Mensagens int            `json:"mensagens"`
+	Consumers int            `json:"consumers,omitempty"`
+	Lanes     map[string]int `json:"lanes,omitempty"`
+}
+
+// Producer é a interface que define o método de produção de mensagens utilizado pelos services e pelo Processor.
+type Producer interface {
+	Produce(queue string, data any, delay time.Duration) error
+}
+
+// rabbitDelivery adapta amqp.Delivery para Delivery.
+type rabbitDelivery struct {
+	delivery amqp.Delivery
+}
+
+func (d rabbitDelivery) Envelope() models.MessageEnvelope {
+	return deliveryEnvelope(d.delivery)
+}
+
+// Headers retorna os headers e as propriedades da mensagem, gravados junto com o corpo original na quarentena.
+func (d rabbitDelivery) Headers() map[string]any {
+	var headers = make(map[string]any, len(d.delivery.Headers)+7)
+	for key, value := range d.delivery.Headers {
+		headers[key] = value
+	}
+	headers["message_id"] = d.delivery.MessageId
+	headers["type"] = d.delivery.Type
+	headers["correlation_id"] = d.delivery.CorrelationId
+	headers["timestamp"] = d.delivery.Timestamp
+	headers["exchange"] = d.delivery.Exchange
+	headers["routing_key"] = d.delivery.RoutingKey
+	headers["redelivered"] = d.delivery.Redelivered
+	return headers
+}
+
+func (d rabbitDelivery) Body() []byte {
+	return d.delivery.Body
+}
+
+func (d rabbitDelivery) Ack() error {
+	return d.delivery.Ack(false)
+}
+
+func (d rabbitDelivery) Nack(requeue bool) error {
+	return d.delivery.Nack(false, requeue)
+}
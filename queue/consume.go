@@ -5,165 +5,205 @@ import (
 	"cobranca-bmp/helpers"
 	"cobranca-bmp/models"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// ConsumeQueues consome as mensagens das filas.
+// ConsumeQueues inicia os workers de cada fila.
 func (r *RabbitMQ) ConsumeQueues() {
-	go r.ConsumeDB()
-	go r.ConsumeWebhook()
+	for _, pool := range r.pools {
+		pool.start()
+	}
 	go r.MonitoreServer()
-	go r.ConsumeCobrancaQueues()
 }
 
-// setUpConsumers configura os consumers para as filas digitacaoDlq, simulacaoDlq, dados bancarios e webhook.
-func (r *RabbitMQ) setUpConsumers() error {
-	msgs, err := r.dbCh.Consume(r.dbqueue, "bmp-db", false, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-	r.dbMsgs = msgs
-
-	msgs, err = r.webhookCh.Consume(r.webhookQueue, "bmp-webhook", false, false, false, false, nil)
-	if err != nil {
-		return err
+// newConsumerPools cria os consumers das filas com a quantidade de workers e o prefetch configurados em RABBITMQ_CONSUMERS.
+// Os canais são obtidos a cada consumo, pois são recriados na reconexão. Os handlers são registrados pelo Processor em Subscribe.
+func (r *RabbitMQ) newConsumerPools() map[string]*consumerPool {
+	var pools = make(map[string]*consumerPool)
+	add := func(name, queue, tag string, channel func() *amqp.Channel) {
+		cfg := config.GetConsumerPoolConfig(name)
+		pools[name] = newConsumerPool(name, queue, tag, cfg.Workers, cfg.Prefetch, channel)
 	}
-	r.webhookMsgs = msgs
 
-	if err := r.setUpCobrancaConsumers(); err != nil {
-		return err
-	}
+	add(config.CONSUMER_DB, r.dbqueue, "bmp-db", func() *amqp.Channel { return r.dbCh })
+	add(config.CONSUMER_WEBHOOK, r.webhookQueue, "bmp-webhook", func() *amqp.Channel { return r.webhookCh })
+	add(config.CONSUMER_DLQ, r.dlqQueue, "bmp-dlq", func() *amqp.Channel { return r.DlqCh })
+	r.addCobrancaConsumerPools(add)
 
-	return nil
+	return pools
 }
 
-// ConsumeWebhook ficará em execução para ouvir as mensagens da fila de envio para o webhook Confiapay.
-func (r *RabbitMQ) ConsumeWebhook() {
-	for msg := range r.webhookMsgs {
-		var payload models.WebhookTaskData
-		err := json.Unmarshal(msg.Body, &payload)
-		if err != nil {
-			var dlqData = models.DLQData{
-				Payload:  payload,
-				Contexto: "consumo de webhook",
-				Mensagem: "Erro ao deserializar json para requisitar para webhook na fila",
-				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
-			}
-			if err := msg.Nack(false, false); err != nil {
-				dlqData.Mensagem += "\n " + "Erro ao realizar Nack de mensagem"
-				dlqData.Erro += "\n " + err.Error()
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de webhook", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-			}
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de webhook", "", "Erro ao deserializar json para requisitar para webhook na fila", err.Error(), nil)
-			r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
+// Subscribe registra o handler da fila no consumer correspondente. workers menores ou iguais a zero mantêm o valor configurado.
+func (r *RabbitMQ) Subscribe(queue string, workers int, handler func(Delivery)) error {
+	for _, pool := range r.pools {
+		if pool.queue != queue {
 			continue
 		}
+		pool.mu.Lock()
+		pool.handler = func(msg amqp.Delivery) { handler(rabbitDelivery{delivery: msg}) }
+		if workers > 0 {
+			pool.workers = workers
+		}
+		pool.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("fila inválida: %s", queue)
+}
 
-		helpers.LogInfo(r.ctx, r.logger, r.location, "Consumo de webhook", "", "Entrou na fila de webhook", payload)
-
-		if err := r.webhookService.RequestToWebhook(payload); err != nil {
-			if err := msg.Nack(false, false); err != nil {
-				var dlqData = models.DLQData{
-					Payload:  payload,
-					Contexto: "consumo de webhook",
-					Mensagem: "Erro ao realizar Nack de mensagem",
-					Erro:     err.Error(),
-					Time:     time.Now().In(r.location),
-				}
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de webhook", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-				r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-			}
-			continue
+// setUpConsumers aplica o prefetch e inicia a entrega das mensagens de cada fila.
+func (r *RabbitMQ) setUpConsumers() error {
+	for _, pool := range r.pools {
+		if err := pool.consume(); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// processWebhook processa uma mensagem da fila de envio para o webhook Confiapay.
+func (p *Processor) processWebhook(msg Delivery) {
+	var payload models.WebhookTaskData
+	err := json.Unmarshal(msg.Body(), &payload)
+	if err != nil {
+		p.quarantine(p.webhookQueue, msg, "Erro ao deserializar json para requisitar para webhook na fila", err)
+		return
+	}
+	if payload.Url == "" {
+		p.quarantine(p.webhookQueue, msg, "Mensagem de webhook sem url", errors.New("url do webhook não informada"))
+		return
+	}
+
+	//Mensagens publicadas antes do envelope não têm o correlation id no corpo
+	if payload.CorrelationId == "" {
+		payload.CorrelationId = msg.Envelope().CorrelationId
+	}
+
+	helpers.LogInfo(p.ctx, p.logger, p.location, "Consumo de webhook", "", "Entrou na fila de webhook", payload)
 
-		if err := msg.Ack(false); err != nil {
+	if err := p.webhookService.RequestToWebhook(payload); err != nil {
+		if err := msg.Nack(false); err != nil {
 			var dlqData = models.DLQData{
 				Payload:  payload,
 				Contexto: "consumo de webhook",
-				Mensagem: "Erro ao realizar Ack de mensagem",
+				Mensagem: "Erro ao realizar Nack de mensagem",
 				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
+				Time:     time.Now().In(p.location),
 			}
+			helpers.LogError(p.ctx, p.logger, p.location, "Consumo de webhook", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
+			p.producer.Produce(p.dlqQueue, dlqData, 0)
+		}
+		return
+	}
 
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de webhook", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
-			r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
+	if err := msg.Ack(); err != nil {
+		var dlqData = models.DLQData{
+			Payload:  payload,
+			Contexto: "consumo de webhook",
+			Mensagem: "Erro ao realizar Ack de mensagem",
+			Erro:     err.Error(),
+			Time:     time.Now().In(p.location),
 		}
 
+		helpers.LogError(p.ctx, p.logger, p.location, "Consumo de webhook", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
+		p.producer.Produce(p.dlqQueue, dlqData, 0)
 	}
 }
 
-// ConsumeDB ficará em execução para ouvir as mensagens da fila de db.
+// processDB processa uma mensagem da fila de db.
+func (p *Processor) processDB(msg Delivery) {
+	var payload models.UpdateDbData
+	if err := json.Unmarshal(msg.Body(), &payload); err != nil {
+		p.quarantine(p.dbqueue, msg, "Erro ao deserializar json para atualização na fila", err)
+		return
+	}
+	if payload.Action == "" {
+		p.quarantine(p.dbqueue, msg, "Mensagem de atualização sem action", errors.New("action da atualização não informada"))
+		return
+	}
+
+	helpers.LogInfo(p.ctx, p.logger, p.location, "Consumo de db", "", "Entrou na fila de db", payload)
+	var err error
+	var noConn bool
+
+	noConn, err = p.dbService.UpdateAssync(payload)
 
-func (r *RabbitMQ) ConsumeDB() {
-	for msg := range r.dbMsgs {
-		var payload models.UpdateDbData
-		if err := json.Unmarshal(msg.Body, &payload); err != nil {
+	if err != nil {
+		if err := msg.Nack(false); err != nil {
 			var dlqData = models.DLQData{
 				Payload:  payload,
 				Contexto: "consumo de db",
-				Mensagem: "Erro ao deserializar json para atualização na fila",
+				Mensagem: "Erro ao realizar Nack de mensagem",
 				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
+				Time:     time.Now().In(p.location),
 			}
-			if err := msg.Nack(false, false); err != nil {
-				dlqData.Mensagem += "\n " + "Erro ao realizar Nack de mensagem"
-				dlqData.Erro += "\n " + err.Error()
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de db", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
+			p.producer.Produce(p.dlqQueue, dlqData, 0)
+			helpers.LogError(p.ctx, p.logger, p.location, "Consumo de db", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
+		}
+		if noConn {
+			p.producer.Produce(p.dbqueue, payload, config.DB_QUEUE_DELAY)
+		} else {
+			var dlqData = models.DLQData{
+				Payload:  payload,
+				Contexto: "consumo de db",
+				Mensagem: "Erro ao realizar inserção em proposta_status",
+				Erro:     err.Error(),
+				Time:     time.Now().In(p.location),
 			}
-			r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de db", "", "Erro ao deserializar json para atualização no db", err.Error(), nil)
-			continue
+			p.producer.Produce(p.dlqQueue, dlqData, 0)
 
 		}
+		return
 
-		helpers.LogInfo(r.ctx, r.logger, r.location, "Consumo de db", "", "Entrou na fila de db", payload)
-		var err error
-		var noConn bool
-
-		noConn, err = r.dbService.UpdateAssync(payload)
-
-		if err != nil {
-			if err := msg.Nack(false, false); err != nil {
-				var dlqData = models.DLQData{
-					Payload:  payload,
-					Contexto: "consumo de db",
-					Mensagem: "Erro ao realizar Nack de mensagem",
-					Erro:     err.Error(),
-					Time:     time.Now().In(r.location),
-				}
-				r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de db", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-			}
-			if noConn {
-				r.baseProducer.Produce(r.dbqueue, payload, config.DB_QUEUE_DELAY)
-			} else {
-				var dlqData = models.DLQData{
-					Payload:  payload,
-					Contexto: "consumo de db",
-					Mensagem: "Erro ao realizar inserção em proposta_status",
-					Erro:     err.Error(),
-					Time:     time.Now().In(r.location),
-				}
-				r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-
-			}
-			continue
+	}
 
+	if err := msg.Ack(); err != nil {
+		var dlqData = models.DLQData{
+			Payload:  payload,
+			Contexto: "consumo de db",
+			Mensagem: "Erro ao realizar Ack de mensagem",
+			Erro:     err.Error(),
+			Time:     time.Now().In(p.location),
 		}
+		p.producer.Produce(p.dlqQueue, dlqData, 0)
+		helpers.LogError(p.ctx, p.logger, p.location, "Consumo de db", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
+	}
+}
 
-		if err := msg.Ack(false); err != nil {
-			var dlqData = models.DLQData{
-				Payload:  payload,
-				Contexto: "consumo de db",
-				Mensagem: "Erro ao realizar Ack de mensagem",
-				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
-			}
-			r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de db", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
+// processDLQ persiste uma mensagem da DLQ para inspeção e reprocessamento.
+// Em caso de falha de conexão com o banco de dados a mensagem é devolvida para a fila; nas demais falhas ela é registrada no log e descartada.
+func (p *Processor) processDLQ(msg Delivery) {
+	var payload models.DLQData
+	if err := json.Unmarshal(msg.Body(), &payload); err != nil {
+		//Mensagens fora do formato de DLQData são persistidas com o corpo original
+		payload = models.DLQData{
+			Payload:  json.RawMessage(msg.Body()),
+			Contexto: "dlq",
+			Mensagem: "Mensagem da DLQ fora do formato esperado",
+			Erro:     err.Error(),
+			Time:     time.Now().In(p.location),
+		}
+		if !json.Valid(msg.Body()) {
+			payload.Payload = string(msg.Body())
 		}
+	}
 
+	noConn, err := p.dlqService.Save(payload)
+	if err != nil {
+		helpers.LogError(p.ctx, p.logger, p.location, "Consumo de dlq", "", "Erro ao persistir mensagem da DLQ", err.Error(), payload)
+		if err := msg.Nack(noConn); err != nil {
+			helpers.LogError(p.ctx, p.logger, p.location, "Consumo de dlq", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
+		}
+		if noConn {
+			time.Sleep(config.DB_QUEUE_DELAY)
+		}
+		return
 	}
 
+	if err := msg.Ack(); err != nil {
+		helpers.LogError(p.ctx, p.logger, p.location, "Consumo de dlq", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
+	}
 }
@@ -5,163 +5,145 @@ import (
 	"cobranca-bmp/helpers"
 	"cobranca-bmp/models"
 	"encoding/json"
+	"errors"
+	"strconv"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// Configura os consumidores para as filas
-func (r *RabbitMQ) setUpCobrancaConsumers() error {
+// Adiciona os consumers das filas de cobrança e consulta
+func (r *RabbitMQ) addCobrancaConsumerPools(add func(name, queue, tag string, channel func() *amqp.Channel)) {
+	add(config.CONSUMER_COBRANCA, r.cobrancaQueue, "bmp-cobranca", func() *amqp.Channel { return r.cobrancaCh })
+	add(config.CONSUMER_CONSULTA, r.consultaQueue, "bmp-consulta", func() *amqp.Channel { return r.consultaCh })
+}
 
-	msgs, err := r.cobrancaCh.Consume(r.cobrancaQueue, "bmp-cobranca", false, false, false, false, nil)
+// processCobranca processa uma mensagem da fila de cobranças.
+func (p *Processor) processCobranca(msg Delivery) {
+	var payload models.CobrancaTaskData
+	err := json.Unmarshal(msg.Body(), &payload)
 	if err != nil {
-		return err
+		p.quarantine(p.cobrancaQueue, msg, "Erro ao deserializar json para requisitar para cobrança na fila", err)
+		return
+	}
+	if payload.Status == "" {
+		p.quarantine(p.cobrancaQueue, msg, "Mensagem de cobrança sem status", errors.New("status da cobrança não informado"))
+		return
 	}
 
-	r.cobrancaMsgs = msgs
+	//Mensagens publicadas antes do envelope não têm o correlation id no corpo
+	if payload.CorrelationId == "" {
+		payload.CorrelationId = msg.Envelope().CorrelationId
+	}
 
-	msgs, err = r.consultaCh.Consume(r.consultaQueue, "bmp-consulta", false, false, false, false, nil)
-	if err != nil {
-		return err
+	helpers.LogInfo(p.ctx, p.logger, p.location, "Consumo de cobrança", "", "Entrou na fila de cobrança", map[string]any{
+		"MessageId":        msg.Envelope().MessageId,
+		"CorrelationId":    payload.CorrelationId,
+		"Attempt":          msg.Envelope().Attempt,
+		"IdempotencyKey":   payload.IdempotencyKey,
+		"IdProposta":       payload.IdProposta,
+		"timeoutRetries":   payload.TimeoutRetries,
+		"rateLimitRetries": payload.RateLimitRetries,
+		"delay":            payload.CurrentDelay,
+	})
+
+	_, status, statusCode, err := p.cobrancaService.Cobranca(&payload)
+	switch cobrancaOutcome(&payload, status, statusCode, err) {
+	case outcomeRetryable:
+		//Falha de infraestrutura: a mensagem volta para a fila e é reentregue até o limite de entregas
+		helpers.LogWarn(p.ctx, p.logger, p.location, "Consumo de cobrança", strconv.Itoa(statusCode), "Falha de infraestrutura, mensagem devolvida para a fila", err.Error(), map[string]any{"MessageId": msg.Envelope().MessageId, "CorrelationId": payload.CorrelationId})
+		p.nackCobranca(msg, "Consumo de cobrança", payload, true)
+		time.Sleep(config.TIMEOUT_DELAY)
+		return
+
+	case outcomeFinal:
+		helpers.LogWarn(p.ctx, p.logger, p.location, "Consumo de cobrança", strconv.Itoa(statusCode), "Erro final no processamento, mensagem descartada", err.Error(), map[string]any{"MessageId": msg.Envelope().MessageId, "CorrelationId": payload.CorrelationId, "status": status})
+		p.nackCobranca(msg, "Consumo de cobrança", payload, false)
+		return
 	}
 
-	r.consultaMsgs = msgs
+	if err := msg.Ack(); err != nil {
+		var dlqData = models.DLQData{
+			Payload:  payload,
+			Contexto: "Consumo de cobrança",
+			Mensagem: "Erro ao realizar Ack de mensagem",
+			Erro:     err.Error(),
+			Time:     time.Now().In(p.location),
+		}
 
-	return nil
+		helpers.LogError(p.ctx, p.logger, p.location, "Consumo de cobrança", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
+		p.producer.Produce(p.dlqQueue, dlqData, 0)
+	}
 }
 
-func (r *RabbitMQ) ConsumeCobrancaQueues() {
-	go r.ConsumeCobranca()
-	go r.ConsumeConsulta()
-
-}
+// processConsulta processa uma mensagem da fila de consultas.
+func (p *Processor) processConsulta(msg Delivery) {
+	var payload models.CobrancaTaskData
+	payload.Status = config.STATUS_CONSULTAR_COBRANCA
+	err := json.Unmarshal(msg.Body(), &payload)
+	if err != nil {
+		p.quarantine(p.consultaQueue, msg, "Erro ao deserializar json para requisitar para consulta na fila", err)
+		return
+	}
 
-// ConsumeCobranca ficará em execução para ouvir as mensagens da fila de cobranças.
-func (r *RabbitMQ) ConsumeCobranca() {
-
-	for msg := range r.cobrancaMsgs {
-		var payload models.CobrancaTaskData
-		err := json.Unmarshal(msg.Body, &payload)
-		if err != nil {
-			var dlqData = models.DLQData{
-				Payload:  payload,
-				Contexto: "Consumo de cobrança",
-				Mensagem: "Erro ao deserializar json para requisitar para cobrança na fila",
-				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
-			}
-
-			if err := msg.Nack(false, false); err != nil {
-				dlqData.Mensagem += "\n " + "Erro ao realizar Nack de mensagem"
-				dlqData.Erro += "\n " + err.Error()
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de cobrança", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-			}
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de cobrança", "", "Erro ao deserializar json para requisitar para cobrança na fila", err.Error(), nil)
-			r.cobrancaProducer.Produce(r.dlqQueue, dlqData, 0)
-			continue
-		}
+	//Mensagens publicadas antes do envelope não têm o correlation id no corpo
+	if payload.CorrelationId == "" {
+		payload.CorrelationId = msg.Envelope().CorrelationId
+	}
 
-		helpers.LogInfo(r.ctx, r.logger, r.location, "Consumo de cobrança", "", "Entrou na fila de cobrança", map[string]any{
-			"IdempotencyKey":   payload.IdempotencyKey,
-			"IdProposta":       payload.IdProposta,
-			"timeoutRetries":   payload.TimeoutRetries,
-			"rateLimitRetries": payload.RateLimitRetries,
-			"delay":            payload.CurrentDelay,
-		})
-
-		_, _, _, err = r.cobrancaService.Cobranca(&payload)
-		if err != nil {
-			if err := msg.Nack(false, false); err != nil {
-				var dlqData = models.DLQData{
-					Payload:  payload,
-					Contexto: "Consumo de cobrança",
-					Mensagem: "Erro ao realizar Nack de mensagem",
-					Erro:     err.Error(),
-					Time:     time.Now().In(r.location),
-				}
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de cobrança", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-				r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-			}
-			continue
-		}
+	helpers.LogInfo(p.ctx, p.logger, p.location, "Consumo de consulta", "", "Entrou na fila de consulta", map[string]any{
+		"MessageId":        msg.Envelope().MessageId,
+		"CorrelationId":    payload.CorrelationId,
+		"Attempt":          msg.Envelope().Attempt,
+		"IdempotencyKey":   payload.IdempotencyKey,
+		"IdProposta":       payload.IdProposta,
+		"timeoutRetries":   payload.TimeoutRetries,
+		"rateLimitRetries": payload.RateLimitRetries,
+		"consultaRetries":  payload.ConsultaRetries,
+		"delay":            payload.CurrentDelay,
+		"modoConsulta":     payload.ModoConsulta,
+	})
+
+	_, status, statusCode, err := p.cobrancaService.Cobranca(&payload)
+	switch cobrancaOutcome(&payload, status, statusCode, err) {
+	case outcomeRetryable:
+		//Falha de infraestrutura: a mensagem volta para a fila e é reentregue até o limite de entregas
+		helpers.LogWarn(p.ctx, p.logger, p.location, "Consumo de consulta", strconv.Itoa(statusCode), "Falha de infraestrutura, mensagem devolvida para a fila", err.Error(), map[string]any{"MessageId": msg.Envelope().MessageId, "CorrelationId": payload.CorrelationId})
+		p.nackCobranca(msg, "Consumo de consulta", payload, true)
+		time.Sleep(config.TIMEOUT_DELAY)
+		return
+
+	case outcomeFinal:
+		helpers.LogWarn(p.ctx, p.logger, p.location, "Consumo de consulta", strconv.Itoa(statusCode), "Erro final no processamento, mensagem descartada", err.Error(), map[string]any{"MessageId": msg.Envelope().MessageId, "CorrelationId": payload.CorrelationId, "status": status})
+		p.nackCobranca(msg, "Consumo de consulta", payload, false)
+		return
+	}
 
-		if err := msg.Ack(false); err != nil {
-			var dlqData = models.DLQData{
-				Payload:  payload,
-				Contexto: "Consumo de cobrança",
-				Mensagem: "Erro ao realizar Ack de mensagem",
-				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
-			}
-
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de cobrança", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
-			r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
+	if err := msg.Ack(); err != nil {
+		var dlqData = models.DLQData{
+			Payload:  payload,
+			Contexto: "Consumo de consulta",
+			Mensagem: "Erro ao realizar Ack de mensagem",
+			Erro:     err.Error(),
+			Time:     time.Now().In(p.location),
 		}
 
+		helpers.LogError(p.ctx, p.logger, p.location, "Consumo de consulta", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
+		p.producer.Produce(p.dlqQueue, dlqData, 0)
 	}
 }
 
-// ConsumeCobranca ficará em execução para ouvir as mensagens da fila de consultas.
-func (r *RabbitMQ) ConsumeConsulta() {
-	for msg := range r.consultaMsgs {
-		var payload models.CobrancaTaskData
-		payload.Status = config.STATUS_CONSULTAR_COBRANCA
-		err := json.Unmarshal(msg.Body, &payload)
-		if err != nil {
-			var dlqData = models.DLQData{
-				Payload:  payload,
-				Contexto: "Consumo de consulta",
-				Mensagem: "Erro ao deserializar json para requisitar para consulta na fila",
-				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
-			}
-
-			if err := msg.Nack(false, false); err != nil {
-				dlqData.Mensagem += "\n " + "Erro ao realizar Nack de mensagem"
-				dlqData.Erro += "\n " + err.Error()
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de consulta", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-			}
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de consulta", "", "Erro ao deserializar json para requisitar para consulta na fila", err.Error(), nil)
-			r.cobrancaProducer.Produce(r.dlqQueue, dlqData, 0)
-			continue
+// Rejeita a mensagem e, caso o Nack falhe, envia o payload para a DLQ.
+func (p *Processor) nackCobranca(msg Delivery, contexto string, payload models.CobrancaTaskData, requeue bool) {
+	if err := msg.Nack(requeue); err != nil {
+		var dlqData = models.DLQData{
+			Payload:  payload,
+			Contexto: contexto,
+			Mensagem: "Erro ao realizar Nack de mensagem",
+			Erro:     err.Error(),
+			Time:     time.Now().In(p.location),
 		}
-
-		helpers.LogInfo(r.ctx, r.logger, r.location, "Consumo de consulta", "", "Entrou na fila de consulta", map[string]any{
-			"IdempotencyKey":   payload.IdempotencyKey,
-			"IdProposta":       payload.IdProposta,
-			"timeoutRetries":   payload.TimeoutRetries,
-			"rateLimitRetries": payload.RateLimitRetries,
-			"consultaRetries":  payload.ConsultaRetries,
-			"delay":            payload.CurrentDelay,
-			"modoConsulta":     payload.ModoConsulta,
-		})
-
-		_, _, _, err = r.cobrancaService.Cobranca(&payload)
-		if err != nil {
-			if err := msg.Nack(false, false); err != nil {
-				var dlqData = models.DLQData{
-					Payload:  payload,
-					Contexto: "Consumo de consulta",
-					Mensagem: "Erro ao realizar Nack de mensagem",
-					Erro:     err.Error(),
-					Time:     time.Now().In(r.location),
-				}
-				helpers.LogError(r.ctx, r.logger, r.location, "Consumo de consulta", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
-				r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-			}
-			continue
-		}
-
-		if err := msg.Ack(false); err != nil {
-			var dlqData = models.DLQData{
-				Payload:  payload,
-				Contexto: "Consumo de consulta",
-				Mensagem: "Erro ao realizar Ack de mensagem",
-				Erro:     err.Error(),
-				Time:     time.Now().In(r.location),
-			}
-
-			helpers.LogError(r.ctx, r.logger, r.location, "Consumo de consulta", "", "Erro ao realizar Ack de mensagem", err.Error(), nil)
-			r.baseProducer.Produce(r.dlqQueue, dlqData, 0)
-		}
-
+		helpers.LogError(p.ctx, p.logger, p.location, contexto, "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
+		p.producer.Produce(p.dlqQueue, dlqData, 0)
 	}
 }
@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// consumerPool distribui as mensagens de uma fila entre workers. O prefetch(QoS) do canal limita as mensagens entregues e ainda não confirmadas.
+type consumerPool struct {
+	name     string
+	queue    string
+	tag      string
+	channel  func() *amqp.Channel
+	handler  func(amqp.Delivery)
+	mu       sync.Mutex
+	workers  int
+	prefetch int
+	msgs     <-chan amqp.Delivery
+	stop     chan struct{}
+	inFlight atomic.Int64
+}
+
+// Representa o estado de um consumer retornado em GetQOS e Check.
+type consumerPoolInfo struct {
+	Workers  int   `json:"workers"`
+	Prefetch int   `json:"prefetch"`
+	InFlight int64 `json:"in_flight"`
+}
+
+func newConsumerPool(name, queue, tag string, workers, prefetch int, channel func() *amqp.Channel) *consumerPool {
+	return &consumerPool{
+		name:     name,
+		queue:    queue,
+		tag:      tag,
+		workers:  workers,
+		prefetch: prefetch,
+		channel:  channel,
+	}
+}
+
+// consume aplica o prefetch no canal e inicia a entrega das mensagens da fila.
+// O QoS sem a flag global vale apenas para os consumers criados depois dele, por isso é aplicado antes do Consume.
+func (p *consumerPool) consume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.consumeLocked()
+}
+
+func (p *consumerPool) consumeLocked() error {
+	ch := p.channel()
+	if ch == nil {
+		return errors.New("canal não configurado para a fila " + p.queue)
+	}
+	if err := ch.Qos(p.prefetch, 0, false); err != nil {
+		return err
+	}
+	msgs, err := ch.Consume(p.queue, p.tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	p.msgs = msgs
+	return nil
+}
+
+// start inicia os workers. Eles são encerrados quando a entrega termina(cancelamento do consumer ou fechamento do canal) ou em adjust.
+func (p *consumerPool) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startLocked()
+}
+
+func (p *consumerPool) startLocked() {
+	if p.handler == nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	for range p.workers {
+		go p.work(p.msgs, p.stop)
+	}
+}
+
+func (p *consumerPool) work(msgs <-chan amqp.Delivery, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			p.inFlight.Add(1)
+			p.handler(msg)
+			p.inFlight.Add(-1)
+		}
+	}
+}
+
+// adjust altera a quantidade de workers e/ou o prefetch sem fechar o canal. Valores menores ou iguais a zero são mantidos.
+// Ao alterar o prefetch, o consumer é cancelado e recriado no mesmo canal: os workers atuais processam as mensagens já entregues
+// e se encerram com o fim da entrega, enquanto novos workers passam a consumir com o novo prefetch.
+func (p *consumerPool) adjust(workers, prefetch int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if workers > 0 {
+		p.workers = workers
+	}
+
+	if prefetch > 0 && prefetch != p.prefetch {
+		ch := p.channel()
+		if ch == nil || ch.IsClosed() {
+			return errors.New("canal fechado para a fila " + p.queue)
+		}
+		if err := ch.Cancel(p.tag, false); err != nil {
+			return err
+		}
+		p.prefetch = prefetch
+		if err := p.consumeLocked(); err != nil {
+			return err
+		}
+	} else if p.stop != nil {
+		close(p.stop)
+	}
+
+	p.startLocked()
+	return nil
+}
+
+// shutdown encerra o consumo: os workers terminam a mensagem atual e não pegam novas, o consumer é cancelado
+// e as mensagens já entregues e ainda não processadas são devolvidas para a fila. Retorna a quantidade devolvida.
+func (p *consumerPool) shutdown() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+
+	ch := p.channel()
+	if ch == nil || ch.IsClosed() || p.msgs == nil {
+		return 0, nil
+	}
+	if err := ch.Cancel(p.tag, false); err != nil {
+		return 0, err
+	}
+
+	//Após o cancelamento, a entrega é encerrada depois de repassar as mensagens que já estavam no buffer
+	var requeued int
+	for msg := range p.msgs {
+		if err := msg.Nack(false, true); err == nil {
+			requeued++
+		}
+	}
+	return requeued, nil
+}
+
+func (p *consumerPool) info() consumerPoolInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return consumerPoolInfo{
+		Workers:  p.workers,
+		Prefetch: p.prefetch,
+		InFlight: p.inFlight.Load(),
+	}
+}
@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers do envelope. Id, tipo, correlation id e data de criação são gravados nas propriedades AMQP.
+const (
+	headerSchemaVersion = "x-schema-version"
+	headerAttempt       = "x-attempt"
+	headerLastError     = "x-last-error"
+)
+
+// Versão atual do schema de cada tipo de mensagem.
+var messageSchemaVersions = map[string]int{
+	models.MESSAGE_TYPE_COBRANCA: 1,
+	models.MESSAGE_TYPE_WEBHOOK:  1,
+	models.MESSAGE_TYPE_DB:       1,
+	models.MESSAGE_TYPE_DLQ:      1,
+}
+
+// Conversões do corpo entre versões do schema: messageUpgraders[tipo][versão] converte o corpo da versão para a seguinte.
+// A versão 0 corresponde às mensagens publicadas antes do envelope, cujo corpo é igual ao da versão 1.
+var messageUpgraders = map[string]map[int]func(body []byte) ([]byte, error){
+	models.MESSAGE_TYPE_COBRANCA: {0: keepBody},
+	models.MESSAGE_TYPE_WEBHOOK:  {0: keepBody},
+	models.MESSAGE_TYPE_DB:       {0: keepBody},
+	models.MESSAGE_TYPE_DLQ:      {0: keepBody},
+}
+
+func keepBody(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// Tipo das mensagens de cada fila.
+func queueMessageType(queue string) string {
+	switch queue {
+	case config.COBRANCA_QUEUE, config.CONSULTA_QUEUE:
+		return models.MESSAGE_TYPE_COBRANCA
+	case config.WEBHOOK_QUEUE:
+		return models.MESSAGE_TYPE_WEBHOOK
+	case config.DB_QUEUE:
+		return models.MESSAGE_TYPE_DB
+	case config.DLQ_QUEUE:
+		return models.MESSAGE_TYPE_DLQ
+	}
+	return ""
+}
+
+// newEnvelope cria o envelope da mensagem publicada na fila. A tentativa, o último erro e o correlation id são obtidos do payload.
+// Quando o payload é um *CobrancaTaskData sem correlation id, o id da mensagem é gravado nele para acompanhar as próximas reentregas.
+func newEnvelope(queue string, data any, loc *time.Location) models.MessageEnvelope {
+	var env = models.MessageEnvelope{
+		MessageId: models.NewId(),
+		Type:      queueMessageType(queue),
+		CreatedAt: time.Now().In(loc),
+		Attempt:   1,
+	}
+	env.Version = messageSchemaVersions[env.Type]
+
+	switch d := data.(type) {
+	case *models.CobrancaTaskData:
+		if d.CorrelationId == "" {
+			d.CorrelationId = env.MessageId
+		}
+		env.CorrelationId, env.Attempt, env.LastError = d.CorrelationId, d.Attempt+1, d.LastError
+		env.Priority = d.Priority()
+	case models.CobrancaTaskData:
+		env.CorrelationId, env.Attempt, env.LastError = d.CorrelationId, d.Attempt+1, d.LastError
+		env.Priority = d.Priority()
+	case *models.WebhookTaskData:
+		setWebhookEnvelope(&env, *d)
+	case models.WebhookTaskData:
+		setWebhookEnvelope(&env, d)
+	case *models.UpdateDbData:
+		env.CorrelationId = fmt.Sprintf("parcela-%d", d.IdPropostaParcela)
+	case models.UpdateDbData:
+		env.CorrelationId = fmt.Sprintf("parcela-%d", d.IdPropostaParcela)
+	case models.DLQData:
+		env.LastError = d.Erro
+		env.CorrelationId = newEnvelope("", d.Payload, loc).CorrelationId
+	}
+
+	if env.CorrelationId == "" {
+		env.CorrelationId = env.MessageId
+	}
+	return env
+}
+
+func setWebhookEnvelope(env *models.MessageEnvelope, d models.WebhookTaskData) {
+	env.CorrelationId = d.CorrelationId
+	env.Attempt = int(d.Retries) + 1
+	if len(d.History) > 0 {
+		env.LastError = d.History[len(d.History)-1].Erro
+	}
+}
+
+// newPublishing monta a mensagem AMQP com o envelope nas propriedades e nos headers.
+func newPublishing(body []byte, env models.MessageEnvelope, delay time.Duration) amqp.Publishing {
+	var headers = amqp.Table{
+		"x-delay":           int32(delay.Milliseconds()),
+		headerSchemaVersion: int32(env.Version),
+		headerAttempt:       int32(env.Attempt),
+	}
+	if env.LastError != "" {
+		headers[headerLastError] = env.LastError
+	}
+
+	return amqp.Publishing{
+		Body:          body,
+		DeliveryMode:  2,
+		ContentType:   "application/json",
+		MessageId:     env.MessageId,
+		Type:          env.Type,
+		CorrelationId: env.CorrelationId,
+		Timestamp:     env.CreatedAt,
+		Priority:      env.Priority,
+		Headers:       headers,
+	}
+}
+
+// deliveryEnvelope lê o envelope das propriedades e dos headers da mensagem. Mensagens sem envelope retornam Version 0.
+func deliveryEnvelope(d amqp.Delivery) models.MessageEnvelope {
+	var env = models.MessageEnvelope{
+		MessageId:     d.MessageId,
+		Type:          d.Type,
+		CorrelationId: d.CorrelationId,
+		CreatedAt:     d.Timestamp,
+		Priority:      d.Priority,
+		Version:       headerInt(d.Headers, headerSchemaVersion),
+		Attempt:       headerInt(d.Headers, headerAttempt),
+	}
+	if lastError, ok := d.Headers[headerLastError].(string); ok {
+		env.LastError = lastError
+	}
+	return env
+}
+
+// O broker pode entregar os inteiros dos headers com tamanhos diferentes do publicado.
+func headerInt(headers amqp.Table, key string) int {
+	switch v := headers[key].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int16:
+		return int(v)
+	case int8:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// upgradeMessage valida o envelope contra o tipo esperado na fila e converte o corpo para a versão atual do schema.
+// Mensagens sem envelope(publicadas antes dele) recebem o tipo da fila, um id derivado do corpo e a data de leitura.
+func upgradeMessage(env *models.MessageEnvelope, body []byte, expected string, loc *time.Location) ([]byte, error) {
+	if env.Type == "" && env.Version == 0 {
+		env.Type = expected
+		if env.MessageId == "" {
+			env.MessageId = legacyMessageId(body)
+		}
+		if env.CreatedAt.IsZero() {
+			env.CreatedAt = time.Now().In(loc)
+		}
+	}
+	if env.Attempt <= 0 {
+		env.Attempt = 1
+	}
+	if env.CorrelationId == "" {
+		env.CorrelationId = env.MessageId
+	}
+
+	if env.Type != expected {
+		return nil, fmt.Errorf("tipo de mensagem inesperado: %s, esperado %s", env.Type, expected)
+	}
+	current := messageSchemaVersions[expected]
+	if env.Version > current {
+		return nil, fmt.Errorf("versão %d do schema de %s não suportada, versão atual %d", env.Version, expected, current)
+	}
+
+	for env.Version < current {
+		upgrade, ok := messageUpgraders[expected][env.Version]
+		if !ok {
+			return nil, fmt.Errorf("sem conversão da versão %d do schema de %s", env.Version, expected)
+		}
+		var err error
+		if body, err = upgrade(body); err != nil {
+			return nil, fmt.Errorf("erro ao converter a versão %d do schema de %s: %w", env.Version, expected, err)
+		}
+		env.Version++
+	}
+	return body, nil
+}
+
+// O id das mensagens sem envelope é derivado do corpo, para que as reentregas da mesma mensagem tenham o mesmo id.
+func legacyMessageId(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "legacy-" + hex.EncodeToString(hash[:16])
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"cobranca-bmp/models"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestUpgradeMessage(t *testing.T) {
+	var body = []byte(`{"status":"consultar","id_proposta_parcela":1001}`)
+	var cases = []struct {
+		name    string
+		env     models.MessageEnvelope
+		version int
+		attempt int
+		err     string
+	}{
+		{"versão atual", models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_COBRANCA, Version: 1, Attempt: 3}, 1, 3, ""},
+		{"mensagem sem envelope", models.MessageEnvelope{}, 1, 1, ""},
+		{"versão 0 com tipo", models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_COBRANCA}, 1, 1, ""},
+		{"tipo de outra fila", models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_WEBHOOK, Version: 1}, 0, 0, "tipo de mensagem inesperado"},
+		{"tipo desconhecido", models.MessageEnvelope{MessageId: "msg-1", Type: "boleto", Version: 1}, 0, 0, "tipo de mensagem inesperado"},
+		{"versão não suportada", models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_COBRANCA, Version: 2}, 0, 0, "versão 2 do schema de cobranca.task não suportada"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env := c.env
+			got, err := upgradeMessage(&env, body, models.MESSAGE_TYPE_COBRANCA, time.UTC)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("erro = %v, esperado %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("upgradeMessage: %v", err)
+			}
+			if string(got) != string(body) {
+				t.Fatalf("corpo = %s", got)
+			}
+			if env.Type != models.MESSAGE_TYPE_COBRANCA || env.Version != c.version || env.Attempt != c.attempt {
+				t.Fatalf("envelope = %+v", env)
+			}
+			if env.MessageId == "" || env.CorrelationId != env.MessageId {
+				t.Fatalf("envelope sem id ou correlation id = %+v", env)
+			}
+		})
+	}
+}
+
+func TestUpgradeMessageSemEnvelopeMantemId(t *testing.T) {
+	//As reentregas de uma mensagem publicada antes do envelope devem ter o mesmo id, utilizado na contagem de entregas
+	var body = []byte(`{"status":"consultar","id_proposta_parcela":1001}`)
+	var first, second models.MessageEnvelope
+	if _, err := upgradeMessage(&first, body, models.MESSAGE_TYPE_COBRANCA, time.UTC); err != nil {
+		t.Fatalf("upgradeMessage: %v", err)
+	}
+	if _, err := upgradeMessage(&second, body, models.MESSAGE_TYPE_COBRANCA, time.UTC); err != nil {
+		t.Fatalf("upgradeMessage: %v", err)
+	}
+	if first.MessageId != second.MessageId || !strings.HasPrefix(first.MessageId, "legacy-") || first.CreatedAt.IsZero() {
+		t.Fatalf("ids = %s, %s", first.MessageId, second.MessageId)
+	}
+}
+
+func TestDeliveryEnvelopeSemHeaders(t *testing.T) {
+	//Mensagem publicada antes do envelope, sem propriedades nem headers
+	env := deliveryEnvelope(amqp.Delivery{Body: []byte(`{}`)})
+	if env.Version != 0 || env.Type != "" || env.Attempt != 0 {
+		t.Fatalf("envelope = %+v", env)
+	}
+
+	env = deliveryEnvelope(amqp.Delivery{
+		MessageId: "msg-1",
+		Type:      models.MESSAGE_TYPE_WEBHOOK,
+		Headers:   amqp.Table{headerSchemaVersion: int64(1), headerAttempt: int16(4), headerLastError: "timeout"},
+	})
+	if env.Version != 1 || env.Attempt != 4 || env.LastError != "timeout" {
+		t.Fatalf("envelope = %+v", env)
+	}
+}
@@ -0,0 +1,453 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capacidade de cada lane das filas em memória. Publicações em uma lane cheia retornam erro.
+const memoryQueueSize = 10000
+
+// Lanes de prioridade das filas em memória, na ordem de consumo.
+var memoryLanes = []string{"alta", "normal", "baixa"}
+
+// Lane da mensagem de acordo com a prioridade do envelope.
+func memoryLane(priority uint8) int {
+	switch {
+	case priority >= config.MESSAGE_PRIORITY_ALTA:
+		return 0
+	case priority >= config.MESSAGE_PRIORITY_NORMAL:
+		return 1
+	}
+	return 2
+}
+
+// MemoryBroker é o backend de filas em memória, utilizado no modo local(QUEUE_BACKEND=memory) e em testes, sem RabbitMQ.
+// Mensagens com delay são agendadas com time.AfterFunc, substituindo o plugin x-delayed-message.
+// As mensagens não são persistidas: as que estiverem nas filas ou agendadas no encerramento são perdidas.
+type MemoryBroker struct {
+	ctx       context.Context
+	location  *time.Location
+	logger    *slog.Logger
+	queues    map[string]*memoryQueue
+	processor *Processor
+	producer  *brokerProducer
+	QOS       int
+	delayed   atomic.Int64
+	closing   atomic.Bool
+}
+
+// memoryQueue é uma fila em memória e seus workers. As mensagens são separadas em lanes por prioridade
+// e os workers sempre consomem a lane de maior prioridade que tiver mensagens.
+type memoryQueue struct {
+	name     string
+	queue    string
+	lanes    []chan memoryMessage
+	handler  func(Delivery)
+	mu       sync.Mutex
+	workers  int
+	stop     chan struct{}
+	inFlight atomic.Int64
+}
+
+// memoryMessage é uma mensagem gravada em uma fila em memória.
+type memoryMessage struct {
+	env  models.MessageEnvelope
+	body []byte
+}
+
+// memoryDelivery é uma mensagem entregue pelo MemoryBroker. Nack com requeue devolve a mensagem para o fim da fila.
+type memoryDelivery struct {
+	broker  *MemoryBroker
+	queue   string
+	msg     memoryMessage
+	settled atomic.Bool
+}
+
+func (d *memoryDelivery) Envelope() models.MessageEnvelope {
+	return d.msg.env
+}
+
+func (d *memoryDelivery) Headers() map[string]any {
+	return map[string]any{
+		"message_id":        d.msg.env.MessageId,
+		"type":              d.msg.env.Type,
+		"correlation_id":    d.msg.env.CorrelationId,
+		"timestamp":         d.msg.env.CreatedAt,
+		"routing_key":       d.queue,
+		headerSchemaVersion: d.msg.env.Version,
+		headerAttempt:       d.msg.env.Attempt,
+		headerLastError:     d.msg.env.LastError,
+	}
+}
+
+func (d *memoryDelivery) Body() []byte {
+	return d.msg.body
+}
+
+func (d *memoryDelivery) Ack() error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return errors.New("mensagem já confirmada")
+	}
+	return nil
+}
+
+func (d *memoryDelivery) Nack(requeue bool) error {
+	if !d.settled.CompareAndSwap(false, true) {
+		return errors.New("mensagem já confirmada")
+	}
+	if requeue {
+		return d.broker.Publish(d.queue, d.msg.env, d.msg.body, 0)
+	}
+	return nil
+}
+
+// NewMemoryBroker cria o backend em memória, injeta o producer nos services e registra os handlers das filas.
+func NewMemoryBroker(ctx context.Context, loc *time.Location, logger *slog.Logger,
+	webhookService WebhookService,
+	cobrancaService CobrancaCreditoPessoalService, dbService dbService,
+	dlqService dlqPersistService,
+	QOS int) (*MemoryBroker, error) {
+
+	var b = &MemoryBroker{
+		ctx:      ctx,
+		location: loc,
+		logger:   logger,
+		QOS:      QOS,
+		queues:   make(map[string]*memoryQueue),
+	}
+
+	var queues = map[string]string{
+		config.CONSUMER_COBRANCA: config.COBRANCA_QUEUE,
+		config.CONSUMER_CONSULTA: config.CONSULTA_QUEUE,
+		config.CONSUMER_WEBHOOK:  config.WEBHOOK_QUEUE,
+		config.CONSUMER_DB:       config.DB_QUEUE,
+		config.CONSUMER_DLQ:      config.DLQ_QUEUE,
+	}
+	for name, queue := range queues {
+		q := &memoryQueue{
+			name:    name,
+			queue:   queue,
+			lanes:   make([]chan memoryMessage, len(memoryLanes)),
+			workers: config.GetConsumerPoolConfig(name).Workers,
+		}
+		for i := range q.lanes {
+			q.lanes[i] = make(chan memoryMessage, memoryQueueSize)
+		}
+		b.queues[queue] = q
+	}
+
+	b.producer = &brokerProducer{ctx: ctx, location: loc, logger: logger, broker: b, dlqQueue: config.DLQ_QUEUE}
+
+	for _, service := range []Service{dbService, webhookService, cobrancaService, dlqService} {
+		if err := service.SetProducer(b.producer); err != nil {
+			return nil, err
+		}
+	}
+
+	b.processor = NewProcessor(ctx, loc, logger, webhookService, cobrancaService, dbService, dlqService)
+	if err := b.processor.SetProducer(b.producer); err != nil {
+		return nil, err
+	}
+	if err := b.processor.Subscribe(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Publish grava a mensagem na fila. Com delay, a mensagem é agendada e só entra na fila quando o delay expira.
+func (b *MemoryBroker) Publish(queue string, env models.MessageEnvelope, body []byte, delay time.Duration) error {
+	if b.closing.Load() {
+		return errors.New("encerramento em andamento")
+	}
+	q, ok := b.queues[queue]
+	if !ok {
+		return fmt.Errorf("fila inválida: %s", queue)
+	}
+
+	var msg = memoryMessage{env: env, body: body}
+	if delay <= 0 {
+		return q.enqueue(msg)
+	}
+
+	b.delayed.Add(1)
+	time.AfterFunc(delay, func() {
+		defer b.delayed.Add(-1)
+		if err := q.enqueue(msg); err != nil {
+			helpers.LogError(b.ctx, b.logger, b.location, "memory-broker", "", "erro ao gravar mensagem agendada na fila", err.Error(), map[string]string{"fila": queue, "mensagem": string(body)})
+		}
+	})
+	return nil
+}
+
+// Subscribe registra o handler da fila. workers menores ou iguais a zero mantêm o valor configurado.
+func (b *MemoryBroker) Subscribe(queue string, workers int, handler func(Delivery)) error {
+	q, ok := b.queues[queue]
+	if !ok {
+		return fmt.Errorf("fila inválida: %s", queue)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handler = handler
+	if workers > 0 {
+		q.workers = workers
+	}
+	return nil
+}
+
+// ConsumeQueues inicia os workers de cada fila.
+func (b *MemoryBroker) ConsumeQueues() {
+	for _, q := range b.queues {
+		q.start(b)
+	}
+}
+
+// SetQOS registra o prefetch. Em memória não há mensagens entregues antecipadamente, então o valor é apenas informativo.
+func (b *MemoryBroker) SetQOS(qos int) error {
+	if qos <= 0 {
+		return errors.New("QOS deve ser maior que zero")
+	}
+	b.QOS = qos
+	return nil
+}
+
+// SetQueueQOS altera a quantidade de workers de uma fila. O prefetch é ignorado.
+func (b *MemoryBroker) SetQueueQOS(fila string, workers, prefetch int) error {
+	for _, q := range b.queues {
+		if q.name != fila {
+			continue
+		}
+		q.adjust(b, workers)
+		helpers.LogInfo(b.ctx, b.logger, b.location, "memory-broker", "", "consumer reconfigurado", map[string]any{"fila": fila, "consumer": q.info(b.QOS)})
+		return nil
+	}
+	return fmt.Errorf("fila inválida: %s", fila)
+}
+
+// GetQOS retorna os workers e as mensagens em processamento de cada fila.
+func (b *MemoryBroker) GetQOS() map[string]any {
+	var consumers = make(map[string]any, len(b.queues))
+	for _, q := range b.queues {
+		consumers[q.name] = q.info(b.QOS)
+	}
+	return map[string]any{
+		"QOS":       b.QOS,
+		"consumers": consumers,
+	}
+}
+
+// StopConsuming encerra os workers e rejeita novas publicações. As mensagens em processamento continuam até o fim.
+func (b *MemoryBroker) StopConsuming(ctx context.Context) error {
+	b.closing.Store(true)
+	for _, q := range b.queues {
+		q.shutdown()
+	}
+	return nil
+}
+
+// WaitConsumers aguarda as mensagens em processamento até o prazo do contexto.
+func (b *MemoryBroker) WaitConsumers(ctx context.Context) error {
+	for {
+		var inFlight int64
+		for _, q := range b.queues {
+			inFlight += q.inFlight.Load()
+		}
+		if inFlight == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d mensagens ainda em processamento", inFlight)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Close registra no log as mensagens que serão perdidas por estarem nas filas ou agendadas.
+func (b *MemoryBroker) Close() error {
+	var pending = b.pending()
+	if pending["agendadas"] > 0 || pending["filas"] > 0 {
+		helpers.LogWarn(b.ctx, b.logger, b.location, "memory-broker", "", "mensagens descartadas no encerramento", nil, pending)
+	}
+	helpers.LogInfo(b.ctx, b.logger, b.location, "memory-broker", "", "filas em memória encerradas", nil)
+	return nil
+}
+
+func (b *MemoryBroker) pending() map[string]int {
+	var total int
+	for _, q := range b.queues {
+		total += q.depth().Mensagens
+	}
+	return map[string]int{"filas": total, "agendadas": int(b.delayed.Load())}
+}
+
+// QueueDepths retorna as mensagens aguardando consumo em cada fila e em cada lane de prioridade.
+func (b *MemoryBroker) QueueDepths() (map[string]any, error) {
+	var depths = make(map[string]any, len(b.queues))
+	for _, q := range b.queues {
+		depths[q.queue] = q.depth()
+	}
+	return depths, nil
+}
+
+func (b *MemoryBroker) Check() (map[string]any, error) {
+	filas, _ := b.QueueDepths()
+	return map[string]any{
+		"status":    "ok",
+		"filas":     filas,
+		"agendadas": b.delayed.Load(),
+		"QOS":       b.GetQOS(),
+	}, nil
+}
+
+func (b *MemoryBroker) GetServiceName() string {
+	return "memory-broker"
+}
+
+func (q *memoryQueue) enqueue(msg memoryMessage) error {
+	lane := memoryLane(msg.env.Priority)
+	select {
+	case q.lanes[lane] <- msg:
+		return nil
+	default:
+		return fmt.Errorf("fila %s cheia(lane %s)", q.queue, memoryLanes[lane])
+	}
+}
+
+// next retorna a próxima mensagem da lane de maior prioridade, aguardando caso todas estejam vazias.
+func (q *memoryQueue) next(stop <-chan struct{}) (memoryMessage, bool) {
+	for _, lane := range q.lanes {
+		select {
+		case msg := <-lane:
+			return msg, true
+		default:
+		}
+	}
+
+	select {
+	case <-stop:
+		return memoryMessage{}, false
+	case msg := <-q.lanes[0]:
+		return msg, true
+	case msg := <-q.lanes[1]:
+		return msg, true
+	case msg := <-q.lanes[2]:
+		return msg, true
+	}
+}
+
+func (q *memoryQueue) depth() queueDepth {
+	var depth = queueDepth{Lanes: make(map[string]int, len(q.lanes))}
+	for i, lane := range q.lanes {
+		depth.Lanes[memoryLanes[i]] = len(lane)
+		depth.Mensagens += len(lane)
+	}
+	return depth
+}
+
+func (q *memoryQueue) start(b *MemoryBroker) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.startLocked(b)
+}
+
+func (q *memoryQueue) startLocked(b *MemoryBroker) {
+	if q.handler == nil {
+		return
+	}
+	q.stop = make(chan struct{})
+	for range q.workers {
+		go q.work(b, q.stop)
+	}
+}
+
+func (q *memoryQueue) work(b *MemoryBroker, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		msg, ok := q.next(stop)
+		if !ok {
+			return
+		}
+		q.inFlight.Add(1)
+		q.handler(&memoryDelivery{broker: b, queue: q.queue, msg: msg})
+		q.inFlight.Add(-1)
+	}
+}
+
+func (q *memoryQueue) adjust(b *MemoryBroker, workers int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if workers > 0 {
+		q.workers = workers
+	}
+	if q.stop != nil {
+		close(q.stop)
+		q.stop = nil
+	}
+	q.startLocked(b)
+}
+
+func (q *memoryQueue) shutdown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stop != nil {
+		close(q.stop)
+		q.stop = nil
+	}
+}
+
+func (q *memoryQueue) info(prefetch int) consumerPoolInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return consumerPoolInfo{
+		Workers:  q.workers,
+		Prefetch: prefetch,
+		InFlight: q.inFlight.Load(),
+	}
+}
+
+// brokerProducer implementa Producer sobre um Broker: serializa a mensagem e, se a publicação falhar, a envia para a DLQ.
+type brokerProducer struct {
+	ctx      context.Context
+	location *time.Location
+	logger   *slog.Logger
+	broker   Broker
+	dlqQueue string
+}
+
+func (p *brokerProducer) Produce(queue string, data any, delay time.Duration) error {
+	env := newEnvelope(queue, data, p.location)
+	body, err := json.Marshal(data)
+	if err != nil {
+		helpers.LogError(p.ctx, p.logger, p.location, "producer", "", "erro ao serializar json", err.Error(), nil)
+		if queue != p.dlqQueue {
+			var dlqData = models.DLQData{Payload: data, Contexto: producerContexto(queue), Mensagem: "Erro ao serializar json no producer", Erro: err.Error(), Time: time.Now().In(p.location)}
+			p.Produce(p.dlqQueue, dlqData, 0)
+		}
+		return err
+	}
+
+	if err := p.broker.Publish(queue, env, body, delay); err != nil {
+		helpers.LogError(p.ctx, p.logger, p.location, producerContexto(queue), "", "erro ao gravar mensagem na fila", err.Error(), map[string]string{"fila": queue})
+		if queue != p.dlqQueue {
+			var dlqData = models.DLQData{Payload: data, Contexto: producerContexto(queue), Erro: err.Error(), Mensagem: "erro ao gravar mensagem na fila", Time: time.Now().In(p.location)}
+			p.Produce(p.dlqQueue, dlqData, 0)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/models"
+	"cobranca-bmp/service"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// setTestQueues define os nomes das filas, lidos das variáveis de ambiente fora dos testes.
+func setTestQueues(t *testing.T) {
+	t.Helper()
+	config.COBRANCA_QUEUE = "bmp.cobranca"
+	config.CONSULTA_QUEUE = "bmp.consulta"
+	config.WEBHOOK_QUEUE = "bmp.webhook"
+	config.DB_QUEUE = "bmp.db"
+	config.DLQ_QUEUE = "bmp.dlq"
+}
+
+// newTestMemoryBroker cria o broker em memória com as filas informadas, sem o Processor.
+func newTestMemoryBroker(t *testing.T, queues ...string) *MemoryBroker {
+	t.Helper()
+	var b = &MemoryBroker{
+		ctx:      context.Background(),
+		location: time.UTC,
+		logger:   testLogger,
+		queues:   make(map[string]*memoryQueue),
+	}
+	for _, queue := range queues {
+		q := &memoryQueue{name: queue, queue: queue, lanes: make([]chan memoryMessage, len(memoryLanes)), workers: 1}
+		for i := range q.lanes {
+			q.lanes[i] = make(chan memoryMessage, memoryQueueSize)
+		}
+		b.queues[queue] = q
+	}
+	t.Cleanup(func() { b.StopConsuming(context.Background()) })
+	return b
+}
+
+// receive aguarda a próxima entrega do handler até o prazo.
+func receive(t *testing.T, deliveries <-chan Delivery, timeout time.Duration) Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(timeout):
+		t.Fatalf("nenhuma mensagem entregue em %s", timeout)
+		return nil
+	}
+}
+
+func TestMemoryBrokerPublishSubscribe(t *testing.T) {
+	b := newTestMemoryBroker(t, "fila")
+	var deliveries = make(chan Delivery, 1)
+	if err := b.Subscribe("fila", 0, func(d Delivery) { deliveries <- d }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	b.ConsumeQueues()
+
+	env := models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_WEBHOOK, Attempt: 2}
+	if err := b.Publish("fila", env, []byte(`{"url":"https://cliente"}`), 0); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	d := receive(t, deliveries, time.Second)
+	if d.Envelope().MessageId != "msg-1" || d.Envelope().Attempt != 2 || string(d.Body()) != `{"url":"https://cliente"}` {
+		t.Fatalf("entrega = %+v %s", d.Envelope(), d.Body())
+	}
+	if d.Headers()[headerAttempt] != 2 || d.Headers()["routing_key"] != "fila" {
+		t.Fatalf("headers = %v", d.Headers())
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	if err := b.Publish("outra", env, nil, 0); err == nil {
+		t.Fatal("publicação em fila inexistente aceita")
+	}
+}
+
+func TestMemoryBrokerDelay(t *testing.T) {
+	b := newTestMemoryBroker(t, "fila")
+	var deliveries = make(chan Delivery, 1)
+	b.Subscribe("fila", 0, func(d Delivery) {
+		d.Ack()
+		deliveries <- d
+	})
+	b.ConsumeQueues()
+
+	start := time.Now()
+	if err := b.Publish("fila", models.MessageEnvelope{MessageId: "agendada"}, []byte(`{}`), 200*time.Millisecond); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if pending := b.pending(); pending["agendadas"] != 1 {
+		t.Fatalf("pendentes = %v, esperada uma agendada", pending)
+	}
+
+	select {
+	case <-deliveries:
+		t.Fatal("mensagem entregue antes do delay")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	receive(t, deliveries, time.Second)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("mensagem entregue após %s, antes do delay", elapsed)
+	}
+	if pending := b.pending(); pending["agendadas"] != 0 {
+		t.Fatalf("pendentes = %v", pending)
+	}
+}
+
+func TestMemoryBrokerAckNack(t *testing.T) {
+	var cases = []struct {
+		name     string
+		settle   func(Delivery) error
+		entregas int
+	}{
+		{"ack", func(d Delivery) error { return d.Ack() }, 1},
+		{"nack sem requeue", func(d Delivery) error { return d.Nack(false) }, 1},
+		{"nack com requeue", func(d Delivery) error { return d.Nack(true) }, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := newTestMemoryBroker(t, "fila")
+			var entregas atomic.Int32
+			var deliveries = make(chan Delivery, 2)
+			b.Subscribe("fila", 0, func(d Delivery) {
+				//Apenas a primeira entrega é confirmada pelo caso; a reentrega é confirmada com ack
+				if entregas.Add(1) == 1 {
+					if err := c.settle(d); err != nil {
+						t.Errorf("primeira confirmação: %v", err)
+					}
+					if err := d.Ack(); err == nil {
+						t.Error("mensagem confirmada duas vezes")
+					}
+				} else {
+					d.Ack()
+				}
+				deliveries <- d
+			})
+			b.ConsumeQueues()
+
+			if err := b.Publish("fila", models.MessageEnvelope{MessageId: "msg-1"}, []byte(`{}`), 0); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			for range c.entregas {
+				if d := receive(t, deliveries, time.Second); d.Envelope().MessageId != "msg-1" {
+					t.Fatalf("mensagem entregue = %+v", d.Envelope())
+				}
+			}
+			select {
+			case <-deliveries:
+				t.Fatalf("mensagem entregue mais de %d vezes", c.entregas)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerPrioridade(t *testing.T) {
+	b := newTestMemoryBroker(t, "fila")
+	for _, msg := range []struct {
+		id       string
+		priority uint8
+	}{
+		{"baixa", config.MESSAGE_PRIORITY_BAIXA},
+		{"normal", config.MESSAGE_PRIORITY_NORMAL},
+		{"alta", config.MESSAGE_PRIORITY_ALTA},
+	} {
+		if err := b.Publish("fila", models.MessageEnvelope{MessageId: msg.id, Priority: msg.priority}, []byte(`{}`), 0); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	//Consumindo depois de publicar, para que as três mensagens estejam nas lanes
+	var deliveries = make(chan Delivery, 3)
+	b.Subscribe("fila", 1, func(d Delivery) {
+		d.Ack()
+		deliveries <- d
+	})
+	b.ConsumeQueues()
+
+	for _, want := range []string{"alta", "normal", "baixa"} {
+		if d := receive(t, deliveries, time.Second); d.Envelope().MessageId != want {
+			t.Fatalf("mensagem consumida = %s, esperada %s", d.Envelope().MessageId, want)
+		}
+	}
+}
+
+// Cliente de webhook que falha em todas as entregas.
+type failingWebhookClient struct {
+	calls atomic.Int32
+}
+
+func (c *failingWebhookClient) RequestToWebhook(data any, url string, scheme string) (models.WebhookDelivery, error) {
+	c.calls.Add(1)
+	return models.WebhookDelivery{Url: url}, errors.New("connection refused")
+}
+
+type fakeCobrancaService struct{}
+
+func (fakeCobrancaService) SetProducer(any) error { return nil }
+func (fakeCobrancaService) Cobranca(payload *models.CobrancaTaskData) (any, string, int, error) {
+	return nil, "", 200, nil
+}
+
+type fakeDBService struct{}
+
+func (fakeDBService) SetProducer(any) error                               { return nil }
+func (fakeDBService) UpdateAssync(data models.UpdateDbData) (bool, error) { return false, nil }
+
+// Service da DLQ que registra as mensagens persistidas.
+type fakeDLQService struct {
+	saved chan models.DLQData
+}
+
+func (*fakeDLQService) SetProducer(any) error { return nil }
+func (s *fakeDLQService) Save(data models.DLQData) (bool, error) {
+	s.saved <- data
+	return false, nil
+}
+
+func TestProcessorWebhookReentregaAteDLQ(t *testing.T) {
+	setTestQueues(t)
+	config.WEBHOOK_RETRIES = 2
+	config.WEBHOOK_DELAY = time.Second
+	config.WEBHOOK_MAX_DELAY = time.Second
+	config.WEBHOOK_MAX_AGE = time.Hour
+
+	client := &failingWebhookClient{}
+	webhookService := service.NewWebhookService(client, nil, nil, testLogger, time.UTC)
+	dlq := &fakeDLQService{saved: make(chan models.DLQData, 1)}
+
+	b, err := NewMemoryBroker(context.Background(), time.UTC, testLogger, webhookService, fakeCobrancaService{}, fakeDBService{}, dlq, 1)
+	if err != nil {
+		t.Fatalf("NewMemoryBroker: %v", err)
+	}
+	t.Cleanup(func() { b.StopConsuming(context.Background()) })
+	b.ConsumeQueues()
+
+	start := time.Now()
+	task := models.NewWebhookTaskData("https://cliente/webhook", map[string]any{"id_proposta_parcela": 1001}, "teste")
+	if err := b.producer.Produce(config.WEBHOOK_QUEUE, task, 0); err != nil {
+		t.Fatalf("Produce: %v", err)
+	}
+
+	//Primeira entrega falha e é reagendada com o backoff de 1s; a segunda esgota as tentativas e vai para a DLQ
+	var dlqData models.DLQData
+	select {
+	case dlqData = <-dlq.saved:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("mensagem não chegou na DLQ, entregas ao webhook: %d", client.calls.Load())
+	}
+
+	if calls := client.calls.Load(); calls != 2 {
+		t.Fatalf("entregas ao webhook = %d, esperadas 2", calls)
+	}
+	if elapsed := time.Since(start); elapsed < config.WEBHOOK_DELAY {
+		t.Fatalf("reentrega após %s, antes do backoff de %s", elapsed, config.WEBHOOK_DELAY)
+	}
+	if dlqData.Contexto != "webhook" || dlqData.Mensagem != "Tentativas de entrega do webhook esgotadas" {
+		t.Fatalf("mensagem da DLQ = %+v", dlqData)
+	}
+
+	body, _ := json.Marshal(dlqData.Payload)
+	var payload models.WebhookTaskData
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("payload da DLQ: %v", err)
+	}
+	if payload.Retries != 2 || len(payload.History) != 2 || payload.Url != "https://cliente/webhook" {
+		t.Fatalf("payload da DLQ = %+v", payload)
+	}
+	if pending := b.pending(); pending["filas"] != 0 || pending["agendadas"] != 0 {
+		t.Fatalf("mensagens pendentes = %v", pending)
+	}
+}
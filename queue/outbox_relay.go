@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Intervalo entre as remoções das mensagens publicadas há mais de OUTBOX_RETENTION.
+const outboxPurgeInterval = time.Hour
+
+// OutboxRepository é o repositório de onde o relay lê as mensagens pendentes do outbox.
+type OutboxRepository interface {
+	Claim(limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkPublished(id int64) error
+	MarkFailed(id int64, erro string, disponivelEm time.Time) error
+	DeletePublished(before time.Time) (int64, error)
+}
+
+// OutboxRelay publica nas filas as mensagens gravadas no outbox, com entrega at-least-once: a mensagem só é confirmada no outbox
+// após a confirmação do broker e, se a instância cair antes disso, é publicada novamente quando o lease expira.
+// A publicação é feita diretamente no broker, sem o fallback dos producers, pois a mensagem continua no outbox até ser publicada.
+type OutboxRelay struct {
+	ctx        context.Context
+	broker     Broker
+	repository OutboxRepository
+	logger     *slog.Logger
+	location   *time.Location
+	stop       chan struct{}
+	stopOnce   sync.Once
+	running    atomic.Bool
+	done       chan struct{}
+}
+
+func NewOutboxRelay(ctx context.Context, location *time.Location, logger *slog.Logger, broker Broker, repository OutboxRepository) *OutboxRelay {
+	return &OutboxRelay{
+		ctx:        ctx,
+		broker:     broker,
+		repository: repository,
+		logger:     logger,
+		location:   location,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Run publica as mensagens pendentes a cada OUTBOX_POLL_INTERVAL até Stop ser chamado.
+// Enquanto as leituras retornarem lotes completos, o próximo lote é lido sem esperar o intervalo.
+func (o *OutboxRelay) Run() {
+	o.running.Store(true)
+	defer close(o.done)
+
+	var lastPurge time.Time
+	for {
+		if time.Since(lastPurge) >= outboxPurgeInterval {
+			o.purge()
+			lastPurge = time.Now()
+		}
+
+		if o.relay() < config.OUTBOX_BATCH_SIZE {
+			select {
+			case <-o.stop:
+				return
+			case <-o.ctx.Done():
+				return
+			case <-time.After(config.OUTBOX_POLL_INTERVAL):
+			}
+			continue
+		}
+
+		select {
+		case <-o.stop:
+			return
+		case <-o.ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+// relay publica um lote de mensagens pendentes e retorna a quantidade lida.
+func (o *OutboxRelay) relay() int {
+	mensagens, err := o.repository.Claim(config.OUTBOX_BATCH_SIZE, config.OUTBOX_LEASE)
+	if err != nil {
+		return 0
+	}
+
+	for _, mensagem := range mensagens {
+		if err := o.publish(mensagem); err != nil {
+			retry := outboxRetryDelay(mensagem.Tentativas)
+			logData := map[string]any{"id": mensagem.Id, "fila": mensagem.Fila, "tentativas": mensagem.Tentativas, "proxima_tentativa": retry.String()}
+			helpers.LogError(o.ctx, o.logger, o.location, "outbox", mensagem.MessageId, "Erro ao publicar mensagem do outbox", err.Error(), logData)
+			o.repository.MarkFailed(mensagem.Id, err.Error(), time.Now().In(o.location).Add(retry))
+			continue
+		}
+		//Sem a confirmação a mensagem é publicada novamente quando o lease expirar
+		o.repository.MarkPublished(mensagem.Id)
+	}
+	return len(mensagens)
+}
+
+// publish publica a mensagem com o envelope da fila. O message id é o gravado no outbox, o mesmo em todas as tentativas.
+func (o *OutboxRelay) publish(mensagem models.OutboxMessage) error {
+	env := newEnvelope(mensagem.Fila, outboxData(mensagem), o.location)
+	env.MessageId = mensagem.MessageId
+	return o.broker.Publish(mensagem.Fila, env, mensagem.Payload, mensagem.Delay)
+}
+
+// purge remove as mensagens publicadas há mais de OUTBOX_RETENTION.
+func (o *OutboxRelay) purge() {
+	removed, err := o.repository.DeletePublished(time.Now().In(o.location).Add(-config.OUTBOX_RETENTION))
+	if err == nil && removed > 0 {
+		helpers.LogInfo(o.ctx, o.logger, o.location, "outbox", "", "Mensagens publicadas removidas do outbox", map[string]any{"quantidade": removed})
+	}
+}
+
+// Stop interrompe o relay e aguarda o lote em andamento terminar.
+func (o *OutboxRelay) Stop(ctx context.Context) error {
+	o.stopOnce.Do(func() { close(o.stop) })
+	if !o.running.Load() {
+		return nil
+	}
+	select {
+	case <-o.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// outboxData desserializa o payload no tipo da fila, para que o envelope receba a tentativa e o correlation id da mensagem.
+func outboxData(mensagem models.OutboxMessage) any {
+	var data any
+	switch queueMessageType(mensagem.Fila) {
+	case models.MESSAGE_TYPE_COBRANCA:
+		data = &models.CobrancaTaskData{}
+	case models.MESSAGE_TYPE_WEBHOOK:
+		data = &models.WebhookTaskData{}
+	case models.MESSAGE_TYPE_DB:
+		data = &models.UpdateDbData{}
+	default:
+		return mensagem.Payload
+	}
+	if err := json.Unmarshal(mensagem.Payload, data); err != nil {
+		return mensagem.Payload
+	}
+	return data
+}
+
+// outboxRetryDelay retorna o delay até a próxima tentativa: OUTBOX_RETRY_DELAY dobrado a cada falha, limitado a 64 vezes o valor inicial.
+func outboxRetryDelay(tentativas int) time.Duration {
+	return config.OUTBOX_RETRY_DELAY << min(max(tentativas-1, 0), 6)
+}
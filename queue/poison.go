@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Resultado do processamento de uma mensagem pelo consumer.
+type outcome int
+
+const (
+	outcomeDone        outcome = iota //Processada com sucesso
+	outcomeRescheduled                //Reentrega já publicada na fila com delay pelo service
+	outcomeRetryable                  //Falha de infraestrutura, a mensagem é devolvida para a fila
+	outcomeFinal                      //Erro de negócio ou tentativas esgotadas, já tratado pelo service
+)
+
+// poisonTracker conta as entregas de cada mensagem pelo message id do envelope. Uma mensagem entregue mais vezes que
+// POISON_MAX_DELIVERIES dentro de POISON_WINDOW é considerada venenosa e enviada para a quarentena.
+// A contagem é local à instância, então com várias instâncias consumindo a mesma fila o limite vale por instância.
+type poisonTracker struct {
+	mu        sync.Mutex
+	entries   map[string]poisonEntry
+	lastSweep time.Time
+}
+
+type poisonEntry struct {
+	deliveries int
+	first      time.Time
+}
+
+func newPoisonTracker() *poisonTracker {
+	return &poisonTracker{entries: make(map[string]poisonEntry), lastSweep: time.Now()}
+}
+
+// deliver registra a entrega da mensagem e retorna a quantidade de entregas na janela e se ela ultrapassou o limite.
+func (t *poisonTracker) deliver(messageId string) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastSweep) > time.Minute {
+		t.sweep(now)
+	}
+
+	entry, ok := t.entries[messageId]
+	if !ok || now.Sub(entry.first) > config.POISON_WINDOW {
+		entry = poisonEntry{first: now}
+	}
+	entry.deliveries++
+	t.entries[messageId] = entry
+
+	return entry.deliveries, config.POISON_MAX_DELIVERIES > 0 && entry.deliveries > config.POISON_MAX_DELIVERIES
+}
+
+// forget remove a contagem da mensagem quando ela deixa a fila(ack ou nack sem requeue).
+func (t *poisonTracker) forget(messageId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, messageId)
+}
+
+// Remove as contagens fora da janela, de mensagens que não voltaram a ser entregues.
+func (t *poisonTracker) sweep(now time.Time) {
+	for id, entry := range t.entries {
+		if now.Sub(entry.first) > config.POISON_WINDOW {
+			delete(t.entries, id)
+		}
+	}
+	t.lastSweep = now
+}
+
+// trackedDelivery remove a contagem de entregas quando a mensagem é confirmada ou rejeitada sem requeue.
+type trackedDelivery struct {
+	Delivery
+	tracker   *poisonTracker
+	messageId string
+}
+
+func (d trackedDelivery) Ack() error {
+	d.tracker.forget(d.messageId)
+	return d.Delivery.Ack()
+}
+
+func (d trackedDelivery) Nack(requeue bool) error {
+	if !requeue {
+		d.tracker.forget(d.messageId)
+	}
+	return d.Delivery.Nack(requeue)
+}
+
+// Nome da fila utilizado no contexto da quarentena, o mesmo utilizado no reprocessamento da DLQ.
+func (p *Processor) queueLabel(queue string) string {
+	switch queue {
+	case p.cobrancaQueue:
+		return "cobrança"
+	case p.consultaQueue:
+		return "consulta"
+	case p.webhookQueue:
+		return "webhook"
+	case p.dbqueue:
+		return "db"
+	}
+	return queue
+}
+
+// quarantine rejeita a mensagem sem requeue e a envia para a DLQ com o corpo original e os headers, no contexto "quarentena de <fila>".
+// É utilizada nas mensagens malformadas e nas que ultrapassaram o limite de entregas.
+func (p *Processor) quarantine(queue string, msg Delivery, mensagem string, erro error) {
+	var dlqData = models.DLQData{
+		Payload:  json.RawMessage(msg.Body()),
+		Contexto: "quarentena de " + p.queueLabel(queue),
+		Mensagem: mensagem,
+		Erro:     erro.Error(),
+		Time:     time.Now().In(p.location),
+		Headers:  msg.Headers(),
+	}
+	if !json.Valid(msg.Body()) {
+		dlqData.Payload = string(msg.Body())
+	}
+
+	helpers.LogError(p.ctx, p.logger, p.location, "Quarentena", "", mensagem, erro.Error(), map[string]any{"fila": queue, "headers": dlqData.Headers})
+	if err := msg.Nack(false); err != nil {
+		dlqData.Mensagem += "\n " + "Erro ao realizar Nack de mensagem"
+		dlqData.Erro += "\n " + err.Error()
+		helpers.LogError(p.ctx, p.logger, p.location, "Quarentena", "", "Erro ao realizar Nack de mensagem", err.Error(), nil)
+	}
+	p.producer.Produce(p.dlqQueue, dlqData, 0)
+}
+
+// cobrancaOutcome classifica o resultado de CobrancalService.Cobranca. As mensagens das filas são processadas com CalledAssync,
+// então os erros da API do BMP já foram tratados pelo service(reentrega com delay ou webhook de erro) e são finais.
+// A falha na autenticação ocorre antes desse tratamento e é considerada de infraestrutura.
+func cobrancaOutcome(payload *models.CobrancaTaskData, status string, statusCode int, err error) outcome {
+	switch {
+	case err == nil:
+		return outcomeDone
+	case payload.Reagendada:
+		return outcomeRescheduled
+	case status == "" && statusCode == 401:
+		return outcomeRetryable
+	}
+	return outcomeFinal
+}
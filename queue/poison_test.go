@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Delivery que registra a confirmação da mensagem.
+type stubDelivery struct {
+	env     models.MessageEnvelope
+	body    []byte
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (d *stubDelivery) Envelope() models.MessageEnvelope { return d.env }
+func (d *stubDelivery) Headers() map[string]any          { return map[string]any{"message_id": d.env.MessageId} }
+func (d *stubDelivery) Body() []byte                     { return d.body }
+func (d *stubDelivery) Ack() error {
+	d.acked = true
+	return nil
+}
+func (d *stubDelivery) Nack(requeue bool) error {
+	d.nacked, d.requeue = true, requeue
+	return nil
+}
+
+// Producer que registra as mensagens publicadas.
+type recordingProducer struct {
+	queues []string
+	data   []any
+}
+
+func (p *recordingProducer) Produce(queue string, data any, delay time.Duration) error {
+	p.queues = append(p.queues, queue)
+	p.data = append(p.data, data)
+	return nil
+}
+
+func setPoisonConfig(t *testing.T, maxDeliveries int, window time.Duration) {
+	t.Helper()
+	oldMax, oldWindow := config.POISON_MAX_DELIVERIES, config.POISON_WINDOW
+	config.POISON_MAX_DELIVERIES, config.POISON_WINDOW = maxDeliveries, window
+	t.Cleanup(func() { config.POISON_MAX_DELIVERIES, config.POISON_WINDOW = oldMax, oldWindow })
+}
+
+func TestPoisonTrackerDeliver(t *testing.T) {
+	var cases = []struct {
+		name       string
+		max        int
+		window     time.Duration
+		deliveries int
+		pause      time.Duration //Intervalo antes da última entrega
+		count      int
+		poisoned   bool
+	}{
+		{"abaixo do limite", 3, time.Hour, 3, 0, 3, false},
+		{"acima do limite", 3, time.Hour, 4, 0, 4, true},
+		{"limite desativado", 0, time.Hour, 10, 0, 10, false},
+		{"janela expirada reinicia a contagem", 3, 50 * time.Millisecond, 4, 100 * time.Millisecond, 1, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setPoisonConfig(t, c.max, c.window)
+			tracker := newPoisonTracker()
+
+			var count int
+			var poisoned bool
+			for i := range c.deliveries {
+				if i == c.deliveries-1 {
+					time.Sleep(c.pause)
+				}
+				count, poisoned = tracker.deliver("msg-1")
+			}
+			if count != c.count || poisoned != c.poisoned {
+				t.Fatalf("deliver = %d, %v, esperado %d, %v", count, poisoned, c.count, c.poisoned)
+			}
+			if other, _ := tracker.deliver("msg-2"); other != 1 {
+				t.Fatalf("entregas de outra mensagem = %d, esperada 1", other)
+			}
+		})
+	}
+}
+
+func TestTrackedDeliveryForget(t *testing.T) {
+	var cases = []struct {
+		name   string
+		settle func(Delivery) error
+		forget bool
+	}{
+		{"ack", func(d Delivery) error { return d.Ack() }, true},
+		{"nack sem requeue", func(d Delivery) error { return d.Nack(false) }, true},
+		{"nack com requeue", func(d Delivery) error { return d.Nack(true) }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setPoisonConfig(t, 5, time.Hour)
+			tracker := newPoisonTracker()
+			tracker.deliver("msg-1")
+			tracker.deliver("msg-1")
+
+			if err := c.settle(trackedDelivery{Delivery: &stubDelivery{}, tracker: tracker, messageId: "msg-1"}); err != nil {
+				t.Fatalf("confirmação: %v", err)
+			}
+			_, tracked := tracker.entries["msg-1"]
+			if tracked == c.forget {
+				t.Fatalf("contagem mantida = %v, esperado %v", tracked, !c.forget)
+			}
+		})
+	}
+}
+
+func TestCobrancaOutcome(t *testing.T) {
+	var erro = errors.New("erro")
+	var cases = []struct {
+		name       string
+		reagendada bool
+		status     string
+		statusCode int
+		err        error
+		outcome    outcome
+	}{
+		{"sucesso", false, "Sucesso", 200, nil, outcomeDone},
+		{"reagendada pelo service", true, "", 500, erro, outcomeRescheduled},
+		{"falha na autenticação", false, "", 401, erro, outcomeRetryable},
+		{"não autorizado pela API do BMP", false, "Não autorizado", 401, erro, outcomeFinal},
+		{"erro de negócio", false, "Requisição inválida", 400, erro, outcomeFinal},
+		{"erro sem status", false, "", 500, erro, outcomeFinal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			payload := &models.CobrancaTaskData{Reagendada: c.reagendada}
+			if got := cobrancaOutcome(payload, c.status, c.statusCode, c.err); got != c.outcome {
+				t.Fatalf("outcome = %d, esperado %d", got, c.outcome)
+			}
+		})
+	}
+}
+
+func TestProcessorQuarentenaMensagemMalformada(t *testing.T) {
+	setTestQueues(t)
+	setPoisonConfig(t, 2, time.Hour)
+
+	var cases = []struct {
+		name     string
+		env      models.MessageEnvelope
+		body     string
+		mensagem string
+	}{
+		{"json inválido", models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_COBRANCA, Version: 1}, `{"status":`, "Erro ao deserializar json para requisitar para cobrança na fila"},
+		{"cobrança sem status", models.MessageEnvelope{MessageId: "msg-2", Type: models.MESSAGE_TYPE_COBRANCA, Version: 1}, `{"id_proposta_parcela":1001}`, "Mensagem de cobrança sem status"},
+		{"tipo de outra fila", models.MessageEnvelope{MessageId: "msg-3", Type: models.MESSAGE_TYPE_WEBHOOK, Version: 1}, `{}`, "Mensagem com envelope inválido"},
+		{"versão não suportada", models.MessageEnvelope{MessageId: "msg-4", Type: models.MESSAGE_TYPE_COBRANCA, Version: 99}, `{}`, "Mensagem com envelope inválido"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			producer := &recordingProducer{}
+			p := NewProcessor(context.Background(), time.UTC, testLogger, nil, fakeCobrancaService{}, fakeDBService{}, nil)
+			p.SetProducer(producer)
+
+			msg := &stubDelivery{env: c.env, body: []byte(c.body)}
+			p.withEnvelope(p.cobrancaQueue, models.MESSAGE_TYPE_COBRANCA, p.processCobranca)(msg)
+
+			if !msg.nacked || msg.requeue || msg.acked {
+				t.Fatalf("mensagem confirmada com ack=%v nack=%v requeue=%v, esperado nack sem requeue", msg.acked, msg.nacked, msg.requeue)
+			}
+			if len(producer.queues) != 1 || producer.queues[0] != config.DLQ_QUEUE {
+				t.Fatalf("publicações = %v, esperada a DLQ", producer.queues)
+			}
+			dlqData := producer.data[0].(models.DLQData)
+			if dlqData.Contexto != "quarentena de cobrança" || dlqData.Mensagem != c.mensagem {
+				t.Fatalf("mensagem da DLQ = %s: %s", dlqData.Contexto, dlqData.Mensagem)
+			}
+		})
+	}
+}
+
+func TestProcessorQuarentenaMensagemVenenosa(t *testing.T) {
+	setTestQueues(t)
+	setPoisonConfig(t, 2, time.Hour)
+
+	producer := &recordingProducer{}
+	p := NewProcessor(context.Background(), time.UTC, testLogger, nil, fakeCobrancaService{}, fakeDBService{}, nil)
+	p.SetProducer(producer)
+
+	//O handler devolve a mensagem para a fila em todas as entregas
+	handler := p.withEnvelope(p.cobrancaQueue, models.MESSAGE_TYPE_COBRANCA, func(d Delivery) { d.Nack(true) })
+	env := models.MessageEnvelope{MessageId: "msg-1", Type: models.MESSAGE_TYPE_COBRANCA, Version: 1}
+	for i := 1; i <= 3; i++ {
+		msg := &stubDelivery{env: env, body: []byte(`{}`)}
+		handler(msg)
+		if quarantined := len(producer.queues) > 0; quarantined != (i == 3) || msg.requeue == (i == 3) {
+			t.Fatalf("entrega %d: quarentena = %v, requeue = %v", i, quarantined, msg.requeue)
+		}
+	}
+	if _, tracked := p.poison.entries["msg-1"]; tracked {
+		t.Fatal("contagem mantida após a quarentena")
+	}
+}
@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Processor processa as mensagens das filas de cobrança, consulta, webhook, db e DLQ, independente do backend de filas.
+type Processor struct {
+	ctx             context.Context
+	location        *time.Location
+	logger          *slog.Logger
+	producer        Producer
+	dbService       dbService
+	dlqService      dlqPersistService
+	cobrancaService CobrancaCreditoPessoalService
+	webhookService  WebhookService
+	cobrancaQueue   string
+	consultaQueue   string
+	webhookQueue    string
+	dbqueue         string
+	dlqQueue        string
+	poison          *poisonTracker
+}
+
+func NewProcessor(ctx context.Context, loc *time.Location, logger *slog.Logger,
+	webhookService WebhookService,
+	cobrancaService CobrancaCreditoPessoalService, dbService dbService,
+	dlqService dlqPersistService) *Processor {
+	return &Processor{
+		ctx:             ctx,
+		location:        loc,
+		logger:          logger,
+		dbService:       dbService,
+		dlqService:      dlqService,
+		cobrancaService: cobrancaService,
+		webhookService:  webhookService,
+		cobrancaQueue:   config.COBRANCA_QUEUE,
+		consultaQueue:   config.CONSULTA_QUEUE,
+		webhookQueue:    config.WEBHOOK_QUEUE,
+		dbqueue:         config.DB_QUEUE,
+		dlqQueue:        config.DLQ_QUEUE,
+		poison:          newPoisonTracker(),
+	}
+}
+
+// Configurando o producer utilizado nas retentativas e no envio para a DLQ.
+func (p *Processor) SetProducer(q any) error {
+	if q == nil {
+		return errors.New("producer não pode ser nulo")
+	}
+	producer, ok := q.(Producer)
+	if !ok {
+		return errors.New("producer deve implementar Produce")
+	}
+	p.producer = producer
+	return nil
+}
+
+// Subscribe registra os handlers de todas as filas no broker, com a quantidade de workers configurada em RABBITMQ_CONSUMERS.
+func (p *Processor) Subscribe(b Broker) error {
+	var handlers = []struct {
+		consumer string
+		queue    string
+		handler  func(Delivery)
+	}{
+		{config.CONSUMER_COBRANCA, p.cobrancaQueue, p.processCobranca},
+		{config.CONSUMER_CONSULTA, p.consultaQueue, p.processConsulta},
+		{config.CONSUMER_WEBHOOK, p.webhookQueue, p.processWebhook},
+		{config.CONSUMER_DB, p.dbqueue, p.processDB},
+		{config.CONSUMER_DLQ, p.dlqQueue, p.processDLQ},
+	}
+
+	for _, h := range handlers {
+		handler := p.withEnvelope(h.queue, queueMessageType(h.queue), h.handler)
+		if err := b.Subscribe(h.queue, config.GetConsumerPoolConfig(h.consumer).Workers, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envelopeDelivery entrega ao handler o envelope validado e o corpo convertido para a versão atual do schema.
+type envelopeDelivery struct {
+	Delivery
+	env  models.MessageEnvelope
+	body []byte
+}
+
+func (d envelopeDelivery) Envelope() models.MessageEnvelope {
+	return d.env
+}
+
+func (d envelopeDelivery) Body() []byte {
+	return d.body
+}
+
+// withEnvelope valida o envelope antes do handler e atualiza as mensagens de versões anteriores.
+// Mensagens de outro tipo, de uma versão não suportada ou entregues além do limite(POISON_MAX_DELIVERIES) vão para a quarentena.
+// Na DLQ a mensagem segue para o handler mesmo com envelope inválido, já que ela é persistida em qualquer formato.
+func (p *Processor) withEnvelope(queue, messageType string, handler func(Delivery)) func(Delivery) {
+	return func(msg Delivery) {
+		env := msg.Envelope()
+		body, err := upgradeMessage(&env, msg.Body(), messageType, p.location)
+		if err != nil {
+			if queue == p.dlqQueue {
+				helpers.LogWarn(p.ctx, p.logger, p.location, "Consumo de dlq", "", "Mensagem da DLQ com envelope inválido", err.Error(), env)
+				handler(msg)
+				return
+			}
+			p.quarantine(queue, msg, "Mensagem com envelope inválido", err)
+			return
+		}
+
+		var delivery Delivery = envelopeDelivery{Delivery: msg, env: env, body: body}
+		if queue == p.dlqQueue {
+			handler(delivery)
+			return
+		}
+
+		deliveries, poisoned := p.poison.deliver(env.MessageId)
+		if poisoned {
+			p.poison.forget(env.MessageId)
+			p.quarantine(queue, delivery, "Mensagem reentregue além do limite", fmt.Errorf("%d entregas da mensagem %s", deliveries, env.MessageId))
+			return
+		}
+		handler(trackedDelivery{Delivery: delivery, tracker: p.poison, messageId: env.MessageId})
+	}
+}
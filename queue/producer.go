@@ -35,6 +35,8 @@ type producer struct {
 	logger           *slog.Logger
 	location         *time.Location
 	redisPublisher   RedisPublisher
+	spool            *spool
+	retry            *retryTopology
 }
 
 // setUpProducers configura os producers para as filas digitacaoDlq, simulacaoDlq, dados bancarios e webhook.
@@ -54,6 +56,8 @@ func setUpProducers(rmq *RabbitMQ) {
 		location:       rmq.location,
 		redisPublisher: rmq.cache,
 		exchange:       rmq.exchange,
+		spool:          rmq.spool,
+		retry:          rmq.retry,
 	}
 
 	rmq.baseProducer = &baseProducer
@@ -67,6 +71,7 @@ func setUpProducers(rmq *RabbitMQ) {
 // data é o dado a ser produzido.
 // delay é o delay em milissegundos.
 func (p *producer) Produce(queue string, data any, delay time.Duration) error {
+	env := newEnvelope(queue, data, p.location)
 	body, err := json.Marshal(data)
 	if err != nil {
 		var dlqData = models.DLQData{Payload: data, Mensagem: "Erro ao serializar json no producer", Erro: err.Error(), Time: time.Now().In(p.location)}
@@ -76,19 +81,11 @@ func (p *producer) Produce(queue string, data any, delay time.Duration) error {
 		return err
 	}
 
-	headers := amqp.Table{
-		"x-delay": int32(delay.Milliseconds()),
-	}
-
-	msg := amqp.Publishing{
-		Body:         body,
-		DeliveryMode: 2,
-		Headers:      headers,
-	}
+	msg := newPublishing(body, env, delay)
 	switch queue {
 
 	case p.webhookQueue:
-		err := p.webhookCh.Publish(p.exchange, queue, false, false, msg)
+		err := p.publish(p.webhookCh, p.exchange, queue, msg, delay)
 		if err != nil {
 			helpers.LogError(p.ctx, p.logger, p.location, "producer webhook", "", "erro ao gravar mensagem na fila de webhook", err.Error(), nil)
 			var dlqData = models.DLQData{Payload: data, Contexto: "producer webhook", Erro: err.Error(), Mensagem: "erro ao gravar mensagem na fila de webhook", Time: time.Now().In(p.location)}
@@ -98,26 +95,10 @@ func (p *producer) Produce(queue string, data any, delay time.Duration) error {
 		return nil
 
 	case p.dbQueue:
-		for try := range config.DB_UPDATE_MAX_RETRIES {
-			if err := p.dbCh.Publish(p.exchange, queue, false, false, msg); err == nil {
-				return nil
-			} else {
-				errMsg := fmt.Sprintf("erro ao gravar mensagem na fila de atualização, tentativa %d", try+1)
-				helpers.LogError(p.ctx, p.logger, p.location, "producer atualização", "", errMsg, err.Error(), nil)
-			}
-
-		}
-		redisPayload := data.(models.UpdateDbData)
-		p.redisPublisher.Publish(redisPayload)
-		return err
+		return p.produceDB(p.dbCh, queue, data, msg, delay)
 
 	case p.dlqQueue:
-		msg.Headers = amqp.Table{}
-		err := p.dlqCh.Publish(p.dlqExchange, queue, false, false, msg)
-		if err != nil {
-			helpers.LogError(p.ctx, p.logger, p.location, "producer dlq", "", "erro ao gravar mensagem na fila de dlq de digitacao", err.Error(), nil)
-			return err
-		}
+		return p.produceDLQ(msg)
 
 	default:
 		err := errors.New("invalid queue name")
@@ -126,6 +107,149 @@ func (p *producer) Produce(queue string, data any, delay time.Duration) error {
 
 	}
 
+}
+
+// publishConfirmed publica a mensagem e aguarda a confirmação do broker por até RABBITMQ_CONFIRM_TIMEOUT.
+// Um nack ou a falta de confirmação no prazo são tratados como falha na publicação.
+func publishConfirmed(ctx context.Context, ch *amqp.Channel, exchange, queue string, mandatory bool, msg amqp.Publishing) error {
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, queue, mandatory, false, msg)
+	if err != nil {
+		return err
+	}
+	//Canal sem confirm mode
+	if confirmation == nil {
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, config.RABBITMQ_CONFIRM_TIMEOUT)
+	defer cancel()
+
+	ack, err := confirmation.WaitContext(waitCtx)
+	if err != nil {
+		return fmt.Errorf("broker não confirmou a mensagem: %w", err)
+	}
+	if !ack {
+		return errors.New("mensagem rejeitada pelo broker(nack)")
+	}
+	return nil
+}
+
+// publish publica a mensagem com confirmação, no exchange definido pela topologia de reentrega(ver retryTopology.route).
+func (p *producer) publish(ch *amqp.Channel, exchange, queue string, msg amqp.Publishing, delay time.Duration) error {
+	exchange, mandatory := p.retry.route(exchange, &msg, delay)
+	return publishConfirmed(p.ctx, ch, exchange, queue, mandatory, msg)
+}
+
+// produceDB publica na fila de atualização. Esgotadas as tentativas, a atualização é enviada pelo Redis e, em último caso, gravada no spool.
+// A fila recebe apenas as atualizações que falharam sem conexão com a base de dados, que não podem passar pelo outbox, gravado na mesma base.
+func (p *producer) produceDB(ch *amqp.Channel, queue string, data any, msg amqp.Publishing, delay time.Duration) error {
+	var err error
+	for try := range config.DB_UPDATE_MAX_RETRIES {
+		if err = p.publish(ch, p.exchange, queue, msg, delay); err == nil {
+			return nil
+		}
+		errMsg := fmt.Sprintf("erro ao gravar mensagem na fila de atualização, tentativa %d", try+1)
+		helpers.LogError(p.ctx, p.logger, p.location, "producer atualização", "", errMsg, err.Error(), nil)
+	}
+
+	updateData, ok := data.(models.UpdateDbData)
+	if !ok {
+		p.toSpool(p.exchange, queue, msg, delay)
+		return err
+	}
+	p.fallbackDB(updateData, msg, delay)
+	return err
+}
+
+// fallbackDB envia a atualização pelo Redis e, caso também falhe, grava a mensagem no spool.
+func (p *producer) fallbackDB(data models.UpdateDbData, msg amqp.Publishing, delay time.Duration) {
+	if p.redisPublisher != nil {
+		if err := p.redisPublisher.Publish(data); err == nil {
+			return
+		}
+	}
+	p.toSpool(p.exchange, p.dbQueue, msg, delay)
+}
+
+// produceDLQ publica na DLQ. Se a DLQ também falhar, a mensagem é gravada no spool.
+func (p *producer) produceDLQ(msg amqp.Publishing) error {
+	delete(msg.Headers, "x-delay")
+	err := p.publish(p.dlqCh, p.dlqExchange, p.dlqQueue, msg, 0)
+	if err != nil {
+		helpers.LogError(p.ctx, p.logger, p.location, "producer dlq", "", "erro ao gravar mensagem na fila de dlq", err.Error(), nil)
+		p.toSpool(p.dlqExchange, p.dlqQueue, msg, 0)
+		return err
+	}
 	return nil
+}
+
+// toSpool grava a publicação no spool local, que é republicado quando a conexão com o broker é restabelecida.
+// exchange é o exchange de destino, antes do roteamento da reentrega.
+func (p *producer) toSpool(exchange, queue string, msg amqp.Publishing, delay time.Duration) {
+	entry := newSpoolEntry(exchange, queue, msg, delay, time.Now().In(p.location))
+	if err := p.spool.append(entry); err != nil {
+		helpers.LogError(p.ctx, p.logger, p.location, "producer spool", "", "erro ao gravar mensagem no spool local", err.Error(), string(msg.Body))
+		return
+	}
+	helpers.LogWarn(p.ctx, p.logger, p.location, "producer spool", "", "mensagem gravada no spool local", nil, map[string]string{"fila": queue})
+}
+
+// returned trata as mensagens devolvidas pelo broker por não terem sido roteadas para nenhuma fila(flag mandatory).
+// Atualizações seguem o fallback da fila de atualização, mensagens da DLQ vão para o spool e as demais vão para a DLQ.
+func (p *producer) returned(ret amqp.Return) {
+	errMsg := fmt.Sprintf("%d %s", ret.ReplyCode, ret.ReplyText)
+	helpers.LogError(p.ctx, p.logger, p.location, "producer", "", "mensagem devolvida pelo broker sem ser roteada", errMsg, map[string]string{"exchange": ret.Exchange, "fila": ret.RoutingKey})
+
+	exchange, msg, delay := p.returnedPublishing(ret)
+	switch ret.RoutingKey {
+	case p.dlqQueue:
+		p.toSpool(exchange, ret.RoutingKey, msg, delay)
 
+	case p.dbQueue:
+		var data models.UpdateDbData
+		if err := json.Unmarshal(ret.Body, &data); err != nil {
+			p.toSpool(exchange, ret.RoutingKey, msg, delay)
+			return
+		}
+		p.fallbackDB(data, msg, delay)
+
+	default:
+		var dlqData = models.DLQData{Payload: json.RawMessage(ret.Body), Contexto: producerContexto(ret.RoutingKey), Erro: errMsg, Mensagem: "mensagem devolvida pelo broker sem ser roteada", Time: time.Now().In(p.location)}
+		p.Produce(p.dlqQueue, dlqData, 0)
+	}
+}
+
+// returnedPublishing reconstrói a publicação devolvida pelo broker. O exchange de reentrega é substituído pelo exchange de destino
+// e o delay é o do header x-delay, para que a topologia roteie a mensagem novamente na republicação.
+func (p *producer) returnedPublishing(ret amqp.Return) (string, amqp.Publishing, time.Duration) {
+	exchange := ret.Exchange
+	if p.retry != nil && exchange == p.retry.exchange {
+		exchange = p.retry.target
+	}
+
+	msg := amqp.Publishing{
+		Headers:       ret.Headers,
+		ContentType:   ret.ContentType,
+		DeliveryMode:  ret.DeliveryMode,
+		Priority:      ret.Priority,
+		CorrelationId: ret.CorrelationId,
+		MessageId:     ret.MessageId,
+		Timestamp:     ret.Timestamp,
+		Type:          ret.Type,
+		Body:          ret.Body,
+	}
+	return exchange, msg, time.Duration(headerInt(ret.Headers, "x-delay")) * time.Millisecond
+}
+
+// Contexto gravado na DLQ para cada fila, o mesmo usado quando a publicação falha.
+func producerContexto(queue string) string {
+	switch queue {
+	case config.COBRANCA_QUEUE:
+		return "producer cobrança"
+	case config.CONSULTA_QUEUE:
+		return "producer consulta"
+	case config.WEBHOOK_QUEUE:
+		return "producer webhook"
+	}
+	return "producer"
 }
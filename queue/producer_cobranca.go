@@ -1,12 +1,10 @@
 package queue
 
 import (
-	"cobranca-bmp/config"
 	"cobranca-bmp/helpers"
 	"cobranca-bmp/models"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -48,6 +46,7 @@ func setUpCobrancaProducer(rmq *RabbitMQ, p producer) {
 // data é o dado a ser produzido.
 // delay é o delay em milissegundos.
 func (c *CobrancaProducer) Produce(queue string, data any, delay time.Duration) error {
+	env := newEnvelope(queue, data, c.location)
 	body, err := json.Marshal(data)
 	// Se houver um erro ao serializar o json, envia para a DLQ
 	if err != nil {
@@ -71,29 +70,15 @@ func (c *CobrancaProducer) Produce(queue string, data any, delay time.Duration)
 		return err
 	}
 
-	headers := amqp.Table{
-		"x-delay": int32(delay.Milliseconds()),
-	}
-
-	msg := amqp.Publishing{
-		Body:         body,
-		DeliveryMode: 2,
-		Headers:      headers,
-	}
+	msg := newPublishing(body, env, delay)
 
 	switch queue {
 
 	case c.dlqQueue:
-		msg.Headers = amqp.Table{}
-		err := c.dlqCh.PublishWithContext(c.ctx, c.dlqExchange, queue, false, false, msg)
-		if err != nil {
-			helpers.LogError(c.ctx, c.logger, c.location, "producer dlq", "", "erro ao gravar mensagem na fila de dlq", err.Error(), nil)
-			return err
-		}
-		return nil
+		return c.produceDLQ(msg)
 
 	case c.cobrancaQueue:
-		err := c.cobrancaCh.PublishWithContext(c.ctx, c.exchange, queue, false, false, msg)
+		err := c.publish(c.cobrancaCh, c.exchange, queue, msg, delay)
 		if err != nil {
 			helpers.LogError(c.ctx, c.logger, c.location, "producer cobrança", "", "erro ao gravar mensagem na fila de cobrança", err.Error(), nil)
 			var dlqData = models.DLQData{Payload: data, Contexto: "producer cobrança", Erro: err.Error(), Mensagem: "erro ao gravar mensagem na fila de cobrança", Time: time.Now().In(c.location)}
@@ -103,7 +88,7 @@ func (c *CobrancaProducer) Produce(queue string, data any, delay time.Duration)
 		return nil
 
 	case c.consultaQueue:
-		err := c.cobrancaCh.PublishWithContext(c.ctx, c.exchange, queue, false, false, msg)
+		err := c.publish(c.cobrancaCh, c.exchange, queue, msg, delay)
 		if err != nil {
 			helpers.LogError(c.ctx, c.logger, c.location, "producer consulta", "", "erro ao gravar mensagem na fila de consultas", err.Error(), nil)
 			var dlqData = models.DLQData{Payload: data, Contexto: "producer consulta", Erro: err.Error(), Mensagem: "erro ao gravar mensagem na fila de consultas", Time: time.Now().In(c.location)}
@@ -113,7 +98,7 @@ func (c *CobrancaProducer) Produce(queue string, data any, delay time.Duration)
 		return nil
 
 	case c.webhookQueue:
-		err := c.webhookCh.PublishWithContext(c.ctx, c.exchange, queue, false, false, msg)
+		err := c.publish(c.webhookCh, c.exchange, queue, msg, delay)
 		if err != nil {
 			helpers.LogError(c.ctx, c.logger, c.location, "producer webhook", "", "erro ao gravar mensagem na fila de webhook", err.Error(), nil)
 			var dlqData = models.DLQData{Payload: data, Contexto: "producer webhook", Erro: err.Error(), Mensagem: "erro ao gravar mensagem na fila de webhook", Time: time.Now().In(c.location)}
@@ -123,18 +108,7 @@ func (c *CobrancaProducer) Produce(queue string, data any, delay time.Duration)
 		return nil
 
 	case c.dbQueue:
-		for try := range config.DB_UPDATE_MAX_RETRIES {
-			if err := c.dbCh.PublishWithContext(c.ctx, c.exchange, queue, false, false, msg); err == nil {
-				return nil
-			} else {
-				errMsg := fmt.Sprintf("erro ao gravar mensagem na fila de atualização, tentativa %d", try+1)
-				helpers.LogError(c.ctx, c.logger, c.location, "producer atualização", "", errMsg, err.Error(), nil)
-			}
-
-		}
-		redisPayload := data.(models.UpdateDbData)
-		c.redisPublisher.Publish(redisPayload)
-		return err
+		return c.produceDB(c.dbCh, queue, data, msg, delay)
 
 	default:
 		err := errors.New("invalid queue name")
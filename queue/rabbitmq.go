@@ -3,10 +3,13 @@ package queue
 import (
 	"cobranca-bmp/config"
 	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -24,16 +27,14 @@ type RabbitMQ struct {
 	webhookCh        *amqp.Channel
 	dbCh             *amqp.Channel
 	DlqCh            *amqp.Channel
-	webhookMsgs      <-chan amqp.Delivery
-	dbMsgs           <-chan amqp.Delivery
-	cobrancaMsgs     <-chan amqp.Delivery
-	consultaMsgs     <-chan amqp.Delivery
+	pools            map[string]*consumerPool
 	cobrancaQueue    string
 	consultaQueue    string
 	webhookQueue     string
 	dbqueue          string
 	dlqQueue         string
 	dbService        dbService
+	dlqService       dlqPersistService
 	cobrancaService  CobrancaCreditoPessoalService
 	webhookService   WebhookService
 	QOS              int
@@ -45,6 +46,10 @@ type RabbitMQ struct {
 	location         *time.Location
 	baseProducer     *producer
 	cobrancaProducer *CobrancaProducer
+	processor        *Processor
+	spool            *spool
+	retry            *retryTopology
+	closing          atomic.Bool
 	mu               *sync.Mutex
 }
 
@@ -53,6 +58,7 @@ func NewRMQ(ctx context.Context, loc *time.Location, cache RedisPublisher,
 	logger *slog.Logger,
 	webhookService WebhookService,
 	cobrancaService CobrancaCreditoPessoalService, dbService dbService,
+	dlqService dlqPersistService,
 	QOS int) (*RabbitMQ, error) {
 
 	var rmq = &RabbitMQ{
@@ -71,11 +77,25 @@ func NewRMQ(ctx context.Context, loc *time.Location, cache RedisPublisher,
 		webhookService:  webhookService,
 		cobrancaService: cobrancaService,
 		dbService:       dbService,
+		dlqService:      dlqService,
 		QOS:             QOS,
 		logger:          logger,
+		spool:           newSpool(config.RABBITMQ_SPOOL_PATH),
 		mu:              &sync.Mutex{},
 	}
 
+	//Sem o plugin x-delayed-message, as mensagens com delay passam por filas de espera com TTL
+	if config.RABBITMQ_RETRY_TOPOLOGY == config.RETRY_TOPOLOGY_TTL {
+		rmq.retry = newRetryTopology(rmq.exchange, config.RABBITMQ_RETRY_TIERS)
+	}
+
+	//Configurando os workers e o prefetch de cada fila
+	rmq.pools = rmq.newConsumerPools()
+	rmq.processor = NewProcessor(ctx, loc, logger, webhookService, cobrancaService, dbService, dlqService)
+	if err := rmq.processor.Subscribe(rmq); err != nil {
+		return nil, err
+	}
+
 	//Se conectando ao RabbitMQ
 	conn, err := amqp.Dial(rmq.url)
 	if err != nil {
@@ -107,6 +127,9 @@ func NewRMQ(ctx context.Context, loc *time.Location, cache RedisPublisher,
 		return nil, err
 	}
 
+	//Republicando as mensagens gravadas no spool enquanto o broker estava indisponível
+	go rmq.drainSpool()
+
 	return rmq, nil
 }
 
@@ -124,9 +147,43 @@ func (r *RabbitMQ) injectProducer() error {
 	if err := r.cobrancaService.SetProducer(r.cobrancaProducer); err != nil {
 		return err
 	}
+
+	if err := r.dlqService.SetProducer(r.cobrancaProducer); err != nil {
+		return err
+	}
+
+	if err := r.processor.SetProducer(r.cobrancaProducer); err != nil {
+		return err
+	}
 	return nil
 }
 
+// Publish publica a mensagem já serializada na fila, com confirmação do broker. Não aplica os fallbacks do producer.
+func (r *RabbitMQ) Publish(queue string, env models.MessageEnvelope, body []byte, delay time.Duration) error {
+	var ch *amqp.Channel
+	var exchange = r.exchange
+	switch queue {
+	case r.cobrancaQueue:
+		ch = r.cobrancaCh
+	case r.consultaQueue:
+		ch = r.consultaCh
+	case r.webhookQueue:
+		ch = r.webhookCh
+	case r.dbqueue:
+		ch = r.dbCh
+	case r.dlqQueue:
+		ch = r.DlqCh
+		exchange = r.dlqExchange
+		delay = 0
+	default:
+		return fmt.Errorf("fila inválida: %s", queue)
+	}
+
+	msg := newPublishing(body, env, delay)
+	exchange, mandatory := r.retry.route(exchange, &msg, delay)
+	return publishConfirmed(r.ctx, ch, exchange, queue, mandatory, msg)
+}
+
 // Configurando os canais
 func (r *RabbitMQ) setUpChannels() error {
 
@@ -135,19 +192,19 @@ func (r *RabbitMQ) setUpChannels() error {
 		return err
 	}
 
-	ch, err := r.conn.Channel()
+	ch, err := r.openChannel()
 	if err != nil {
 		return err
 	}
 	r.dbCh = ch
 
-	ch, err = r.conn.Channel()
+	ch, err = r.openChannel()
 	if err != nil {
 		return err
 	}
 	r.DlqCh = ch
 
-	ch, err = r.conn.Channel()
+	ch, err = r.openChannel()
 	if err != nil {
 		return err
 	}
@@ -156,12 +213,72 @@ func (r *RabbitMQ) setUpChannels() error {
 	return nil
 }
 
+// Abre um canal em modo confirm, para que cada publicação aguarde o ack/nack do broker.
+// As mensagens publicadas com a flag mandatory e devolvidas por não terem sido roteadas são tratadas em handleReturns.
+func (r *RabbitMQ) openChannel() (*amqp.Channel, error) {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	go r.handleReturns(ch.NotifyReturn(make(chan amqp.Return, 1)))
+	return ch, nil
+}
+
+// Trata as mensagens devolvidas pelo broker. Cada devolução é tratada em outra goroutine para não bloquear a leitura da conexão,
+// que também entrega as confirmações aguardadas pelo fallback.
+func (r *RabbitMQ) handleReturns(returns <-chan amqp.Return) {
+	for ret := range returns {
+		p := r.baseProducer
+		if p == nil {
+			helpers.LogError(r.ctx, r.logger, r.location, "rabbitmq-return", "", "mensagem devolvida antes da configuração dos producers", ret.ReplyText, string(ret.Body))
+			continue
+		}
+		go p.returned(ret)
+	}
+}
+
+// Republica as mensagens gravadas no spool local. As que falharem permanecem no spool até a próxima reconexão.
+func (r *RabbitMQ) drainSpool() {
+	if r.spool.Pending() == 0 {
+		return
+	}
+
+	sent, err := r.spool.drain(func(entry spoolEntry) error {
+		ch := r.DlqCh
+		if entry.Queue == r.dbqueue {
+			ch = r.dbCh
+		}
+		msg, delay := entry.publishing(time.Now())
+		exchange, mandatory := r.retry.route(entry.Exchange, &msg, delay)
+		return publishConfirmed(r.ctx, ch, exchange, entry.Queue, mandatory, msg)
+	})
+	if err != nil {
+		helpers.LogError(r.ctx, r.logger, r.location, "rabbitmq-spool", "", "erro ao republicar mensagens do spool local", err.Error(), map[string]int{"republicadas": sent})
+		return
+	}
+	helpers.LogInfo(r.ctx, r.logger, r.location, "rabbitmq-spool", "", "mensagens do spool local republicadas", map[string]int{"republicadas": sent, "pendentes": r.spool.Pending()})
+}
+
 // Declara as filas
 func (r *RabbitMQ) declareQueues() error {
 
-	err := r.cobrancaCh.ExchangeDeclare(r.exchange, "x-delayed-message",
-		true, false, false, false,
-		amqp.Table{"x-delayed-type": "direct"})
+	var err error
+	if r.retry != nil {
+		err = r.cobrancaCh.ExchangeDeclare(r.exchange, amqp.ExchangeDirect,
+			true, false, false, false,
+			nil)
+		if err != nil {
+			return err
+		}
+		err = r.retry.declare(r.cobrancaCh)
+	} else {
+		err = r.cobrancaCh.ExchangeDeclare(r.exchange, "x-delayed-message",
+			true, false, false, false,
+			amqp.Table{"x-delayed-type": "direct"})
+	}
 	if err != nil {
 		return err
 	}
@@ -216,6 +333,9 @@ func (r *RabbitMQ) declareQueues() error {
 func (r *RabbitMQ) Reconnect() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.closing.Load() {
+		return errors.New("encerramento em andamento")
+	}
 	if r.conn == nil || r.conn.IsClosed() {
 		conn, err := amqp.Dial(r.url)
 		if err != nil {
@@ -245,25 +365,52 @@ func (r *RabbitMQ) Reconnect() error {
 	helpers.LogInfo(r.ctx, r.logger, r.location, "rabbitmq-reconnect", "", "reconexão bem-sucedida com o rabbitmq", nil)
 
 	go r.ConsumeQueues()
+	go r.drainSpool()
 
 	return nil
 
 }
 
-// SetQOS define o QOS (Quality of Service) para a fila de cobranca.
-// O QOS define a quantidade de mensagens que podem ser consumidas por vez.
+// SetQOS define o prefetch de todas as filas, sem reconectar.
+// O prefetch define a quantidade de mensagens entregues e ainda não confirmadas em cada canal.
 func (r *RabbitMQ) SetQOS(qos int) error {
+	if qos <= 0 {
+		return errors.New("QOS deve ser maior que zero")
+	}
+	r.QOS = qos
 
-	if qos > 0 {
-		r.QOS = qos
+	var errs = make([]error, 0)
+	for _, pool := range r.pools {
+		if err := pool.adjust(0, qos); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pool.name, err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	return r.Reconnect()
+// SetQueueQOS altera a quantidade de workers e/ou o prefetch de uma fila, sem fechar a conexão nem os canais das demais filas.
+// Valores menores ou iguais a zero são mantidos.
+func (r *RabbitMQ) SetQueueQOS(fila string, workers, prefetch int) error {
+	pool, ok := r.pools[fila]
+	if !ok {
+		return fmt.Errorf("fila inválida: %s", fila)
+	}
+	if err := pool.adjust(workers, prefetch); err != nil {
+		return err
+	}
+	helpers.LogInfo(r.ctx, r.logger, r.location, "rabbitmq-qos", "", "consumer reconfigurado", map[string]any{"fila": fila, "consumer": pool.info()})
+	return nil
 }
 
+// GetQOS retorna os workers, o prefetch e as mensagens em processamento de cada fila.
 func (r *RabbitMQ) GetQOS() map[string]any {
+	var consumers = make(map[string]any, len(r.pools))
+	for name, pool := range r.pools {
+		consumers[name] = pool.info()
+	}
 	return map[string]any{
-		"QOS": r.QOS,
+		"QOS":       r.QOS,
+		"consumers": consumers,
 	}
 }
 
@@ -273,6 +420,9 @@ func (r *RabbitMQ) MonitoreServer() {
 		helpers.LogError(r.ctx, r.logger, r.location, "rabbitmq-monitore server", "", "rabbitmq  desconectado", errConn.Error(), nil)
 		for {
 			time.Sleep(time.Second * 20)
+			if r.closing.Load() {
+				return
+			}
 			err := r.Reconnect()
 			if err == nil {
 				return
@@ -282,6 +432,43 @@ func (r *RabbitMQ) MonitoreServer() {
 	}
 }
 
+// StopConsuming cancela os consumers de todas as filas para o encerramento. As mensagens em processamento continuam até o fim
+// e as já entregues e ainda não processadas são devolvidas para a fila. A partir daqui não há mais reconexão.
+func (r *RabbitMQ) StopConsuming(ctx context.Context) error {
+	r.closing.Store(true)
+
+	var errs = make([]error, 0)
+	var requeued = make(map[string]int, len(r.pools))
+	for name, pool := range r.pools {
+		n, err := pool.shutdown()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		requeued[name] = n
+	}
+	helpers.LogInfo(r.ctx, r.logger, r.location, "rabbitmq-shutdown", "", "consumers cancelados", map[string]any{"devolvidas": requeued})
+	return errors.Join(errs...)
+}
+
+// WaitConsumers aguarda as mensagens em processamento até o prazo do contexto.
+// As que não terminarem continuam sem ack e são devolvidas para a fila pelo broker quando os canais forem fechados.
+func (r *RabbitMQ) WaitConsumers(ctx context.Context) error {
+	for {
+		var inFlight int64
+		for _, pool := range r.pools {
+			inFlight += pool.inFlight.Load()
+		}
+		if inFlight == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d mensagens ainda em processamento", inFlight)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
 // Encerra a conexão com o rabbitmq
 func (r *RabbitMQ) Close() error {
 
@@ -356,11 +543,42 @@ func (r *RabbitMQ) Check() (map[string]any, error) {
 	}
 
 	info["QOS"] = r.GetQOS()
+	info["spool"] = r.spool.Pending()
+	info["topologia_reentrega"] = config.RABBITMQ_RETRY_TOPOLOGY
+
+	filas, err := r.QueueDepths()
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+	info["filas"] = filas
 
 	return info, nil
 
 }
 
+// QueueDepths retorna as mensagens aguardando consumo e os consumers de cada fila.
+// A consulta é feita em um canal próprio, pois a declaração passiva de uma fila inexistente fecha o canal.
+func (r *RabbitMQ) QueueDepths() (map[string]any, error) {
+	var depths = make(map[string]any)
+	if r.conn == nil || r.conn.IsClosed() {
+		return depths, fmt.Errorf("conexão fechada com o Rabbitmq")
+	}
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return depths, fmt.Errorf("falha ao abrir canal para consultar as filas: %s", err.Error())
+	}
+	defer ch.Close()
+
+	for _, name := range []string{r.cobrancaQueue, r.consultaQueue, r.webhookQueue, r.dbqueue, r.dlqQueue} {
+		q, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+		if err != nil {
+			return depths, fmt.Errorf("falha ao consultar a fila %s: %s", name, err.Error())
+		}
+		depths[name] = queueDepth{Mensagens: q.Messages, Consumers: q.Consumers}
+	}
+	return depths, nil
+}
+
 func (r *RabbitMQ) GetServiceName() string {
 	return "rabbitmq"
 }
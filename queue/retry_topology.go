@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Header utilizado pelo exchange de reentrega para rotear a mensagem para a fila de espera do nível.
+// Não pode começar com "x-", pois o exchange headers ignora esses headers na comparação.
+const retryTierHeader = "retry-tier"
+
+// retryTier é um nível de espera: a mensagem fica na fila até o TTL expirar e então volta para o exchange das filas.
+type retryTier struct {
+	ttl   time.Duration
+	name  string
+	queue string
+}
+
+// retryTopology substitui o exchange x-delayed-message por filas de espera com TTL(RABBITMQ_RETRY_TOPOLOGY=ttl).
+// As mensagens com delay são publicadas no exchange de reentrega(headers) com o nível no header retry-tier e a fila de destino
+// como routing key. Ao expirar, a mensagem é enviada por dead-letter para o exchange das filas mantendo a routing key original,
+// então uma única fila de espera por nível atende todas as filas.
+type retryTopology struct {
+	target   string
+	exchange string
+	tiers    []retryTier
+}
+
+// newRetryTopology cria a topologia para o exchange target. tiers deve estar ordenado.
+func newRetryTopology(target string, tiers []time.Duration) *retryTopology {
+	var t = &retryTopology{
+		target:   target,
+		exchange: target + ".retry",
+		tiers:    make([]retryTier, 0, len(tiers)),
+	}
+	for _, ttl := range tiers {
+		name := ttl.String()
+		t.tiers = append(t.tiers, retryTier{ttl: ttl, name: name, queue: t.exchange + "." + name})
+	}
+	return t
+}
+
+// declare declara o exchange de reentrega e as filas de espera de cada nível.
+// O TTL faz parte do nome da fila, então alterar os níveis cria novas filas em vez de conflitar com os argumentos das existentes.
+func (t *retryTopology) declare(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(t.exchange, amqp.ExchangeHeaders, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	for _, tier := range t.tiers {
+		_, err := ch.QueueDeclare(tier.queue, true, false, false, false, amqp.Table{
+			"x-message-ttl":          tier.ttl.Milliseconds(),
+			"x-dead-letter-exchange": t.target,
+		})
+		if err != nil {
+			return err
+		}
+		err = ch.QueueBind(tier.queue, "", t.exchange, false, amqp.Table{
+			"x-match":       "all",
+			retryTierHeader: tier.name,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nearest retorna o nível mais próximo do delay. Em caso de empate, o nível maior é escolhido para não antecipar a reentrega.
+func (t *retryTopology) nearest(delay time.Duration) retryTier {
+	var chosen = t.tiers[0]
+	for _, tier := range t.tiers[1:] {
+		if (tier.ttl - delay).Abs() <= (chosen.ttl - delay).Abs() {
+			chosen = tier
+		}
+	}
+	return chosen
+}
+
+// route define o exchange e a flag mandatory da publicação. Sem a topologia de TTL, a mensagem segue para o exchange x-delayed-message,
+// que só roteia a mensagem quando o delay expira, então a flag mandatory é usada apenas nas publicações sem delay.
+// Com a topologia de TTL, a mensagem com delay é direcionada para a fila de espera do nível mais próximo.
+func (t *retryTopology) route(exchange string, msg *amqp.Publishing, delay time.Duration) (string, bool) {
+	if t == nil {
+		return exchange, delay <= 0
+	}
+	if delay <= 0 || exchange != t.target {
+		return exchange, true
+	}
+
+	tier := t.nearest(delay)
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers[retryTierHeader] = tier.name
+	return t.exchange, true
+}
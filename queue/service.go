@@ -23,3 +23,9 @@ type CobrancaCreditoPessoalService interface {
 	Service
 	Cobranca(payload *models.CobrancaTaskData) (any, string, int, error)
 }
+
+// dlqPersistService é a interface que define os métodos que um serviço de persistência da DLQ deve implementar.
+type dlqPersistService interface {
+	Service
+	Save(data models.DLQData) (bool, error)
+}
@@ -1,11 +1,25 @@
 package queue
 
-import "cobranca-bmp/helpers"
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Argumentos das filas de cobrança e consulta. Com RABBITMQ_MAX_PRIORITY, as mensagens são entregues por prioridade
+// e as primeiras tentativas não esperam atrás das reentregas.
+func priorityArgs() amqp.Table {
+	if config.RABBITMQ_MAX_PRIORITY <= 0 {
+		return nil
+	}
+	return amqp.Table{"x-max-priority": int32(config.RABBITMQ_MAX_PRIORITY)}
+}
 
 // Declarando as filas necessárias para o processamento de CreditoPessoal
 func (r *RabbitMQ) declareCobrancaQueues() error {
 
-	_, err := r.cobrancaCh.QueueDeclare(r.cobrancaQueue, true, false, false, false, nil)
+	_, err := r.cobrancaCh.QueueDeclare(r.cobrancaQueue, true, false, false, false, priorityArgs())
 	if err != nil {
 		return err
 	}
@@ -14,7 +28,7 @@ func (r *RabbitMQ) declareCobrancaQueues() error {
 		return err
 	}
 
-	_, err = r.consultaCh.QueueDeclare(r.consultaQueue, true, false, false, false, nil)
+	_, err = r.consultaCh.QueueDeclare(r.consultaQueue, true, false, false, false, priorityArgs())
 	if err != nil {
 		return err
 	}
@@ -27,16 +41,16 @@ func (r *RabbitMQ) declareCobrancaQueues() error {
 
 }
 
-// Obtendo os canais da conexão, eles serão utilizados pelos consumers.
+// Obtendo os canais da conexão, eles serão utilizados pelos consumers e producers.
 func (r *RabbitMQ) setUpCobrancaChannels() error {
-	ch, err := r.conn.Channel()
+	ch, err := r.openChannel()
 	if err != nil {
 		return err
 	}
 
 	r.cobrancaCh = ch
 
-	ch, err = r.conn.Channel()
+	ch, err = r.openChannel()
 	if err != nil {
 		return err
 	}
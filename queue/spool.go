@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// spoolEntry é uma mensagem que não pôde ser publicada no broker e aguarda a reconexão para ser republicada.
+// A publicação é gravada completa(headers e propriedades do envelope) com o delay pedido, e Exchange é o exchange de destino
+// antes do roteamento da reentrega, refeito na republicação(ver retryTopology.route).
+type spoolEntry struct {
+	Exchange      string         `json:"exchange"`
+	Queue         string         `json:"queue"`
+	Body          []byte         `json:"body"`
+	Headers       map[string]any `json:"headers,omitempty"`
+	ContentType   string         `json:"content_type,omitempty"`
+	MessageId     string         `json:"message_id,omitempty"`
+	Type          string         `json:"type,omitempty"`
+	CorrelationId string         `json:"correlation_id,omitempty"`
+	Priority      uint8          `json:"priority,omitempty"`
+	Timestamp     time.Time      `json:"timestamp"`
+	Delay         time.Duration  `json:"delay,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// newSpoolEntry grava a publicação para o exchange de destino. O nível de reentrega não é gravado, pois é definido novamente
+// pela topologia na republicação.
+func newSpoolEntry(exchange, queue string, msg amqp.Publishing, delay time.Duration, now time.Time) spoolEntry {
+	var headers = make(map[string]any, len(msg.Headers))
+	for key, value := range msg.Headers {
+		if key == retryTierHeader {
+			continue
+		}
+		headers[key] = value
+	}
+
+	return spoolEntry{
+		Exchange:      exchange,
+		Queue:         queue,
+		Body:          msg.Body,
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		MessageId:     msg.MessageId,
+		Type:          msg.Type,
+		CorrelationId: msg.CorrelationId,
+		Priority:      msg.Priority,
+		Timestamp:     msg.Timestamp,
+		Delay:         delay,
+		CreatedAt:     now,
+	}
+}
+
+// publishing reconstrói a publicação gravada com o delay que resta desde a gravação, também atualizado no header x-delay.
+func (e spoolEntry) publishing(now time.Time) (amqp.Publishing, time.Duration) {
+	var delay = max(e.Delay-now.Sub(e.CreatedAt), 0)
+
+	var headers = make(amqp.Table, len(e.Headers))
+	for key, value := range e.Headers {
+		headers[key] = spoolHeaderValue(value)
+	}
+	if _, ok := headers["x-delay"]; ok {
+		headers["x-delay"] = int32(delay.Milliseconds())
+	}
+
+	return amqp.Publishing{
+		Body:          e.Body,
+		DeliveryMode:  2,
+		ContentType:   e.ContentType,
+		MessageId:     e.MessageId,
+		Type:          e.Type,
+		CorrelationId: e.CorrelationId,
+		Priority:      e.Priority,
+		Timestamp:     e.Timestamp,
+		Headers:       headers,
+	}, delay
+}
+
+// spoolHeaderValue restaura o tipo inteiro dos headers numéricos, lidos do json como float64.
+// Os headers do envelope(x-schema-version, x-attempt, x-delay) são publicados como int32.
+func spoolHeaderValue(value any) any {
+	number, ok := value.(float64)
+	if !ok || number != math.Trunc(number) {
+		return value
+	}
+	if number >= math.MinInt32 && number <= math.MaxInt32 {
+		return int32(number)
+	}
+	return int64(number)
+}
+
+// spool é o último fallback das publicações: grava em disco(uma mensagem por linha) o que não foi aceito nem pela DLQ,
+// para que nenhuma atualização ou retentativa seja perdida enquanto o broker estiver indisponível.
+type spool struct {
+	path    string
+	mu      sync.Mutex
+	pending int
+}
+
+func newSpool(path string) *spool {
+	s := &spool{path: path}
+	if entries, err := s.read(); err == nil {
+		s.pending = len(entries)
+	}
+	return s
+}
+
+// append grava uma mensagem no final do arquivo.
+func (s *spool) append(entry spoolEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+// drain republica as mensagens gravadas. As que falharem permanecem no arquivo para a próxima tentativa.
+// publish não deve gravar no spool, pois o arquivo fica bloqueado durante a republicação.
+func (s *spool) drain(publish func(spoolEntry) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	var failed bytes.Buffer
+	var sent int
+	for _, line := range entries {
+		var entry spoolEntry
+		if err := json.Unmarshal(line, &entry); err != nil || publish(entry) != nil {
+			failed.Write(append(line, '\n'))
+			continue
+		}
+		sent++
+	}
+
+	if err := os.WriteFile(s.path, failed.Bytes(), 0o644); err != nil {
+		return sent, err
+	}
+	s.pending = len(entries) - sent
+	return sent, nil
+}
+
+// Pending retorna a quantidade de mensagens aguardando republicação.
+func (s *spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Retorna as linhas gravadas. Linhas inválidas também são mantidas para não descartar nenhuma mensagem.
+func (s *spool) read() ([][]byte, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines = make([][]byte, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		lines = append(lines, bytes.Clone(scanner.Bytes()))
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestSpoolPreservaPublicacao(t *testing.T) {
+	s := newSpool(filepath.Join(t.TempDir(), "spool.jsonl"))
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	msg := amqp.Publishing{
+		Body:          []byte(`{"id_proposta_parcela":1001}`),
+		ContentType:   "application/json",
+		MessageId:     "msg-1",
+		Type:          "cobranca",
+		CorrelationId: "corr-1",
+		Priority:      9,
+		Timestamp:     now,
+		Headers: amqp.Table{
+			"x-delay":           int32(30000),
+			headerSchemaVersion: int32(2),
+			headerAttempt:       int32(3),
+			headerLastError:     "timeout",
+			retryTierHeader:     "30s",
+		},
+	}
+	if err := s.append(newSpoolEntry("bmp", "cobranca", msg, 30*time.Second, now)); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if s.Pending() != 1 {
+		t.Fatalf("Pending = %d, esperado 1", s.Pending())
+	}
+
+	var got amqp.Publishing
+	var gotDelay time.Duration
+	var gotEntry spoolEntry
+	sent, err := s.drain(func(entry spoolEntry) error {
+		gotEntry = entry
+		got, gotDelay = entry.publishing(now.Add(10 * time.Second))
+		return nil
+	})
+	if err != nil || sent != 1 || s.Pending() != 0 {
+		t.Fatalf("drain = %d, %v, pendentes %d", sent, err, s.Pending())
+	}
+
+	if gotEntry.Exchange != "bmp" || gotEntry.Queue != "cobranca" {
+		t.Fatalf("destino = %s/%s", gotEntry.Exchange, gotEntry.Queue)
+	}
+	if got.MessageId != "msg-1" || got.Type != "cobranca" || got.CorrelationId != "corr-1" || got.Priority != 9 ||
+		got.ContentType != "application/json" || !got.Timestamp.Equal(now) || got.DeliveryMode != 2 || string(got.Body) != string(msg.Body) {
+		t.Fatalf("propriedades = %+v", got)
+	}
+	if gotDelay != 20*time.Second || got.Headers["x-delay"] != int32(20000) {
+		t.Fatalf("delay = %s, x-delay = %v, esperado o restante de 20s", gotDelay, got.Headers["x-delay"])
+	}
+	if got.Headers[headerSchemaVersion] != int32(2) || got.Headers[headerAttempt] != int32(3) || got.Headers[headerLastError] != "timeout" {
+		t.Fatalf("headers = %v", got.Headers)
+	}
+	if _, ok := got.Headers[retryTierHeader]; ok {
+		t.Fatalf("nível de reentrega gravado no spool: %v", got.Headers)
+	}
+}
+
+func TestSpoolMantemFalhas(t *testing.T) {
+	s := newSpool(filepath.Join(t.TempDir(), "spool.jsonl"))
+	now := time.Now()
+	for _, id := range []string{"msg-1", "msg-2"} {
+		if err := s.append(newSpoolEntry("bmp", "db", amqp.Publishing{MessageId: id, Body: []byte(`{}`)}, 0, now)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	sent, err := s.drain(func(entry spoolEntry) error {
+		if entry.MessageId == "msg-2" {
+			return amqp.ErrClosed
+		}
+		return nil
+	})
+	if err != nil || sent != 1 || s.Pending() != 1 {
+		t.Fatalf("drain = %d, %v, pendentes %d", sent, err, s.Pending())
+	}
+
+	var restantes []string
+	if _, err := s.drain(func(entry spoolEntry) error {
+		restantes = append(restantes, entry.MessageId)
+		return nil
+	}); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if len(restantes) != 1 || restantes[0] != "msg-2" {
+		t.Fatalf("mensagens mantidas no spool = %v", restantes)
+	}
+}
+
+func TestRetryTopologyRoteiaMensagemDoSpool(t *testing.T) {
+	topology := newRetryTopology("bmp", []time.Duration{5 * time.Second, 30 * time.Second})
+	now := time.Now()
+	entry := newSpoolEntry("bmp", "cobranca", amqp.Publishing{Headers: amqp.Table{"x-delay": int32(30000)}}, 30*time.Second, now)
+
+	msg, delay := entry.publishing(now)
+	exchange, mandatory := topology.route(entry.Exchange, &msg, delay)
+	if exchange != "bmp.retry" || !mandatory || msg.Headers[retryTierHeader] != "30s" {
+		t.Fatalf("route = %s, %v, headers %v", exchange, mandatory, msg.Headers)
+	}
+}
@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Representa as operações realizadas nas tabelas de mensagens da DLQ e de auditoria de reprocessamentos
+type DLQRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewDLQRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *DLQRepo {
+	return &DLQRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *DLQRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava uma mensagem da DLQ.
+func (s *DLQRepo) Insert(data models.DLQEntry) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	var payload any
+	if len(data.Payload) > 0 {
+		payload = string(data.Payload)
+	}
+	var headers any
+	if len(data.Headers) > 0 {
+		headers = string(data.Headers)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO bmp_dlq (
+	    contexto,
+	    mensagem,
+	    erro,
+	    id_proposta_parcela,
+	    payload,
+	    headers,
+	    time,
+	    created_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		data.Contexto,
+		data.Mensagem,
+		data.Erro,
+		data.IdPropostaParcela,
+		payload,
+		headers,
+		data.Time,
+		data.CreatedAt,
+	)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_dlq", err.Error(), data)
+		return isConnError(s.db, err), err
+	}
+
+	return false, nil
+}
+
+// FindById busca uma mensagem da DLQ pelo id.
+func (s *DLQRepo) FindById(id int64) (models.DLQEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, `
+			SELECT
+			    d.id,
+			    d.contexto,
+			    d.mensagem,
+			    d.erro,
+			    d.id_proposta_parcela,
+			    d.payload,
+			    d.headers,
+			    d.time,
+			    (SELECT COUNT(*) FROM bmp_dlq_replays r WHERE r.id_dlq=d.id),
+			    d.created_at
+			FROM
+			    bmp_dlq d
+			WHERE
+			    d.id=$1`, id)
+
+	entry, err := scanDLQEntry(row)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em bmp_dlq por id", err.Error(), map[string]any{"id": id})
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DLQEntry{}, errors.New("dados nao encontrados")
+		}
+		return models.DLQEntry{}, err
+	}
+	return entry, nil
+}
+
+// Find lista as mensagens da DLQ por contexto, id_proposta_parcela e/ou intervalo de tempo, da mais recente para a mais antiga.
+func (s *DLQRepo) Find(filter models.DLQEntryFilter) ([]models.DLQEntry, error) {
+	var conditions = make([]string, 0)
+	var args = make([]any, 0)
+
+	if filter.Contexto != "" {
+		args = append(args, filter.Contexto)
+		conditions = append(conditions, fmt.Sprintf("d.contexto=$%d", len(args)))
+	}
+	if filter.IdPropostaParcela > 0 {
+		args = append(args, filter.IdPropostaParcela)
+		conditions = append(conditions, fmt.Sprintf("d.id_proposta_parcela=$%d", len(args)))
+	}
+	if !filter.Inicio.IsZero() {
+		args = append(args, filter.Inicio)
+		conditions = append(conditions, fmt.Sprintf("d.time>=$%d", len(args)))
+	}
+	if !filter.Fim.IsZero() {
+		args = append(args, filter.Fim)
+		conditions = append(conditions, fmt.Sprintf("d.time<=$%d", len(args)))
+	}
+
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	args = append(args, filter.Limit, filter.Offset)
+
+	query := fmt.Sprintf(`
+			SELECT
+			    d.id,
+			    d.contexto,
+			    d.mensagem,
+			    d.erro,
+			    d.id_proposta_parcela,
+			    d.payload,
+			    d.headers,
+			    d.time,
+			    (SELECT COUNT(*) FROM bmp_dlq_replays r WHERE r.id_dlq=d.id),
+			    d.created_at
+			FROM
+			    bmp_dlq d
+			%s
+			ORDER BY d.time DESC, d.id DESC
+			LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_dlq", err.Error(), filter)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries = make([]models.DLQEntry, 0)
+	for rows.Next() {
+		entry, err := scanDLQEntry(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_dlq", err.Error(), filter)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// InsertReplay grava a auditoria de um reprocessamento e, na mesma transação, as mensagens do outbox que o republicam.
+func (s *DLQRepo) InsertReplay(data models.DLQReplay, outbox ...models.OutboxMessage) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	var id int64
+	err := withOutbox(ctx, s.db, outbox, func(exec execer) error {
+		return exec.QueryRowContext(ctx, `
+	INSERT INTO bmp_dlq_replays (
+	    id_dlq,
+	    fila,
+	    usuario,
+	    editado,
+	    payload,
+	    created_at
+	) VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`,
+			data.IdDLQ,
+			data.Fila,
+			data.Usuario,
+			data.Editado,
+			string(data.Payload),
+			data.CreatedAt,
+		).Scan(&id)
+	})
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_dlq_replays", err.Error(), data)
+		return 0, err
+	}
+	return id, nil
+}
+
+// FindReplays lista os reprocessamentos de uma mensagem da DLQ.
+func (s *DLQRepo) FindReplays(idDLQ int64) ([]models.DLQReplay, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+			SELECT
+			    id,
+			    id_dlq,
+			    fila,
+			    usuario,
+			    editado,
+			    payload,
+			    created_at
+			FROM
+			    bmp_dlq_replays
+			WHERE
+			    id_dlq=$1
+			ORDER BY created_at DESC, id DESC`, idDLQ)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_dlq_replays", err.Error(), map[string]any{"id_dlq": idDLQ})
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replays = make([]models.DLQReplay, 0)
+	for rows.Next() {
+		var replay models.DLQReplay
+		var payload sql.NullString
+		if err := rows.Scan(&replay.Id, &replay.IdDLQ, &replay.Fila, &replay.Usuario, &replay.Editado, &payload, &replay.CreatedAt); err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_dlq_replays", err.Error(), map[string]any{"id_dlq": idDLQ})
+			return nil, err
+		}
+		if payload.Valid {
+			replay.Payload = []byte(payload.String)
+		}
+		replays = append(replays, replay)
+	}
+
+	return replays, rows.Err()
+}
+
+func scanDLQEntry(row rowScanner) (models.DLQEntry, error) {
+	var entry models.DLQEntry
+	var payload, headers sql.NullString
+	var mensagem, erro sql.NullString
+
+	err := row.Scan(&entry.Id,
+		&entry.Contexto,
+		&mensagem,
+		&erro,
+		&entry.IdPropostaParcela,
+		&payload,
+		&headers,
+		&entry.Time,
+		&entry.Replays,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		return models.DLQEntry{}, err
+	}
+
+	if payload.Valid {
+		entry.Payload = []byte(payload.String)
+	}
+	if headers.Valid {
+		entry.Headers = []byte(headers.String)
+	}
+	entry.Mensagem = mensagem.String
+	entry.Erro = erro.String
+	return entry, nil
+}
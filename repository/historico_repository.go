@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Representa as operações realizadas na tabela de histórico das parcelas
+type HistoricoRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewHistoricoRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *HistoricoRepo {
+	return &HistoricoRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *HistoricoRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava um registro no histórico da parcela.
+func (s *HistoricoRepo) Insert(data models.CobrancaHistorico) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	var payload any
+	if len(data.Payload) > 0 {
+		payload = string(data.Payload)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO bmp_cobranca_historico (
+	    id_proposta_parcela,
+	    tipo,
+	    ator,
+	    operacao,
+	    idempotency_key,
+	    correlation_id,
+	    status_code,
+	    mensagem,
+	    payload,
+	    created_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		data.IdPropostaParcela,
+		data.Tipo,
+		data.Ator,
+		data.Operacao,
+		data.IdempotencyKey,
+		data.CorrelationId,
+		data.StatusCode,
+		data.Mensagem,
+		payload,
+		data.CreatedAt,
+	)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_cobranca_historico", err.Error(), data)
+		return isConnError(s.db, err), err
+	}
+
+	return false, nil
+}
+
+// FindByIdPropostaParcela retorna o histórico da parcela em ordem cronológica.
+func (s *HistoricoRepo) FindByIdPropostaParcela(idPropostaParcela int) ([]models.CobrancaHistorico, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+			SELECT
+			    id,
+			    id_proposta_parcela,
+			    tipo,
+			    ator,
+			    operacao,
+			    idempotency_key,
+			    correlation_id,
+			    status_code,
+			    mensagem,
+			    payload,
+			    created_at
+			FROM
+			    bmp_cobranca_historico
+			WHERE
+			    id_proposta_parcela=$1
+			ORDER BY created_at, id`, idPropostaParcela)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_cobranca_historico", err.Error(), map[string]any{"id_proposta_parcela": idPropostaParcela})
+		return nil, err
+	}
+	defer rows.Close()
+
+	var historico = make([]models.CobrancaHistorico, 0)
+	for rows.Next() {
+		entry, err := scanCobrancaHistorico(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_cobranca_historico", err.Error(), map[string]any{"id_proposta_parcela": idPropostaParcela})
+			return nil, err
+		}
+		historico = append(historico, entry)
+	}
+
+	return historico, rows.Err()
+}
+
+func scanCobrancaHistorico(row rowScanner) (models.CobrancaHistorico, error) {
+	var entry models.CobrancaHistorico
+	var operacao, idempotencyKey, correlationId, mensagem, payload sql.NullString
+	var statusCode sql.NullInt64
+
+	err := row.Scan(&entry.Id,
+		&entry.IdPropostaParcela,
+		&entry.Tipo,
+		&entry.Ator,
+		&operacao,
+		&idempotencyKey,
+		&correlationId,
+		&statusCode,
+		&mensagem,
+		&payload,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		return models.CobrancaHistorico{}, err
+	}
+
+	if payload.Valid {
+		entry.Payload = []byte(payload.String)
+	}
+	entry.Operacao = operacao.String
+	entry.IdempotencyKey = idempotencyKey.String
+	entry.CorrelationId = correlationId.String
+	entry.StatusCode = int(statusCode.Int64)
+	entry.Mensagem = mensagem.String
+	return entry, nil
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Representa as operações realizadas na tabela de lançamentos das parcelas
+type LancamentoRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewLancamentoRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *LancamentoRepo {
+	return &LancamentoRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *LancamentoRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava um lançamento. Retorna models.ErrLancamentoDuplicado se o evento de origem já foi gravado.
+func (s *LancamentoRepo) Insert(data models.Lancamento) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+	INSERT INTO bmp_lancamentos (
+	    id_proposta_parcela,
+	    id_proposta,
+	    numero_ccb,
+	    numero_parcela,
+	    chave_evento,
+	    tipo,
+	    valor_pagamento,
+	    valor_encargos,
+	    valor_desconto,
+	    valor_abatimento,
+	    valor_excedente,
+	    saldo_anterior,
+	    saldo_atual,
+	    parcela_liquidada,
+	    data_evento,
+	    created_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	ON CONFLICT (chave_evento) DO NOTHING`,
+		data.IdPropostaParcela,
+		data.IdProposta,
+		strconv.Itoa(data.NumeroCCB),
+		data.NumeroParcela,
+		data.ChaveEvento,
+		data.Tipo,
+		data.ValorPagamento,
+		data.ValorEncargos,
+		data.ValorDesconto,
+		data.ValorAbatimento,
+		data.ValorExcedente,
+		data.SaldoAnterior,
+		data.SaldoAtual,
+		data.ParcelaLiquidada,
+		data.DataEvento,
+		data.CreatedAt,
+	)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_lancamentos", err.Error(), data)
+		return isConnError(s.db, err), err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, models.ErrLancamentoDuplicado
+	}
+	return false, nil
+}
+
+// FindByIdPropostaParcela lista os lançamentos da parcela em ordem cronológica.
+func (s *LancamentoRepo) FindByIdPropostaParcela(idPropostaParcela int) ([]models.Lancamento, error) {
+	return s.find("id_proposta_parcela", idPropostaParcela)
+}
+
+// FindByIdProposta lista os lançamentos das parcelas da proposta em ordem cronológica.
+func (s *LancamentoRepo) FindByIdProposta(idProposta int) ([]models.Lancamento, error) {
+	return s.find("id_proposta", idProposta)
+}
+
+// find lista os lançamentos pela coluna informada, que deve ser id_proposta_parcela ou id_proposta.
+func (s *LancamentoRepo) find(column string, id int) ([]models.Lancamento, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+			SELECT
+			    id,
+			    id_proposta_parcela,
+			    id_proposta,
+			    numero_ccb,
+			    numero_parcela,
+			    chave_evento,
+			    tipo,
+			    valor_pagamento,
+			    valor_encargos,
+			    valor_desconto,
+			    valor_abatimento,
+			    valor_excedente,
+			    saldo_anterior,
+			    saldo_atual,
+			    parcela_liquidada,
+			    data_evento,
+			    created_at
+			FROM
+			    bmp_lancamentos
+			WHERE
+			    `+column+`=$1
+			ORDER BY data_evento, id`, id)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_lancamentos", err.Error(), map[string]any{column: id})
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lancamentos = make([]models.Lancamento, 0)
+	for rows.Next() {
+		var data models.Lancamento
+		var numeroCCB, dataEvento sql.NullString
+		err := rows.Scan(&data.Id,
+			&data.IdPropostaParcela,
+			&data.IdProposta,
+			&numeroCCB,
+			&data.NumeroParcela,
+			&data.ChaveEvento,
+			&data.Tipo,
+			&data.ValorPagamento,
+			&data.ValorEncargos,
+			&data.ValorDesconto,
+			&data.ValorAbatimento,
+			&data.ValorExcedente,
+			&data.SaldoAnterior,
+			&data.SaldoAtual,
+			&data.ParcelaLiquidada,
+			&dataEvento,
+			&data.CreatedAt,
+		)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_lancamentos", err.Error(), map[string]any{column: id})
+			return nil, err
+		}
+		data.NumeroCCB, _ = strconv.Atoi(numeroCCB.String)
+		data.DataEvento = dataEvento.String
+		lancamentos = append(lancamentos, data)
+	}
+
+	return lancamentos, rows.Err()
+}
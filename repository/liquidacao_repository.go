@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// registrarLiquidacao grava no histórico a liquidação atual da parcela, na transação da gravação em bmp_cobrancas.
+// Com numeroBoleto 0 é gravado o código de liquidação; caso contrário, o boleto emitido para o código de liquidação informado.
+// Uma nova emissão substitui a liquidação ativa, e o boleto emitido para um código ainda sem boleto apenas o completa.
+func registrarLiquidacao(ctx context.Context, exec execer, idPropostaParcela int, codigoLiquidacao string, numeroBoleto int, now time.Time) error {
+	if codigoLiquidacao == "" && numeroBoleto == 0 {
+		return substituirLiquidacoes(ctx, exec, idPropostaParcela, now)
+	}
+
+	var id, boleto int64
+	err := exec.QueryRowContext(ctx, `
+	SELECT
+	    id,
+	    numero_boleto
+	FROM
+	    bmp_liquidacoes
+	WHERE
+	    id_proposta_parcela=$1 AND codigo_liquidacao=$2 AND status=$3 AND ($4::bigint=0 OR numero_boleto IN (0, $4))
+	ORDER BY numero_boleto DESC
+	LIMIT 1`,
+		idPropostaParcela, codigoLiquidacao, models.LIQUIDACAO_STATUS_ATIVA, numeroBoleto,
+	).Scan(&id, &boleto)
+
+	switch {
+	case err == nil && (numeroBoleto == 0 || boleto == int64(numeroBoleto)):
+		return nil
+	case err == nil:
+		_, err = exec.ExecContext(ctx, `UPDATE bmp_liquidacoes SET numero_boleto=$1, updated_at=$2 WHERE id=$3`, numeroBoleto, now, id)
+		return err
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	if err := substituirLiquidacoes(ctx, exec, idPropostaParcela, now); err != nil {
+		return err
+	}
+	_, err = exec.ExecContext(ctx, `
+	INSERT INTO bmp_liquidacoes (
+	    id_proposta_parcela,
+	    numero_ccb,
+	    parcela,
+	    codigo_liquidacao,
+	    numero_boleto,
+	    status,
+	    data_vencimento,
+	    data_expiracao,
+	    emitida_em,
+	    updated_at
+	)
+	SELECT
+	    id_proposta_parcela,
+	    numero_ccb,
+	    parcela,
+	    $2::text,
+	    $3::bigint,
+	    $4::text,
+	    data_vencimento,
+	    data_expiracao,
+	    $5::timestamptz,
+	    $5::timestamptz
+	FROM
+	    bmp_cobrancas
+	WHERE
+	    id_proposta_parcela=$1
+	ON CONFLICT (id_proposta_parcela, codigo_liquidacao, numero_boleto)
+	DO UPDATE SET
+	    status       = EXCLUDED.status,
+	    encerrada_em = NULL,
+	    updated_at   = EXCLUDED.updated_at`,
+		idPropostaParcela, codigoLiquidacao, numeroBoleto, models.LIQUIDACAO_STATUS_ATIVA, now,
+	)
+	return err
+}
+
+// substituirLiquidacoes encerra a liquidação ativa da parcela, substituída por uma nova emissão.
+func substituirLiquidacoes(ctx context.Context, exec execer, idPropostaParcela int, now time.Time) error {
+	return encerrarLiquidacoes(ctx, exec, idPropostaParcela, models.LIQUIDACAO_STATUS_SUBSTITUIDA, now)
+}
+
+// encerrarLiquidacoes altera para status a liquidação ativa da parcela. A parcela paga com uma liquidação já encerrada
+// como paga(um boleto substituído pago pelo cliente) mantém a liquidação ativa, que não recebeu o pagamento.
+func encerrarLiquidacoes(ctx context.Context, exec execer, idPropostaParcela int, status string, now time.Time) error {
+	_, err := exec.ExecContext(ctx, `
+	UPDATE
+	      bmp_liquidacoes
+	SET
+	    status=$1,
+	    encerrada_em=$2,
+	    updated_at=$2
+	WHERE
+	     id_proposta_parcela=$3 AND status=$4
+	     AND NOT ($1::text=$5::text AND EXISTS (SELECT 1 FROM bmp_liquidacoes p WHERE p.id_proposta_parcela=$3 AND p.status=$5))`,
+		status, now, idPropostaParcela, models.LIQUIDACAO_STATUS_ATIVA, models.LIQUIDACAO_STATUS_PAGA,
+	)
+	return err
+}
+
+// UpdateLiquidacaoStatus altera o status das liquidações da parcela com o código de liquidação ou o número do boleto informados,
+// ao receber um evento de cancelamento ou pagamento de uma emissão que pode já ter sido substituída.
+func (s *ParcelaRepo) UpdateLiquidacaoStatus(idPropostaParcela int, codigoLiquidacao string, numeroBoleto int, status string) (bool, error) {
+	now := time.Now().In(s.location)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE
+	      bmp_liquidacoes
+	SET
+	    status=$1,
+	    encerrada_em=COALESCE(encerrada_em, $2),
+	    updated_at=$2
+	WHERE
+	     id_proposta_parcela=$3 AND status<>$1
+	     AND (($4<>'' AND codigo_liquidacao=$4) OR ($5::bigint<>0 AND numero_boleto=$5))`,
+		status, now, idPropostaParcela, codigoLiquidacao, numeroBoleto,
+	)
+	if err != nil {
+		var logData = map[string]any{"id_proposta_parcela": idPropostaParcela, "codigo_liquidacao": codigoLiquidacao, "numero_boleto": numeroBoleto, "status": status}
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao atualizar status em bmp_liquidacoes", err.Error(), logData)
+		return isConnError(s.db, err), err
+	}
+	return false, nil
+}
+
+// FindLiquidacoes retorna o histórico de liquidações das parcelas de um contrato, por parcela e em ordem de emissão.
+func (s *ParcelaRepo) FindLiquidacoes(numeroCCB int) ([]models.Liquidacao, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT
+	    id,
+	    id_proposta_parcela,
+	    numero_ccb,
+	    parcela,
+	    codigo_liquidacao,
+	    numero_boleto,
+	    status,
+	    to_char(data_vencimento, 'YYYY-MM-DD'),
+	    to_char(data_expiracao, 'YYYY-MM-DD'),
+	    emitida_em,
+	    encerrada_em
+	FROM
+	    bmp_liquidacoes
+	WHERE
+	    numero_ccb=$1
+	ORDER BY parcela, id`, strconv.Itoa(numeroCCB))
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em bmp_liquidacoes por numero_ccb", err.Error(), map[string]any{"numero_ccb": numeroCCB})
+		return nil, err
+	}
+	defer rows.Close()
+
+	var liquidacoes = make([]models.Liquidacao, 0)
+	for rows.Next() {
+		liquidacao, err := scanLiquidacao(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_liquidacoes", err.Error(), map[string]any{"numero_ccb": numeroCCB})
+			return nil, err
+		}
+		liquidacoes = append(liquidacoes, liquidacao)
+	}
+	return liquidacoes, rows.Err()
+}
+
+func scanLiquidacao(row rowScanner) (models.Liquidacao, error) {
+	var liquidacao models.Liquidacao
+	var numeroCCB, dataVencimento, dataExpiracao sql.NullString
+	var parcela sql.NullInt64
+	var encerradaEm sql.NullTime
+
+	err := row.Scan(&liquidacao.Id,
+		&liquidacao.IdPropostaParcela,
+		&numeroCCB,
+		&parcela,
+		&liquidacao.CodigoLiquidacao,
+		&liquidacao.NumeroBoleto,
+		&liquidacao.Status,
+		&dataVencimento,
+		&dataExpiracao,
+		&liquidacao.EmitidaEm,
+		&encerradaEm,
+	)
+	if err != nil {
+		return models.Liquidacao{}, err
+	}
+
+	liquidacao.NumeroCCB, _ = strconv.Atoi(numeroCCB.String)
+	liquidacao.NumeroParcela = int(parcela.Int64)
+	liquidacao.DataVencimento = dataVencimento.String
+	liquidacao.DataExpiracao = dataExpiracao.String
+	if encerradaEm.Valid {
+		liquidacao.EncerradaEm = &encerradaEm.Time
+	}
+	return liquidacao, nil
+}
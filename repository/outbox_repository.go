@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Executa comandos em uma conexão do pool ou em uma transação.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Representa as operações realizadas na tabela do outbox
+type OutboxRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewOutboxRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *OutboxRepo {
+	return &OutboxRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *OutboxRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava mensagens no outbox sem uma alteração associada.
+func (s *OutboxRepo) Insert(mensagens ...models.OutboxMessage) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	err := withOutbox(ctx, s.db, mensagens, func(exec execer) error { return nil })
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_outbox", err.Error(), mensagens)
+		return isConnError(s.db, err), err
+	}
+	return false, nil
+}
+
+// Claim reserva até limit mensagens pendentes por lease e incrementa as tentativas de cada uma.
+// As mensagens reservadas por outra instância são ignoradas(SKIP LOCKED) e voltam a ficar disponíveis quando o lease expira sem confirmação.
+func (s *OutboxRepo) Claim(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	now := time.Now().In(s.location)
+	rows, err := s.db.QueryContext(ctx, `
+	UPDATE
+	      bmp_outbox
+	SET
+	    disponivel_em=$1,
+	    tentativas=tentativas+1
+	WHERE
+	     id IN (
+	        SELECT id FROM bmp_outbox
+	        WHERE published_at IS NULL AND disponivel_em<=$2
+	        ORDER BY disponivel_em, id
+	        LIMIT $3
+	        FOR UPDATE SKIP LOCKED)
+	RETURNING
+	    id,
+	    message_id,
+	    fila,
+	    payload,
+	    delay_ms,
+	    id_proposta_parcela,
+	    tentativas,
+	    erro,
+	    disponivel_em,
+	    published_at,
+	    created_at`,
+		now.Add(lease),
+		now,
+		limit,
+	)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao reservar mensagens de bmp_outbox", err.Error(), nil)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mensagens = make([]models.OutboxMessage, 0)
+	for rows.Next() {
+		mensagem, err := scanOutboxMessage(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_outbox", err.Error(), nil)
+			return nil, err
+		}
+		mensagens = append(mensagens, mensagem)
+	}
+
+	return mensagens, rows.Err()
+}
+
+// MarkPublished confirma a publicação da mensagem.
+func (s *OutboxRepo) MarkPublished(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE bmp_outbox SET published_at=$1, erro=NULL WHERE id=$2`, time.Now().In(s.location), id)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao confirmar publicação em bmp_outbox", err.Error(), map[string]any{"id": id})
+	}
+	return err
+}
+
+// MarkFailed registra a falha na publicação e reagenda a mensagem para disponivelEm.
+func (s *OutboxRepo) MarkFailed(id int64, erro string, disponivelEm time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE bmp_outbox SET erro=$1, disponivel_em=$2 WHERE id=$3 AND published_at IS NULL`, erro, disponivelEm, id)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao registrar falha de publicação em bmp_outbox", err.Error(), map[string]any{"id": id})
+	}
+	return err
+}
+
+// DeletePublished remove as mensagens publicadas antes de before e retorna a quantidade removida.
+func (s *OutboxRepo) DeletePublished(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM bmp_outbox WHERE published_at IS NOT NULL AND published_at<$1`, before)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao remover mensagens publicadas de bmp_outbox", err.Error(), map[string]any{"before": before})
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// withOutbox executa fn e grava as mensagens na mesma transação.
+func withOutbox(ctx context.Context, db *sql.DB, mensagens []models.OutboxMessage, fn func(exec execer) error) error {
+	return withTx(ctx, db, func(exec execer) error {
+		if err := fn(exec); err != nil {
+			return err
+		}
+		return insertOutbox(ctx, exec, mensagens)
+	})
+}
+
+// withTx executa fn em uma transação, confirmada apenas se fn não retornar erro.
+func withTx(ctx context.Context, db *sql.DB, fn func(exec execer) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertOutbox grava as mensagens do outbox utilizando exec.
+func insertOutbox(ctx context.Context, exec execer, mensagens []models.OutboxMessage) error {
+	for _, mensagem := range mensagens {
+		_, err := exec.ExecContext(ctx, `
+		INSERT INTO bmp_outbox (
+		    message_id,
+		    fila,
+		    payload,
+		    delay_ms,
+		    id_proposta_parcela,
+		    disponivel_em,
+		    created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+			mensagem.MessageId,
+			mensagem.Fila,
+			string(mensagem.Payload),
+			mensagem.Delay.Milliseconds(),
+			mensagem.IdPropostaParcela,
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanOutboxMessage(row rowScanner) (models.OutboxMessage, error) {
+	var mensagem models.OutboxMessage
+	var payload string
+	var delayMs int64
+	var erro sql.NullString
+	var publishedAt sql.NullTime
+
+	err := row.Scan(&mensagem.Id,
+		&mensagem.MessageId,
+		&mensagem.Fila,
+		&payload,
+		&delayMs,
+		&mensagem.IdPropostaParcela,
+		&mensagem.Tentativas,
+		&erro,
+		&mensagem.DisponivelEm,
+		&publishedAt,
+		&mensagem.CreatedAt,
+	)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+
+	mensagem.Payload = []byte(payload)
+	mensagem.Delay = time.Duration(delayMs) * time.Millisecond
+	mensagem.Erro = erro.String
+	if publishedAt.Valid {
+		mensagem.PublishedAt = &publishedAt.Time
+	}
+	return mensagem, nil
+}
@@ -0,0 +1,527 @@
+package repository
+
+import (
+	"cobranca-bmp/models"
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Representa a tabela de cobranças em memória, com a mesma semântica de ParcelaRepo: upsert por id_proposta_parcela,
+// buscas retornando os mesmos campos, versão incrementada a cada gravação, atualizações condicionadas ao status ou à versão atual
+// e histórico de liquidações.
+// Utilizado nos testes dos services e dos handlers, que assim não dependem de um banco de dados.
+type MemoryParcelaRepo struct {
+	mu        sync.Mutex
+	location  *time.Location
+	cobrancas map[int]*memoryCobranca
+	outbox    []models.OutboxMessage
+	nextId    int64
+
+	liquidacoes      []*models.Liquidacao
+	nextLiquidacaoId int64
+}
+
+// Linha de bmp_cobrancas. A URL do webhook não faz parte da listagem e é mantida à parte.
+type memoryCobranca struct {
+	models.CobrancaResumo
+	urlWebhook string
+}
+
+func NewMemoryParcelaRepo(location *time.Location) *MemoryParcelaRepo {
+	return &MemoryParcelaRepo{
+		location:  location,
+		cobrancas: make(map[int]*memoryCobranca),
+	}
+}
+
+// Outbox retorna as mensagens gravadas junto com as alterações de status.
+func (s *MemoryParcelaRepo) Outbox() []models.OutboxMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.outbox)
+}
+
+// now retorna o horário atual com a precisão das colunas timestamptz.
+func (s *MemoryParcelaRepo) now() time.Time {
+	return time.Now().In(s.location).Truncate(time.Microsecond)
+}
+
+// upsert grava a cobrança de idPropostaParcela, criando-a com status pendente se ainda não existir.
+// A URL do webhook só é substituída quando informada.
+func (s *MemoryParcelaRepo) upsert(idPropostaParcela int, urlWebhook string, fn func(c *memoryCobranca)) {
+	now := s.now()
+	cobranca, ok := s.cobrancas[idPropostaParcela]
+	if !ok {
+		s.nextId++
+		cobranca = &memoryCobranca{CobrancaResumo: models.CobrancaResumo{
+			Id:                s.nextId,
+			IdPropostaParcela: idPropostaParcela,
+			Status:            models.COBRANCA_STATUS_PENDENTE,
+			Version:           1,
+			CreatedAt:         now,
+		}}
+		s.cobrancas[idPropostaParcela] = cobranca
+	} else {
+		cobranca.Version++
+	}
+	if urlWebhook != "" {
+		cobranca.urlWebhook = urlWebhook
+	}
+	fn(cobranca)
+	cobranca.UpdatedAt = now
+}
+
+func (s *MemoryParcelaRepo) UpdateGeracaoCobranca(data models.GerarCobrancaFrontendInput) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upsert(data.IdPropostaParcela, data.UrlWebhook, func(c *memoryCobranca) {
+		c.IdProposta = data.IdProposta
+		c.IdSecuritizadora = data.IdSecuritizadora
+		c.IdConvenio = data.IdConvenio
+		c.NumeroAcompanhamento = data.NumeroAcompanhamento
+		c.NumeroCCB = data.NumeroCCB
+		c.DataVencimento = data.DataVencimento
+		c.DataExpiracao = data.DataExpiracao
+		c.NumeroParcela = data.NumeroParcela
+		c.IdFormaCobranca = data.TipoCobranca
+	})
+	return false, nil
+}
+
+func (s *MemoryParcelaRepo) UpdateCancelamentoCobranca(data models.CancelarCobrancaFrontendInput) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upsert(data.IdPropostaParcela, data.UrlWebhook, func(c *memoryCobranca) {
+		c.IdProposta = data.IdProposta
+		c.IdSecuritizadora = data.IdSecuritizadora
+		c.IdConvenio = data.IdConvenio
+		c.NumeroAcompanhamento = data.NumeroAcompanhamento
+		c.NumeroCCB = data.NumeroCCB
+		c.NumeroParcela = data.NumeroParcela
+	})
+	return false, nil
+}
+
+func (s *MemoryParcelaRepo) UpdateLancamentoParcela(data models.LancamentoParcelaFrontendInput) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upsert(data.IdPropostaParcela, data.UrlWebhook, func(c *memoryCobranca) {
+		c.IdProposta = data.IdProposta
+		c.IdSecuritizadora = data.IdSecuritizadora
+		c.IdConvenio = data.IdConvenio
+		c.NumeroAcompanhamento = data.NumeroAcompanhamento
+		c.NumeroCCB = data.NumeroCCB
+		c.NumeroParcela = data.NumeroParcela
+	})
+	return false, nil
+}
+
+// UpdateCodLiquidacao grava o código de liquidação, com a mesma condição de versão de ParcelaRepo.UpdateCodLiquidacao.
+func (s *MemoryParcelaRepo) UpdateCodLiquidacao(IdPropostaParcela int, codigoLiquidacao string, version int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updateVersion(IdPropostaParcela, version, func(c *memoryCobranca) {
+		c.CodigoLiquidacao = codigoLiquidacao
+		s.registrarLiquidacao(c, codigoLiquidacao, 0)
+	})
+}
+
+func (s *MemoryParcelaRepo) UpdateNumeroBoleto(IdPropostaParcela, numeroBoleto int, version int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.updateVersion(IdPropostaParcela, version, func(c *memoryCobranca) {
+		c.NumeroBoleto = numeroBoleto
+		s.registrarLiquidacao(c, c.CodigoLiquidacao, numeroBoleto)
+	})
+}
+
+// updateVersion aplica fn à cobrança se ela ainda estiver na versão informada. Versão 0 atualiza sem condição.
+func (s *MemoryParcelaRepo) updateVersion(idPropostaParcela int, version int64, fn func(c *memoryCobranca)) (bool, error) {
+	cobranca, ok := s.cobrancas[idPropostaParcela]
+	if version > 0 && (!ok || cobranca.Version != version) {
+		return false, models.ErrVersaoConcorrente
+	}
+	if ok {
+		fn(cobranca)
+		cobranca.Version++
+		cobranca.UpdatedAt = s.now()
+	}
+	return false, nil
+}
+
+// find retorna a cobrança de menor id que atende a match.
+func (s *MemoryParcelaRepo) find(match func(c *memoryCobranca) bool) (*memoryCobranca, bool) {
+	var found *memoryCobranca
+	for _, cobranca := range s.cobrancas {
+		if match(cobranca) && (found == nil || cobranca.Id < found.Id) {
+			found = cobranca
+		}
+	}
+	return found, found != nil
+}
+
+// cobrancaBMP retorna os campos lidos pelas buscas de ParcelaRepo.
+func (c *memoryCobranca) cobrancaBMP() models.CobrancaBMP {
+	return models.CobrancaBMP{
+		IdProposta:           c.IdProposta,
+		NumeroAcompanhamento: c.NumeroAcompanhamento,
+		IdSecuritizadora:     c.IdSecuritizadora,
+		IdConvenio:           c.IdConvenio,
+		NumeroCCB:            c.NumeroCCB,
+		UrlWebhook:           c.urlWebhook,
+		IdPropostaParcela:    c.IdPropostaParcela,
+		CodigoLiquidacao:     c.CodigoLiquidacao,
+		NumeroParcela:        c.NumeroParcela,
+		NumeroBoleto:         c.NumeroBoleto,
+		IdFormaCobranca:      c.IdFormaCobranca,
+		Status:               c.Status,
+		Version:              c.Version,
+	}
+}
+
+// FindByCodLiquidacao busca pelo código de liquidação atual e, depois, pelos códigos do histórico, como ParcelaRepo.
+func (s *MemoryParcelaRepo) FindByCodLiquidacao(codigoLiquidacao string, numeroCCB int) (models.CobrancaBMP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if codigoLiquidacao == "" {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	return s.findByLiquidacao(numeroCCB, func(codigo string, _ int) bool { return codigo == codigoLiquidacao })
+}
+
+// FindByNumeroBoleto busca pelo boleto atual e, depois, pelos boletos do histórico, como ParcelaRepo.
+func (s *MemoryParcelaRepo) FindByNumeroBoleto(numeroBoleto int, numeroCCB int) (models.CobrancaBMP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if numeroBoleto <= 0 {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	return s.findByLiquidacao(numeroCCB, func(_ string, boleto int) bool { return boleto == numeroBoleto })
+}
+
+// findByLiquidacao retorna a cobrança do contrato cuja liquidação atual atende a match ou, não havendo, a que possui
+// uma liquidação no histórico que atenda a match.
+func (s *MemoryParcelaRepo) findByLiquidacao(numeroCCB int, match func(codigoLiquidacao string, numeroBoleto int) bool) (models.CobrancaBMP, error) {
+	cobranca, ok := s.find(func(c *memoryCobranca) bool {
+		return c.NumeroCCB == numeroCCB && match(c.CodigoLiquidacao, c.NumeroBoleto)
+	})
+	if !ok {
+		cobranca, ok = s.find(func(c *memoryCobranca) bool {
+			return c.NumeroCCB == numeroCCB && slices.ContainsFunc(s.liquidacoes, func(l *models.Liquidacao) bool {
+				return l.IdPropostaParcela == c.IdPropostaParcela && l.NumeroCCB == numeroCCB && match(l.CodigoLiquidacao, l.NumeroBoleto)
+			})
+		})
+	}
+	if !ok {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	return cobranca.cobrancaBMP(), nil
+}
+
+func (s *MemoryParcelaRepo) FindByNumParcela(numParcela int, numeroCCB int) (models.CobrancaBMP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cobranca, ok := s.find(func(c *memoryCobranca) bool {
+		return c.NumeroParcela == numParcela && c.NumeroCCB == numeroCCB
+	})
+	if !ok {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	return cobranca.cobrancaBMP(), nil
+}
+
+// FindByIdPropostaParcela não retorna o número da parcela, assim como ParcelaRepo.
+func (s *MemoryParcelaRepo) FindByIdPropostaParcela(idPropostaParcela int) (models.CobrancaBMP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cobranca, ok := s.cobrancas[idPropostaParcela]
+	if !ok {
+		return models.CobrancaBMP{}, errors.New("dados nao encontrados")
+	}
+	data := cobranca.cobrancaBMP()
+	data.NumeroParcela = 0
+	return data, nil
+}
+
+func (s *MemoryParcelaRepo) FindByDataVencimento(dataVencimento string, numeroCCB int) (models.CobrancaBMP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cobranca, ok := s.find(func(c *memoryCobranca) bool {
+		return c.DataVencimento != "" && c.DataVencimento == dataVencimento && c.NumeroCCB == numeroCCB
+	})
+	if !ok {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	return cobranca.cobrancaBMP(), nil
+}
+
+// UpdateStatus altera o status apenas se a cobrança ainda estiver em from e na versão informada. As mensagens do outbox só são gravadas com a alteração.
+func (s *MemoryParcelaRepo) UpdateStatus(idPropostaParcela int, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cobranca, ok := s.cobrancas[idPropostaParcela]
+	if !ok || cobranca.Status != from || cobranca.Version != version {
+		return false, models.ErrVersaoConcorrente
+	}
+	cobranca.Status = to
+	cobranca.Version++
+	cobranca.UpdatedAt = s.now()
+	if status := models.LiquidacaoStatus(to); status != "" {
+		s.encerrarLiquidacoes(idPropostaParcela, status)
+	}
+	s.outbox = append(s.outbox, outbox...)
+	return false, nil
+}
+
+// UpdateRegistroCobranca grava o código de liquidação e altera o status para to, com a mesma condição de UpdateStatus.
+func (s *MemoryParcelaRepo) UpdateRegistroCobranca(idPropostaParcela int, codigoLiquidacao string, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cobranca, ok := s.cobrancas[idPropostaParcela]
+	if !ok || cobranca.Status != from || cobranca.Version != version {
+		return false, models.ErrVersaoConcorrente
+	}
+	cobranca.CodigoLiquidacao = codigoLiquidacao
+	s.registrarLiquidacao(cobranca, codigoLiquidacao, 0)
+	cobranca.Status = to
+	cobranca.Version++
+	cobranca.UpdatedAt = s.now()
+	s.outbox = append(s.outbox, outbox...)
+	return false, nil
+}
+
+func (s *MemoryParcelaRepo) FindStatus(idPropostaParcela int) (string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cobranca, ok := s.cobrancas[idPropostaParcela]; ok {
+		return cobranca.Status, cobranca.Version, nil
+	}
+	return "", 0, nil
+}
+
+func (s *MemoryParcelaRepo) FindStatusByNumeroCCB(numeroCCB int) (map[int]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status = make(map[int]string)
+	for _, cobranca := range s.cobrancas {
+		if cobranca.NumeroCCB == numeroCCB {
+			status[cobranca.NumeroParcela] = cobranca.Status
+		}
+	}
+	return status, nil
+}
+
+// Search lista as cobranças com os filtros, a ordenação e o cursor de ParcelaRepo.Search.
+func (s *MemoryParcelaRepo) Search(filter models.CobrancaFilter) ([]models.CobrancaResumo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cobrancas = make([]models.CobrancaResumo, 0)
+	for _, c := range s.cobrancas {
+		if !matchesCobrancaFilter(filter, c.CobrancaResumo) {
+			continue
+		}
+		if filter.After != nil {
+			cmp := compareCobranca(c.CobrancaResumo, filter.Sort, filter.After.Value, filter.After.Id)
+			if (filter.Order == "asc" && cmp <= 0) || (filter.Order != "asc" && cmp >= 0) {
+				continue
+			}
+		}
+		cobranca := c.CobrancaResumo
+		if !filter.IncluirPagamento {
+			cobranca.Pagamento = nil
+		}
+		cobrancas = append(cobrancas, cobranca)
+	}
+
+	slices.SortFunc(cobrancas, func(a, b models.CobrancaResumo) int {
+		cmp := compareCobranca(a, filter.Sort, b.SortValue(filter.Sort), b.Id)
+		if filter.Order == "asc" {
+			return cmp
+		}
+		return -cmp
+	})
+
+	if filter.Limit > 0 && len(cobrancas) > filter.Limit {
+		cobrancas = cobrancas[:filter.Limit]
+	}
+	return cobrancas, nil
+}
+
+func (s *MemoryParcelaRepo) UpdatePagamento(idPropostaParcela int, pagamento models.CobrancaPagamento, outbox ...models.OutboxMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cobranca, ok := s.cobrancas[idPropostaParcela]; ok {
+		cobranca.Pagamento = &pagamento
+	}
+	s.outbox = append(s.outbox, outbox...)
+	return false, nil
+}
+
+// registrarLiquidacao grava a liquidação atual da cobrança no histórico, com a semântica de registrarLiquidacao de ParcelaRepo.
+func (s *MemoryParcelaRepo) registrarLiquidacao(c *memoryCobranca, codigoLiquidacao string, numeroBoleto int) {
+	now := s.now()
+	if codigoLiquidacao == "" && numeroBoleto == 0 {
+		s.encerrarLiquidacoes(c.IdPropostaParcela, models.LIQUIDACAO_STATUS_SUBSTITUIDA)
+		return
+	}
+
+	var ativa *models.Liquidacao
+	for _, l := range s.liquidacoes {
+		if l.IdPropostaParcela == c.IdPropostaParcela && l.CodigoLiquidacao == codigoLiquidacao && l.Status == models.LIQUIDACAO_STATUS_ATIVA &&
+			(numeroBoleto == 0 || l.NumeroBoleto == 0 || l.NumeroBoleto == numeroBoleto) && (ativa == nil || l.NumeroBoleto > ativa.NumeroBoleto) {
+			ativa = l
+		}
+	}
+	switch {
+	case ativa != nil && (numeroBoleto == 0 || ativa.NumeroBoleto == numeroBoleto):
+		return
+	case ativa != nil:
+		ativa.NumeroBoleto = numeroBoleto
+		return
+	}
+
+	s.encerrarLiquidacoes(c.IdPropostaParcela, models.LIQUIDACAO_STATUS_SUBSTITUIDA)
+	for _, l := range s.liquidacoes {
+		if l.IdPropostaParcela == c.IdPropostaParcela && l.CodigoLiquidacao == codigoLiquidacao && l.NumeroBoleto == numeroBoleto {
+			l.Status = models.LIQUIDACAO_STATUS_ATIVA
+			l.EncerradaEm = nil
+			return
+		}
+	}
+	s.nextLiquidacaoId++
+	s.liquidacoes = append(s.liquidacoes, &models.Liquidacao{
+		Id:                s.nextLiquidacaoId,
+		IdPropostaParcela: c.IdPropostaParcela,
+		NumeroCCB:         c.NumeroCCB,
+		NumeroParcela:     c.NumeroParcela,
+		CodigoLiquidacao:  codigoLiquidacao,
+		NumeroBoleto:      numeroBoleto,
+		Status:            models.LIQUIDACAO_STATUS_ATIVA,
+		DataVencimento:    c.DataVencimento,
+		DataExpiracao:     c.DataExpiracao,
+		EmitidaEm:         now,
+	})
+}
+
+// encerrarLiquidacoes altera para status a liquidação ativa da parcela, como encerrarLiquidacoes de ParcelaRepo.
+func (s *MemoryParcelaRepo) encerrarLiquidacoes(idPropostaParcela int, status string) {
+	if status == models.LIQUIDACAO_STATUS_PAGA && slices.ContainsFunc(s.liquidacoes, func(l *models.Liquidacao) bool {
+		return l.IdPropostaParcela == idPropostaParcela && l.Status == models.LIQUIDACAO_STATUS_PAGA
+	}) {
+		return
+	}
+	now := s.now()
+	for _, l := range s.liquidacoes {
+		if l.IdPropostaParcela == idPropostaParcela && l.Status == models.LIQUIDACAO_STATUS_ATIVA {
+			l.Status = status
+			l.EncerradaEm = &now
+		}
+	}
+}
+
+// UpdateLiquidacaoStatus altera o status das liquidações da parcela com o código de liquidação ou o número do boleto informados.
+func (s *MemoryParcelaRepo) UpdateLiquidacaoStatus(idPropostaParcela int, codigoLiquidacao string, numeroBoleto int, status string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, l := range s.liquidacoes {
+		if l.IdPropostaParcela != idPropostaParcela || l.Status == status ||
+			!((codigoLiquidacao != "" && l.CodigoLiquidacao == codigoLiquidacao) || (numeroBoleto != 0 && l.NumeroBoleto == numeroBoleto)) {
+			continue
+		}
+		l.Status = status
+		if l.EncerradaEm == nil {
+			l.EncerradaEm = &now
+		}
+	}
+	return false, nil
+}
+
+// FindLiquidacoes retorna o histórico de liquidações das parcelas do contrato, por parcela e em ordem de emissão.
+func (s *MemoryParcelaRepo) FindLiquidacoes(numeroCCB int) ([]models.Liquidacao, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var liquidacoes = make([]models.Liquidacao, 0)
+	for _, l := range s.liquidacoes {
+		if l.NumeroCCB == numeroCCB {
+			liquidacoes = append(liquidacoes, *l)
+		}
+	}
+	slices.SortFunc(liquidacoes, func(a, b models.Liquidacao) int {
+		if a.NumeroParcela != b.NumeroParcela {
+			return a.NumeroParcela - b.NumeroParcela
+		}
+		return int(a.Id - b.Id)
+	})
+	return liquidacoes, nil
+}
+
+// matchesCobrancaFilter aplica os filtros de CobrancaFilter, exceto o cursor, a uma cobrança.
+// Assim como no banco, as cobranças sem data de vencimento não atendem aos filtros de vencimento.
+func matchesCobrancaFilter(f models.CobrancaFilter, c models.CobrancaResumo) bool {
+	switch {
+	case f.IdProposta > 0 && c.IdProposta != f.IdProposta,
+		f.NumeroCCB > 0 && c.NumeroCCB != f.NumeroCCB,
+		f.IdConvenio > 0 && c.IdConvenio != f.IdConvenio,
+		f.IdSecuritizadora > 0 && c.IdSecuritizadora != f.IdSecuritizadora,
+		f.IdFormaCobranca > 0 && c.IdFormaCobranca != f.IdFormaCobranca,
+		len(f.Status) > 0 && !slices.Contains(f.Status, c.Status),
+		!f.VencimentoInicio.IsZero() && (c.DataVencimento == "" || c.DataVencimento < f.VencimentoInicio.Format("2006-01-02")),
+		!f.VencimentoFim.IsZero() && (c.DataVencimento == "" || c.DataVencimento > f.VencimentoFim.Format("2006-01-02")),
+		!f.CriadoInicio.IsZero() && c.CreatedAt.Before(f.CriadoInicio),
+		!f.CriadoFim.IsZero() && c.CreatedAt.After(f.CriadoFim):
+		return false
+	}
+	return true
+}
+
+// compareCobranca compara a cobrança com a posição (value, id) na ordenação sort, na ordem crescente.
+func compareCobranca(c models.CobrancaResumo, sort, value string, id int64) int {
+	var cmp int
+	switch sort {
+	case models.COBRANCA_SORT_CREATED_AT, models.COBRANCA_SORT_UPDATED_AT:
+		t, _ := time.Parse(time.RFC3339Nano, value)
+		cmp = c.CreatedAt.Compare(t)
+		if sort == models.COBRANCA_SORT_UPDATED_AT {
+			cmp = c.UpdatedAt.Compare(t)
+		}
+	case models.COBRANCA_SORT_ID_PROPOSTA_PARCELA:
+		v, _ := strconv.Atoi(value)
+		cmp = c.IdPropostaParcela - v
+	default:
+		cmp = strings.Compare(c.SortValue(sort), value)
+	}
+	if cmp != 0 {
+		return cmp
+	}
+	switch {
+	case c.Id < id:
+		return -1
+	case c.Id > id:
+		return 1
+	}
+	return 0
+}
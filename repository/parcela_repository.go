@@ -6,9 +6,12 @@ import (
 	"cobranca-bmp/models"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -113,13 +116,14 @@ DO UPDATE SET
 	id_convenio            = EXCLUDED.id_convenio,
     numero_acompanhamento = EXCLUDED.numero_acompanhamento,
     numero_ccb            = EXCLUDED.numero_ccb,
-    url_webhook           = EXCLUDED.url_webhook,
+    url_webhook           = COALESCE(NULLIF(EXCLUDED.url_webhook, ''), bmp_cobrancas.url_webhook),
 	id_proposta_parcela   = EXCLUDED.id_proposta_parcela,
     data_vencimento       = EXCLUDED.data_vencimento,
     data_expiracao        = EXCLUDED.data_expiracao,
     parcela        = EXCLUDED.parcela,
     id_forma_cobranca     = EXCLUDED.id_forma_cobranca,
-    updated_at            = EXCLUDED.updated_at;
+    updated_at            = EXCLUDED.updated_at,
+    version               = bmp_cobrancas.version + 1;
 	`,
 		data.IdProposta,
 		data.IdSecuritizadora,
@@ -220,10 +224,11 @@ DO UPDATE SET
 	id_convenio            = EXCLUDED.id_convenio,
     numero_acompanhamento = EXCLUDED.numero_acompanhamento,
     numero_ccb            = EXCLUDED.numero_ccb,
-    url_webhook           = EXCLUDED.url_webhook,
+    url_webhook           = COALESCE(NULLIF(EXCLUDED.url_webhook, ''), bmp_cobrancas.url_webhook),
 	id_proposta_parcela   = EXCLUDED.id_proposta_parcela,
 	parcela        = EXCLUDED.parcela,
-    updated_at            = EXCLUDED.updated_at
+    updated_at            = EXCLUDED.updated_at,
+    version               = bmp_cobrancas.version + 1
 	`,
 		data.IdProposta,
 		data.IdSecuritizadora,
@@ -321,10 +326,11 @@ DO UPDATE SET
 	id_convenio            = EXCLUDED.id_convenio,
     numero_acompanhamento = EXCLUDED.numero_acompanhamento,
     numero_ccb            = EXCLUDED.numero_ccb,
-    url_webhook           = EXCLUDED.url_webhook,
+    url_webhook           = COALESCE(NULLIF(EXCLUDED.url_webhook, ''), bmp_cobrancas.url_webhook),
 	id_proposta_parcela   = EXCLUDED.id_proposta_parcela,
 	parcela        = EXCLUDED.parcela,
-    updated_at            = EXCLUDED.updated_at
+    updated_at            = EXCLUDED.updated_at,
+    version               = bmp_cobrancas.version + 1
 	`,
 		data.IdProposta,
 		data.IdSecuritizadora,
@@ -347,7 +353,10 @@ DO UPDATE SET
 	return false, nil
 }
 
-func (s *ParcelaRepo) UpdateCodLiquidacao(IdPropostaParcela int, codigoLiquidacao string) (bool, error) {
+// UpdateCodLiquidacao grava o código de liquidação da parcela. Com version maior que zero a gravação só ocorre se a parcela
+// ainda estiver nessa versão, retornando models.ErrVersaoConcorrente caso outra operação a tenha gravado depois da leitura.
+// O código é registrado no histórico de liquidações na mesma transação, substituindo a liquidação anterior.
+func (s *ParcelaRepo) UpdateCodLiquidacao(IdPropostaParcela int, codigoLiquidacao string, version int64) (bool, error) {
 	var codLiquidacao any
 
 	switch codigoLiquidacao {
@@ -363,86 +372,167 @@ func (s *ParcelaRepo) UpdateCodLiquidacao(IdPropostaParcela int, codigoLiquidaca
 
 	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
 	defer cancel()
-	_, err := s.db.ExecContext(ctx, `
+
+	var rows int64
+	err := withTx(ctx, s.db, func(exec execer) error {
+		result, err := exec.ExecContext(ctx, `
 	UPDATE
 	      bmp_cobrancas 
 	SET  
         codigo_liquidacao=$1, 
-		updated_at=$2     
+		updated_at=$2,
+		version=version+1
 	WHERE
-	     id_proposta_parcela=$3`,
-
-		codLiquidacao,
-		now,
-		IdPropostaParcela,
-	)
+	     id_proposta_parcela=$3 AND ($4::bigint=0 OR version=$4)`,
+
+			codLiquidacao,
+			now,
+			IdPropostaParcela,
+			version,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, _ = result.RowsAffected(); rows == 0 {
+			return nil
+		}
+		return registrarLiquidacao(ctx, exec, IdPropostaParcela, codigoLiquidacao, 0, now)
+	})
 
+	var logData = map[string]any{"id_proposta_parcela": IdPropostaParcela, "codigo_liquidacao": codigoLiquidacao, "version": version}
 	if err != nil {
-		var logData = map[string]any{"id_proposta_parcela": IdPropostaParcela, "codigo_liquidacao": codigoLiquidacao}
 		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao realizar update na tabela bmp_cobrancas", err.Error(), logData)
 		return isConnError(s.db, err), err
 	}
 
-	return false, nil
+	return s.checkVersion(rows, version, logData)
 }
 
-func (s *ParcelaRepo) UpdateNumeroBoleto(IdPropostaParcela, numeroBoleto int) (bool, error) {
+// UpdateNumeroBoleto grava o número do boleto da parcela, com a mesma condição de versão de UpdateCodLiquidacao.
+// O boleto é registrado no histórico de liquidações junto ao código de liquidação atual da parcela.
+func (s *ParcelaRepo) UpdateNumeroBoleto(IdPropostaParcela, numeroBoleto int, version int64) (bool, error) {
 
 	now := time.Now().In(s.location)
 
 	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
 	defer cancel()
-	_, err := s.db.ExecContext(ctx, `
+
+	var rows int64
+	err := withTx(ctx, s.db, func(exec execer) error {
+		var codigoLiquidacao string
+		err := exec.QueryRowContext(ctx, `
 	UPDATE
 	      bmp_cobrancas 
 	SET  
         numero_boleto=$1, 
-		updated_at=$2     
+		updated_at=$2,
+		version=version+1
 	WHERE
-	     id_proposta_parcela=$3`,
-
-		numeroBoleto,
-		now,
-		IdPropostaParcela,
-	)
+	     id_proposta_parcela=$3 AND ($4::bigint=0 OR version=$4)
+	RETURNING
+	     COALESCE(codigo_liquidacao, '')`,
+
+			numeroBoleto,
+			now,
+			IdPropostaParcela,
+			version,
+		).Scan(&codigoLiquidacao)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rows = 1
+		return registrarLiquidacao(ctx, exec, IdPropostaParcela, codigoLiquidacao, numeroBoleto, now)
+	})
 
+	var logData = map[string]any{"id_proposta_parcela": IdPropostaParcela, "numero_boleto": numeroBoleto, "version": version}
 	if err != nil {
-		var logData = map[string]any{"id_proposta_parcela": IdPropostaParcela, "numero_boleto": numeroBoleto}
 		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao realizar update na tabela bmp_cobrancas", err.Error(), logData)
 		return isConnError(s.db, err), err
 	}
 
+	return s.checkVersion(rows, version, logData)
+}
+
+// checkVersion retorna models.ErrVersaoConcorrente quando a atualização condicionada à versão não alterou a parcela.
+func (s *ParcelaRepo) checkVersion(rows int64, version int64, logData map[string]any) (bool, error) {
+	if version <= 0 {
+		return false, nil
+	}
+	if rows == 0 {
+		helpers.LogWarn(s.ctx, s.logger, s.location, "db", "", "Parcela não atualizada em bmp_cobrancas", models.ErrVersaoConcorrente.Error(), logData)
+		return false, models.ErrVersaoConcorrente
+	}
 	return false, nil
 }
 
+// FindByCodLiquidacao busca a parcela pelo código de liquidação atual ou por um código do histórico de liquidações,
+// para que os eventos de uma liquidação substituída ainda encontrem a parcela. Retorna o código de liquidação atual.
 func (s *ParcelaRepo) FindByCodLiquidacao(codigoLiquidacao string, numeroCCB int) (models.CobrancaBMP, error) {
+	if codigoLiquidacao == "" {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	cobranca, err := s.findByLiquidacao(`c.codigo_liquidacao=$1`, `l.codigo_liquidacao=$1`, codigoLiquidacao, numeroCCB)
+	if err != nil {
+		var logData = map[string]any{"codigo_liquidacao": codigoLiquidacao, "numero_ccb": numeroCCB}
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em cobranca_bmp por código de liquidação", err.Error(), logData)
+		return models.CobrancaBMP{}, err
+	}
+	return cobranca, nil
+}
+
+// FindByNumeroBoleto busca a parcela pelo boleto atual ou por um boleto do histórico de liquidações, como FindByCodLiquidacao.
+func (s *ParcelaRepo) FindByNumeroBoleto(numeroBoleto int, numeroCCB int) (models.CobrancaBMP, error) {
+	if numeroBoleto <= 0 {
+		return models.CobrancaBMP{}, sql.ErrNoRows
+	}
+	cobranca, err := s.findByLiquidacao(`c.numero_boleto=$1`, `l.numero_boleto=$1`, numeroBoleto, numeroCCB)
+	if err != nil {
+		var logData = map[string]any{"numero_boleto": numeroBoleto, "numero_ccb": numeroCCB}
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em cobranca_bmp por número do boleto", err.Error(), logData)
+		return models.CobrancaBMP{}, err
+	}
+	return cobranca, nil
+}
+
+// findByLiquidacao busca a parcela do contrato que atende a atual ou que tenha uma liquidação no histórico que atenda a historico.
+// A parcela cuja liquidação atual atende à condição tem prioridade.
+func (s *ParcelaRepo) findByLiquidacao(atual, historico string, value any, numeroCCB int) (models.CobrancaBMP, error) {
 	var cobranca models.CobrancaBMP
-	cobranca.CodigoLiquidacao = codigoLiquidacao
+	var codLiquidacao sql.NullString
 	var numeroBoleto sql.NullInt64
 	numeroCCBString := strconv.Itoa(numeroCCB)
 
 	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, `
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
 			SELECT
-			    id_proposta,
-				numero_acompanhamento,
-		        id_securitizadora,
-				id_convenio, 
-		        numero_ccb,
-		        url_webhook,
-		        id_proposta_parcela,
-		        parcela,
-		        id_forma_cobranca,
-				numero_boleto
+			    c.id_proposta,
+				c.numero_acompanhamento,
+		        c.id_securitizadora,
+				c.id_convenio, 
+		        c.numero_ccb,
+		        c.url_webhook,
+		        c.id_proposta_parcela,
+		        c.parcela,
+		        c.codigo_liquidacao,
+		        COALESCE(c.id_forma_cobranca, 0),
+				c.numero_boleto,
+				c.status,
+				c.version
 			
 			FROM 
-			    bmp_cobrancas
+			    bmp_cobrancas c
 
 	        WHERE
-				codigo_liquidacao=$1 AND numero_ccb=$2`,
-		codigoLiquidacao, numeroCCBString,
+				c.numero_ccb=$2 AND (%s OR c.id_proposta_parcela IN (
+				    SELECT l.id_proposta_parcela FROM bmp_liquidacoes l WHERE %s AND l.numero_ccb=$2))
+			ORDER BY COALESCE(%s, false) DESC, c.id
+			LIMIT 1`, atual, historico, atual),
+		value, numeroCCBString,
 	).Scan(&cobranca.IdProposta,
 		&cobranca.NumeroAcompanhamento,
 		&cobranca.IdSecuritizadora,
@@ -451,13 +541,15 @@ func (s *ParcelaRepo) FindByCodLiquidacao(codigoLiquidacao string, numeroCCB int
 		&cobranca.UrlWebhook,
 		&cobranca.IdPropostaParcela,
 		&cobranca.NumeroParcela,
+		&codLiquidacao,
 		&cobranca.IdFormaCobranca,
-		&numeroBoleto)
+		&numeroBoleto,
+		&cobranca.Status,
+		&cobranca.Version)
 	if err != nil {
-		var logData = map[string]any{"codigo_liquidacao": codigoLiquidacao, "numero_ccb": numeroCCB}
-		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em cobranca_bmp por código de liquidação", err.Error(), logData)
 		return models.CobrancaBMP{}, err
 	}
+	cobranca.CodigoLiquidacao = codLiquidacao.String
 	cobranca.NumeroBoleto = int(numeroBoleto.Int64)
 	return cobranca, nil
 
@@ -483,8 +575,10 @@ func (s *ParcelaRepo) FindByNumParcela(numParcela int, numeroCCB int) (models.Co
 		        url_webhook,
 		        id_proposta_parcela,
 		      codigo_liquidacao,
-		        id_forma_cobranca,
-				numero_boleto
+		        COALESCE(id_forma_cobranca, 0),
+				numero_boleto,
+				status,
+				version
 			
 			FROM 
 			    bmp_cobrancas
@@ -502,6 +596,8 @@ func (s *ParcelaRepo) FindByNumParcela(numParcela int, numeroCCB int) (models.Co
 		&codLiquidacao,
 		&cobranca.IdFormaCobranca,
 		&numeroBoleto,
+		&cobranca.Status,
+		&cobranca.Version,
 	)
 	if err != nil {
 		var logData = map[string]any{"parcela": numParcela, "numero_ccb": numeroCCB}
@@ -535,7 +631,9 @@ func (s *ParcelaRepo) FindByIdPropostaParcela(idPropostaParcela int) (models.Cob
 		        url_webhook,
 		      codigo_liquidacao,
 			  numero_boleto,
-		        id_forma_cobranca
+		        COALESCE(id_forma_cobranca, 0),
+				status,
+				version
 			
 			FROM 
 			    bmp_cobrancas
@@ -551,7 +649,9 @@ func (s *ParcelaRepo) FindByIdPropostaParcela(idPropostaParcela int) (models.Cob
 		&cobranca.UrlWebhook,
 		&codLiquidacao,
 		&numeroBoleto,
-		&cobranca.IdFormaCobranca)
+		&cobranca.IdFormaCobranca,
+		&cobranca.Status,
+		&cobranca.Version)
 	if err != nil {
 		var logData = map[string]any{"id_proposta_parcela": idPropostaParcela}
 		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em cobranca_bmp por id_proposta_parcela", err.Error(), logData)
@@ -585,10 +685,12 @@ func (s *ParcelaRepo) FindByDataVencimento(dataVencimento string, numeroCCB int)
 		        numero_ccb,
 		        url_webhook,
 		        id_proposta_parcela,
-		        codigo_liquidacao,
+		        COALESCE(codigo_liquidacao, ''),
 			    parcela,
 				numero_boleto,
-		        id_forma_cobranca
+		        COALESCE(id_forma_cobranca, 0),
+				status,
+				version
 			
 			FROM 
 			    bmp_cobrancas
@@ -606,7 +708,9 @@ func (s *ParcelaRepo) FindByDataVencimento(dataVencimento string, numeroCCB int)
 		&cobranca.CodigoLiquidacao,
 		&cobranca.NumeroParcela,
 		&numeroBoleto,
-		&cobranca.IdFormaCobranca)
+		&cobranca.IdFormaCobranca,
+		&cobranca.Status,
+		&cobranca.Version)
 	if err != nil {
 		var logData = map[string]any{"data_vencimento": dataVencimento, "numero_ccb": numeroCCB}
 		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em cobranca_bmp por data de vencimento", err.Error(), logData)
@@ -617,3 +721,354 @@ func (s *ParcelaRepo) FindByDataVencimento(dataVencimento string, numeroCCB int)
 	return cobranca, nil
 
 }
+
+// UpdateStatus altera o status da cobrança apenas se ela ainda estiver no status "from" e na versão lida(compare-and-swap),
+// retornando models.ErrVersaoConcorrente caso outra operação a tenha gravado depois da leitura.
+// As mensagens do outbox são gravadas na mesma transação e descartadas se o status não for alterado.
+// A cobrança cancelada ou paga encerra a liquidação ativa no histórico com o mesmo status.
+func (s *ParcelaRepo) UpdateStatus(idPropostaParcela int, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error) {
+	now := time.Now().In(s.location)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	err := withOutbox(ctx, s.db, outbox, func(exec execer) error {
+		result, err := exec.ExecContext(ctx, `
+	UPDATE
+	      bmp_cobrancas
+	SET
+        status=$1,
+		updated_at=$2,
+		version=version+1
+	WHERE
+	     id_proposta_parcela=$3 AND status=$4 AND version=$5`,
+
+			to,
+			now,
+			idPropostaParcela,
+			from,
+			version,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return models.ErrVersaoConcorrente
+		}
+		if status := models.LiquidacaoStatus(to); status != "" {
+			return encerrarLiquidacoes(ctx, exec, idPropostaParcela, status, now)
+		}
+		return nil
+	})
+
+	var logData = map[string]any{"id_proposta_parcela": idPropostaParcela, "de": from, "para": to, "version": version, "outbox": len(outbox)}
+	if errors.Is(err, models.ErrVersaoConcorrente) {
+		helpers.LogWarn(s.ctx, s.logger, s.location, "db", "", "Status não atualizado em bmp_cobrancas", err.Error(), logData)
+		return false, err
+	}
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao atualizar status em bmp_cobrancas", err.Error(), logData)
+		return isConnError(s.db, err), err
+	}
+
+	return false, nil
+}
+
+// UpdateRegistroCobranca grava o código de liquidação retornado pelo BMP e altera o status de from para to, apenas se a cobrança
+// ainda estiver nesse status e na versão lida, retornando models.ErrVersaoConcorrente caso contrário. As duas alterações,
+// o registro no histórico de liquidações e as mensagens do outbox são gravados na mesma transação.
+func (s *ParcelaRepo) UpdateRegistroCobranca(idPropostaParcela int, codigoLiquidacao string, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error) {
+	now := time.Now().In(s.location)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	err := withOutbox(ctx, s.db, outbox, func(exec execer) error {
+		result, err := exec.ExecContext(ctx, `
+	UPDATE
+	      bmp_cobrancas
+	SET
+        codigo_liquidacao=$1,
+        status=$2,
+		updated_at=$3,
+		version=version+1
+	WHERE
+	     id_proposta_parcela=$4 AND status=$5 AND version=$6`,
+
+			codigoLiquidacao,
+			to,
+			now,
+			idPropostaParcela,
+			from,
+			version,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return models.ErrVersaoConcorrente
+		}
+		return registrarLiquidacao(ctx, exec, idPropostaParcela, codigoLiquidacao, 0, now)
+	})
+
+	var logData = map[string]any{"id_proposta_parcela": idPropostaParcela, "codigo_liquidacao": codigoLiquidacao, "de": from, "para": to, "version": version}
+	if errors.Is(err, models.ErrVersaoConcorrente) {
+		helpers.LogWarn(s.ctx, s.logger, s.location, "db", "", "Registro da cobrança não gravado em bmp_cobrancas", err.Error(), logData)
+		return false, err
+	}
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao gravar registro da cobrança em bmp_cobrancas", err.Error(), logData)
+		return isConnError(s.db, err), err
+	}
+
+	return false, nil
+}
+
+// FindStatus retorna o status e a versão da cobrança da parcela. Uma parcela ainda não gravada retorna status vazio e versão 0, sem erro.
+func (s *ParcelaRepo) FindStatus(idPropostaParcela int) (string, int64, error) {
+	var status string
+	var version int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, `SELECT status, version FROM bmp_cobrancas WHERE id_proposta_parcela=$1`, idPropostaParcela).Scan(&status, &version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, nil
+		}
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar status em bmp_cobrancas", err.Error(), map[string]any{"id_proposta_parcela": idPropostaParcela})
+		return "", 0, err
+	}
+	return status, version, nil
+}
+
+// FindStatusByNumeroCCB retorna o status das cobranças de um contrato, indexado pelo número da parcela.
+func (s *ParcelaRepo) FindStatusByNumeroCCB(numeroCCB int) (map[int]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT parcela, status FROM bmp_cobrancas WHERE numero_ccb=$1 AND parcela IS NOT NULL`, strconv.Itoa(numeroCCB))
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar status em bmp_cobrancas por numero_ccb", err.Error(), map[string]any{"numero_ccb": numeroCCB})
+		return nil, err
+	}
+	defer rows.Close()
+
+	var status = make(map[int]string)
+	for rows.Next() {
+		var parcela int
+		var parcelaStatus string
+		if err := rows.Scan(&parcela, &parcelaStatus); err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler status em bmp_cobrancas", err.Error(), map[string]any{"numero_ccb": numeroCCB})
+			return nil, err
+		}
+		status[parcela] = parcelaStatus
+	}
+	return status, rows.Err()
+}
+
+// Expressões SQL e tipos dos campos de ordenação da listagem de cobranças. Os valores do cursor são convertidos com o tipo do campo.
+var cobrancaSortColumns = map[string]struct{ expr, cast string }{
+	models.COBRANCA_SORT_CREATED_AT:          {"created_at", "timestamptz"},
+	models.COBRANCA_SORT_UPDATED_AT:          {"updated_at", "timestamptz"},
+	models.COBRANCA_SORT_DATA_VENCIMENTO:     {"COALESCE(data_vencimento, DATE '" + models.COBRANCA_VENCIMENTO_VAZIO + "')", "date"},
+	models.COBRANCA_SORT_ID_PROPOSTA_PARCELA: {"id_proposta_parcela", "integer"},
+}
+
+// Search lista as cobranças que atendem aos filtros, ordenadas pelo campo de ordenação e pelo id.
+// A paginação é por cursor: são retornadas até filter.Limit cobranças posteriores a filter.After na ordenação.
+// Os dados de pagamento são lidos apenas quando filter.IncluirPagamento é informado.
+func (s *ParcelaRepo) Search(filter models.CobrancaFilter) ([]models.CobrancaResumo, error) {
+	var conditions = make([]string, 0)
+	var args = make([]any, 0)
+
+	if filter.IdProposta > 0 {
+		args = append(args, filter.IdProposta)
+		conditions = append(conditions, fmt.Sprintf("id_proposta=$%d", len(args)))
+	}
+	if filter.NumeroCCB > 0 {
+		args = append(args, strconv.Itoa(filter.NumeroCCB))
+		conditions = append(conditions, fmt.Sprintf("numero_ccb=$%d", len(args)))
+	}
+	if filter.IdConvenio > 0 {
+		args = append(args, filter.IdConvenio)
+		conditions = append(conditions, fmt.Sprintf("id_convenio=$%d", len(args)))
+	}
+	if filter.IdSecuritizadora > 0 {
+		args = append(args, filter.IdSecuritizadora)
+		conditions = append(conditions, fmt.Sprintf("id_securitizadora=$%d", len(args)))
+	}
+	if filter.IdFormaCobranca > 0 {
+		args = append(args, filter.IdFormaCobranca)
+		conditions = append(conditions, fmt.Sprintf("id_forma_cobranca=$%d", len(args)))
+	}
+	if len(filter.Status) > 0 {
+		var placeholders = make([]string, 0, len(filter.Status))
+		for _, status := range filter.Status {
+			args = append(args, status)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		conditions = append(conditions, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if !filter.VencimentoInicio.IsZero() {
+		args = append(args, filter.VencimentoInicio.Format("2006-01-02"))
+		conditions = append(conditions, fmt.Sprintf("data_vencimento>=$%d", len(args)))
+	}
+	if !filter.VencimentoFim.IsZero() {
+		args = append(args, filter.VencimentoFim.Format("2006-01-02"))
+		conditions = append(conditions, fmt.Sprintf("data_vencimento<=$%d", len(args)))
+	}
+	if !filter.CriadoInicio.IsZero() {
+		args = append(args, filter.CriadoInicio)
+		conditions = append(conditions, fmt.Sprintf("created_at>=$%d", len(args)))
+	}
+	if !filter.CriadoFim.IsZero() {
+		args = append(args, filter.CriadoFim)
+		conditions = append(conditions, fmt.Sprintf("created_at<=$%d", len(args)))
+	}
+
+	sort, ok := cobrancaSortColumns[filter.Sort]
+	if !ok {
+		sort = cobrancaSortColumns[models.COBRANCA_SORT_CREATED_AT]
+	}
+	var order = "DESC"
+	var comparison = "<"
+	if filter.Order == "asc" {
+		order = "ASC"
+		comparison = ">"
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.Value, filter.After.Id)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", sort.expr, comparison, len(args)-1, sort.cast, len(args)))
+	}
+
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var pagamento = "NULL"
+	if filter.IncluirPagamento {
+		pagamento = "pagamento"
+	}
+
+	args = append(args, filter.Limit)
+
+	query := fmt.Sprintf(`
+			SELECT
+			    id,
+			    id_proposta_parcela,
+			    id_proposta,
+			    numero_acompanhamento,
+			    numero_ccb,
+			    parcela,
+			    id_convenio,
+			    id_securitizadora,
+			    id_forma_cobranca,
+			    to_char(data_vencimento, 'YYYY-MM-DD'),
+			    to_char(data_expiracao, 'YYYY-MM-DD'),
+			    codigo_liquidacao,
+			    numero_boleto,
+			    status,
+			    version,
+			    %s,
+			    created_at,
+			    updated_at
+			FROM
+			    bmp_cobrancas
+			%s
+			ORDER BY %s %s, id %s
+			LIMIT $%d`, pagamento, where, sort.expr, order, order, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_cobrancas", err.Error(), filter)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cobrancas = make([]models.CobrancaResumo, 0)
+	for rows.Next() {
+		cobranca, err := scanCobrancaResumo(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_cobrancas", err.Error(), filter)
+			return nil, err
+		}
+		cobrancas = append(cobrancas, cobranca)
+	}
+
+	return cobrancas, rows.Err()
+}
+
+// UpdatePagamento grava os últimos dados de pagamento(boletos e pix) retornados pelo BMP para a cobrança.
+// As mensagens do outbox são gravadas na mesma transação.
+func (s *ParcelaRepo) UpdatePagamento(idPropostaParcela int, pagamento models.CobrancaPagamento, outbox ...models.OutboxMessage) (bool, error) {
+	data, err := json.Marshal(pagamento)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	err = withOutbox(ctx, s.db, outbox, func(exec execer) error {
+		_, err := exec.ExecContext(ctx, `UPDATE bmp_cobrancas SET pagamento=$1 WHERE id_proposta_parcela=$2`, string(data), idPropostaParcela)
+		return err
+	})
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao gravar dados de pagamento em bmp_cobrancas", err.Error(), map[string]any{"id_proposta_parcela": idPropostaParcela})
+		return isConnError(s.db, err), err
+	}
+	return false, nil
+}
+
+func scanCobrancaResumo(row rowScanner) (models.CobrancaResumo, error) {
+	var cobranca models.CobrancaResumo
+	var numeroAcompanhamento, numeroCCB, dataVencimento, dataExpiracao, codigoLiquidacao, pagamento sql.NullString
+	var parcela, idConvenio, idSecuritizadora, idFormaCobranca, numeroBoleto sql.NullInt64
+
+	err := row.Scan(&cobranca.Id,
+		&cobranca.IdPropostaParcela,
+		&cobranca.IdProposta,
+		&numeroAcompanhamento,
+		&numeroCCB,
+		&parcela,
+		&idConvenio,
+		&idSecuritizadora,
+		&idFormaCobranca,
+		&dataVencimento,
+		&dataExpiracao,
+		&codigoLiquidacao,
+		&numeroBoleto,
+		&cobranca.Status,
+		&cobranca.Version,
+		&pagamento,
+		&cobranca.CreatedAt,
+		&cobranca.UpdatedAt,
+	)
+	if err != nil {
+		return models.CobrancaResumo{}, err
+	}
+
+	cobranca.NumeroAcompanhamento = numeroAcompanhamento.String
+	cobranca.NumeroCCB, _ = strconv.Atoi(numeroCCB.String)
+	cobranca.NumeroParcela = int(parcela.Int64)
+	cobranca.IdConvenio = int(idConvenio.Int64)
+	cobranca.IdSecuritizadora = int(idSecuritizadora.Int64)
+	cobranca.IdFormaCobranca = int(idFormaCobranca.Int64)
+	cobranca.DataVencimento = dataVencimento.String
+	cobranca.DataExpiracao = dataExpiracao.String
+	cobranca.CodigoLiquidacao = codigoLiquidacao.String
+	cobranca.NumeroBoleto = int(numeroBoleto.Int64)
+	if pagamento.Valid {
+		var data models.CobrancaPagamento
+		if err := json.Unmarshal([]byte(pagamento.String), &data); err == nil {
+			cobranca.Pagamento = &data
+		}
+	}
+	return cobranca, nil
+}
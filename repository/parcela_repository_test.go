@@ -0,0 +1,639 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/db"
+	"cobranca-bmp/models"
+	"cobranca-bmp/service"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+var _ service.ParcelaRepository = (*ParcelaRepo)(nil)
+var _ service.ParcelaRepository = (*MemoryParcelaRepo)(nil)
+
+// Cria um repositório vazio para um teste e retorna também a leitura das mensagens gravadas no outbox.
+type parcelaRepositoryFactory func(t *testing.T) (service.ParcelaRepository, func() []models.OutboxMessage)
+
+func TestMemoryParcelaRepo(t *testing.T) {
+	testParcelaRepository(t, func(t *testing.T) (service.ParcelaRepository, func() []models.OutboxMessage) {
+		repo := NewMemoryParcelaRepo(time.UTC)
+		return repo, repo.Outbox
+	})
+}
+
+// TestParcelaRepo executa a suíte no Postgres de TEST_DATABASE_DSN. As migrations são aplicadas e as tabelas bmp_cobrancas,
+// bmp_liquidacoes e bmp_outbox são esvaziadas a cada teste: utilize um banco exclusivo para os testes.
+func TestParcelaRepo(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN não informado")
+	}
+	if config.DB_QUERY_TIMEOUT <= 0 {
+		config.DB_QUERY_TIMEOUT = 5 * time.Second
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	conn, err := db.Open(dsn)
+	if err != nil {
+		t.Fatalf("conexão com o banco: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	migrator, err := db.NewMigrator(ctx, conn, logger, time.UTC)
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+
+	testParcelaRepository(t, func(t *testing.T) (service.ParcelaRepository, func() []models.OutboxMessage) {
+		if _, err := conn.Exec(`TRUNCATE bmp_cobrancas, bmp_liquidacoes, bmp_outbox RESTART IDENTITY`); err != nil {
+			t.Fatalf("limpeza das tabelas: %v", err)
+		}
+		outbox := func() []models.OutboxMessage {
+			rows, err := conn.Query(`
+			SELECT id, message_id, fila, payload, delay_ms, id_proposta_parcela, tentativas, erro, disponivel_em, published_at, created_at
+			FROM bmp_outbox ORDER BY id`)
+			if err != nil {
+				t.Fatalf("leitura do outbox: %v", err)
+			}
+			defer rows.Close()
+
+			var mensagens []models.OutboxMessage
+			for rows.Next() {
+				mensagem, err := scanOutboxMessage(rows)
+				if err != nil {
+					t.Fatalf("leitura do outbox: %v", err)
+				}
+				mensagens = append(mensagens, mensagem)
+			}
+			return mensagens
+		}
+		return NewParcelaRepo(ctx, conn, logger, time.UTC), outbox
+	})
+}
+
+// testParcelaRepository é a suíte de conformidade de service.ParcelaRepository, executada em todas as implementações.
+func testParcelaRepository(t *testing.T, newRepo parcelaRepositoryFactory) {
+	t.Run("geração insere e atualiza por id_proposta_parcela", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+
+		cobranca, err := repo.FindByIdPropostaParcela(1001)
+		if err != nil {
+			t.Fatalf("FindByIdPropostaParcela: %v", err)
+		}
+		if cobranca.IdProposta != 10 || cobranca.NumeroCCB != 555 || cobranca.IdConvenio != 3 || cobranca.IdSecuritizadora != 4 ||
+			cobranca.IdFormaCobranca != config.TIPO_COBRANCA_BOLETO || cobranca.UrlWebhook != "https://cliente/webhook" ||
+			cobranca.NumeroAcompanhamento != "ACP-10" {
+			t.Fatalf("cobrança gravada incorretamente: %+v", cobranca)
+		}
+		if cobranca.Status != models.COBRANCA_STATUS_PENDENTE {
+			t.Fatalf("status inicial = %q, esperado %q", cobranca.Status, models.COBRANCA_STATUS_PENDENTE)
+		}
+
+		//URL vazia mantém a URL gravada
+		input := geracaoInput(1001, 1, "2025-02-10")
+		input.UrlWebhook = ""
+		input.IdConvenio = 7
+		mustExec(t)(repo.UpdateGeracaoCobranca(input))
+
+		cobranca, err = repo.FindByNumParcela(1, 555)
+		if err != nil {
+			t.Fatalf("FindByNumParcela: %v", err)
+		}
+		if cobranca.IdPropostaParcela != 1001 || cobranca.IdConvenio != 7 || cobranca.UrlWebhook != "https://cliente/webhook" {
+			t.Fatalf("upsert incorreto: %+v", cobranca)
+		}
+		if _, err := repo.FindByDataVencimento("2025-02-10", 555); err != nil {
+			t.Fatalf("FindByDataVencimento após upsert: %v", err)
+		}
+		if _, err := repo.FindByDataVencimento("2025-01-10", 555); err == nil {
+			t.Fatal("FindByDataVencimento encontrou a data de vencimento substituída")
+		}
+	})
+
+	t.Run("cancelamento e lançamento inserem a parcela", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateCancelamentoCobranca(models.CancelarCobrancaFrontendInput{
+			IdPropostaParcela: 2001, IdProposta: 20, NumeroAcompanhamento: "ACP-20", NumeroCCB: 777, NumeroParcela: 2, IdConvenio: 3,
+		}))
+		mustExec(t)(repo.UpdateLancamentoParcela(models.LancamentoParcelaFrontendInput{
+			IdPropostaParcela: 2002, IdProposta: 20, NumeroAcompanhamento: "ACP-20", NumeroCCB: 777, NumeroParcela: 3, IdConvenio: 3,
+			UrlWebhook: "https://cliente/lancamento",
+		}))
+
+		cobranca, err := repo.FindByIdPropostaParcela(2001)
+		if err != nil {
+			t.Fatalf("FindByIdPropostaParcela: %v", err)
+		}
+		if cobranca.IdProposta != 20 || cobranca.IdFormaCobranca != 0 || cobranca.Status != models.COBRANCA_STATUS_PENDENTE {
+			t.Fatalf("cancelamento gravado incorretamente: %+v", cobranca)
+		}
+
+		cobranca, err = repo.FindByNumParcela(3, 777)
+		if err != nil {
+			t.Fatalf("FindByNumParcela: %v", err)
+		}
+		if cobranca.IdPropostaParcela != 2002 || cobranca.UrlWebhook != "https://cliente/lancamento" {
+			t.Fatalf("lançamento gravado incorretamente: %+v", cobranca)
+		}
+	})
+
+	t.Run("buscas sem resultado retornam erro", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+
+		if _, err := repo.FindByIdPropostaParcela(9999); err == nil || err.Error() != "dados nao encontrados" {
+			t.Fatalf("FindByIdPropostaParcela = %v, esperado dados nao encontrados", err)
+		}
+		if _, err := repo.FindByNumParcela(2, 555); err == nil {
+			t.Fatal("FindByNumParcela encontrou parcela inexistente")
+		}
+		if _, err := repo.FindByNumParcela(1, 556); err == nil {
+			t.Fatal("FindByNumParcela encontrou parcela de outra CCB")
+		}
+		if _, err := repo.FindByCodLiquidacao("LIQ-1", 555); err == nil {
+			t.Fatal("FindByCodLiquidacao encontrou código não gravado")
+		}
+		if _, err := repo.FindByDataVencimento("2025-03-10", 555); err == nil {
+			t.Fatal("FindByDataVencimento encontrou data inexistente")
+		}
+	})
+
+	t.Run("código de liquidação e número do boleto", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		mustExec(t)(repo.UpdateCodLiquidacao(1001, "LIQ-1", 0))
+		mustExec(t)(repo.UpdateNumeroBoleto(1001, 123456, 0))
+
+		cobranca, err := repo.FindByCodLiquidacao("LIQ-1", 555)
+		if err != nil {
+			t.Fatalf("FindByCodLiquidacao: %v", err)
+		}
+		if cobranca.IdPropostaParcela != 1001 || cobranca.NumeroParcela != 1 || cobranca.NumeroBoleto != 123456 || cobranca.CodigoLiquidacao != "LIQ-1" {
+			t.Fatalf("cobrança incorreta: %+v", cobranca)
+		}
+
+		cobranca, err = repo.FindByDataVencimento("2025-01-10", 555)
+		if err != nil {
+			t.Fatalf("FindByDataVencimento: %v", err)
+		}
+		if cobranca.CodigoLiquidacao != "LIQ-1" || cobranca.NumeroParcela != 1 {
+			t.Fatalf("cobrança incorreta: %+v", cobranca)
+		}
+
+		//Código vazio remove o código gravado, que continua no histórico de liquidações
+		mustExec(t)(repo.UpdateCodLiquidacao(1001, "", 0))
+		cobranca, err = repo.FindByCodLiquidacao("LIQ-1", 555)
+		if err != nil || cobranca.IdPropostaParcela != 1001 || cobranca.CodigoLiquidacao != "" {
+			t.Fatalf("FindByCodLiquidacao após remoção = %+v, %v", cobranca, err)
+		}
+		cobranca, err = repo.FindByNumParcela(1, 555)
+		if err != nil || cobranca.CodigoLiquidacao != "" {
+			t.Fatalf("FindByNumParcela = %+v, %v", cobranca, err)
+		}
+
+		//Parcela inexistente não é criada
+		mustExec(t)(repo.UpdateCodLiquidacao(9999, "LIQ-9", 0))
+		if status, _, _ := repo.FindStatus(9999); status != "" {
+			t.Fatalf("parcela criada por UpdateCodLiquidacao: status %q", status)
+		}
+	})
+
+	t.Run("histórico de liquidações", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1002, 2, "2025-02-10")))
+		mustExec(t)(repo.UpdateCodLiquidacao(1001, "LIQ-1", 0))
+		mustExec(t)(repo.UpdateNumeroBoleto(1001, 111, 0))
+		//Reemissão: novo código de liquidação e, depois, o boleto emitido para ele
+		mustExec(t)(repo.UpdateRegistroCobranca(1001, "LIQ-2", models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_PENDENTE, currentVersion(t, repo, 1001)))
+		mustExec(t)(repo.UpdateNumeroBoleto(1001, 222, 0))
+		//Gravações repetidas não duplicam o histórico
+		mustExec(t)(repo.UpdateNumeroBoleto(1001, 222, 0))
+		mustExec(t)(repo.UpdateCodLiquidacao(1001, "LIQ-2", 0))
+		mustExec(t)(repo.UpdateCodLiquidacao(1002, "LIQ-3", 0))
+
+		liquidacoes, err := repo.FindLiquidacoes(555)
+		if err != nil {
+			t.Fatalf("FindLiquidacoes: %v", err)
+		}
+		if len(liquidacoes) != 3 {
+			t.Fatalf("FindLiquidacoes = %+v, esperadas três", liquidacoes)
+		}
+		anterior, atual := liquidacoes[0], liquidacoes[1]
+		if anterior.CodigoLiquidacao != "LIQ-1" || anterior.NumeroBoleto != 111 || anterior.Status != models.LIQUIDACAO_STATUS_SUBSTITUIDA ||
+			anterior.EncerradaEm == nil || anterior.NumeroParcela != 1 || anterior.NumeroCCB != 555 || anterior.DataVencimento != "2025-01-10" {
+			t.Fatalf("liquidação substituída = %+v", anterior)
+		}
+		if atual.CodigoLiquidacao != "LIQ-2" || atual.NumeroBoleto != 222 || atual.Status != models.LIQUIDACAO_STATUS_ATIVA || atual.EncerradaEm != nil {
+			t.Fatalf("liquidação atual = %+v", atual)
+		}
+		if liquidacoes[2].IdPropostaParcela != 1002 || liquidacoes[2].NumeroParcela != 2 {
+			t.Fatalf("liquidação da segunda parcela = %+v", liquidacoes[2])
+		}
+
+		//Os identificadores substituídos ainda encontram a parcela, que retorna a liquidação atual
+		cobranca, err := repo.FindByCodLiquidacao("LIQ-1", 555)
+		if err != nil || cobranca.IdPropostaParcela != 1001 || cobranca.CodigoLiquidacao != "LIQ-2" || cobranca.NumeroBoleto != 222 {
+			t.Fatalf("FindByCodLiquidacao de código substituído = %+v, %v", cobranca, err)
+		}
+		cobranca, err = repo.FindByNumeroBoleto(111, 555)
+		if err != nil || cobranca.IdPropostaParcela != 1001 || cobranca.NumeroParcela != 1 || cobranca.NumeroBoleto != 222 {
+			t.Fatalf("FindByNumeroBoleto de boleto substituído = %+v, %v", cobranca, err)
+		}
+		if _, err := repo.FindByNumeroBoleto(111, 556); err == nil {
+			t.Fatal("FindByNumeroBoleto encontrou boleto de outra CCB")
+		}
+		if _, err := repo.FindByNumeroBoleto(333, 555); err == nil {
+			t.Fatal("FindByNumeroBoleto encontrou boleto não emitido")
+		}
+
+		//Boleto substituído pago: a liquidação atual continua ativa quando a parcela é paga
+		mustExec(t)(repo.UpdateLiquidacaoStatus(1001, "", 111, models.LIQUIDACAO_STATUS_PAGA))
+		mustExec(t)(repo.UpdateStatus(1001, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_PAGA, currentVersion(t, repo, 1001)))
+		//Cancelamento da parcela encerra a liquidação ativa
+		mustExec(t)(repo.UpdateStatus(1002, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_CANCELADA, currentVersion(t, repo, 1002)))
+
+		liquidacoes, _ = repo.FindLiquidacoes(555)
+		var status []string
+		for _, liquidacao := range liquidacoes {
+			status = append(status, liquidacao.Status)
+		}
+		want := []string{models.LIQUIDACAO_STATUS_PAGA, models.LIQUIDACAO_STATUS_ATIVA, models.LIQUIDACAO_STATUS_CANCELADA}
+		if !slices.Equal(status, want) {
+			t.Fatalf("status das liquidações = %v, esperado %v", status, want)
+		}
+	})
+
+	t.Run("versão incrementada a cada gravação", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		version := func() int64 {
+			t.Helper()
+			cobranca, err := repo.FindByIdPropostaParcela(1001)
+			if err != nil {
+				t.Fatalf("FindByIdPropostaParcela: %v", err)
+			}
+			return cobranca.Version
+		}
+
+		if v := version(); v != 1 {
+			t.Fatalf("versão inicial = %d", v)
+		}
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		mustExec(t)(repo.UpdateStatus(1001, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO, currentVersion(t, repo, 1001)))
+		mustExec(t)(repo.UpdateRegistroCobranca(1001, "LIQ-1", models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, currentVersion(t, repo, 1001)))
+		if v := version(); v != 4 {
+			t.Fatalf("versão após três gravações = %d", v)
+		}
+
+		//Gravação condicionada à versão atual
+		mustExec(t)(repo.UpdateNumeroBoleto(1001, 123, 4))
+		if v := version(); v != 5 {
+			t.Fatalf("versão após número do boleto = %d", v)
+		}
+
+		//Versão lida antes de outra gravação: a atualização é descartada
+		if _, err := repo.UpdateCodLiquidacao(1001, "", 4); !errors.Is(err, models.ErrVersaoConcorrente) {
+			t.Fatalf("UpdateCodLiquidacao com versão desatualizada: %v", err)
+		}
+		if _, err := repo.UpdateNumeroBoleto(1001, 999, 4); !errors.Is(err, models.ErrVersaoConcorrente) {
+			t.Fatalf("UpdateNumeroBoleto com versão desatualizada: %v", err)
+		}
+		cobranca, err := repo.FindByIdPropostaParcela(1001)
+		if err != nil || cobranca.CodigoLiquidacao != "LIQ-1" || cobranca.NumeroBoleto != 123 || cobranca.Version != 5 {
+			t.Fatalf("cobrança alterada por gravação desatualizada: %+v, %v", cobranca, err)
+		}
+		if _, err := repo.UpdateCodLiquidacao(9999, "LIQ-9", 1); !errors.Is(err, models.ErrVersaoConcorrente) {
+			t.Fatalf("UpdateCodLiquidacao com versão em parcela inexistente: %v", err)
+		}
+
+		page, err := repo.Search(models.CobrancaFilter{Sort: models.COBRANCA_SORT_CREATED_AT, Order: "asc", Limit: 10})
+		if err != nil || len(page) != 1 || page[0].Version != 5 {
+			t.Fatalf("versão na listagem: %+v, %v", page, err)
+		}
+	})
+
+	t.Run("status condicionado ao status e à versão atuais", func(t *testing.T) {
+		repo, outbox := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+
+		if status, version, err := repo.FindStatus(1001); err != nil || status != models.COBRANCA_STATUS_PENDENTE || version != 1 {
+			t.Fatalf("FindStatus = %q, %d, %v", status, version, err)
+		}
+		if status, version, err := repo.FindStatus(9999); err != nil || status != "" || version != 0 {
+			t.Fatalf("FindStatus de parcela inexistente = %q, %d, %v", status, version, err)
+		}
+
+		mustExec(t)(repo.UpdateStatus(1001, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO, 1, outboxMessage(t, 1001)))
+		if status, version, _ := repo.FindStatus(1001); status != models.COBRANCA_STATUS_GERANDO || version != 2 {
+			t.Fatalf("status = %q, versão %d, esperado %q na versão 2", status, version, models.COBRANCA_STATUS_GERANDO)
+		}
+		if mensagens := outbox(); len(mensagens) != 1 || mensagens[0].IdPropostaParcela != 1001 {
+			t.Fatalf("outbox = %+v, esperada uma mensagem", mensagens)
+		}
+
+		//Status ou versão diferentes dos lidos: nada é alterado e o outbox é descartado
+		var cases = []struct {
+			name    string
+			from    string
+			version int64
+		}{
+			{"status alterado", models.COBRANCA_STATUS_PENDENTE, 2},
+			{"versão alterada", models.COBRANCA_STATUS_GERANDO, 1},
+		}
+		for _, c := range cases {
+			if _, err := repo.UpdateStatus(1001, c.from, models.COBRANCA_STATUS_FALHA, c.version, outboxMessage(t, 1001)); !errors.Is(err, models.ErrVersaoConcorrente) {
+				t.Fatalf("UpdateStatus com %s: %v", c.name, err)
+			}
+		}
+		if status, version, _ := repo.FindStatus(1001); status != models.COBRANCA_STATUS_GERANDO || version != 2 {
+			t.Fatalf("status = %q, versão %d, esperado %q na versão 2", status, version, models.COBRANCA_STATUS_GERANDO)
+		}
+		if mensagens := outbox(); len(mensagens) != 1 {
+			t.Fatalf("outbox = %d mensagens, esperada uma", len(mensagens))
+		}
+
+		if _, err := repo.UpdateStatus(9999, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO, 0); !errors.Is(err, models.ErrVersaoConcorrente) {
+			t.Fatalf("UpdateStatus de parcela inexistente: %v", err)
+		}
+	})
+
+	t.Run("registro da cobrança", func(t *testing.T) {
+		repo, outbox := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		mustExec(t)(repo.UpdateStatus(1001, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO, currentVersion(t, repo, 1001)))
+
+		mustExec(t)(repo.UpdateRegistroCobranca(1001, "LIQ-1", models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, currentVersion(t, repo, 1001), outboxMessage(t, 1001)))
+		cobranca, err := repo.FindByIdPropostaParcela(1001)
+		if err != nil {
+			t.Fatalf("FindByIdPropostaParcela: %v", err)
+		}
+		if cobranca.CodigoLiquidacao != "LIQ-1" || cobranca.Status != models.COBRANCA_STATUS_REGISTRADA {
+			t.Fatalf("registro incorreto: %+v", cobranca)
+		}
+		if mensagens := outbox(); len(mensagens) != 1 {
+			t.Fatalf("outbox = %d mensagens, esperada uma", len(mensagens))
+		}
+
+		//Status ou versão diferentes dos lidos: nada é gravado e o outbox é descartado
+		if _, err := repo.UpdateRegistroCobranca(1001, "LIQ-2", models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, cobranca.Version, outboxMessage(t, 1001)); !errors.Is(err, models.ErrVersaoConcorrente) {
+			t.Fatalf("UpdateRegistroCobranca com status alterado: %v", err)
+		}
+		if _, err := repo.UpdateRegistroCobranca(1001, "LIQ-2", models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_REGISTRADA, cobranca.Version-1); !errors.Is(err, models.ErrVersaoConcorrente) {
+			t.Fatalf("UpdateRegistroCobranca com versão alterada: %v", err)
+		}
+		atual, _ := repo.FindByIdPropostaParcela(1001)
+		if atual.CodigoLiquidacao != "LIQ-1" || atual.Status != models.COBRANCA_STATUS_REGISTRADA || atual.Version != cobranca.Version {
+			t.Fatalf("registro alterado por gravação desatualizada: %+v", atual)
+		}
+		if mensagens := outbox(); len(mensagens) != 1 {
+			t.Fatalf("outbox = %d mensagens, esperada uma", len(mensagens))
+		}
+
+		//Transição não permitida a partir do status atual: apenas o código de liquidação é gravado
+		mustExec(t)(repo.UpdateRegistroCobranca(1001, "LIQ-2", models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_REGISTRADA, cobranca.Version))
+		atual, _ = repo.FindByIdPropostaParcela(1001)
+		if atual.CodigoLiquidacao != "LIQ-2" || atual.Status != models.COBRANCA_STATUS_REGISTRADA {
+			t.Fatalf("registro incorreto: %+v", atual)
+		}
+	})
+
+	t.Run("status por número da CCB", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1002, 2, "2025-02-10")))
+		other := geracaoInput(3001, 1, "2025-01-10")
+		other.NumeroCCB = 556
+		mustExec(t)(repo.UpdateGeracaoCobranca(other))
+		mustExec(t)(repo.UpdateStatus(1002, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO, currentVersion(t, repo, 1002)))
+
+		status, err := repo.FindStatusByNumeroCCB(555)
+		if err != nil {
+			t.Fatalf("FindStatusByNumeroCCB: %v", err)
+		}
+		if len(status) != 2 || status[1] != models.COBRANCA_STATUS_PENDENTE || status[2] != models.COBRANCA_STATUS_GERANDO {
+			t.Fatalf("FindStatusByNumeroCCB = %v", status)
+		}
+		if status, err := repo.FindStatusByNumeroCCB(999); err != nil || len(status) != 0 {
+			t.Fatalf("FindStatusByNumeroCCB de CCB inexistente = %v, %v", status, err)
+		}
+	})
+
+	t.Run("listagem com filtros", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		seedCobrancas(t, repo)
+
+		var cases = []struct {
+			name   string
+			filter models.CobrancaFilter
+			want   []int
+		}{
+			{"sem filtros", models.CobrancaFilter{}, []int{1001, 1002, 1003, 1004, 1005}},
+			{"id_proposta", models.CobrancaFilter{IdProposta: 11}, []int{1004, 1005}},
+			{"numero_ccb", models.CobrancaFilter{NumeroCCB: 555}, []int{1001, 1002, 1003}},
+			{"convênio", models.CobrancaFilter{IdConvenio: 8}, []int{1004, 1005}},
+			{"securitizadora", models.CobrancaFilter{IdSecuritizadora: 4}, []int{1001, 1002, 1003}},
+			{"forma de cobrança", models.CobrancaFilter{IdFormaCobranca: config.TIPO_COBRANCA_PIX}, []int{1005}},
+			{"status", models.CobrancaFilter{Status: []string{models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_FALHA}}, []int{1002, 1003}},
+			{"vencimento", models.CobrancaFilter{VencimentoInicio: date(t, "2025-02-01"), VencimentoFim: date(t, "2025-03-10")}, []int{1002, 1003, 1004}},
+			{"criação", models.CobrancaFilter{CriadoInicio: time.Now().Add(-time.Hour), CriadoFim: time.Now().Add(time.Hour)}, []int{1001, 1002, 1003, 1004, 1005}},
+			{"criação futura", models.CobrancaFilter{CriadoInicio: time.Now().Add(time.Hour)}, []int{}},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				c.filter.Sort = models.COBRANCA_SORT_ID_PROPOSTA_PARCELA
+				c.filter.Order = "asc"
+				if got := searchAll(t, repo, c.filter); !slices.Equal(got, c.want) {
+					t.Fatalf("Search = %v, esperado %v", got, c.want)
+				}
+			})
+		}
+	})
+
+	t.Run("listagem ordenada e paginada", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		seedCobrancas(t, repo)
+		//Sem data de vencimento: ordenada antes das demais na ordem crescente
+		mustExec(t)(repo.UpdateCancelamentoCobranca(models.CancelarCobrancaFrontendInput{
+			IdPropostaParcela: 1006, IdProposta: 12, NumeroAcompanhamento: "ACP-12", NumeroCCB: 888, NumeroParcela: 1,
+		}))
+
+		var cases = []struct {
+			sort, order string
+			want        []int
+		}{
+			{models.COBRANCA_SORT_ID_PROPOSTA_PARCELA, "asc", []int{1001, 1002, 1003, 1004, 1005, 1006}},
+			{models.COBRANCA_SORT_ID_PROPOSTA_PARCELA, "desc", []int{1006, 1005, 1004, 1003, 1002, 1001}},
+			{models.COBRANCA_SORT_CREATED_AT, "asc", []int{1001, 1002, 1003, 1004, 1005, 1006}},
+			{models.COBRANCA_SORT_CREATED_AT, "desc", []int{1006, 1005, 1004, 1003, 1002, 1001}},
+			{models.COBRANCA_SORT_DATA_VENCIMENTO, "asc", []int{1006, 1001, 1004, 1002, 1003, 1005}},
+			{models.COBRANCA_SORT_DATA_VENCIMENTO, "desc", []int{1005, 1003, 1002, 1004, 1001, 1006}},
+		}
+		for _, c := range cases {
+			t.Run(c.sort+" "+c.order, func(t *testing.T) {
+				filter := models.CobrancaFilter{Sort: c.sort, Order: c.order, Limit: 2}
+				if got := searchAll(t, repo, filter); !slices.Equal(got, c.want) {
+					t.Fatalf("Search = %v, esperado %v", got, c.want)
+				}
+			})
+		}
+
+		//Atualizar a cobrança a move para o fim da ordenação por updated_at
+		time.Sleep(time.Millisecond)
+		mustExec(t)(repo.UpdateNumeroBoleto(1001, 123, 0))
+		filter := models.CobrancaFilter{Sort: models.COBRANCA_SORT_UPDATED_AT, Order: "asc", Limit: 4}
+		if got := searchAll(t, repo, filter); got[len(got)-1] != 1001 {
+			t.Fatalf("Search por updated_at = %v, esperado 1001 por último", got)
+		}
+	})
+
+	t.Run("dados de pagamento", func(t *testing.T) {
+		repo, outbox := newRepo(t)
+		mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+		mustExec(t)(repo.UpdatePagamento(1001, models.CobrancaPagamento{
+			Boletos:      []models.WebhookBoletoData{{NumeroBoleto: 123, LinhaDigitavel: "0019", UrlImpressao: "https://bmp/boleto"}},
+			AtualizadoEm: time.Now(),
+		}, outboxMessage(t, 1001)))
+		if mensagens := outbox(); len(mensagens) != 1 || mensagens[0].IdPropostaParcela != 1001 {
+			t.Fatalf("outbox = %+v, esperada uma mensagem gravada com os dados de pagamento", mensagens)
+		}
+
+		cobrancas, err := repo.Search(models.CobrancaFilter{Sort: models.COBRANCA_SORT_CREATED_AT, Order: "asc", Limit: 10})
+		if err != nil || len(cobrancas) != 1 {
+			t.Fatalf("Search = %v, %v", cobrancas, err)
+		}
+		if cobrancas[0].Pagamento != nil {
+			t.Fatal("dados de pagamento retornados sem IncluirPagamento")
+		}
+
+		cobrancas, err = repo.Search(models.CobrancaFilter{Sort: models.COBRANCA_SORT_CREATED_AT, Order: "asc", Limit: 10, IncluirPagamento: true})
+		if err != nil || len(cobrancas) != 1 {
+			t.Fatalf("Search = %v, %v", cobrancas, err)
+		}
+		pagamento := cobrancas[0].Pagamento
+		if pagamento == nil || len(pagamento.Boletos) != 1 || pagamento.Boletos[0].UrlImpressao != "https://bmp/boleto" {
+			t.Fatalf("dados de pagamento = %+v", pagamento)
+		}
+		if cobrancas[0].DataVencimento != "2025-01-10" || cobrancas[0].NumeroCCB != 555 || cobrancas[0].Status != models.COBRANCA_STATUS_PENDENTE {
+			t.Fatalf("cobrança listada incorretamente: %+v", cobrancas[0])
+		}
+	})
+}
+
+// currentVersion retorna a versão atual da parcela, utilizada nas gravações condicionadas à versão.
+func currentVersion(t *testing.T, repo service.ParcelaRepository, idPropostaParcela int) int64 {
+	t.Helper()
+	_, version, err := repo.FindStatus(idPropostaParcela)
+	if err != nil {
+		t.Fatalf("FindStatus: %v", err)
+	}
+	return version
+}
+
+// mustExec falha o teste se uma escrita no repositório retornar erro.
+func mustExec(t *testing.T) func(bool, error) {
+	t.Helper()
+	return func(_ bool, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("escrita no repositório: %v", err)
+		}
+	}
+}
+
+func geracaoInput(idPropostaParcela, parcela int, dataVencimento string) models.GerarCobrancaFrontendInput {
+	return models.GerarCobrancaFrontendInput{
+		NumeroAcompanhamento: "ACP-10",
+		NumeroCCB:            555,
+		IdProposta:           10,
+		UrlWebhook:           "https://cliente/webhook",
+		DataVencimento:       dataVencimento,
+		DataExpiracao:        dataVencimento,
+		NumeroParcela:        parcela,
+		IdPropostaParcela:    idPropostaParcela,
+		TipoCobranca:         config.TIPO_COBRANCA_BOLETO,
+		IdConvenio:           3,
+		IdSecuritizadora:     4,
+	}
+}
+
+// seedCobrancas grava cinco cobranças, em ordem de criação, de duas propostas.
+func seedCobrancas(t *testing.T, repo service.ParcelaRepository) {
+	t.Helper()
+	mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1001, 1, "2025-01-10")))
+	mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1002, 2, "2025-03-10")))
+	mustExec(t)(repo.UpdateGeracaoCobranca(geracaoInput(1003, 3, "2025-03-10")))
+	mustExec(t)(repo.UpdateStatus(1002, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_GERANDO, currentVersion(t, repo, 1002)))
+	mustExec(t)(repo.UpdateStatus(1003, models.COBRANCA_STATUS_PENDENTE, models.COBRANCA_STATUS_FALHA, currentVersion(t, repo, 1003)))
+
+	for i, vencimento := range []string{"2025-02-10", "2025-04-10"} {
+		input := geracaoInput(1004+i, i+1, vencimento)
+		input.IdProposta = 11
+		input.NumeroAcompanhamento = "ACP-11"
+		input.NumeroCCB = 666
+		input.IdConvenio = 8
+		input.IdSecuritizadora = 9
+		if i == 1 {
+			input.TipoCobranca = config.TIPO_COBRANCA_PIX
+		}
+		mustExec(t)(repo.UpdateGeracaoCobranca(input))
+	}
+}
+
+// searchAll percorre todas as páginas da listagem e retorna os id_proposta_parcela na ordem recebida.
+func searchAll(t *testing.T, repo service.ParcelaRepository, filter models.CobrancaFilter) []int {
+	t.Helper()
+	var ids = make([]int, 0)
+	for page := 0; ; page++ {
+		if page > 20 {
+			t.Fatal("paginação não terminou")
+		}
+		if err := filter.Validate(); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		query := filter
+		query.Limit++
+		cobrancas, err := repo.Search(query)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		result := models.NewCobrancaPage(cobrancas, filter)
+		for _, cobranca := range result.Items {
+			ids = append(ids, cobranca.IdPropostaParcela)
+		}
+		if result.NextCursor == "" {
+			return ids
+		}
+		filter.Cursor = result.NextCursor
+	}
+}
+
+func outboxMessage(t *testing.T, idPropostaParcela int) models.OutboxMessage {
+	t.Helper()
+	mensagem, err := models.NewOutboxMessage("webhook", map[string]any{"id_proposta_parcela": idPropostaParcela}, 0, idPropostaParcela)
+	if err != nil {
+		t.Fatalf("NewOutboxMessage: %v", err)
+	}
+	return mensagem
+}
+
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+	d, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Representa as operações realizadas nas tabelas de execuções e divergências da reconciliação com o BMP
+type ReconciliacaoRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewReconciliacaoRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *ReconciliacaoRepo {
+	return &ReconciliacaoRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *ReconciliacaoRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava o início de uma execução e retorna o id gerado.
+func (s *ReconciliacaoRepo) Insert(data models.Reconciliacao) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+	INSERT INTO bmp_reconciliacoes (
+	    status,
+	    origem,
+	    iniciada_em
+	) VALUES ($1, $2, $3)
+	RETURNING id`,
+		data.Status,
+		data.Origem,
+		data.IniciadaEm,
+	).Scan(&id)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_reconciliacoes", err.Error(), data)
+		return 0, err
+	}
+	return id, nil
+}
+
+// Update grava o status e os totais de uma execução.
+func (s *ReconciliacaoRepo) Update(data models.Reconciliacao) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE bmp_reconciliacoes SET
+	    status=$2,
+	    parcelas_verificadas=$3,
+	    divergencias=$4,
+	    corrigidas=$5,
+	    erros=$6,
+	    erro=$7,
+	    finalizada_em=$8
+	WHERE id=$1`,
+		data.Id,
+		data.Status,
+		data.ParcelasVerificadas,
+		data.Divergencias,
+		data.Corrigidas,
+		data.Erros,
+		data.Erro,
+		data.FinalizadaEm,
+	)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao atualizar a tabela bmp_reconciliacoes", err.Error(), data)
+		return err
+	}
+	return nil
+}
+
+// FindById busca uma execução pelo id.
+func (s *ReconciliacaoRepo) FindById(id int64) (models.Reconciliacao, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, `
+			SELECT
+			    id,
+			    status,
+			    origem,
+			    parcelas_verificadas,
+			    divergencias,
+			    corrigidas,
+			    erros,
+			    erro,
+			    iniciada_em,
+			    finalizada_em
+			FROM
+			    bmp_reconciliacoes
+			WHERE
+			    id=$1`, id)
+
+	data, err := scanReconciliacao(row)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em bmp_reconciliacoes por id", err.Error(), map[string]any{"id": id})
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Reconciliacao{}, errors.New("dados nao encontrados")
+		}
+		return models.Reconciliacao{}, err
+	}
+	return data, nil
+}
+
+// Find lista as execuções por status e/ou intervalo de início, da mais recente para a mais antiga.
+func (s *ReconciliacaoRepo) Find(filter models.ReconciliacaoFilter) ([]models.Reconciliacao, error) {
+	var conditions = make([]string, 0)
+	var args = make([]any, 0)
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status=$%d", len(args)))
+	}
+	if !filter.Inicio.IsZero() {
+		args = append(args, filter.Inicio)
+		conditions = append(conditions, fmt.Sprintf("iniciada_em>=$%d", len(args)))
+	}
+	if !filter.Fim.IsZero() {
+		args = append(args, filter.Fim)
+		conditions = append(conditions, fmt.Sprintf("iniciada_em<=$%d", len(args)))
+	}
+
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	args = append(args, filter.Limit, filter.Offset)
+
+	query := fmt.Sprintf(`
+			SELECT
+			    id,
+			    status,
+			    origem,
+			    parcelas_verificadas,
+			    divergencias,
+			    corrigidas,
+			    erros,
+			    erro,
+			    iniciada_em,
+			    finalizada_em
+			FROM
+			    bmp_reconciliacoes
+			%s
+			ORDER BY iniciada_em DESC, id DESC
+			LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_reconciliacoes", err.Error(), filter)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reconciliacoes = make([]models.Reconciliacao, 0)
+	for rows.Next() {
+		data, err := scanReconciliacao(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_reconciliacoes", err.Error(), filter)
+			return nil, err
+		}
+		reconciliacoes = append(reconciliacoes, data)
+	}
+
+	return reconciliacoes, rows.Err()
+}
+
+// InsertDivergencia grava uma divergência encontrada na execução.
+func (s *ReconciliacaoRepo) InsertDivergencia(data models.ReconciliacaoDivergencia) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO bmp_reconciliacao_divergencias (
+	    id_reconciliacao,
+	    id_proposta_parcela,
+	    id_proposta,
+	    numero_parcela,
+	    tipo,
+	    local,
+	    bmp,
+	    corrigida,
+	    acao,
+	    created_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		data.IdReconciliacao,
+		data.IdPropostaParcela,
+		data.IdProposta,
+		data.NumeroParcela,
+		data.Tipo,
+		data.Local,
+		data.BMP,
+		data.Corrigida,
+		data.Acao,
+		data.CreatedAt,
+	)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_reconciliacao_divergencias", err.Error(), data)
+		return isConnError(s.db, err), err
+	}
+	return false, nil
+}
+
+// FindDivergencias lista as divergências de uma execução na ordem em que foram encontradas.
+func (s *ReconciliacaoRepo) FindDivergencias(filter models.ReconciliacaoDivergenciaFilter) ([]models.ReconciliacaoDivergencia, error) {
+	var conditions = []string{"id_reconciliacao=$1"}
+	var args = []any{filter.IdReconciliacao}
+
+	if filter.IdPropostaParcela > 0 {
+		args = append(args, filter.IdPropostaParcela)
+		conditions = append(conditions, fmt.Sprintf("id_proposta_parcela=$%d", len(args)))
+	}
+	if filter.Tipo != "" {
+		args = append(args, filter.Tipo)
+		conditions = append(conditions, fmt.Sprintf("tipo=$%d", len(args)))
+	}
+	if filter.Pendentes {
+		conditions = append(conditions, "NOT corrigida")
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	args = append(args, filter.Limit, filter.Offset)
+
+	query := fmt.Sprintf(`
+			SELECT
+			    id,
+			    id_reconciliacao,
+			    id_proposta_parcela,
+			    id_proposta,
+			    numero_parcela,
+			    tipo,
+			    local,
+			    bmp,
+			    corrigida,
+			    acao,
+			    created_at
+			FROM
+			    bmp_reconciliacao_divergencias
+			WHERE %s
+			ORDER BY id
+			LIMIT $%d OFFSET $%d`, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_reconciliacao_divergencias", err.Error(), filter)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var divergencias = make([]models.ReconciliacaoDivergencia, 0)
+	for rows.Next() {
+		var data models.ReconciliacaoDivergencia
+		var local, bmp, acao sql.NullString
+		err := rows.Scan(&data.Id,
+			&data.IdReconciliacao,
+			&data.IdPropostaParcela,
+			&data.IdProposta,
+			&data.NumeroParcela,
+			&data.Tipo,
+			&local,
+			&bmp,
+			&data.Corrigida,
+			&acao,
+			&data.CreatedAt,
+		)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_reconciliacao_divergencias", err.Error(), filter)
+			return nil, err
+		}
+		data.Local = local.String
+		data.BMP = bmp.String
+		data.Acao = acao.String
+		divergencias = append(divergencias, data)
+	}
+
+	return divergencias, rows.Err()
+}
+
+func scanReconciliacao(row rowScanner) (models.Reconciliacao, error) {
+	var data models.Reconciliacao
+	var erro sql.NullString
+	var finalizadaEm sql.NullTime
+
+	err := row.Scan(&data.Id,
+		&data.Status,
+		&data.Origem,
+		&data.ParcelasVerificadas,
+		&data.Divergencias,
+		&data.Corrigidas,
+		&data.Erros,
+		&erro,
+		&data.IniciadaEm,
+		&finalizadaEm,
+	)
+	if err != nil {
+		return models.Reconciliacao{}, err
+	}
+
+	data.Erro = erro.String
+	if finalizadaEm.Valid {
+		data.FinalizadaEm = &finalizadaEm.Time
+	}
+	return data, nil
+}
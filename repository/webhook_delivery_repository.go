@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Representa as operações realizadas na tabela de entregas de webhook
+type WebhookDeliveryRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewWebhookDeliveryRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *WebhookDeliveryRepo {
+	return &WebhookDeliveryRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *WebhookDeliveryRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava uma tentativa de entrega de webhook.
+func (s *WebhookDeliveryRepo) Insert(data models.WebhookDelivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	var payload any
+	if len(data.Payload) > 0 {
+		payload = string(data.Payload)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO bmp_webhook_entregas (
+	    id_proposta_parcela,
+	    url,
+	    contexto,
+	    auth_scheme,
+	    schema_version,
+	    payload,
+	    payload_hash,
+	    status_code,
+	    latencia_ms,
+	    resposta,
+	    tentativa,
+	    erro,
+	    created_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		data.IdPropostaParcela,
+		data.Url,
+		data.Contexto,
+		data.AuthScheme,
+		data.SchemaVersion,
+		payload,
+		data.PayloadHash,
+		data.StatusCode,
+		data.LatenciaMs,
+		data.Resposta,
+		data.Tentativa,
+		data.Erro,
+		data.CreatedAt,
+	)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_webhook_entregas", err.Error(), data)
+		return isConnError(s.db, err), err
+	}
+
+	return false, nil
+}
+
+// FindById busca uma entrega de webhook pelo id.
+func (s *WebhookDeliveryRepo) FindById(id int64) (models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, `
+			SELECT
+			    id,
+			    id_proposta_parcela,
+			    url,
+			    contexto,
+			    auth_scheme,
+			    schema_version,
+			    payload,
+			    payload_hash,
+			    status_code,
+			    latencia_ms,
+			    resposta,
+			    tentativa,
+			    erro,
+			    created_at
+			FROM
+			    bmp_webhook_entregas
+			WHERE
+			    id=$1`, id)
+
+	delivery, err := scanWebhookDelivery(row)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em bmp_webhook_entregas por id", err.Error(), map[string]any{"id": id})
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebhookDelivery{}, models.ErrEntregaNaoEncontrada
+		}
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// Find lista as entregas de webhook por id_proposta_parcela, url e/ou intervalo de tempo, da mais recente para a mais antiga.
+func (s *WebhookDeliveryRepo) Find(filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	var conditions = make([]string, 0)
+	var args = make([]any, 0)
+
+	if filter.IdPropostaParcela > 0 {
+		args = append(args, filter.IdPropostaParcela)
+		conditions = append(conditions, fmt.Sprintf("id_proposta_parcela=$%d", len(args)))
+	}
+	if filter.Url != "" {
+		args = append(args, filter.Url)
+		conditions = append(conditions, fmt.Sprintf("url=$%d", len(args)))
+	}
+	if !filter.Inicio.IsZero() {
+		args = append(args, filter.Inicio)
+		conditions = append(conditions, fmt.Sprintf("created_at>=$%d", len(args)))
+	}
+	if !filter.Fim.IsZero() {
+		args = append(args, filter.Fim)
+		conditions = append(conditions, fmt.Sprintf("created_at<=$%d", len(args)))
+	}
+
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	args = append(args, filter.Limit, filter.Offset)
+
+	query := fmt.Sprintf(`
+			SELECT
+			    id,
+			    id_proposta_parcela,
+			    url,
+			    contexto,
+			    auth_scheme,
+			    schema_version,
+			    payload,
+			    payload_hash,
+			    status_code,
+			    latencia_ms,
+			    resposta,
+			    tentativa,
+			    erro,
+			    created_at
+			FROM
+			    bmp_webhook_entregas
+			%s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_webhook_entregas", err.Error(), filter)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries = make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_webhook_entregas", err.Error(), filter)
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhookDelivery(row rowScanner) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var payload sql.NullString
+	var resposta, erro sql.NullString
+
+	err := row.Scan(&delivery.Id,
+		&delivery.IdPropostaParcela,
+		&delivery.Url,
+		&delivery.Contexto,
+		&delivery.AuthScheme,
+		&delivery.SchemaVersion,
+		&payload,
+		&delivery.PayloadHash,
+		&delivery.StatusCode,
+		&delivery.LatenciaMs,
+		&resposta,
+		&delivery.Tentativa,
+		&erro,
+		&delivery.CreatedAt,
+	)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	if payload.Valid {
+		delivery.Payload = []byte(payload.String)
+	}
+	delivery.Resposta = resposta.String
+	delivery.Erro = erro.String
+	return delivery, nil
+}
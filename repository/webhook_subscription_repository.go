@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Representa as operações realizadas na tabela de assinaturas de webhook
+type WebhookSubscriptionRepo struct {
+	ctx      context.Context
+	db       *sql.DB
+	logger   *slog.Logger
+	location *time.Location
+}
+
+func NewWebhookSubscriptionRepo(ctx context.Context, db *sql.DB, logger *slog.Logger, location *time.Location) *WebhookSubscriptionRepo {
+	return &WebhookSubscriptionRepo{db: db,
+		logger:   logger,
+		location: location,
+		ctx:      ctx,
+	}
+}
+
+func (s *WebhookSubscriptionRepo) SetDB(db *sql.DB) {
+	s.db = db
+
+}
+
+// Insert grava uma nova assinatura e retorna o id gerado.
+func (s *WebhookSubscriptionRepo) Insert(data models.WebhookSubscription) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+	INSERT INTO bmp_webhook_assinaturas (
+	    id_convenio,
+	    id_securitizadora,
+	    url,
+	    eventos,
+	    auth_scheme,
+	    schema_version,
+	    ativo,
+	    created_at,
+	    updated_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id`,
+		data.IdConvenio,
+		data.IdSecuritizadora,
+		data.Url,
+		pq.Array(data.Eventos),
+		data.AuthScheme,
+		data.SchemaVersion,
+		data.Ativo,
+		data.CreatedAt,
+		data.UpdatedAt,
+	).Scan(&id)
+
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao inserir na tabela bmp_webhook_assinaturas", err.Error(), data)
+		return 0, err
+	}
+	return id, nil
+}
+
+// Update atualiza uma assinatura existente.
+func (s *WebhookSubscriptionRepo) Update(data models.WebhookSubscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+	UPDATE
+	    bmp_webhook_assinaturas
+	SET
+	    id_convenio=$1,
+	    id_securitizadora=$2,
+	    url=$3,
+	    eventos=$4,
+	    auth_scheme=$5,
+	    schema_version=$6,
+	    ativo=$7,
+	    updated_at=$8
+	WHERE
+	    id=$9`,
+		data.IdConvenio,
+		data.IdSecuritizadora,
+		data.Url,
+		pq.Array(data.Eventos),
+		data.AuthScheme,
+		data.SchemaVersion,
+		data.Ativo,
+		data.UpdatedAt,
+		data.Id,
+	)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao atualizar bmp_webhook_assinaturas", err.Error(), data)
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errors.New("dados nao encontrados")
+	}
+	return nil
+}
+
+// Delete remove uma assinatura.
+func (s *WebhookSubscriptionRepo) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM bmp_webhook_assinaturas WHERE id=$1`, id)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao remover de bmp_webhook_assinaturas", err.Error(), map[string]any{"id": id})
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errors.New("dados nao encontrados")
+	}
+	return nil
+}
+
+// FindById busca uma assinatura pelo id.
+func (s *WebhookSubscriptionRepo) FindById(id int64) (models.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, `
+			SELECT
+			    id,
+			    id_convenio,
+			    id_securitizadora,
+			    url,
+			    eventos,
+			    auth_scheme,
+			    schema_version,
+			    ativo,
+			    created_at,
+			    updated_at
+			FROM
+			    bmp_webhook_assinaturas
+			WHERE
+			    id=$1`, id)
+
+	subscription, err := scanWebhookSubscription(row)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao buscar em bmp_webhook_assinaturas por id", err.Error(), map[string]any{"id": id})
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebhookSubscription{}, errors.New("dados nao encontrados")
+		}
+		return models.WebhookSubscription{}, err
+	}
+	return subscription, nil
+}
+
+// Find lista as assinaturas por convênio e/ou securitizadora.
+func (s *WebhookSubscriptionRepo) Find(filter models.WebhookSubscriptionFilter) ([]models.WebhookSubscription, error) {
+	var conditions = make([]string, 0)
+	var args = make([]any, 0)
+
+	if filter.IdConvenio > 0 {
+		args = append(args, filter.IdConvenio)
+		conditions = append(conditions, fmt.Sprintf("id_convenio=$%d", len(args)))
+	}
+	if filter.IdSecuritizadora > 0 {
+		args = append(args, filter.IdSecuritizadora)
+		conditions = append(conditions, fmt.Sprintf("id_securitizadora=$%d", len(args)))
+	}
+
+	var where string
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return s.query(fmt.Sprintf(`
+			SELECT
+			    id,
+			    id_convenio,
+			    id_securitizadora,
+			    url,
+			    eventos,
+			    auth_scheme,
+			    schema_version,
+			    ativo,
+			    created_at,
+			    updated_at
+			FROM
+			    bmp_webhook_assinaturas
+			%s
+			ORDER BY id_convenio, id_securitizadora, id`, where), args...)
+}
+
+// FindAtivas lista as assinaturas ativas de um convênio, incluindo as que valem para todas as securitizadoras(id_securitizadora=0).
+func (s *WebhookSubscriptionRepo) FindAtivas(idConvenio, idSecuritizadora int) ([]models.WebhookSubscription, error) {
+	return s.query(`
+			SELECT
+			    id,
+			    id_convenio,
+			    id_securitizadora,
+			    url,
+			    eventos,
+			    auth_scheme,
+			    schema_version,
+			    ativo,
+			    created_at,
+			    updated_at
+			FROM
+			    bmp_webhook_assinaturas
+			WHERE
+			    ativo AND id_convenio=$1 AND (id_securitizadora=0 OR id_securitizadora=$2)
+			ORDER BY id`, idConvenio, idSecuritizadora)
+}
+
+func (s *WebhookSubscriptionRepo) query(query string, args ...any) ([]models.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DB_QUERY_TIMEOUT)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao listar bmp_webhook_assinaturas", err.Error(), args)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions = make([]models.WebhookSubscription, 0)
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			helpers.LogError(s.ctx, s.logger, s.location, "db", "", "Erro ao ler bmp_webhook_assinaturas", err.Error(), args)
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func scanWebhookSubscription(row rowScanner) (models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	var authScheme sql.NullString
+
+	err := row.Scan(&subscription.Id,
+		&subscription.IdConvenio,
+		&subscription.IdSecuritizadora,
+		&subscription.Url,
+		pq.Array(&subscription.Eventos),
+		&authScheme,
+		&subscription.SchemaVersion,
+		&subscription.Ativo,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	subscription.AuthScheme = authScheme.String
+	return subscription, nil
+}
@@ -21,9 +21,13 @@ type CobrancalService struct {
 	client            CobrancaClient
 	queue             QueueProducer
 	cache             *cache.RedisCache
+	locker            Locker
 	parcelaRepository ParcelaRepository
 	webhookService    *WebhookService
 	updateService     *UpdateService
+	outboxRepository  OutboxRepository
+	historico         *HistoricoService
+	lancamentos       *LancamentoService
 	operations        map[string]string
 }
 
@@ -45,7 +49,7 @@ func (c *CobrancalService) SetProducer(q any) error {
 func NewCobrancaService(ctx context.Context, logger *slog.Logger, loc *time.Location,
 	client CobrancaClient, webhookService *WebhookService,
 	cache *cache.RedisCache, parcelaRepository ParcelaRepository, updateService *UpdateService) *CobrancalService {
-	return &CobrancalService{
+	var service = &CobrancalService{
 		ctx:               ctx,
 		logger:            logger,
 		loc:               loc,
@@ -61,6 +65,10 @@ func NewCobrancaService(ctx context.Context, logger *slog.Logger, loc *time.Loca
 			config.STATUS_CONSULTAR_COBRANCA: "Consulta de cobrança",
 		},
 	}
+	if cache != nil {
+		service.locker = cache
+	}
+	return service
 }
 
 func (c CobrancalService) Auth(data models.AuthPayload, expire bool) (string, error) {
@@ -77,6 +85,15 @@ func (c *CobrancalService) Cobranca(payload *models.CobrancaTaskData) (any, stri
 
 	}
 
+	//Operações concorrentes na mesma parcela(API, filas e eventos do BMP) são serializadas pelo lock da parcela
+	if operacoesExclusivas[payload.Status] {
+		unlock, err := c.lockParcela(payload.IdPropostaParcela)
+		if err != nil {
+			return c.handleParcelaOcupada(payload)
+		}
+		defer unlock()
+	}
+
 	switch payload.Status {
 	case config.STATUS_CANCELAR_COBRANCA:
 		data, status, statusCode, err := c.CancelarCobranca(payload)
@@ -146,7 +163,11 @@ func (c *CobrancalService) GerarCobranca(payload *models.CobrancaTaskData) (any,
 	var statusCode int
 	var data models.GerarCobrancaResponse
 
+	idPropostaParcela := payload.GerarCobrancaInput.IdPropostaParcela
 	if !payload.Updated {
+		if err := c.ValidateOperacao(idPropostaParcela, config.STATUS_GERAR_COBRANCA); err != nil {
+			return c.HandleErrorCobranca(config.API_STATUS_ERR, 409, payload, statusError(err, idPropostaParcela))
+		}
 
 		_, err := c.updateService.UpdateGeracaoParcela(models.UpdateDbData{
 			GeracaoParcela: &payload.GerarCobrancaInput,
@@ -162,8 +183,8 @@ func (c *CobrancalService) GerarCobranca(payload *models.CobrancaTaskData) (any,
 
 	payload.CobrancaDBInfo.IdFormaCobranca = payload.GerarCobrancaInput.TipoCobranca
 
-	cobrancaInfo, _ := c.parcelaRepository.FindByIdPropostaParcela(payload.GerarCobrancaInput.IdPropostaParcela)
-	if cobrancaInfo.CodigoLiquidacao != "" {
+	cobrancaInfo, _ := c.parcelaRepository.FindByIdPropostaParcela(idPropostaParcela)
+	if statusAtivo(cobrancaInfo.Status) && cobrancaInfo.CodigoLiquidacao != "" {
 		payload.ConsultarCobrancaInput = models.ConsultarDetalhesInput{
 			DTO: models.DtoCobranca{
 				CodigoProposta: payload.GerarCobrancaInput.NumeroAcompanhamento,
@@ -177,6 +198,7 @@ func (c *CobrancalService) GerarCobranca(payload *models.CobrancaTaskData) (any,
 		payload.Status = config.STATUS_CONSULTAR_COBRANCA
 		payload.NumeroBoleto = cobrancaInfo.NumeroBoleto
 		payload.CobrancaDBInfo.CodigoLiquidacao = cobrancaInfo.CodigoLiquidacao
+		payload.CobrancaDBInfo.UrlWebhook = cobrancaInfo.UrlWebhook
 		payload.CobrancaDBInfo.IdPropostaParcela = payload.GerarCobrancaInput.IdPropostaParcela
 		payload.IdPropostaParcela = payload.GerarCobrancaInput.IdPropostaParcela
 		payload.SwitchCobrancaMode()
@@ -185,6 +207,11 @@ func (c *CobrancalService) GerarCobranca(payload *models.CobrancaTaskData) (any,
 
 	}
 
+	cobrancaInfo.IdPropostaParcela = idPropostaParcela
+	if err := c.TransitionStatus(cobrancaInfo, models.COBRANCA_STATUS_GERANDO, "geração de cobrança"); errors.Is(err, ErrTransicaoStatus) {
+		return c.HandleErrorCobranca(config.API_STATUS_ERR, 409, payload, statusError(err, idPropostaParcela))
+	}
+
 	if payload.MultiplasCobrancas {
 
 		data, statusCode, status, errCobrancas = c.GerarCobrancaParcelasMultiplas(payload)
@@ -199,11 +226,7 @@ func (c *CobrancalService) GerarCobranca(payload *models.CobrancaTaskData) (any,
 
 	}
 
-	c.updateService.UpdateCodLiquidacao(models.UpdateDbData{
-		IdPropostaParcela: payload.IdPropostaParcela,
-		CodigoLiquidacao:  data.Cobrancas[0].CodigoLiquidacao,
-		Action:            "update_codigo_liquidacao",
-	}, false)
+	c.registrarCobranca(payload.IdPropostaParcela, data.Cobrancas[0].CodigoLiquidacao)
 
 	var cobrancaGeradaInfo = map[string]any{
 		"id_proposta":         payload.GerarCobrancaInput.IdProposta,
@@ -221,6 +244,15 @@ func (c CobrancalService) SendToDLQ(data any) error {
 
 }
 
+// Enqueue envia a operação para a fila de consultas ou de cobranças, para ser processada pelos consumers.
+func (c CobrancalService) Enqueue(payload *models.CobrancaTaskData) error {
+	payload.CalledAssync = true
+	if payload.Status == config.STATUS_CONSULTAR_COBRANCA {
+		return c.queue.Produce(config.CONSULTA_QUEUE, payload, 0)
+	}
+	return c.queue.Produce(config.COBRANCA_QUEUE, payload, 0)
+}
+
 func (c *CobrancalService) GerarCobrancaParcela(payload *models.CobrancaTaskData) (models.GerarCobrancaResponse, int, string, error) {
 	var payloadBMP = models.CobrancaUnicaInput{
 		Dto: models.DtoCobranca{
@@ -242,6 +274,7 @@ func (c *CobrancalService) GerarCobrancaParcela(payload *models.CobrancaTaskData
 	}
 
 	data, statusCode, status, err := c.client.GerarCobrancaParcela(payloadBMP, payload.Token, payload.IdempotencyKey)
+	c.recordChamadaBMP(payload, payloadBMP, statusCode, err)
 
 	if err != nil {
 		errAPI, ok := err.(models.APIError)
@@ -291,6 +324,7 @@ func (c *CobrancalService) GerarCobrancaParcelasMultiplas(payload *models.Cobran
 
 		data, statusCode, status, err = c.client.GerarCobrancaParcelasMultiplas(payloadBMP, payload.Token, payload.IdempotencyKey)
 	}
+	c.recordChamadaBMP(payload, payloadBMP, statusCode, err)
 
 	if err != nil {
 		errAPI, ok := err.(models.APIError)
@@ -308,6 +342,10 @@ func (c *CobrancalService) GerarCobrancaParcelasMultiplas(payload *models.Cobran
 func (c *CobrancalService) CancelarCobranca(payload *models.CobrancaTaskData) (any, string, int, error) {
 
 	if !payload.Updated {
+		if err := c.ValidateOperacao(payload.IdPropostaParcela, config.STATUS_CANCELAR_COBRANCA); err != nil {
+			return c.HandleErrorCobranca(config.API_STATUS_ERR, 409, payload, statusError(err, payload.IdPropostaParcela))
+		}
+
 		_, err := c.updateService.UpdateCancelamentoParcela(models.UpdateDbData{
 			CancelamentoCobranca: &payload.CancelamentoData,
 			CodigoLiquidacao:     payload.CancelamentoCobranca.DTOCancelarCobrancas.CodigosLiquidacoes[0],
@@ -331,6 +369,7 @@ func (c *CobrancalService) CancelarCobranca(payload *models.CobrancaTaskData) (a
 		payload.Token = token
 		data, statusCode, status, err = c.client.CancelarCobranca(payload.CancelamentoCobranca, payload.Token, payload.IdempotencyKey)
 	}
+	c.recordChamadaBMP(payload, payload.CancelamentoCobranca, statusCode, err)
 
 	if err != nil {
 		errApi, ok := err.(models.APIError)
@@ -345,6 +384,8 @@ func (c *CobrancalService) CancelarCobranca(payload *models.CobrancaTaskData) (a
 		return nil, status, statusCode, errApi
 	}
 
+	c.transitionStatusById(payload.IdPropostaParcela, models.COBRANCA_STATUS_CANCELADA, "cancelamento de cobrança")
+
 	return data, status, statusCode, nil
 }
 
@@ -374,6 +415,7 @@ func (c *CobrancalService) LancamentoParcela(payload *models.CobrancaTaskData) (
 		payload.Token = token
 		data, statusCode, status, err = c.client.LancamentoParcela(payload.FormatLancamento(), payload.Token, payload.IdempotencyKey)
 	}
+	c.recordChamadaBMP(payload, payload.FormatLancamento(), statusCode, err)
 
 	if err != nil {
 		errApi, ok := err.(models.APIError)
@@ -403,6 +445,7 @@ func (c *CobrancalService) ConsultarCobranca(payload *models.CobrancaTaskData, c
 		payload.Token = token
 		data, statusCode, status, err = c.client.ConsultarCobranca(payload.ConsultarCobrancaInput, payload.Token, payload.IdempotencyKey)
 	}
+	c.recordChamadaBMP(payload, payload.ConsultarCobrancaInput, statusCode, err)
 
 	if err != nil {
 		return data, status, statusCode, err
@@ -411,6 +454,7 @@ func (c *CobrancalService) ConsultarCobranca(payload *models.CobrancaTaskData, c
 	if len(data.Parcelas) < 1 {
 		return models.ConsultaCobrancaResponse{}, "", 404, models.NewAPIError("", "Parcelas não encontradas", strconv.Itoa(payload.IdPropostaParcela))
 	}
+	c.fillParcelasStatus(&data)
 
 	var whData = make(map[string]any)
 	whData["id_proposta_parcela"] = payload.CobrancaDBInfo.IdPropostaParcela
@@ -441,9 +485,19 @@ func (c *CobrancalService) ConsultarCobranca(payload *models.CobrancaTaskData, c
 
 	payload.WhData = whData
 
-	if payload.WebhookUrl != "" && callBack {
-		c.webhookService.RequestToWebhook(models.NewWebhookTaskData(payload.WebhookUrl, whData, "consulta-cobranca"))
+	boletos := models.WebhookBoletosFromConsulta(data.Parcelas[0].Boletos)
+	pix := models.WebhookPixFromConsulta(data.Parcelas[0].Pix)
+
+	var outbox []models.OutboxMessage
+	if callBack && payload.CalledAssync {
+		event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_COBRANCA_REGISTRADA, payload.CobrancaDBInfo.IdPropostaParcela, models.CobrancaRegistradaData{
+			CodigoLiquidacao: payload.CobrancaDBInfo.CodigoLiquidacao,
+			Boletos:          boletos,
+			Pix:              pix,
+		}, whData)
+		outbox, _ = c.webhookService.Outbox(payload.WebhookDestino(), event, "consulta-cobranca")
 	}
+	c.savePagamento(payload.CobrancaDBInfo.IdPropostaParcela, boletos, pix, outbox...)
 
 	return data, status, statusCode, nil
 }
@@ -470,6 +524,7 @@ func (c *CobrancalService) ConsultarBoleto(payload *models.CobrancaTaskData, cal
 		payload.Token = token
 		data, statusCode, status, err = c.client.ConsultarBoleto(consultaInput, payload.Token, payload.IdempotencyKey)
 	}
+	c.recordChamadaBMP(payload, consultaInput, statusCode, err)
 
 	if err != nil {
 		return data, status, statusCode, err
@@ -487,33 +542,88 @@ func (c *CobrancalService) ConsultarBoleto(payload *models.CobrancaTaskData, cal
 
 	payload.WhData = whData
 
-	if payload.WebhookUrl != "" && callBack {
-		c.webhookService.RequestToWebhook(models.NewWebhookTaskData(payload.WebhookUrl, whData, "consulta-boleto"))
+	boletos := []models.WebhookBoletoData{models.NewWebhookBoletoFromBoleto(data.Boletos[0])}
+
+	var outbox []models.OutboxMessage
+	if callBack && payload.CalledAssync {
+		event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_COBRANCA_REGISTRADA, payload.CobrancaDBInfo.IdPropostaParcela, models.CobrancaRegistradaData{
+			CodigoLiquidacao: payload.CobrancaDBInfo.CodigoLiquidacao,
+			Boletos:          boletos,
+		}, whData)
+		outbox, _ = c.webhookService.Outbox(payload.WebhookDestino(), event, "consulta-boleto")
 	}
+	c.savePagamento(payload.CobrancaDBInfo.IdPropostaParcela, boletos, nil, outbox...)
 
 	return data, status, statusCode, nil
 }
 
+// SearchCobrancas lista as cobranças gravadas. O filtro deve ter sido validado por CobrancaFilter.Validate.
+func (c *CobrancalService) SearchCobrancas(filter models.CobrancaFilter) (models.CobrancaPage, error) {
+	//Uma cobrança além do limite indica que há uma próxima página
+	var query = filter
+	query.Limit++
+
+	cobrancas, err := c.parcelaRepository.Search(query)
+	if err != nil {
+		return models.CobrancaPage{}, err
+	}
+	return models.NewCobrancaPage(cobrancas, filter), nil
+}
+
+// savePagamento grava os dados de pagamento retornados em uma consulta ao BMP, exibidos na listagem de cobranças, e as mensagens
+// do outbox na mesma transação. Falhas na gravação dos dados são apenas registradas no log pelo repositório, pois eles são
+// atualizados novamente na próxima consulta; nesse caso as mensagens são gravadas sozinhas, já que a consulta ocorreu.
+func (c *CobrancalService) savePagamento(idPropostaParcela int, boletos []models.WebhookBoletoData, pix []models.WebhookPixData, outbox ...models.OutboxMessage) {
+	if idPropostaParcela <= 0 {
+		c.SaveOutbox(outbox)
+		return
+	}
+	_, err := c.parcelaRepository.UpdatePagamento(idPropostaParcela, models.CobrancaPagamento{
+		Boletos:      boletos,
+		Pix:          pix,
+		AtualizadoEm: time.Now().In(c.loc),
+	}, outbox...)
+	if err != nil {
+		c.SaveOutbox(outbox)
+	}
+}
+
 func (c *CobrancalService) FindByCodLiquidacao(codigoLiquidacao string, numeroCCB int) (models.CobrancaBMP, error) {
 	return c.parcelaRepository.FindByCodLiquidacao(codigoLiquidacao, numeroCCB)
 }
 
-func (c *CobrancalService) UpdateCodLiquidacao(idPropostaParcela int, codigoLiquidacao string) (bool, error) {
+// UpdateCodLiquidacao grava o código de liquidação se a parcela ainda estiver na versão lida(version 0 grava sem condição).
+// Retorna models.ErrVersaoConcorrente se outra operação gravou a parcela depois da leitura.
+func (c *CobrancalService) UpdateCodLiquidacao(idPropostaParcela int, codigoLiquidacao string, version int64) (bool, error) {
 	return c.updateService.UpdateCodLiquidacao(models.UpdateDbData{
 		CodigoLiquidacao:  codigoLiquidacao,
 		IdPropostaParcela: idPropostaParcela,
+		Version:           version,
 		Action:            "update_codigo_liquidacao",
 	}, false)
 }
 
-func (c *CobrancalService) UpdateNumeroBoleto(idPropostaParcela, numeroBoleto int) (bool, error) {
+// UpdateNumeroBoleto grava o número do boleto, com a mesma condição de versão de UpdateCodLiquidacao.
+func (c *CobrancalService) UpdateNumeroBoleto(idPropostaParcela, numeroBoleto int, version int64) (bool, error) {
 	return c.updateService.UpdateNumeroBoleto(models.UpdateDbData{
 		IdPropostaParcela: idPropostaParcela,
 		NumeroBoleto:      numeroBoleto,
+		Version:           version,
 		Action:            "update_numero_boleto",
 	}, false)
 }
 
+// FindByNumeroBoleto busca a parcela pelo boleto atual ou por um boleto substituído do histórico de liquidações.
+func (c *CobrancalService) FindByNumeroBoleto(numeroBoleto int, numeroCCB int) (models.CobrancaBMP, error) {
+	return c.parcelaRepository.FindByNumeroBoleto(numeroBoleto, numeroCCB)
+}
+
+// UpdateLiquidacaoStatus altera no histórico o status da liquidação com o código de liquidação ou o número do boleto informados.
+func (c *CobrancalService) UpdateLiquidacaoStatus(idPropostaParcela int, codigoLiquidacao string, numeroBoleto int, status string) error {
+	_, err := c.parcelaRepository.UpdateLiquidacaoStatus(idPropostaParcela, codigoLiquidacao, numeroBoleto, status)
+	return err
+}
+
 func (c *CobrancalService) FindByNumParcela(numParcela int, numeroCCB int) (models.CobrancaBMP, error) {
 	return c.parcelaRepository.FindByNumParcela(numParcela, numeroCCB)
 }
@@ -555,12 +665,13 @@ func (c *CobrancalService) HandleErrorCobranca(status string, statusCode int, pa
 	if errAPI.Msg == "" {
 		errAPI.Msg = fmt.Sprintf("%s indisponível", operation)
 	}
+	payload.LastError = errAPI.Msg
 
 	if payload.Status == config.STATUS_CONSULTAR_COBRANCA {
 		payload.SetTry(config.TIMEOUT_DELAY, status)
 		payload.SwitchCobrancaMode()
 		payload.GenIdempotencyKey(c.cache.GenID())
-		c.queue.Produce(config.CONSULTA_QUEUE, payload, payload.CurrentDelay)
+		payload.Reagendada = c.queue.Produce(config.CONSULTA_QUEUE, payload, payload.CurrentDelay) == nil
 		return nil, status, statusCode, errAPI
 	}
 
@@ -576,6 +687,7 @@ func (c *CobrancalService) HandleErrorCobranca(status string, statusCode int, pa
 
 			return nil, config.API_STATUS_ERR, 500, models.NewAPIError("", "Erro ao enviar dados para fila: "+err.Error(), strconv.Itoa(payload.IdProposta))
 		}
+		payload.Reagendada = true
 
 		var resp = models.NewAPIError("", fmt.Sprintf("%s entrou em fila de processamento. Aguarde!", c.operations[payload.Status]), strconv.Itoa(payload.IdProposta))
 		resp.HasError = false
@@ -583,38 +695,42 @@ func (c *CobrancalService) HandleErrorCobranca(status string, statusCode int, pa
 
 	}
 
+	//O webhook de erro é gravado no outbox na mesma transação do status de falha
+	var outbox []models.OutboxMessage
 	if payload.CalledAssync {
-		var whData = make(map[string]any)
+		var operacao string
 
 		switch payload.Status {
 		case config.STATUS_GERAR_COBRANCA:
-			whData["has_error"] = true
-			whData["msg"] = errAPI.Msg
-			whData["id_proposta_parcela"] = payload.IdPropostaParcela
-			whData["operacao"] = "R"
-
-			c.webhookService.RequestToWebhook(models.NewWebhookTaskData(payload.WebhookUrl, whData, "cobranca service"))
-
+			operacao = "R"
 		case config.STATUS_CANCELAR_COBRANCA:
-			whData["has_error"] = true
-			whData["msg"] = errAPI.Msg
-			whData["id_proposta_parcela"] = payload.IdPropostaParcela
-			whData["operacao"] = "C"
-
-			c.webhookService.RequestToWebhook(models.NewWebhookTaskData(payload.WebhookUrl, whData, "cobranca service"))
-
+			operacao = "C"
 		case config.STATUS_LANCAMENTO_PARCELA:
+			operacao = payload.LancamentoParcela.Operacao
+		}
+
+		if operacao != "" {
 			var whData = make(map[string]any)
 			whData["has_error"] = true
 			whData["msg"] = errAPI.Msg
 			whData["id_proposta_parcela"] = payload.IdPropostaParcela
-			whData["operacao"] = payload.LancamentoParcela.Operacao
-			c.webhookService.RequestToWebhook(models.NewWebhookTaskData(payload.WebhookUrl, whData, "cobranca service"))
+			whData["operacao"] = operacao
 
+			event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_COBRANCA_ERRO, payload.IdPropostaParcela, models.CobrancaErroData{
+				Operacao: operacao,
+				Mensagem: errAPI.Msg,
+			}, whData)
+			outbox, _ = c.webhookService.Outbox(payload.WebhookDestino(), event, "cobranca service")
 		}
 
 	}
 
+	if payload.Status == config.STATUS_GERAR_COBRANCA && statusCode != 409 {
+		c.transitionStatusById(payload.GerarCobrancaInput.IdPropostaParcela, models.COBRANCA_STATUS_FALHA, "geração de cobrança", outbox...)
+	} else {
+		c.SaveOutbox(outbox)
+	}
+
 	return nil, status, statusCode, errAPI
 
 }
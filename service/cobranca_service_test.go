@@ -0,0 +1,816 @@
+package service
+
+import (
+	"cobranca-bmp/cache"
+	"cobranca-bmp/config"
+	"cobranca-bmp/models"
+	"cobranca-bmp/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Producer que registra as mensagens enviadas para as filas.
+type fakeQueue struct {
+	mu       sync.Mutex
+	produced map[string][]any
+}
+
+func (q *fakeQueue) Produce(queue string, data any, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.produced == nil {
+		q.produced = make(map[string][]any)
+	}
+	q.produced[queue] = append(q.produced[queue], data)
+	return nil
+}
+
+func (q *fakeQueue) count(queue string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.produced[queue])
+}
+
+// Outbox que registra as mensagens gravadas sem alteração associada, ou falha com err.
+type fakeOutbox struct {
+	mensagens []models.OutboxMessage
+	err       error
+}
+
+func (o *fakeOutbox) Insert(mensagens ...models.OutboxMessage) (bool, error) {
+	if o.err != nil {
+		return false, o.err
+	}
+	o.mensagens = append(o.mensagens, mensagens...)
+	return false, nil
+}
+
+// Client do BMP. Apenas a autenticação, o cancelamento e as consultas são implementados.
+type fakeClient struct {
+	CobrancaClient
+	boleto   models.BoletoConsultado
+	consulta models.ConsultaCobrancaResponse
+}
+
+func (f *fakeClient) Auth(data models.AuthPayload, expire bool) (string, error) {
+	return "token", nil
+}
+
+func (f *fakeClient) ConsultarCobranca(payload models.ConsultarDetalhesInput, token string, idempotencyKey string) (models.ConsultaCobrancaResponse, int, string, error) {
+	return f.consulta, 200, "", nil
+}
+
+func (f *fakeClient) CancelarCobranca(payload models.CancelarCobrancaInput, token string, idempotencyKey string) (any, int, string, error) {
+	return nil, 200, "", nil
+}
+
+func (f *fakeClient) ConsultarBoleto(payload models.ConsultaBoletoInput, token string, idempotencyKey string) (models.BoletoConsultado, int, string, error) {
+	return f.boleto, 200, "", nil
+}
+
+// Lock em memória, com as chaves obtidas e ainda não liberadas.
+type fakeLocker struct {
+	mu       sync.Mutex
+	held     map[string]string
+	acquired int
+}
+
+func (l *fakeLocker) Lock(key string, ttl, wait time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = make(map[string]string)
+	}
+	if _, ok := l.held[key]; ok {
+		return "", cache.ErrLockOcupado
+	}
+	l.acquired++
+	l.held[key] = key
+	return key, nil
+}
+
+func (l *fakeLocker) Unlock(key, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[key] == token {
+		delete(l.held, key)
+	}
+	return nil
+}
+
+type testCobrancaService struct {
+	*CobrancalService
+	repo   *repository.MemoryParcelaRepo
+	outbox *fakeOutbox
+	queue  *fakeQueue
+	client *fakeClient
+}
+
+// newTestCobrancaService instancia o service com o repositório em memória e sem dependências externas.
+func newTestCobrancaService(t *testing.T) testCobrancaService {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := repository.NewMemoryParcelaRepo(time.UTC)
+	queue := &fakeQueue{}
+	outbox := &fakeOutbox{}
+	client := &fakeClient{}
+
+	updateService := NewUpdateService(logger, time.UTC, repo)
+	updateService.SetProducer(queue)
+	webhookService := NewWebhookService(nil, nil, nil, logger, time.UTC)
+	webhookService.SetProducer(queue)
+
+	service := NewCobrancaService(context.Background(), logger, time.UTC, client, webhookService, nil, repo, updateService)
+	service.SetProducer(queue)
+	service.SetOutbox(outbox)
+	return testCobrancaService{CobrancalService: service, repo: repo, outbox: outbox, queue: queue, client: client}
+}
+
+// seedParcela grava a parcela e aplica as transições até o status informado.
+func (s testCobrancaService) seedParcela(t *testing.T, idPropostaParcela int, status ...string) models.CobrancaBMP {
+	t.Helper()
+	_, err := s.repo.UpdateGeracaoCobranca(models.GerarCobrancaFrontendInput{
+		IdPropostaParcela:    idPropostaParcela,
+		IdProposta:           10,
+		NumeroAcompanhamento: "ACP-10",
+		NumeroCCB:            555,
+		NumeroParcela:        idPropostaParcela % 100,
+		DataVencimento:       "2025-01-10",
+		DataExpiracao:        "2025-01-10",
+		UrlWebhook:           "https://cliente/webhook",
+		TipoCobranca:         config.TIPO_COBRANCA_BOLETO,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGeracaoCobranca: %v", err)
+	}
+	from := models.COBRANCA_STATUS_PENDENTE
+	for _, to := range status {
+		_, version, _ := s.repo.FindStatus(idPropostaParcela)
+		if _, err := s.repo.UpdateStatus(idPropostaParcela, from, to, version); err != nil {
+			t.Fatalf("UpdateStatus %s -> %s: %v", from, to, err)
+		}
+		from = to
+	}
+	cobranca, err := s.repo.FindByIdPropostaParcela(idPropostaParcela)
+	if err != nil {
+		t.Fatalf("FindByIdPropostaParcela: %v", err)
+	}
+	return cobranca
+}
+
+func (s testCobrancaService) status(t *testing.T, idPropostaParcela int) string {
+	t.Helper()
+	status, _, err := s.repo.FindStatus(idPropostaParcela)
+	if err != nil {
+		t.Fatalf("FindStatus: %v", err)
+	}
+	return status
+}
+
+func webhookOutbox(t *testing.T, idPropostaParcela int) []models.OutboxMessage {
+	t.Helper()
+	mensagem, err := models.NewOutboxMessage(config.WEBHOOK_QUEUE, models.NewWebhookTaskData("https://cliente/webhook", map[string]any{"id_proposta_parcela": idPropostaParcela}, "teste"), 0, idPropostaParcela)
+	if err != nil {
+		t.Fatalf("NewOutboxMessage: %v", err)
+	}
+	return []models.OutboxMessage{mensagem}
+}
+
+func TestValidateOperacao(t *testing.T) {
+	s := newTestCobrancaService(t)
+	s.seedParcela(t, 1001)
+	s.seedParcela(t, 1002, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA)
+	s.seedParcela(t, 1003, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_PAGA)
+
+	var cases = []struct {
+		name              string
+		idPropostaParcela int
+		operacao          string
+		permitida         bool
+	}{
+		{"geração de parcela nova", 9999, config.STATUS_GERAR_COBRANCA, true},
+		{"geração de parcela pendente", 1001, config.STATUS_GERAR_COBRANCA, true},
+		{"geração de cobrança ativa vira consulta", 1002, config.STATUS_GERAR_COBRANCA, true},
+		{"cancelamento de cobrança registrada", 1002, config.STATUS_CANCELAR_COBRANCA, true},
+		{"cancelamento de parcela paga", 1003, config.STATUS_CANCELAR_COBRANCA, false},
+		{"geração de parcela paga", 1003, config.STATUS_GERAR_COBRANCA, false},
+		{"operação sem status de destino", 1003, config.STATUS_LANCAMENTO_PARCELA, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := s.ValidateOperacao(c.idPropostaParcela, c.operacao)
+			if c.permitida && err != nil {
+				t.Fatalf("operação rejeitada: %v", err)
+			}
+			if !c.permitida && !errors.Is(err, ErrTransicaoStatus) {
+				t.Fatalf("ValidateOperacao = %v, esperado ErrTransicaoStatus", err)
+			}
+		})
+	}
+}
+
+func TestTransitionStatus(t *testing.T) {
+	t.Run("outbox gravado com o status", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA)
+
+		if err := s.TransitionStatus(cobranca, models.COBRANCA_STATUS_CANCELADA, "teste", webhookOutbox(t, 1001)...); err != nil {
+			t.Fatalf("TransitionStatus: %v", err)
+		}
+		if status := s.status(t, 1001); status != models.COBRANCA_STATUS_CANCELADA {
+			t.Fatalf("status = %q, esperado %q", status, models.COBRANCA_STATUS_CANCELADA)
+		}
+		if len(s.repo.Outbox()) != 1 || len(s.outbox.mensagens) != 0 {
+			t.Fatalf("outbox da transação = %d, outbox avulso = %d", len(s.repo.Outbox()), len(s.outbox.mensagens))
+		}
+	})
+
+	t.Run("transição rejeitada grava apenas o outbox", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_PAGA)
+
+		err := s.TransitionStatus(cobranca, models.COBRANCA_STATUS_CANCELADA, "teste", webhookOutbox(t, 1001)...)
+		if !errors.Is(err, ErrTransicaoStatus) {
+			t.Fatalf("TransitionStatus = %v, esperado ErrTransicaoStatus", err)
+		}
+		if status := s.status(t, 1001); status != models.COBRANCA_STATUS_PAGA {
+			t.Fatalf("status = %q, esperado %q", status, models.COBRANCA_STATUS_PAGA)
+		}
+		if len(s.repo.Outbox()) != 0 || len(s.outbox.mensagens) != 1 {
+			t.Fatalf("outbox da transação = %d, outbox avulso = %d", len(s.repo.Outbox()), len(s.outbox.mensagens))
+		}
+	})
+
+	t.Run("status repetido grava apenas o outbox", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_CANCELADA)
+
+		if err := s.TransitionStatus(cobranca, models.COBRANCA_STATUS_CANCELADA, "teste", webhookOutbox(t, 1001)...); err != nil {
+			t.Fatalf("TransitionStatus: %v", err)
+		}
+		if len(s.repo.Outbox()) != 0 || len(s.outbox.mensagens) != 1 {
+			t.Fatalf("outbox da transação = %d, outbox avulso = %d", len(s.repo.Outbox()), len(s.outbox.mensagens))
+		}
+	})
+
+	t.Run("parcela gravada concorrentemente", func(t *testing.T) {
+		var cases = []struct {
+			name     string
+			from, to string //Transição gravada por outra operação depois da leitura
+			err      error
+			status   string
+		}{
+			{"versão alterada sem mudança de status", models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_REGISTRADA, nil, models.COBRANCA_STATUS_PAGA},
+			{"transição ainda permitida", models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_BOLETO_EMITIDO, nil, models.COBRANCA_STATUS_PAGA},
+			{"transição não permitida", models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_CANCELADA, ErrTransicaoStatus, models.COBRANCA_STATUS_CANCELADA},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				s := newTestCobrancaService(t)
+				cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA)
+				if c.from == c.to {
+					if _, err := s.repo.UpdateNumeroBoleto(1001, 123, 0); err != nil {
+						t.Fatalf("UpdateNumeroBoleto: %v", err)
+					}
+				} else if _, err := s.repo.UpdateStatus(1001, c.from, c.to, cobranca.Version); err != nil {
+					t.Fatalf("UpdateStatus: %v", err)
+				}
+
+				//cobranca ainda possui o status e a versão lidos antes da gravação: a transição é validada novamente a partir do status atual
+				if err := s.TransitionStatus(cobranca, models.COBRANCA_STATUS_PAGA, "teste", webhookOutbox(t, 1001)...); !errors.Is(err, c.err) {
+					t.Fatalf("TransitionStatus = %v, esperado %v", err, c.err)
+				}
+				if status := s.status(t, 1001); status != c.status {
+					t.Fatalf("status = %q, esperado %q", status, c.status)
+				}
+				if len(s.repo.Outbox())+len(s.outbox.mensagens) != 1 {
+					t.Fatalf("outbox da transação = %d, outbox avulso = %d, esperada uma mensagem", len(s.repo.Outbox()), len(s.outbox.mensagens))
+				}
+			})
+		}
+	})
+
+	t.Run("outbox indisponível publica na fila", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		s.outbox.err = errors.New("outbox indisponível")
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_PAGA)
+
+		s.TransitionStatus(cobranca, models.COBRANCA_STATUS_CANCELADA, "teste", webhookOutbox(t, 1001)...)
+		if s.queue.count(config.WEBHOOK_QUEUE) != 1 {
+			t.Fatalf("mensagens na fila de webhook = %d, esperada uma", s.queue.count(config.WEBHOOK_QUEUE))
+		}
+	})
+}
+
+func TestRegistrarCobranca(t *testing.T) {
+	var cases = []struct {
+		name   string
+		status []string
+		want   string
+	}{
+		{"geração concluída", []string{models.COBRANCA_STATUS_GERANDO}, models.COBRANCA_STATUS_REGISTRADA},
+		{"transição não permitida grava apenas o código", nil, models.COBRANCA_STATUS_PENDENTE},
+		{"boleto já emitido", []string{models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO}, models.COBRANCA_STATUS_BOLETO_EMITIDO},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestCobrancaService(t)
+			s.seedParcela(t, 1001, c.status...)
+
+			s.registrarCobranca(1001, "LIQ-1")
+
+			cobranca, err := s.repo.FindByIdPropostaParcela(1001)
+			if err != nil {
+				t.Fatalf("FindByIdPropostaParcela: %v", err)
+			}
+			if cobranca.CodigoLiquidacao != "LIQ-1" || cobranca.Status != c.want {
+				t.Fatalf("cobrança = %+v, esperado código LIQ-1 e status %q", cobranca, c.want)
+			}
+		})
+	}
+
+	t.Run("webhook gravado no outbox com o registro", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO)
+
+		s.registrarCobranca(1001, "LIQ-1", webhookOutbox(t, 1001)...)
+
+		if len(s.repo.Outbox()) != 1 || len(s.outbox.mensagens) != 0 || s.queue.count(config.WEBHOOK_QUEUE) != 0 {
+			t.Fatalf("outbox da transação = %d, outbox avulso = %d, fila = %d", len(s.repo.Outbox()), len(s.outbox.mensagens), s.queue.count(config.WEBHOOK_QUEUE))
+		}
+	})
+
+	t.Run("parcela gravada entre a leitura e o registro", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO)
+		s.updateService.parcelaRepository = &racingParcelaRepo{MemoryParcelaRepo: s.repo}
+
+		s.registrarCobranca(1001, "LIQ-1", webhookOutbox(t, 1001)...)
+
+		cobranca, err := s.repo.FindByIdPropostaParcela(1001)
+		if err != nil || cobranca.CodigoLiquidacao != "LIQ-1" || cobranca.Status != models.COBRANCA_STATUS_REGISTRADA || cobranca.NumeroBoleto != 123 {
+			t.Fatalf("cobrança = %+v, %v", cobranca, err)
+		}
+		if len(s.repo.Outbox()) != 1 || len(s.outbox.mensagens) != 0 {
+			t.Fatalf("outbox da transação = %d, outbox avulso = %d", len(s.repo.Outbox()), len(s.outbox.mensagens))
+		}
+	})
+}
+
+// Repositório em memória cuja gravação do registro da cobrança falha enquanto err estiver definido.
+type unavailableParcelaRepo struct {
+	*repository.MemoryParcelaRepo
+	noConn bool
+	err    error
+}
+
+func (r *unavailableParcelaRepo) UpdateRegistroCobranca(idPropostaParcela int, codigoLiquidacao string, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error) {
+	if r.err != nil {
+		return r.noConn, r.err
+	}
+	return r.MemoryParcelaRepo.UpdateRegistroCobranca(idPropostaParcela, codigoLiquidacao, from, to, version, outbox...)
+}
+
+func TestRegistrarCobrancaSemConexao(t *testing.T) {
+	setUpdateQueues(t)
+	s := newTestCobrancaService(t)
+	s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO)
+	repo := &unavailableParcelaRepo{MemoryParcelaRepo: s.repo, noConn: true, err: errors.New("connection refused")}
+	s.updateService.parcelaRepository = repo
+
+	s.registrarCobranca(1001, "LIQ-1", webhookOutbox(t, 1001)...)
+
+	//As mensagens seguem com a atualização para a fila de atualização, sem serem gravadas sozinhas
+	if s.queue.count(config.DB_QUEUE) != 1 || len(s.outbox.mensagens) != 0 || len(s.repo.Outbox()) != 0 || s.queue.count(config.WEBHOOK_QUEUE) != 0 {
+		t.Fatalf("fila de atualização = %d, outbox avulso = %d, outbox da transação = %d", s.queue.count(config.DB_QUEUE), len(s.outbox.mensagens), len(s.repo.Outbox()))
+	}
+
+	//A atualização é serializada na fila e gravada inteira quando a conexão é restabelecida
+	body, err := json.Marshal(s.queue.produced[config.DB_QUEUE][0])
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var data models.UpdateDbData
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	repo.err = nil
+	if _, err := s.updateService.UpdateAssync(data); err != nil {
+		t.Fatalf("UpdateAssync: %v", err)
+	}
+	cobranca, err := s.repo.FindByIdPropostaParcela(1001)
+	if err != nil || cobranca.CodigoLiquidacao != "LIQ-1" || cobranca.Status != models.COBRANCA_STATUS_REGISTRADA {
+		t.Fatalf("cobrança = %+v, %v", cobranca, err)
+	}
+	if len(s.repo.Outbox()) != 1 || len(s.outbox.mensagens) != 0 {
+		t.Fatalf("outbox da transação = %d, outbox avulso = %d", len(s.repo.Outbox()), len(s.outbox.mensagens))
+	}
+}
+
+func TestRegistrarCobrancaFalhaComBaseDisponivel(t *testing.T) {
+	setUpdateQueues(t)
+	s := newTestCobrancaService(t)
+	s.updateService.SetOutbox(s.outbox)
+	s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO)
+	s.updateService.parcelaRepository = &unavailableParcelaRepo{MemoryParcelaRepo: s.repo, err: errors.New("violação de constraint")}
+
+	s.registrarCobranca(1001, "LIQ-1", webhookOutbox(t, 1001)...)
+
+	//O webhook é gravado sozinho e a atualização, sem as mensagens, segue para a DLQ pelo outbox
+	if len(s.outbox.mensagens) != 2 || s.queue.count(config.DB_QUEUE) != 0 {
+		t.Fatalf("outbox avulso = %d, fila de atualização = %d", len(s.outbox.mensagens), s.queue.count(config.DB_QUEUE))
+	}
+	dlq := s.outbox.mensagens[1]
+	var dlqData struct {
+		Payload models.UpdateDbData `json:"payload"`
+	}
+	if err := json.Unmarshal(dlq.Payload, &dlqData); err != nil {
+		t.Fatalf("payload da DLQ: %v", err)
+	}
+	if dlq.Fila != config.DLQ_QUEUE || dlqData.Payload.CodigoLiquidacao != "LIQ-1" || len(dlqData.Payload.Outbox) != 0 {
+		t.Fatalf("mensagem da DLQ = %+v, payload %+v", dlq, dlqData.Payload)
+	}
+}
+
+// Repositório em memória em que outra operação grava a parcela logo após a primeira leitura do status.
+type racingParcelaRepo struct {
+	*repository.MemoryParcelaRepo
+	raced bool
+}
+
+func (r *racingParcelaRepo) FindStatus(idPropostaParcela int) (string, int64, error) {
+	status, version, err := r.MemoryParcelaRepo.FindStatus(idPropostaParcela)
+	if !r.raced {
+		r.raced = true
+		r.MemoryParcelaRepo.UpdateNumeroBoleto(idPropostaParcela, 123, 0)
+	}
+	return status, version, err
+}
+
+func TestConsultarBoletoGravaPagamento(t *testing.T) {
+	s := newTestCobrancaService(t)
+	cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+	s.client.boleto = models.BoletoConsultado{Boletos: []models.Boleto{{
+		NumeroBoleto:   123,
+		LinhaDigitavel: "00190000090",
+		Impressao:      "https://bmp/boleto/123",
+		DtVencimento:   "2025-01-10",
+	}}}
+
+	payload := models.NewCobrancaTastkData(1, config.STATUS_CONSULTAR_COBRANCA, cobranca.IdProposta, cobranca.NumeroAcompanhamento, models.AuthPayload{})
+	payload.Token = "token"
+	payload.CobrancaDBInfo = cobranca
+	payload.NumeroBoleto = 123
+	if _, _, _, err := s.ConsultarBoleto(payload, false); err != nil {
+		t.Fatalf("ConsultarBoleto: %v", err)
+	}
+
+	filter := models.CobrancaFilter{IdProposta: cobranca.IdProposta, IncluirPagamento: true}
+	if err := filter.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	page, err := s.SearchCobrancas(filter)
+	if err != nil {
+		t.Fatalf("SearchCobrancas: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Pagamento == nil {
+		t.Fatalf("SearchCobrancas = %+v, esperados os dados de pagamento", page)
+	}
+	if boletos := page.Items[0].Pagamento.Boletos; len(boletos) != 1 || boletos[0].UrlImpressao != "https://bmp/boleto/123" {
+		t.Fatalf("boletos = %+v", boletos)
+	}
+}
+
+func TestConsultarBoletoGravaWebhookNoOutbox(t *testing.T) {
+	s := newTestCobrancaService(t)
+	cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+	s.client.boleto = models.BoletoConsultado{Boletos: []models.Boleto{{NumeroBoleto: 123, Impressao: "https://bmp/boleto/123"}}}
+
+	payload := models.NewCobrancaTastkData(1, config.STATUS_CONSULTAR_COBRANCA, cobranca.IdProposta, cobranca.NumeroAcompanhamento, models.AuthPayload{})
+	payload.Token = "token"
+	payload.CobrancaDBInfo = cobranca
+	payload.NumeroBoleto = 123
+	payload.WebhookUrl = "https://cliente/webhook"
+	payload.CalledAssync = true
+	if _, _, _, err := s.ConsultarBoleto(payload, true); err != nil {
+		t.Fatalf("ConsultarBoleto: %v", err)
+	}
+
+	//O webhook cobranca.registrada é gravado com os dados de pagamento, e não publicado diretamente na fila
+	outbox := s.repo.Outbox()
+	if len(outbox) != 1 || outbox[0].Fila != config.WEBHOOK_QUEUE || s.queue.count(config.WEBHOOK_QUEUE) != 0 {
+		t.Fatalf("outbox = %+v, mensagens na fila de webhook = %d", outbox, s.queue.count(config.WEBHOOK_QUEUE))
+	}
+	var task models.WebhookTaskData
+	if err := json.Unmarshal(outbox[0].Payload, &task); err != nil || task.Url != "https://cliente/webhook" || task.Context != "consulta-boleto" {
+		t.Fatalf("webhook = %+v, %v", task, err)
+	}
+}
+
+func TestSearchCobrancasPaginacao(t *testing.T) {
+	s := newTestCobrancaService(t)
+	for id := 1001; id <= 1005; id++ {
+		s.seedParcela(t, id)
+	}
+
+	filter := models.CobrancaFilter{Sort: models.COBRANCA_SORT_ID_PROPOSTA_PARCELA, Order: "asc", Limit: 2}
+	var ids []int
+	for {
+		if err := filter.Validate(); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		page, err := s.SearchCobrancas(filter)
+		if err != nil {
+			t.Fatalf("SearchCobrancas: %v", err)
+		}
+		if len(page.Items) > 2 {
+			t.Fatalf("página com %d cobranças, limite 2", len(page.Items))
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item.IdPropostaParcela)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	if len(ids) != 5 || ids[0] != 1001 || ids[4] != 1005 {
+		t.Fatalf("cobranças listadas = %v", ids)
+	}
+
+	//O cursor só é aceito com a ordenação em que foi gerado
+	filter.Order = "desc"
+	if err := filter.Validate(); err == nil {
+		t.Fatal("cursor aceito com outra ordenação")
+	}
+}
+
+func cancelamentoPayload(cobranca models.CobrancaBMP) *models.CobrancaTaskData {
+	payload := models.NewCobrancaTastkData(1, config.STATUS_CANCELAR_COBRANCA, cobranca.IdProposta, cobranca.NumeroAcompanhamento, models.AuthPayload{})
+	payload.Token = "token"
+	payload.IdPropostaParcela = cobranca.IdPropostaParcela
+	payload.CancelamentoData = models.CancelarCobrancaFrontendInput{IdPropostaParcela: cobranca.IdPropostaParcela, IdProposta: cobranca.IdProposta, NumeroCCB: cobranca.NumeroCCB}
+	payload.CancelamentoCobranca.DTOCancelarCobrancas.CodigosLiquidacoes = []string{"LIQ-1"}
+	return payload
+}
+
+func TestLockParcela(t *testing.T) {
+	t.Run("operação executada com o lock da parcela", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		locker := &fakeLocker{}
+		s.SetLocker(locker)
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+
+		if _, _, _, err := s.Cobranca(cancelamentoPayload(cobranca)); err != nil {
+			t.Fatalf("Cobranca: %v", err)
+		}
+		if got := s.status(t, 1001); got != models.COBRANCA_STATUS_CANCELADA {
+			t.Fatalf("status = %q", got)
+		}
+		if locker.acquired != 1 || len(locker.held) != 0 {
+			t.Fatalf("lock obtido %d vezes, %d não liberados", locker.acquired, len(locker.held))
+		}
+	})
+
+	t.Run("operação síncrona rejeitada com a parcela ocupada", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		locker := &fakeLocker{held: map[string]string{parcelaLockKey(1001): "outra"}}
+		s.SetLocker(locker)
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+
+		_, _, statusCode, err := s.Cobranca(cancelamentoPayload(cobranca))
+		if err == nil || statusCode != 409 {
+			t.Fatalf("Cobranca = %d, %v, esperado 409", statusCode, err)
+		}
+		if got := s.status(t, 1001); got != models.COBRANCA_STATUS_BOLETO_EMITIDO {
+			t.Fatalf("status alterado com a parcela ocupada: %q", got)
+		}
+		if locker.held[parcelaLockKey(1001)] != "outra" {
+			t.Fatal("lock de outra operação liberado")
+		}
+	})
+
+	t.Run("operação assíncrona reagendada com a parcela ocupada", func(t *testing.T) {
+		s := newTestCobrancaService(t)
+		s.SetLocker(&fakeLocker{held: map[string]string{parcelaLockKey(1001): "outra"}})
+		cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+
+		payload := cancelamentoPayload(cobranca)
+		payload.CalledAssync = true
+		if _, _, _, err := s.Cobranca(payload); err != nil {
+			t.Fatalf("Cobranca: %v", err)
+		}
+		if !payload.Reagendada || s.queue.count(config.COBRANCA_QUEUE) != 1 {
+			t.Fatalf("operação não reagendada: reagendada=%v, fila=%d", payload.Reagendada, s.queue.count(config.COBRANCA_QUEUE))
+		}
+		if got := s.status(t, 1001); got != models.COBRANCA_STATUS_BOLETO_EMITIDO {
+			t.Fatalf("status alterado com a parcela ocupada: %q", got)
+		}
+	})
+}
+
+func TestUpdateVersaoConcorrente(t *testing.T) {
+	s := newTestCobrancaService(t)
+	cobranca := s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO)
+	s.registrarCobranca(1001, "LIQ-1")
+
+	//Leitura anterior ao registro da cobrança: as gravações são descartadas sem ir para a DLQ
+	if _, err := s.UpdateCodLiquidacao(1001, "", cobranca.Version); !errors.Is(err, models.ErrVersaoConcorrente) {
+		t.Fatalf("UpdateCodLiquidacao: %v", err)
+	}
+	if _, err := s.updateService.UpdateAssync(models.UpdateDbData{IdPropostaParcela: 1001, NumeroBoleto: 9, Version: cobranca.Version, Action: "update_numero_boleto"}); err != nil {
+		t.Fatalf("UpdateAssync: %v", err)
+	}
+	if n := s.queue.count(config.DLQ_QUEUE); n != 0 {
+		t.Fatalf("mensagens na DLQ = %d", n)
+	}
+	atual, err := s.repo.FindByIdPropostaParcela(1001)
+	if err != nil || atual.CodigoLiquidacao != "LIQ-1" || atual.NumeroBoleto != 0 {
+		t.Fatalf("cobrança = %+v, %v", atual, err)
+	}
+
+	if _, err := s.UpdateNumeroBoleto(1001, 123, atual.Version); err != nil {
+		t.Fatalf("UpdateNumeroBoleto com a versão atual: %v", err)
+	}
+}
+
+// Repositório de parcelas cuja gravação do código de liquidação falha, com ou sem conexão com a base de dados.
+type failingParcelaRepo struct {
+	ParcelaRepository
+	noConn bool
+}
+
+func (r failingParcelaRepo) UpdateCodLiquidacao(idPropostaParcela int, codigoLiquidacao string, version int64) (bool, error) {
+	return r.noConn, errors.New("falha na gravação")
+}
+
+// setUpdateQueues define nomes distintos para a fila de atualização e a DLQ, lidos das variáveis de ambiente fora dos testes.
+func setUpdateQueues(t *testing.T) {
+	t.Helper()
+	oldDB, oldDLQ := config.DB_QUEUE, config.DLQ_QUEUE
+	config.DB_QUEUE, config.DLQ_QUEUE = "bmp.db", "bmp.dlq"
+	t.Cleanup(func() { config.DB_QUEUE, config.DLQ_QUEUE = oldDB, oldDLQ })
+}
+
+func TestUpdateFalhaEncaminhada(t *testing.T) {
+	setUpdateQueues(t)
+
+	var cases = []struct {
+		name         string
+		noConn       bool
+		calledAssync bool
+		outboxErr    error
+		outbox       int
+		dlq          int
+		db           int
+	}{
+		{"base disponível", false, false, nil, 1, 0, 0},
+		{"base disponível chamada pela fila", false, true, nil, 1, 0, 0},
+		{"outbox indisponível", false, false, errors.New("outbox"), 0, 1, 0},
+		{"sem conexão", true, false, nil, 0, 0, 1},
+		{"sem conexão chamada pela fila", true, true, nil, 0, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			queue := &fakeQueue{}
+			outbox := &fakeOutbox{err: c.outboxErr}
+			u := NewUpdateService(slog.New(slog.NewTextHandler(io.Discard, nil)), time.UTC, failingParcelaRepo{noConn: c.noConn})
+			u.SetProducer(queue)
+			u.SetOutbox(outbox)
+
+			data := models.UpdateDbData{IdPropostaParcela: 1001, CodigoLiquidacao: "LIQ-1", Action: "update_codigo_liquidacao"}
+			if noConn, err := u.UpdateCodLiquidacao(data, c.calledAssync); err == nil || noConn != c.noConn {
+				t.Fatalf("UpdateCodLiquidacao = %v, %v", noConn, err)
+			}
+			if len(outbox.mensagens) != c.outbox || queue.count(config.DLQ_QUEUE) != c.dlq || queue.count(config.DB_QUEUE) != c.db {
+				t.Fatalf("outbox = %d, dlq = %d, fila de atualização = %d", len(outbox.mensagens), queue.count(config.DLQ_QUEUE), queue.count(config.DB_QUEUE))
+			}
+			if c.outbox == 0 {
+				return
+			}
+
+			//A mensagem da DLQ leva a atualização, para que possa ser reprocessada
+			mensagem := outbox.mensagens[0]
+			var dlqData struct {
+				Payload models.UpdateDbData `json:"payload"`
+			}
+			if err := json.Unmarshal(mensagem.Payload, &dlqData); err != nil {
+				t.Fatalf("payload do outbox: %v", err)
+			}
+			if mensagem.Fila != config.DLQ_QUEUE || mensagem.IdPropostaParcela != 1001 || dlqData.Payload.CodigoLiquidacao != "LIQ-1" {
+				t.Fatalf("mensagem do outbox = %+v, payload %+v", mensagem, dlqData.Payload)
+			}
+		})
+	}
+}
+
+// Repositório da reconciliação em memória.
+type fakeReconciliacaoRepo struct {
+	ReconciliacaoRepository
+	reconciliacao models.Reconciliacao
+	divergencias  []models.ReconciliacaoDivergencia
+}
+
+func (r *fakeReconciliacaoRepo) Insert(data models.Reconciliacao) (int64, error) {
+	r.reconciliacao = data
+	r.reconciliacao.Id = 1
+	return 1, nil
+}
+
+func (r *fakeReconciliacaoRepo) Update(data models.Reconciliacao) error {
+	r.reconciliacao = data
+	return nil
+}
+
+func (r *fakeReconciliacaoRepo) InsertDivergencia(data models.ReconciliacaoDivergencia) (bool, error) {
+	r.divergencias = append(r.divergencias, data)
+	return false, nil
+}
+
+func TestReconciliacao(t *testing.T) {
+	batchSize, rateLimit, corrigir := config.RECONCILIACAO_BATCH_SIZE, config.RECONCILIACAO_RATE_LIMIT, config.RECONCILIACAO_CORRIGIR
+	config.RECONCILIACAO_BATCH_SIZE, config.RECONCILIACAO_RATE_LIMIT, config.RECONCILIACAO_CORRIGIR = 2, 1000, true
+	t.Cleanup(func() {
+		config.RECONCILIACAO_BATCH_SIZE, config.RECONCILIACAO_RATE_LIMIT, config.RECONCILIACAO_CORRIGIR = batchSize, rateLimit, corrigir
+	})
+
+	s := newTestCobrancaService(t)
+	s.seedParcela(t, 1001, models.COBRANCA_STATUS_GERANDO)
+	s.seedParcela(t, 1002, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO)
+	s.seedParcela(t, 1003, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA)
+	s.seedParcela(t, 1004, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_REGISTRADA)
+	s.seedParcela(t, 1005, models.COBRANCA_STATUS_GERANDO, models.COBRANCA_STATUS_BOLETO_EMITIDO, models.COBRANCA_STATUS_PAGA)
+
+	//Parcela 1: boleto emitido sem o evento de geração. Parcela 2: paga sem o evento de lançamento.
+	//Parcela 3: sem boleto no BMP. Parcela 4: não retornada.
+	s.client.consulta = models.ConsultaCobrancaResponse{Parcelas: []models.ConsultaCobrancaParcela{
+		{NroParcela: 1, VlrSaldoAtual: 100, Boletos: []models.ConsultaBoleto{{NumeroBoleto: 77, UrlImpressao: "https://bmp/boleto/77"}}},
+		{NroParcela: 2, VlrTotalPago: 100, Lancamentos: []models.ConsultaCobrancaLancamento{{DtLancamento: "2025-01-09", VlrPagamento: 100}}},
+		{NroParcela: 3, VlrSaldoAtual: 100},
+	}}
+
+	repo := &fakeReconciliacaoRepo{}
+	reconciliacao := NewReconciliacaoService(context.Background(), s.logger, time.UTC, s.CobrancalService, repo)
+	reconciliacao.executar(models.Reconciliacao{Id: 1, Status: models.RECONCILIACAO_STATUS_EXECUTANDO})
+
+	got := repo.reconciliacao
+	if got.Status != models.RECONCILIACAO_STATUS_CONCLUIDA || got.ParcelasVerificadas != 4 || got.Divergencias != 4 || got.Corrigidas != 2 || got.FinalizadaEm == nil {
+		t.Fatalf("reconciliação = %+v", got)
+	}
+
+	var tipos = make(map[int]models.ReconciliacaoDivergencia)
+	for _, divergencia := range repo.divergencias {
+		tipos[divergencia.IdPropostaParcela] = divergencia
+	}
+	for id, tipo := range map[int]string{
+		1001: models.DIVERGENCIA_BOLETO,
+		1002: models.DIVERGENCIA_PAGAMENTO,
+		1003: models.DIVERGENCIA_COBRANCA_AUSENTE,
+		1004: models.DIVERGENCIA_PARCELA_AUSENTE,
+	} {
+		if tipos[id].Tipo != tipo || tipos[id].IdReconciliacao != 1 {
+			t.Fatalf("divergência da parcela %d = %+v, esperado %s", id, tipos[id], tipo)
+		}
+	}
+
+	cobranca, err := s.repo.FindByIdPropostaParcela(1001)
+	if err != nil || cobranca.Status != models.COBRANCA_STATUS_BOLETO_EMITIDO || cobranca.NumeroBoleto != 77 {
+		t.Fatalf("parcela 1001 = %+v, %v", cobranca, err)
+	}
+	if got := s.status(t, 1002); got != models.COBRANCA_STATUS_PAGA {
+		t.Fatalf("status da parcela 1002 = %q", got)
+	}
+	if got := s.status(t, 1003); got != models.COBRANCA_STATUS_REGISTRADA {
+		t.Fatalf("status da parcela 1003 = %q", got)
+	}
+
+	//Webhooks cobranca.registrada e parcela.lancamento gravados com as alterações de status
+	if n := len(s.repo.Outbox()); n != 2 {
+		t.Fatalf("mensagens no outbox = %d", n)
+	}
+
+	//Uma nova execução não encontra divergências nas parcelas corrigidas
+	repo.divergencias = nil
+	reconciliacao.executar(models.Reconciliacao{Id: 2, Status: models.RECONCILIACAO_STATUS_EXECUTANDO})
+	if repo.reconciliacao.Divergencias != 2 || repo.reconciliacao.Corrigidas != 0 {
+		t.Fatalf("segunda execução = %+v", repo.reconciliacao)
+	}
+}
+
+func TestIniciarReconciliacaoEmAndamento(t *testing.T) {
+	s := newTestCobrancaService(t)
+	locker := &fakeLocker{}
+	s.SetLocker(locker)
+	locker.Lock(reconciliacaoLockKey, time.Minute, 0)
+
+	reconciliacao := NewReconciliacaoService(context.Background(), s.logger, time.UTC, s.CobrancalService, &fakeReconciliacaoRepo{})
+	if _, err := reconciliacao.Iniciar(models.RECONCILIACAO_ORIGEM_MANUAL); !errors.Is(err, models.ErrReconciliacaoEmAndamento) {
+		t.Fatalf("Iniciar com o lock ocupado: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// ErrTransicaoStatus é retornado quando a operação ou o evento levaria a cobrança a um status não permitido a partir do atual.
+var ErrTransicaoStatus = errors.New("transição de status da cobrança não permitida")
+
+// Transições permitidas a partir de cada status. Repetir o status atual é sempre permitido(eventos reentregues pelo BMP).
+var cobrancaStatusTransitions = map[string][]string{
+	models.COBRANCA_STATUS_PENDENTE: {
+		models.COBRANCA_STATUS_GERANDO,
+		models.COBRANCA_STATUS_FALHA,
+	},
+	models.COBRANCA_STATUS_GERANDO: {
+		models.COBRANCA_STATUS_REGISTRADA,
+		models.COBRANCA_STATUS_BOLETO_EMITIDO,
+		models.COBRANCA_STATUS_CANCELADA,
+		models.COBRANCA_STATUS_FALHA,
+	},
+	models.COBRANCA_STATUS_REGISTRADA: {
+		models.COBRANCA_STATUS_BOLETO_EMITIDO,
+		models.COBRANCA_STATUS_PAGA_PARCIAL,
+		models.COBRANCA_STATUS_PAGA,
+		models.COBRANCA_STATUS_CANCELADA,
+	},
+	models.COBRANCA_STATUS_BOLETO_EMITIDO: {
+		models.COBRANCA_STATUS_PAGA_PARCIAL,
+		models.COBRANCA_STATUS_PAGA,
+		models.COBRANCA_STATUS_CANCELADA,
+	},
+	models.COBRANCA_STATUS_PAGA_PARCIAL: {
+		models.COBRANCA_STATUS_PAGA,
+		models.COBRANCA_STATUS_CANCELADA,
+	},
+	models.COBRANCA_STATUS_PAGA: {},
+	models.COBRANCA_STATUS_CANCELADA: {
+		models.COBRANCA_STATUS_GERANDO,
+	},
+	models.COBRANCA_STATUS_FALHA: {
+		models.COBRANCA_STATUS_GERANDO,
+		models.COBRANCA_STATUS_REGISTRADA,
+		models.COBRANCA_STATUS_BOLETO_EMITIDO,
+	},
+}
+
+// Status de destino de cada operação solicitada pela API. Operações sem destino não alteram o status.
+var operacaoStatus = map[string]string{
+	config.STATUS_GERAR_COBRANCA:    models.COBRANCA_STATUS_GERANDO,
+	config.STATUS_CANCELAR_COBRANCA: models.COBRANCA_STATUS_CANCELADA,
+}
+
+// validateStatusTransition verifica se a cobrança pode passar de "from" para "to". Status vazio(parcela não gravada) equivale a pendente.
+func validateStatusTransition(from, to string) error {
+	if from == "" {
+		from = models.COBRANCA_STATUS_PENDENTE
+	}
+	if from == to || slices.Contains(cobrancaStatusTransitions[from], to) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrTransicaoStatus, from, to)
+}
+
+// statusAtivo informa se o status corresponde a uma cobrança vigente no BMP. Nesse caso a geração é convertida em consulta.
+func statusAtivo(status string) bool {
+	switch status {
+	case models.COBRANCA_STATUS_REGISTRADA, models.COBRANCA_STATUS_BOLETO_EMITIDO, models.COBRANCA_STATUS_PAGA_PARCIAL:
+		return true
+	}
+	return false
+}
+
+// ValidateOperacao verifica se a operação é permitida no status atual da parcela, antes de ela ser enviada para processamento.
+// Se o status não puder ser lido, a operação segue e é validada novamente no processamento.
+func (c *CobrancalService) ValidateOperacao(idPropostaParcela int, operacao string) error {
+	to, ok := operacaoStatus[operacao]
+	if !ok {
+		return nil
+	}
+	status, _, err := c.parcelaRepository.FindStatus(idPropostaParcela)
+	if err != nil {
+		return nil
+	}
+	if operacao == config.STATUS_GERAR_COBRANCA && statusAtivo(status) {
+		return nil
+	}
+	return validateStatusTransition(status, to)
+}
+
+// Número de vezes que a gravação condicionada à versão é repetida quando outra operação grava a parcela entre a leitura e a gravação.
+const tentativasVersao = 3
+
+// TransitionStatus altera o status da cobrança, validando a transição a partir do status atual. "origem" identifica a operação
+// ou o evento do BMP que motivou a alteração e é registrada no log.
+// O status só é gravado se a parcela ainda estiver na versão lida(cobranca.Version). Se outra operação gravou a parcela depois
+// da leitura, o status e a versão são lidos novamente e a transição é validada e repetida.
+// As mensagens do outbox são gravadas na mesma transação do status. Se o status não for alterado(status repetido, transição
+// rejeitada ou falha na gravação) as mensagens são gravadas sozinhas, pois o evento que as originou já ocorreu.
+func (c *CobrancalService) TransitionStatus(cobranca models.CobrancaBMP, to string, origem string, outbox ...models.OutboxMessage) error {
+	for tentativa := 1; ; tentativa++ {
+		from := cobranca.Status
+		if from == "" {
+			from = models.COBRANCA_STATUS_PENDENTE
+		}
+		if from == to {
+			c.SaveOutbox(outbox)
+			return nil
+		}
+
+		var logData = map[string]any{"id_proposta_parcela": cobranca.IdPropostaParcela, "de": from, "para": to, "origem": origem}
+		if err := validateStatusTransition(from, to); err != nil {
+			helpers.LogWarn(c.ctx, c.logger, c.loc, "cobranca service", "", "Transição de status rejeitada", err.Error(), logData)
+			c.SaveOutbox(outbox)
+			return err
+		}
+
+		_, err := c.parcelaRepository.UpdateStatus(cobranca.IdPropostaParcela, from, to, cobranca.Version, outbox...)
+		if err == nil {
+			helpers.LogInfo(c.ctx, c.logger, c.loc, "cobranca service", "", "Status da cobrança alterado", logData)
+			c.recordStatus(cobranca.IdPropostaParcela, from, to, origem)
+			return nil
+		}
+		if !errors.Is(err, models.ErrVersaoConcorrente) || tentativa >= tentativasVersao {
+			c.SaveOutbox(outbox)
+			return err
+		}
+		if cobranca.Status, cobranca.Version, err = c.parcelaRepository.FindStatus(cobranca.IdPropostaParcela); err != nil {
+			c.SaveOutbox(outbox)
+			return err
+		}
+	}
+}
+
+// transitionStatusById lê o status atual da parcela e aplica a transição.
+func (c *CobrancalService) transitionStatusById(idPropostaParcela int, to string, origem string, outbox ...models.OutboxMessage) error {
+	status, version, err := c.parcelaRepository.FindStatus(idPropostaParcela)
+	if err != nil {
+		c.SaveOutbox(outbox)
+		return err
+	}
+	return c.TransitionStatus(models.CobrancaBMP{IdPropostaParcela: idPropostaParcela, Status: status, Version: version}, to, origem, outbox...)
+}
+
+// registrarCobranca grava o código de liquidação retornado pelo BMP, o status registrada e as mensagens do outbox na mesma transação.
+// A gravação é feita pelo UpdateService(ver UpdateService.UpdateRegistroCobranca), que sem conexão com a base de dados envia a
+// atualização inteira, com as mensagens e a transição de status, para a fila de atualização.
+func (c *CobrancalService) registrarCobranca(idPropostaParcela int, codigoLiquidacao string, outbox ...models.OutboxMessage) {
+	c.updateService.UpdateRegistroCobranca(models.UpdateDbData{
+		IdPropostaParcela: idPropostaParcela,
+		CodigoLiquidacao:  codigoLiquidacao,
+		Outbox:            outbox,
+		Action:            "update_registro_cobranca",
+	}, false)
+}
+
+// statusError converte a transição rejeitada no erro da API retornado para a operação.
+func statusError(err error, idPropostaParcela int) models.APIError {
+	return models.NewAPIError("", err.Error(), strconv.Itoa(idPropostaParcela))
+}
+
+// fillParcelasStatus preenche o status local e o histórico de liquidações de cada parcela retornada na consulta ao BMP.
+func (c *CobrancalService) fillParcelasStatus(data *models.ConsultaCobrancaResponse) {
+	if data.NumeroProposta <= 0 || len(data.Parcelas) < 1 {
+		return
+	}
+	status, err := c.parcelaRepository.FindStatusByNumeroCCB(data.NumeroProposta)
+	if err != nil {
+		return
+	}
+	var liquidacoes = make(map[int][]models.Liquidacao)
+	if historico, err := c.parcelaRepository.FindLiquidacoes(data.NumeroProposta); err == nil {
+		for _, liquidacao := range historico {
+			liquidacoes[liquidacao.NumeroParcela] = append(liquidacoes[liquidacao.NumeroParcela], liquidacao)
+		}
+	}
+	for i := range data.Parcelas {
+		data.Parcelas[i].Status = status[data.Parcelas[i].NroParcela]
+		data.Parcelas[i].Liquidacoes = liquidacoes[data.Parcelas[i].NroParcela]
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Filas para as quais uma mensagem da DLQ pode ser reprocessada.
+const (
+	DLQ_FILA_COBRANCA = "cobranca"
+	DLQ_FILA_CONSULTA = "consulta"
+	DLQ_FILA_WEBHOOK  = "webhook"
+	DLQ_FILA_DB       = "db"
+)
+
+// Fila de origem de acordo com o contexto gravado na mensagem da DLQ.
+var dlqContextoFila = map[string]string{
+	"consumo de cobrança": DLQ_FILA_COBRANCA,
+	"producer cobrança":   DLQ_FILA_COBRANCA,
+	"consumo de consulta": DLQ_FILA_CONSULTA,
+	"producer consulta":   DLQ_FILA_CONSULTA,
+	"webhook":             DLQ_FILA_WEBHOOK,
+	"consumo de webhook":  DLQ_FILA_WEBHOOK,
+	"producer webhook":    DLQ_FILA_WEBHOOK,
+	"db":                  DLQ_FILA_DB,
+	"consumo de db":       DLQ_FILA_DB,
+	//Mensagens em quarentena(malformadas ou reentregues além do limite)
+	"quarentena de cobrança": DLQ_FILA_COBRANCA,
+	"quarentena de consulta": DLQ_FILA_CONSULTA,
+	"quarentena de webhook":  DLQ_FILA_WEBHOOK,
+	"quarentena de db":       DLQ_FILA_DB,
+}
+
+// Representa o service que persiste as mensagens da DLQ e permite reprocessá-las.
+type DLQService struct {
+	repository DLQRepository
+	queue      QueueProducer
+	logger     *slog.Logger
+	loc        *time.Location
+}
+
+func NewDLQService(repository DLQRepository, logger *slog.Logger, loc *time.Location) *DLQService {
+	return &DLQService{
+		repository: repository,
+		logger:     logger,
+		loc:        loc,
+	}
+}
+
+// Configurando o producer das filas
+func (d *DLQService) SetProducer(q any) error {
+	if q == nil {
+		return errors.New("producer não pode ser nulo")
+	}
+	queue, ok := q.(Queue)
+	if !ok {
+		return errors.New("producer deve implementar queue")
+	}
+	d.queue = queue
+	return nil
+}
+
+// Save persiste uma mensagem consumida da DLQ. Retorna true caso a falha seja de conexão com o banco de dados.
+func (d *DLQService) Save(data models.DLQData) (bool, error) {
+	payload, err := json.Marshal(data.Payload)
+	if err != nil {
+		return false, err
+	}
+
+	entry := models.DLQEntry{
+		Contexto:          data.Contexto,
+		Mensagem:          data.Mensagem,
+		Erro:              data.Erro,
+		IdPropostaParcela: models.IdPropostaParcelaFromDLQPayload(payload),
+		Payload:           payload,
+		Time:              data.Time,
+		CreatedAt:         time.Now().In(d.loc),
+	}
+	if entry.Time.IsZero() {
+		entry.Time = entry.CreatedAt
+	}
+	if len(data.Headers) > 0 {
+		if entry.Headers, err = json.Marshal(data.Headers); err != nil {
+			return false, err
+		}
+	}
+
+	return d.repository.Insert(entry)
+}
+
+// FindEntries lista as mensagens da DLQ.
+func (d *DLQService) FindEntries(filter models.DLQEntryFilter) ([]models.DLQEntry, error) {
+	return d.repository.Find(filter)
+}
+
+// FindEntry busca uma mensagem da DLQ.
+func (d *DLQService) FindEntry(id int64) (models.DLQEntry, error) {
+	return d.repository.FindById(id)
+}
+
+// FindReplays lista os reprocessamentos de uma mensagem da DLQ.
+func (d *DLQService) FindReplays(id int64) ([]models.DLQReplay, error) {
+	return d.repository.FindReplays(id)
+}
+
+// Replay republica o payload(original ou editado) de uma mensagem da DLQ na fila de origem e registra a auditoria do reprocessamento.
+// A mensagem é gravada no outbox junto com a auditoria e publicada pelo relay; o reprocessamento falha se a auditoria não for gravada.
+// As tentativas do payload são reiniciadas para que a mensagem não volte imediatamente para a DLQ.
+func (d *DLQService) Replay(id int64, input models.DLQReplayInput) (models.DLQReplay, error) {
+	entry, err := d.repository.FindById(id)
+	if err != nil {
+		return models.DLQReplay{}, err
+	}
+
+	fila := input.Fila
+	if fila == "" {
+		fila = dlqContextoFila[strings.ToLower(entry.Contexto)]
+	}
+	if fila == "" {
+		return models.DLQReplay{}, errors.New("não foi possível identificar a fila de origem pelo contexto, informe a fila")
+	}
+
+	payload := entry.Payload
+	editado := len(input.Payload) > 0
+	if editado {
+		payload = input.Payload
+	}
+	if len(payload) == 0 || string(payload) == "null" {
+		return models.DLQReplay{}, errors.New("mensagem sem payload registrado")
+	}
+
+	var queue string
+	var data any
+	now := time.Now().In(d.loc)
+
+	switch fila {
+	case DLQ_FILA_COBRANCA, DLQ_FILA_CONSULTA:
+		var taskData models.CobrancaTaskData
+		if err := json.Unmarshal(payload, &taskData); err != nil {
+			return models.DLQReplay{}, errors.New("payload de cobrança inválido: " + err.Error())
+		}
+		taskData.Reset()
+		taskData.CurrentDelay = 0
+		queue = config.COBRANCA_QUEUE
+		if fila == DLQ_FILA_CONSULTA {
+			taskData.ConsultaRetries = 0
+			queue = config.CONSULTA_QUEUE
+		}
+		data = &taskData
+
+	case DLQ_FILA_WEBHOOK:
+		var taskData models.WebhookTaskData
+		if err := json.Unmarshal(payload, &taskData); err != nil {
+			return models.DLQReplay{}, errors.New("payload de webhook inválido: " + err.Error())
+		}
+		if taskData.Url == "" {
+			return models.DLQReplay{}, errors.New("payload de webhook sem url")
+		}
+		taskData.Retries = 0
+		taskData.Delay = 0
+		taskData.CreatedAt = now
+		queue = config.WEBHOOK_QUEUE
+		data = taskData
+
+	case DLQ_FILA_DB:
+		var updateData models.UpdateDbData
+		if err := json.Unmarshal(payload, &updateData); err != nil {
+			return models.DLQReplay{}, errors.New("payload de atualização inválido: " + err.Error())
+		}
+		if updateData.Action == "" {
+			return models.DLQReplay{}, errors.New("payload de atualização sem action")
+		}
+		queue = config.DB_QUEUE
+		data = updateData
+
+	default:
+		return models.DLQReplay{}, errors.New("fila inválida")
+	}
+
+	mensagem, err := models.NewOutboxMessage(queue, data, 0, entry.IdPropostaParcela)
+	if err != nil {
+		return models.DLQReplay{}, err
+	}
+
+	replay := models.DLQReplay{
+		IdDLQ:     entry.Id,
+		Fila:      fila,
+		Usuario:   input.Usuario,
+		Editado:   editado,
+		Payload:   payload,
+		CreatedAt: now,
+	}
+
+	//A auditoria e a mensagem são gravadas na mesma transação: sem auditoria a mensagem não é republicada
+	replay.Id, err = d.repository.InsertReplay(replay, mensagem)
+	if err != nil {
+		return models.DLQReplay{}, err
+	}
+
+	helpers.LogInfo(context.Background(), d.logger, d.loc, "dlq service", "", "Mensagem da DLQ reprocessada", replay)
+	return replay, nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Representa o service que grava e consulta o histórico das parcelas.
+type HistoricoService struct {
+	repository HistoricoRepository
+	logger     *slog.Logger
+	loc        *time.Location
+}
+
+func NewHistoricoService(repository HistoricoRepository, logger *slog.Logger, loc *time.Location) *HistoricoService {
+	return &HistoricoService{
+		repository: repository,
+		logger:     logger,
+		loc:        loc,
+	}
+}
+
+// Record grava um registro no histórico da parcela com o payload serializado.
+// O histórico não interrompe a operação registrada: falhas ao gravar são apenas registradas no log.
+func (h *HistoricoService) Record(entry models.CobrancaHistorico, payload any) {
+	if h == nil || entry.IdPropostaParcela <= 0 {
+		return
+	}
+
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			helpers.LogError(context.Background(), h.logger, h.loc, "historico service", "", "Erro ao serializar payload do histórico", err.Error(), entry)
+		} else {
+			entry.Payload = payloadBytes
+		}
+	}
+	if entry.Ator == "" {
+		entry.Ator = models.HISTORICO_ATOR_SISTEMA
+	}
+	entry.CreatedAt = time.Now().In(h.loc)
+
+	if _, err := h.repository.Insert(entry); err != nil {
+		helpers.LogError(context.Background(), h.logger, h.loc, "historico service", "", "Erro ao gravar histórico da parcela", err.Error(), entry)
+	}
+}
+
+// FindHistorico retorna o histórico da parcela em ordem cronológica.
+func (h *HistoricoService) FindHistorico(idPropostaParcela int) ([]models.CobrancaHistorico, error) {
+	return h.repository.FindByIdPropostaParcela(idPropostaParcela)
+}
+
+// Configura o histórico em que as operações e as chamadas ao BMP serão registradas.
+func (c *CobrancalService) SetHistorico(historico *HistoricoService) {
+	c.historico = historico
+}
+
+// RecordHistorico grava um registro no histórico da parcela.
+func (c *CobrancalService) RecordHistorico(entry models.CobrancaHistorico, payload any) {
+	c.historico.Record(entry, payload)
+}
+
+// RecordOperacao registra a solicitação de uma operação com os dados recebidos, sem os dados de autenticação.
+func (c *CobrancalService) RecordOperacao(payload *models.CobrancaTaskData) {
+	c.historico.Record(models.CobrancaHistorico{
+		IdPropostaParcela: payload.IdPropostaParcela,
+		Tipo:              models.HISTORICO_OPERACAO,
+		Ator:              atorHistorico(payload),
+		Operacao:          payload.Status,
+		IdempotencyKey:    payload.IdempotencyKey,
+		CorrelationId:     payload.CorrelationId,
+		Mensagem:          c.operations[payload.Status],
+	}, operacaoSnapshot(payload))
+}
+
+// recordChamadaBMP registra o resultado de uma chamada à API do BMP.
+func (c *CobrancalService) recordChamadaBMP(payload *models.CobrancaTaskData, request any, statusCode int, err error) {
+	var entry = models.CobrancaHistorico{
+		IdPropostaParcela: payload.IdPropostaParcela,
+		Tipo:              models.HISTORICO_CHAMADA_BMP,
+		Ator:              atorHistorico(payload),
+		Operacao:          payload.Status,
+		IdempotencyKey:    payload.IdempotencyKey,
+		CorrelationId:     payload.CorrelationId,
+		StatusCode:        statusCode,
+		Mensagem:          "Chamada ao BMP realizada",
+	}
+	if err != nil {
+		entry.Mensagem = err.Error()
+	} else if entry.StatusCode == 0 {
+		entry.StatusCode = 200
+	}
+	c.historico.Record(entry, request)
+}
+
+// recordStatus registra a alteração de status gravada na parcela.
+func (c *CobrancalService) recordStatus(idPropostaParcela int, from, to, origem string) {
+	c.historico.recordStatus(idPropostaParcela, from, to, origem)
+}
+
+// recordStatus registra a alteração de status gravada em bmp_cobrancas.
+func (h *HistoricoService) recordStatus(idPropostaParcela int, from, to, origem string) {
+	h.Record(models.CobrancaHistorico{
+		IdPropostaParcela: idPropostaParcela,
+		Tipo:              models.HISTORICO_DB,
+		Operacao:          origem,
+		Mensagem:          fmt.Sprintf("Status alterado de %s para %s", from, to),
+	}, map[string]string{"de": from, "para": to})
+}
+
+// atorHistorico identifica quem originou a operação: reentregas são do scheduler e as demais são de quem a solicitou.
+func atorHistorico(payload *models.CobrancaTaskData) string {
+	if payload.Attempt > 0 {
+		return models.HISTORICO_ATOR_SCHEDULER
+	}
+	if payload.Origem != "" {
+		return payload.Origem
+	}
+	return models.HISTORICO_ATOR_API
+}
+
+// operacaoSnapshot retorna os dados da operação recebidos na solicitação.
+func operacaoSnapshot(payload *models.CobrancaTaskData) any {
+	switch payload.Status {
+	case config.STATUS_GERAR_COBRANCA:
+		return payload.GerarCobrancaInput
+	case config.STATUS_CANCELAR_COBRANCA:
+		return payload.CancelamentoData
+	case config.STATUS_LANCAMENTO_PARCELA:
+		return payload.LancamentoParcela
+	case config.STATUS_CONSULTAR_COBRANCA:
+		return payload.ConsultarCobrancaInput
+	}
+	return nil
+}
+
+// Configura o histórico em que as alterações gravadas em bmp_cobrancas serão registradas.
+func (u *UpdateService) SetHistorico(historico *HistoricoService) {
+	u.historico = historico
+}
+
+// recordUpdate registra a alteração gravada em bmp_cobrancas.
+func (u *UpdateService) recordUpdate(data models.UpdateDbData) {
+	u.historico.Record(models.CobrancaHistorico{
+		IdPropostaParcela: data.Parcela(),
+		Tipo:              models.HISTORICO_DB,
+		Operacao:          data.Action,
+		Mensagem:          "Parcela atualizada na base de dados",
+	}, data)
+}
+
+// Configura o histórico em que os webhooks enviados serão registrados.
+func (w *WebhookService) SetHistorico(historico *HistoricoService) {
+	w.historico = historico
+}
+
+// recordWebhook registra a tentativa de entrega do webhook no histórico da parcela.
+func (w *WebhookService) recordWebhook(data models.WebhookTaskData, delivery models.WebhookDelivery) {
+	var entry = models.CobrancaHistorico{
+		IdPropostaParcela: delivery.IdPropostaParcela,
+		Tipo:              models.HISTORICO_WEBHOOK,
+		Ator:              models.HISTORICO_ATOR_SISTEMA,
+		Operacao:          data.Context,
+		CorrelationId:     data.CorrelationId,
+		StatusCode:        delivery.StatusCode,
+		Mensagem:          "Webhook enviado para " + delivery.Url,
+	}
+	if data.Retries > 0 {
+		entry.Ator = models.HISTORICO_ATOR_SCHEDULER
+	}
+	if delivery.Erro != "" {
+		entry.Mensagem = delivery.Erro
+	}
+	w.historico.Record(entry, data.Data)
+}
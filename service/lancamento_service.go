@@ -0,0 +1,68 @@
+package service
+
+import (
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Representa o service que grava e consulta os lançamentos nas parcelas. Os totais são calculados a partir dos lançamentos
+// gravados, sem consulta ao BMP.
+type LancamentoService struct {
+	repository LancamentoRepository
+	logger     *slog.Logger
+	loc        *time.Location
+}
+
+func NewLancamentoService(repository LancamentoRepository, logger *slog.Logger, loc *time.Location) *LancamentoService {
+	return &LancamentoService{
+		repository: repository,
+		logger:     logger,
+		loc:        loc,
+	}
+}
+
+// Record grava o lançamento. Retorna models.ErrLancamentoDuplicado se o evento de origem já foi gravado.
+func (l *LancamentoService) Record(data models.Lancamento) error {
+	if l == nil || data.IdPropostaParcela <= 0 {
+		return nil
+	}
+
+	data.CreatedAt = time.Now().In(l.loc)
+	_, err := l.repository.Insert(data)
+	if errors.Is(err, models.ErrLancamentoDuplicado) {
+		helpers.LogInfo(context.Background(), l.logger, l.loc, "lancamento service", "", "Lançamento já registrado", map[string]any{"id_proposta_parcela": data.IdPropostaParcela, "chave_evento": data.ChaveEvento})
+	}
+	return err
+}
+
+// FindLancamentosParcela retorna os totais e o histórico dos lançamentos da parcela.
+func (l *LancamentoService) FindLancamentosParcela(idPropostaParcela int) (models.LancamentosParcela, error) {
+	lancamentos, err := l.repository.FindByIdPropostaParcela(idPropostaParcela)
+	if err != nil {
+		return models.LancamentosParcela{}, err
+	}
+	return models.NewLancamentosParcela(idPropostaParcela, lancamentos), nil
+}
+
+// FindLancamentosProposta retorna os totais da proposta e de cada parcela e o histórico dos lançamentos.
+func (l *LancamentoService) FindLancamentosProposta(idProposta int) (models.LancamentosProposta, error) {
+	lancamentos, err := l.repository.FindByIdProposta(idProposta)
+	if err != nil {
+		return models.LancamentosProposta{}, err
+	}
+	return models.NewLancamentosProposta(idProposta, lancamentos), nil
+}
+
+// Configura o registro dos lançamentos recebidos nos eventos do BMP.
+func (c *CobrancalService) SetLancamentos(lancamentos *LancamentoService) {
+	c.lancamentos = lancamentos
+}
+
+// RecordLancamento grava o lançamento do evento recebido do BMP. Retorna models.ErrLancamentoDuplicado se o evento já foi gravado.
+func (c *CobrancalService) RecordLancamento(cobranca models.CobrancaBMP, evento models.EventoLancamentoParcela) error {
+	return c.lancamentos.Record(models.NewLancamento(cobranca, evento))
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Configura o repositório do outbox, onde são gravadas as mensagens originadas por alterações na base de dados.
+func (c *CobrancalService) SetOutbox(repository OutboxRepository) {
+	c.outboxRepository = repository
+}
+
+// SaveOutbox grava mensagens no outbox sem uma alteração associada, para publicação pelo relay.
+// Se o outbox não puder ser gravado as mensagens são publicadas diretamente na fila, para que o evento não seja perdido.
+func (c *CobrancalService) SaveOutbox(mensagens []models.OutboxMessage) {
+	saveOutbox(c.ctx, c.logger, c.loc, "cobranca service", c.outboxRepository, c.queue, mensagens)
+}
+
+// Configura o repositório do outbox, onde são gravadas as mensagens das atualizações que falharam com a base de dados disponível.
+func (u *UpdateService) SetOutbox(repository OutboxRepository) {
+	u.outboxRepository = repository
+}
+
+// saveOutbox grava as mensagens no outbox e, se ele não estiver configurado ou não puder ser gravado, as publica diretamente na fila.
+func saveOutbox(ctx context.Context, logger *slog.Logger, loc *time.Location, contexto string, repository OutboxRepository, queue QueueProducer, mensagens []models.OutboxMessage) {
+	if len(mensagens) == 0 {
+		return
+	}
+	if repository != nil {
+		if _, err := repository.Insert(mensagens...); err == nil {
+			return
+		}
+	}
+
+	for _, mensagem := range mensagens {
+		helpers.LogWarn(ctx, logger, loc, contexto, mensagem.MessageId, "Outbox indisponível, mensagem publicada diretamente na fila", nil, map[string]any{"fila": mensagem.Fila, "id_proposta_parcela": mensagem.IdPropostaParcela})
+		queue.Produce(mensagem.Fila, mensagem.Payload, mensagem.Delay)
+	}
+}
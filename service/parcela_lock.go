@@ -0,0 +1,87 @@
+package service
+
+import (
+	"cobranca-bmp/cache"
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Representa o lock distribuído que serializa as operações de uma mesma parcela entre as goroutines e as instâncias.
+type Locker interface {
+	Lock(key string, ttl, wait time.Duration) (string, error)
+	Unlock(key, token string) error
+}
+
+// Operações que gravam a parcela e chamam o BMP, executadas com o lock da parcela. A consulta não altera a cobrança no BMP
+// e é executada sem o lock, inclusive quando a geração de uma cobrança ativa é convertida em consulta.
+var operacoesExclusivas = map[string]bool{
+	config.STATUS_GERAR_COBRANCA:     true,
+	config.STATUS_CANCELAR_COBRANCA:  true,
+	config.STATUS_LANCAMENTO_PARCELA: true,
+}
+
+// SetLocker configura o lock das operações por parcela. Sem lock as operações não são serializadas e dependem apenas
+// das atualizações condicionadas ao status e à versão da parcela.
+func (c *CobrancalService) SetLocker(locker Locker) {
+	c.locker = locker
+}
+
+func parcelaLockKey(idPropostaParcela int) string {
+	return fmt.Sprintf("lock:parcela:%d", idPropostaParcela)
+}
+
+// lockParcela obtém o lock da parcela, aguardando até PARCELA_LOCK_WAIT pela operação em andamento, e retorna a função que o libera.
+// Se o Redis estiver indisponível a operação segue sem o lock, pois as gravações continuam condicionadas ao status e à versão.
+func (c *CobrancalService) lockParcela(idPropostaParcela int) (func(), error) {
+	if c.locker == nil || idPropostaParcela <= 0 {
+		return func() {}, nil
+	}
+
+	key := parcelaLockKey(idPropostaParcela)
+	token, err := c.locker.Lock(key, config.PARCELA_LOCK_TTL, config.PARCELA_LOCK_WAIT)
+	if errors.Is(err, cache.ErrLockOcupado) {
+		return nil, err
+	}
+	if err != nil {
+		helpers.LogWarn(c.ctx, c.logger, c.loc, "cobranca service", "", "Lock da parcela indisponível, operação segue sem lock", err.Error(), map[string]any{"id_proposta_parcela": idPropostaParcela})
+		return func() {}, nil
+	}
+	return func() { c.locker.Unlock(key, token) }, nil
+}
+
+// WithParcelaLock executa fn com o lock da parcela, serializando os eventos do BMP que gravam a parcela com as operações exclusivas.
+// Retorna cache.ErrLockOcupado, sem executar fn, se a operação em andamento não terminar em PARCELA_LOCK_WAIT.
+func (c *CobrancalService) WithParcelaLock(idPropostaParcela int, fn func()) error {
+	unlock, err := c.lockParcela(idPropostaParcela)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fn()
+	return nil
+}
+
+// handleParcelaOcupada trata a operação que não obteve o lock da parcela. A operação assíncrona volta para a fila, para
+// ser processada depois da operação em andamento; a síncrona é rejeitada com 409.
+func (c *CobrancalService) handleParcelaOcupada(payload *models.CobrancaTaskData) (any, string, int, error) {
+	operacao := c.operations[payload.Status]
+	errAPI := models.NewAPIError("", fmt.Sprintf("%s não realizada: outra operação está em andamento para a parcela", operacao), strconv.Itoa(payload.IdPropostaParcela))
+
+	var logData = map[string]any{"id_proposta_parcela": payload.IdPropostaParcela, "operacao": operacao, "assincrona": payload.CalledAssync}
+	helpers.LogWarn(c.ctx, c.logger, c.loc, "cobranca service", "409", "Parcela com outra operação em andamento", cache.ErrLockOcupado.Error(), logData)
+
+	if payload.CalledAssync && c.queue != nil {
+		if err := c.queue.Produce(config.COBRANCA_QUEUE, payload, config.PARCELA_LOCK_WAIT); err == nil {
+			payload.Reagendada = true
+			var resp = models.NewAPIError("", fmt.Sprintf("%s entrou em fila de processamento. Aguarde!", operacao), strconv.Itoa(payload.IdPropostaParcela))
+			resp.HasError = false
+			return resp, "", 200, nil
+		}
+	}
+	return nil, config.API_STATUS_ERR, 409, errAPI
+}
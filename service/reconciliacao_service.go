@@ -0,0 +1,556 @@
+package service
+
+import (
+	"cobranca-bmp/cache"
+	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
+	"cobranca-bmp/models"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Chave do lock que impede execuções simultâneas da reconciliação entre as instâncias.
+const reconciliacaoLockKey = "lock:reconciliacao"
+
+// Tempo máximo em que uma execução mantém o lock, para que uma instância encerrada sem liberá-lo não impeça as próximas execuções.
+const reconciliacaoLockTTL = 2 * time.Hour
+
+// Status locais das parcelas verificadas na reconciliação: cobranças ainda não pagas, canceladas ou pendentes de geração.
+var reconciliacaoStatus = []string{
+	models.COBRANCA_STATUS_GERANDO,
+	models.COBRANCA_STATUS_FALHA,
+	models.COBRANCA_STATUS_REGISTRADA,
+	models.COBRANCA_STATUS_BOLETO_EMITIDO,
+	models.COBRANCA_STATUS_PAGA_PARCIAL,
+}
+
+// Representa o service que reconcilia as cobranças em aberto com o BMP. As parcelas são consultadas por proposta e as
+// divergências encontradas são corrigidas na base local, com a emissão dos webhooks que não foram enviados, e registradas
+// no relatório da execução.
+type ReconciliacaoService struct {
+	ctx             context.Context
+	logger          *slog.Logger
+	loc             *time.Location
+	cobrancaService *CobrancalService
+	repository      ReconciliacaoRepository
+	stop            chan struct{}
+	stopOnce        sync.Once
+	running         atomic.Bool
+	done            chan struct{}
+	executando      atomic.Bool
+	wg              sync.WaitGroup
+}
+
+func NewReconciliacaoService(ctx context.Context, logger *slog.Logger, loc *time.Location, cobrancaService *CobrancalService, repository ReconciliacaoRepository) *ReconciliacaoService {
+	return &ReconciliacaoService{
+		ctx:             ctx,
+		logger:          logger,
+		loc:             loc,
+		cobrancaService: cobrancaService,
+		repository:      repository,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+}
+
+// Run inicia uma execução a cada RECONCILIACAO_INTERVAL até Stop ser chamado. Com o intervalo 0 apenas as execuções
+// manuais são realizadas.
+func (s *ReconciliacaoService) Run() {
+	s.running.Store(true)
+	defer close(s.done)
+
+	if config.RECONCILIACAO_INTERVAL <= 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.ctx.Done():
+			return
+		case <-time.After(config.RECONCILIACAO_INTERVAL):
+		}
+
+		if _, err := s.Iniciar(models.RECONCILIACAO_ORIGEM_AGENDADA); err != nil && !errors.Is(err, models.ErrReconciliacaoEmAndamento) {
+			helpers.LogError(s.ctx, s.logger, s.loc, "reconciliacao", "", "Erro ao iniciar a reconciliação agendada", err.Error(), nil)
+		}
+	}
+}
+
+// Stop interrompe o agendamento e a execução em andamento, que é finalizada após a parcela atual.
+func (s *ReconciliacaoService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	var finished = make(chan struct{})
+	go func() {
+		if s.running.Load() {
+			<-s.done
+		}
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Iniciar grava uma nova execução e a realiza em segundo plano. Retorna models.ErrReconciliacaoEmAndamento se outra execução
+// estiver em andamento nesta ou em outra instância.
+func (s *ReconciliacaoService) Iniciar(origem string) (models.Reconciliacao, error) {
+	select {
+	case <-s.stop:
+		return models.Reconciliacao{}, errors.New("serviço em encerramento")
+	default:
+	}
+
+	if !s.executando.CompareAndSwap(false, true) {
+		return models.Reconciliacao{}, models.ErrReconciliacaoEmAndamento
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		s.executando.Store(false)
+		return models.Reconciliacao{}, err
+	}
+
+	var reconciliacao = models.Reconciliacao{
+		Status:     models.RECONCILIACAO_STATUS_EXECUTANDO,
+		Origem:     origem,
+		IniciadaEm: time.Now().In(s.loc),
+	}
+	reconciliacao.Id, err = s.repository.Insert(reconciliacao)
+	if err != nil {
+		unlock()
+		s.executando.Store(false)
+		return models.Reconciliacao{}, err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.executando.Store(false)
+		defer unlock()
+		s.executar(reconciliacao)
+	}()
+
+	return reconciliacao, nil
+}
+
+// lock obtém o lock da reconciliação entre as instâncias. Se o Redis estiver indisponível a execução segue sem o lock,
+// pois as correções são feitas com o lock de cada parcela.
+func (s *ReconciliacaoService) lock() (func(), error) {
+	locker := s.cobrancaService.locker
+	if locker == nil {
+		return func() {}, nil
+	}
+
+	token, err := locker.Lock(reconciliacaoLockKey, reconciliacaoLockTTL, 0)
+	if errors.Is(err, cache.ErrLockOcupado) {
+		return nil, models.ErrReconciliacaoEmAndamento
+	}
+	if err != nil {
+		helpers.LogWarn(s.ctx, s.logger, s.loc, "reconciliacao", "", "Lock da reconciliação indisponível, execução segue sem lock", err.Error(), nil)
+		return func() {}, nil
+	}
+	return func() { locker.Unlock(reconciliacaoLockKey, token) }, nil
+}
+
+// executar percorre as parcelas em aberto em lotes de RECONCILIACAO_BATCH_SIZE, consultando o BMP por proposta com até
+// RECONCILIACAO_RATE_LIMIT consultas por segundo, e grava os totais da execução ao final.
+func (s *ReconciliacaoService) executar(reconciliacao models.Reconciliacao) {
+	helpers.LogInfo(s.ctx, s.logger, s.loc, "reconciliacao", "", "Reconciliação iniciada", reconciliacao)
+
+	limiter := time.NewTicker(time.Second / time.Duration(config.RECONCILIACAO_RATE_LIMIT))
+	defer limiter.Stop()
+
+	var filter = models.CobrancaFilter{
+		Status: reconciliacaoStatus,
+		Sort:   models.COBRANCA_SORT_ID_PROPOSTA_PARCELA,
+		Order:  "asc",
+		Limit:  config.RECONCILIACAO_BATCH_SIZE,
+	}
+
+	reconciliacao.Status = models.RECONCILIACAO_STATUS_CONCLUIDA
+lotes:
+	for {
+		if err := filter.Validate(); err != nil {
+			reconciliacao.Status = models.RECONCILIACAO_STATUS_FALHA
+			reconciliacao.Erro = err.Error()
+			break
+		}
+		page, err := s.cobrancaService.SearchCobrancas(filter)
+		if err != nil {
+			reconciliacao.Status = models.RECONCILIACAO_STATUS_FALHA
+			reconciliacao.Erro = "Erro ao listar as parcelas em aberto: " + err.Error()
+			break
+		}
+
+		for _, parcelas := range agruparPorProposta(page.Items) {
+			select {
+			case <-s.stop:
+				reconciliacao.Status = models.RECONCILIACAO_STATUS_FALHA
+				reconciliacao.Erro = "Execução interrompida no encerramento do serviço"
+				break lotes
+			case <-s.ctx.Done():
+				reconciliacao.Status = models.RECONCILIACAO_STATUS_FALHA
+				reconciliacao.Erro = "Execução interrompida no encerramento do serviço"
+				break lotes
+			case <-limiter.C:
+			}
+			s.reconciliarProposta(&reconciliacao, parcelas)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+
+	finalizadaEm := time.Now().In(s.loc)
+	reconciliacao.FinalizadaEm = &finalizadaEm
+	s.repository.Update(reconciliacao)
+
+	if reconciliacao.Status == models.RECONCILIACAO_STATUS_FALHA {
+		helpers.LogError(s.ctx, s.logger, s.loc, "reconciliacao", "", "Reconciliação finalizada com falha", reconciliacao.Erro, reconciliacao)
+		return
+	}
+	helpers.LogInfo(s.ctx, s.logger, s.loc, "reconciliacao", "", "Reconciliação finalizada", reconciliacao)
+}
+
+// agruparPorProposta agrupa as parcelas do lote por proposta, mantendo a ordem do lote, para uma consulta ao BMP por proposta.
+func agruparPorProposta(parcelas []models.CobrancaResumo) [][]models.CobrancaResumo {
+	var grupos = make([][]models.CobrancaResumo, 0)
+	var index = make(map[string]int)
+	for _, parcela := range parcelas {
+		key := parcela.NumeroAcompanhamento + "|" + strconv.Itoa(parcela.IdProposta)
+		i, ok := index[key]
+		if !ok {
+			i = len(grupos)
+			index[key] = i
+			grupos = append(grupos, nil)
+		}
+		grupos[i] = append(grupos[i], parcela)
+	}
+	return grupos
+}
+
+// reconciliarProposta consulta as parcelas de uma proposta no BMP e grava as divergências encontradas em cada uma.
+// Se a consulta falhar as parcelas não são verificadas e a falha é contabilizada nos erros da execução.
+func (s *ReconciliacaoService) reconciliarProposta(reconciliacao *models.Reconciliacao, parcelas []models.CobrancaResumo) {
+	consulta, err := s.consultar(parcelas)
+	if err != nil {
+		reconciliacao.Erros++
+		var logData = map[string]any{"id_reconciliacao": reconciliacao.Id, "id_proposta": parcelas[0].IdProposta, "numero_acompanhamento": parcelas[0].NumeroAcompanhamento}
+		helpers.LogError(s.ctx, s.logger, s.loc, "reconciliacao", "", "Erro ao consultar as parcelas no BMP", err, logData)
+		return
+	}
+
+	var parcelasBMP = make(map[int]models.ConsultaCobrancaParcela, len(consulta.Parcelas))
+	for _, parcela := range consulta.Parcelas {
+		parcelasBMP[parcela.NroParcela] = parcela
+	}
+
+	for _, local := range parcelas {
+		var parcelaBMP *models.ConsultaCobrancaParcela
+		if parcela, ok := parcelasBMP[local.NumeroParcela]; ok {
+			parcelaBMP = &parcela
+		}
+
+		reconciliacao.ParcelasVerificadas++
+		for _, divergencia := range s.reconciliarParcela(reconciliacao.Id, local, parcelaBMP) {
+			reconciliacao.Divergencias++
+			if divergencia.Corrigida {
+				reconciliacao.Corrigidas++
+			}
+			s.repository.InsertDivergencia(divergencia)
+		}
+	}
+}
+
+// consultar realiza a consulta detalhada das parcelas de uma proposta no BMP, com os boletos, pix e lançamentos.
+func (s *ReconciliacaoService) consultar(parcelas []models.CobrancaResumo) (models.ConsultaCobrancaResponse, error) {
+	var authPayload = models.AuthPayload{
+		IdConvenio:       parcelas[0].IdConvenio,
+		IdSecuritizadora: parcelas[0].IdSecuritizadora,
+		Id:               strconv.Itoa(parcelas[0].IdProposta),
+	}
+
+	var numeros = make([]int, 0, len(parcelas))
+	for _, parcela := range parcelas {
+		numeros = append(numeros, parcela.NumeroParcela)
+	}
+	var input = models.ConsultarDetalhesInput{
+		DTO: models.DtoCobranca{
+			CodigoProposta: parcelas[0].NumeroAcompanhamento,
+			CodigoOperacao: strconv.Itoa(parcelas[0].IdProposta),
+			NroParcelas:    numeros,
+		},
+		DTOConsultaDetalhes: models.DTOConsultaDetalhes{
+			TrazerBoleto:          true,
+			TrazerAgendaDetalhada: true,
+		},
+	}
+
+	token, err := s.cobrancaService.Auth(authPayload, false)
+	if err != nil {
+		return models.ConsultaCobrancaResponse{}, err
+	}
+
+	idempotencyKey := models.NewId()
+	data, _, status, err := s.cobrancaService.client.ConsultarCobranca(input, token, idempotencyKey)
+	if status == config.API_STATUS_UNAUTHORIZED {
+		token, authErr := s.cobrancaService.Auth(authPayload, true)
+		if authErr != nil {
+			return data, err
+		}
+		data, _, _, err = s.cobrancaService.client.ConsultarCobranca(input, token, idempotencyKey)
+	}
+	return data, err
+}
+
+// reconciliarParcela compara a parcela com os dados do BMP e corrige as divergências encontradas. A correção é feita com o
+// lock da parcela e os dados são comparados novamente após o lock, pois outra operação pode ter gravado a parcela depois
+// da leitura do lote.
+func (s *ReconciliacaoService) reconciliarParcela(idReconciliacao int64, local models.CobrancaResumo, parcela *models.ConsultaCobrancaParcela) []models.ReconciliacaoDivergencia {
+	var divergencias []models.ReconciliacaoDivergencia
+	defer func() {
+		now := time.Now().In(s.loc)
+		for i := range divergencias {
+			divergencias[i].IdReconciliacao = idReconciliacao
+			divergencias[i].IdPropostaParcela = local.IdPropostaParcela
+			divergencias[i].IdProposta = local.IdProposta
+			divergencias[i].NumeroParcela = local.NumeroParcela
+			divergencias[i].CreatedAt = now
+		}
+	}()
+
+	if parcela == nil {
+		divergencias = []models.ReconciliacaoDivergencia{{
+			Tipo:  models.DIVERGENCIA_PARCELA_AUSENTE,
+			Local: fmt.Sprintf("status %s, código de liquidação %s", local.Status, local.CodigoLiquidacao),
+			BMP:   "parcela não retornada na consulta",
+			Acao:  "verificação manual",
+		}}
+		return divergencias
+	}
+
+	divergencias = diffParcela(models.CobrancaBMP{
+		IdPropostaParcela: local.IdPropostaParcela,
+		NumeroBoleto:      local.NumeroBoleto,
+		CodigoLiquidacao:  local.CodigoLiquidacao,
+		Status:            local.Status,
+	}, *parcela)
+	if len(divergencias) == 0 || !config.RECONCILIACAO_CORRIGIR {
+		return divergencias
+	}
+
+	unlock, err := s.cobrancaService.lockParcela(local.IdPropostaParcela)
+	if err != nil {
+		for i := range divergencias {
+			divergencias[i].Acao = "não corrigida: outra operação está em andamento para a parcela"
+		}
+		return divergencias
+	}
+	defer unlock()
+
+	cobranca, err := s.cobrancaService.FindByIdPropostaParcela(local.IdPropostaParcela)
+	if err != nil {
+		for i := range divergencias {
+			divergencias[i].Acao = "não corrigida: erro ao ler a parcela: " + err.Error()
+		}
+		return divergencias
+	}
+
+	divergencias = diffParcela(cobranca, *parcela)
+	for i := range divergencias {
+		s.corrigir(&cobranca, *parcela, &divergencias[i])
+	}
+	return divergencias
+}
+
+// diffParcela compara a parcela gravada com a retornada pelo BMP. As divergências de boleto vêm antes das de pagamento,
+// para que a correção do boleto leve a cobrança a um status a partir do qual o pagamento possa ser gravado.
+func diffParcela(cobranca models.CobrancaBMP, parcela models.ConsultaCobrancaParcela) []models.ReconciliacaoDivergencia {
+	var divergencias = make([]models.ReconciliacaoDivergencia, 0)
+	pagamento := statusPagamento(parcela)
+	boleto := boletoVigente(parcela.Boletos)
+	emitida := boleto != nil || pixVigente(parcela.Pix)
+
+	var aguardandoEmissao = cobranca.Status == models.COBRANCA_STATUS_GERANDO ||
+		cobranca.Status == models.COBRANCA_STATUS_FALHA ||
+		cobranca.Status == models.COBRANCA_STATUS_REGISTRADA
+
+	//Em uma parcela quitada a troca de boleto não é divergência, mas a emissão pendente é gravada para que o status possa seguir para paga
+	if emitida && (aguardandoEmissao || (pagamento != models.COBRANCA_STATUS_PAGA && boleto != nil && boleto.NumeroBoleto != cobranca.NumeroBoleto)) {
+		var bmp = "pix emitido"
+		if boleto != nil {
+			bmp = fmt.Sprintf("boleto %d emitido", boleto.NumeroBoleto)
+		}
+		divergencias = append(divergencias, models.ReconciliacaoDivergencia{
+			Tipo:  models.DIVERGENCIA_BOLETO,
+			Local: fmt.Sprintf("status %s, boleto %d", cobranca.Status, cobranca.NumeroBoleto),
+			BMP:   bmp,
+		})
+	}
+
+	if pagamento != "" && pagamento != cobranca.Status {
+		divergencias = append(divergencias, models.ReconciliacaoDivergencia{
+			Tipo:  models.DIVERGENCIA_PAGAMENTO,
+			Local: "status " + cobranca.Status,
+			BMP:   fmt.Sprintf("situação %d, pago %.2f, saldo %.2f", parcela.Situacao, parcela.VlrTotalPago, parcela.VlrSaldoAtual),
+		})
+	}
+
+	if pagamento == "" && !emitida && statusAtivo(cobranca.Status) {
+		divergencias = append(divergencias, models.ReconciliacaoDivergencia{
+			Tipo:  models.DIVERGENCIA_COBRANCA_AUSENTE,
+			Local: fmt.Sprintf("status %s, código de liquidação %s", cobranca.Status, cobranca.CodigoLiquidacao),
+			BMP:   fmt.Sprintf("situação %d, sem boleto ou pix vigente", parcela.Situacao),
+			Acao:  "verificação manual",
+		})
+	}
+
+	return divergencias
+}
+
+// corrigir aplica a correção da divergência na parcela lida com o lock, atualizando o status e a versão em memória para as
+// próximas correções. Os webhooks não enviados são gravados no outbox na mesma transação do status.
+func (s *ReconciliacaoService) corrigir(cobranca *models.CobrancaBMP, parcela models.ConsultaCobrancaParcela, divergencia *models.ReconciliacaoDivergencia) {
+	const origem = "reconciliação"
+	c := s.cobrancaService
+
+	switch divergencia.Tipo {
+	case models.DIVERGENCIA_BOLETO:
+		if boleto := boletoVigente(parcela.Boletos); boleto != nil && boleto.NumeroBoleto != cobranca.NumeroBoleto {
+			if _, err := c.UpdateNumeroBoleto(cobranca.IdPropostaParcela, boleto.NumeroBoleto, cobranca.Version); err != nil {
+				divergencia.Acao = "não corrigida: erro ao gravar o número do boleto: " + err.Error()
+				return
+			}
+			cobranca.NumeroBoleto = boleto.NumeroBoleto
+			cobranca.Version++
+		}
+
+		boletos := models.WebhookBoletosFromConsulta(parcela.Boletos)
+		pix := models.WebhookPixFromConsulta(parcela.Pix)
+		c.savePagamento(cobranca.IdPropostaParcela, boletos, pix)
+
+		var whData = make(map[string]any)
+		whData["id_proposta_parcela"] = cobranca.IdPropostaParcela
+		whData["codigo_liquidacao"] = cobranca.CodigoLiquidacao
+		whData["operacao"] = "R"
+		if len(parcela.Boletos) > 0 {
+			whData["boleto"] = parcela.Boletos
+		}
+		if len(parcela.Pix) > 0 {
+			whData["pix"] = parcela.Pix
+		}
+		event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_COBRANCA_REGISTRADA, cobranca.IdPropostaParcela, models.CobrancaRegistradaData{
+			CodigoLiquidacao: cobranca.CodigoLiquidacao,
+			Boletos:          boletos,
+			Pix:              pix,
+		}, whData)
+		outbox, _ := c.webhookService.Outbox(cobranca.WebhookDestino(), event, "reconciliacao")
+
+		to := cobranca.Status
+		if to == models.COBRANCA_STATUS_GERANDO || to == models.COBRANCA_STATUS_FALHA || to == models.COBRANCA_STATUS_REGISTRADA {
+			to = models.COBRANCA_STATUS_BOLETO_EMITIDO
+		}
+		if err := c.TransitionStatus(*cobranca, to, origem, outbox...); err != nil {
+			divergencia.Acao = "webhook cobranca.registrada emitido, status não alterado: " + err.Error()
+			return
+		}
+		cobranca.Status = to
+		divergencia.Corrigida = true
+		divergencia.Acao = fmt.Sprintf("dados de pagamento gravados, status %s e webhook cobranca.registrada emitido", to)
+
+	case models.DIVERGENCIA_PAGAMENTO:
+		to := statusPagamento(parcela)
+		var dataPagamento string
+		for _, lancamento := range parcela.Lancamentos {
+			if lancamento.VlrPagamento > 0 {
+				dataPagamento = lancamento.DtLancamento
+			}
+		}
+
+		var whData = make(map[string]any)
+		whData["id_proposta_parcela"] = cobranca.IdPropostaParcela
+		whData["data_pagamento"] = dataPagamento
+		whData["valor_pago"] = parcela.VlrTotalPago
+		whData["valor_encargo"] = 0
+		whData["valor_desconto"] = parcela.VlrDesconto
+		whData["operacao"] = "P"
+		event := models.NewWebhookEvent(config.WEBHOOK_EVENTO_PARCELA_LANCAMENTO, cobranca.IdPropostaParcela, models.ParcelaLancamentoData{
+			Operacao:      "P",
+			DataPagamento: dataPagamento,
+			ValorPago:     parcela.VlrTotalPago,
+			ValorDesconto: parcela.VlrDesconto,
+			Saldo:         parcela.VlrSaldoAtual,
+		}, whData)
+		outbox, _ := c.webhookService.Outbox(cobranca.WebhookDestino(), event, "reconciliacao")
+
+		if err := c.TransitionStatus(*cobranca, to, origem, outbox...); err != nil {
+			divergencia.Acao = "webhook parcela.lancamento emitido, status não alterado: " + err.Error()
+			return
+		}
+		cobranca.Status = to
+		divergencia.Corrigida = true
+		divergencia.Acao = fmt.Sprintf("status %s e webhook parcela.lancamento emitido", to)
+	}
+}
+
+// statusPagamento retorna o status correspondente aos pagamentos da parcela no BMP, ou vazio se não houver pagamento.
+func statusPagamento(parcela models.ConsultaCobrancaParcela) string {
+	if parcela.VlrTotalPago <= 0 {
+		return ""
+	}
+	if parcela.VlrSaldoAtual <= 0 {
+		return models.COBRANCA_STATUS_PAGA
+	}
+	return models.COBRANCA_STATUS_PAGA_PARCIAL
+}
+
+// boletoVigente retorna o último boleto emitido da parcela, ou nil se nenhum boleto tiver sido emitido.
+func boletoVigente(boletos []models.ConsultaBoleto) *models.ConsultaBoleto {
+	for i := len(boletos) - 1; i >= 0; i-- {
+		if boletos[i].UrlImpressao != "" || boletos[i].LinhaDigitavel != "" {
+			return &boletos[i]
+		}
+	}
+	return nil
+}
+
+// pixVigente informa se algum pix da parcela foi emitido.
+func pixVigente(pix []models.ConsultaPix) bool {
+	for _, p := range pix {
+		if p.Emv != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// FindReconciliacao retorna uma execução da reconciliação.
+func (s *ReconciliacaoService) FindReconciliacao(id int64) (models.Reconciliacao, error) {
+	return s.repository.FindById(id)
+}
+
+// FindReconciliacoes lista as execuções da reconciliação.
+func (s *ReconciliacaoService) FindReconciliacoes(filter models.ReconciliacaoFilter) ([]models.Reconciliacao, error) {
+	return s.repository.Find(filter)
+}
+
+// FindDivergencias lista as divergências de uma execução.
+func (s *ReconciliacaoService) FindDivergencias(filter models.ReconciliacaoDivergenciaFilter) ([]models.ReconciliacaoDivergencia, error) {
+	return s.repository.FindDivergencias(filter)
+}
@@ -5,13 +5,76 @@ import "cobranca-bmp/models"
 // Representa os repositórios que realizarão ações no banco de dados.
 
 type ParcelaRepository interface {
-	UpdateCodLiquidacao(IdPropostaParcela int, codigoLiquidacao string) (bool, error)
-	UpdateNumeroBoleto(IdPropostaParcela, numeroBoleto int) (bool, error)
+	UpdateCodLiquidacao(IdPropostaParcela int, codigoLiquidacao string, version int64) (bool, error)
+	UpdateNumeroBoleto(IdPropostaParcela, numeroBoleto int, version int64) (bool, error)
 	UpdateGeracaoCobranca(data models.GerarCobrancaFrontendInput) (bool, error)
 	UpdateCancelamentoCobranca(data models.CancelarCobrancaFrontendInput) (bool, error)
 	UpdateLancamentoParcela(data models.LancamentoParcelaFrontendInput) (bool, error)
 	FindByCodLiquidacao(codigoLiquidacao string, numeroCCB int) (models.CobrancaBMP, error)
+	FindByNumeroBoleto(numeroBoleto int, numeroCCB int) (models.CobrancaBMP, error)
 	FindByNumParcela(numParcela int, numeroCCB int) (models.CobrancaBMP, error)
 	FindByDataVencimento(dataExpiracao string, numeroCCB int) (models.CobrancaBMP, error)
 	FindByIdPropostaParcela(idPropostaParcela int) (models.CobrancaBMP, error)
+	UpdateStatus(idPropostaParcela int, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error)
+	UpdateRegistroCobranca(idPropostaParcela int, codigoLiquidacao string, from, to string, version int64, outbox ...models.OutboxMessage) (bool, error)
+	FindStatus(idPropostaParcela int) (string, int64, error)
+	FindStatusByNumeroCCB(numeroCCB int) (map[int]string, error)
+	Search(filter models.CobrancaFilter) ([]models.CobrancaResumo, error)
+	UpdatePagamento(idPropostaParcela int, pagamento models.CobrancaPagamento, outbox ...models.OutboxMessage) (bool, error)
+	UpdateLiquidacaoStatus(idPropostaParcela int, codigoLiquidacao string, numeroBoleto int, status string) (bool, error)
+	FindLiquidacoes(numeroCCB int) ([]models.Liquidacao, error)
+}
+
+// Representa o repositório de registro das entregas de webhook.
+type WebhookDeliveryRepository interface {
+	Insert(data models.WebhookDelivery) (bool, error)
+	FindById(id int64) (models.WebhookDelivery, error)
+	Find(filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+}
+
+// Representa o repositório de assinaturas de webhook dos convênios.
+type WebhookSubscriptionRepository interface {
+	Insert(data models.WebhookSubscription) (int64, error)
+	Update(data models.WebhookSubscription) error
+	Delete(id int64) error
+	FindById(id int64) (models.WebhookSubscription, error)
+	Find(filter models.WebhookSubscriptionFilter) ([]models.WebhookSubscription, error)
+	FindAtivas(idConvenio, idSecuritizadora int) ([]models.WebhookSubscription, error)
+}
+
+// Representa o repositório de mensagens da DLQ e da auditoria de reprocessamentos.
+type DLQRepository interface {
+	Insert(data models.DLQEntry) (bool, error)
+	FindById(id int64) (models.DLQEntry, error)
+	Find(filter models.DLQEntryFilter) ([]models.DLQEntry, error)
+	InsertReplay(data models.DLQReplay, outbox ...models.OutboxMessage) (int64, error)
+	FindReplays(idDLQ int64) ([]models.DLQReplay, error)
+}
+
+// Representa o repositório do histórico das parcelas.
+type HistoricoRepository interface {
+	Insert(data models.CobrancaHistorico) (bool, error)
+	FindByIdPropostaParcela(idPropostaParcela int) ([]models.CobrancaHistorico, error)
+}
+
+// Representa o repositório do outbox, onde são gravadas as mensagens publicadas pelo relay.
+type OutboxRepository interface {
+	Insert(mensagens ...models.OutboxMessage) (bool, error)
+}
+
+// Representa o repositório das execuções e divergências da reconciliação com o BMP.
+type ReconciliacaoRepository interface {
+	Insert(data models.Reconciliacao) (int64, error)
+	Update(data models.Reconciliacao) error
+	FindById(id int64) (models.Reconciliacao, error)
+	Find(filter models.ReconciliacaoFilter) ([]models.Reconciliacao, error)
+	InsertDivergencia(data models.ReconciliacaoDivergencia) (bool, error)
+	FindDivergencias(filter models.ReconciliacaoDivergenciaFilter) ([]models.ReconciliacaoDivergencia, error)
+}
+
+// Representa o repositório dos lançamentos nas parcelas.
+type LancamentoRepository interface {
+	Insert(data models.Lancamento) (bool, error)
+	FindByIdPropostaParcela(idPropostaParcela int) ([]models.Lancamento, error)
+	FindByIdProposta(idProposta int) ([]models.Lancamento, error)
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"cobranca-bmp/config"
 	"cobranca-bmp/models"
+	"context"
 	"errors"
 	"log/slog"
 	"time"
@@ -17,6 +18,8 @@ type UpdateService struct {
 	logger            *slog.Logger
 	loc               *time.Location
 	parcelaRepository ParcelaRepository
+	historico         *HistoricoService
+	outboxRepository  OutboxRepository
 }
 
 func NewUpdateService(logger *slog.Logger, loc *time.Location, parcelaRepository ParcelaRepository) *UpdateService {
@@ -60,77 +63,55 @@ func (u *UpdateService) UpdateAssync(data models.UpdateDbData) (bool, error) {
 	case "update_numero_boleto":
 		noConn, err = u.UpdateNumeroBoleto(data, true)
 
+	case "update_registro_cobranca":
+		noConn, err = u.UpdateRegistroCobranca(data, true)
+
 	default:
 		return false, errors.New("ação de update inválida")
 	}
+
+	//A parcela foi gravada por outra operação depois da leitura: a atualização está desatualizada e é descartada
+	if errors.Is(err, models.ErrVersaoConcorrente) {
+		return false, nil
+	}
 	return noConn, err
 }
 
 func (u *UpdateService) UpdateCodLiquidacao(data models.UpdateDbData, calledAssync bool) (bool, error) {
 
-	noConn, err := u.parcelaRepository.UpdateCodLiquidacao(data.IdPropostaParcela, data.CodigoLiquidacao)
+	noConn, err := u.parcelaRepository.UpdateCodLiquidacao(data.IdPropostaParcela, data.CodigoLiquidacao, data.Version)
+	if errors.Is(err, models.ErrVersaoConcorrente) {
+		return false, err
+	}
 	if err != nil {
-		if !noConn {
-			var dlqData = models.DLQData{
-				Contexto: "db",
-				Payload:  nil,
-				Mensagem: "falha ao realizar update de código liquidação",
-				Erro:     err.Error(),
-				Time:     time.Now().In(u.loc),
-			}
-			u.queue.Produce(config.DLQ_QUEUE, dlqData, 0)
-		} else {
-			if !calledAssync {
-				u.queue.Produce(config.DB_QUEUE, data, config.DB_QUEUE_DELAY)
-			}
-		}
+		u.updateFailed(data, noConn, calledAssync, "falha ao realizar update de código liquidação", err)
 		return noConn, err
 	}
+	u.recordUpdate(data)
 	return false, nil
 }
 
 func (u *UpdateService) UpdateNumeroBoleto(data models.UpdateDbData, calledAssync bool) (bool, error) {
 
-	noConn, err := u.parcelaRepository.UpdateNumeroBoleto(data.IdPropostaParcela, data.NumeroBoleto)
+	noConn, err := u.parcelaRepository.UpdateNumeroBoleto(data.IdPropostaParcela, data.NumeroBoleto, data.Version)
+	if errors.Is(err, models.ErrVersaoConcorrente) {
+		return false, err
+	}
 	if err != nil {
-		if !noConn {
-			var dlqData = models.DLQData{
-				Contexto: "db",
-				Payload:  nil,
-				Mensagem: "falha ao realizar update de código liquidação",
-				Erro:     err.Error(),
-				Time:     time.Now().In(u.loc),
-			}
-			u.queue.Produce(config.DLQ_QUEUE, dlqData, 0)
-		} else {
-			if !calledAssync {
-				u.queue.Produce(config.DB_QUEUE, data, config.DB_QUEUE_DELAY)
-			}
-		}
+		u.updateFailed(data, noConn, calledAssync, "falha ao realizar update de número do boleto", err)
 		return noConn, err
 	}
+	u.recordUpdate(data)
 	return false, nil
 }
 
 func (u *UpdateService) UpdateGeracaoParcela(data models.UpdateDbData, calledAssync bool) (bool, error) {
 	noConn, err := u.parcelaRepository.UpdateGeracaoCobranca(*data.GeracaoParcela)
 	if err != nil {
-		if !noConn {
-			var dlqData = models.DLQData{
-				Contexto: "db",
-				Payload:  nil,
-				Mensagem: "falha ao realizar update de parcela",
-				Erro:     err.Error(),
-				Time:     time.Now().In(u.loc),
-			}
-			u.queue.Produce(config.DLQ_QUEUE, dlqData, 0)
-		} else {
-			if !calledAssync {
-				u.queue.Produce(config.DB_QUEUE, data, config.DB_QUEUE_DELAY)
-			}
-		}
+		u.updateFailed(data, noConn, calledAssync, "falha ao realizar update de parcela", err)
 		return noConn, err
 	}
+	u.recordUpdate(data)
 	return false, nil
 }
 
@@ -138,22 +119,10 @@ func (u *UpdateService) UpdateCancelamentoParcela(data models.UpdateDbData, call
 
 	noConn, err := u.parcelaRepository.UpdateCancelamentoCobranca(*data.CancelamentoCobranca)
 	if err != nil {
-		if !noConn {
-			var dlqData = models.DLQData{
-				Contexto: "db",
-				Payload:  nil,
-				Mensagem: "falha ao realizar update de parcela",
-				Erro:     err.Error(),
-				Time:     time.Now().In(u.loc),
-			}
-			u.queue.Produce(config.DLQ_QUEUE, dlqData, 0)
-		} else {
-			if !calledAssync {
-				u.queue.Produce(config.DB_QUEUE, data, config.DB_QUEUE_DELAY)
-			}
-		}
+		u.updateFailed(data, noConn, calledAssync, "falha ao realizar update de parcela", err)
 		return noConn, err
 	}
+	u.recordUpdate(data)
 	return false, nil
 }
 
@@ -161,21 +130,80 @@ func (u *UpdateService) UpdateLancamentoParcela(data models.UpdateDbData, called
 
 	noConn, err := u.parcelaRepository.UpdateLancamentoParcela(*data.LancamentoParcela)
 	if err != nil {
-		if !noConn {
-			var dlqData = models.DLQData{
-				Contexto: "db",
-				Payload:  nil,
-				Mensagem: "falha ao realizar update de parcela",
-				Erro:     err.Error(),
-				Time:     time.Now().In(u.loc),
-			}
-			u.queue.Produce(config.DLQ_QUEUE, dlqData, 0)
-		} else {
-			if !calledAssync {
-				u.queue.Produce(config.DB_QUEUE, data, config.DB_QUEUE_DELAY)
-			}
-		}
+		u.updateFailed(data, noConn, calledAssync, "falha ao realizar update de parcela", err)
 		return noConn, err
 	}
+	u.recordUpdate(data)
 	return false, nil
 }
+
+// UpdateRegistroCobranca grava o código de liquidação retornado pelo BMP, o status registrada e as mensagens do outbox(data.Outbox)
+// na mesma transação, condicionados à versão da parcela lida antes da gravação. Se outra operação gravou a parcela nesse intervalo
+// a leitura e a gravação são repetidas; se o status atual não permitir a transição apenas o código de liquidação é gravado.
+// Sem conexão com a base de dados a atualização segue, com as mensagens, para a fila de atualização e é gravada inteira quando
+// a conexão for restabelecida. Com a base disponível e o registro não gravado, as mensagens são gravadas sozinhas, pois a
+// cobrança já foi registrada no BMP, e a atualização segue para a DLQ.
+func (u *UpdateService) UpdateRegistroCobranca(data models.UpdateDbData, calledAssync bool) (bool, error) {
+	var noConn bool
+	var err error
+	for tentativa := 1; tentativa <= tentativasVersao; tentativa++ {
+		var from string
+		var version int64
+		if from, version, err = u.parcelaRepository.FindStatus(data.IdPropostaParcela); err != nil {
+			//A leitura não informa a falta de conexão: a atualização é repetida pela fila de atualização
+			noConn = true
+			break
+		}
+		to := models.COBRANCA_STATUS_REGISTRADA
+		if validateStatusTransition(from, to) != nil {
+			to = from
+		}
+
+		noConn, err = u.parcelaRepository.UpdateRegistroCobranca(data.IdPropostaParcela, data.CodigoLiquidacao, from, to, version, data.Outbox...)
+		if err == nil {
+			data.Outbox = nil
+			u.recordUpdate(data)
+			if from != to {
+				u.historico.recordStatus(data.IdPropostaParcela, from, to, "geração de cobrança")
+			}
+			return false, nil
+		}
+		if !errors.Is(err, models.ErrVersaoConcorrente) {
+			break
+		}
+	}
+
+	if !noConn {
+		saveOutbox(context.Background(), u.logger, u.loc, "update service", u.outboxRepository, u.queue, data.Outbox)
+		data.Outbox = nil
+	}
+	u.updateFailed(data, noConn, calledAssync, "falha ao gravar registro da cobrança", err)
+	return noConn, err
+}
+
+// updateFailed encaminha a atualização que falhou. Com a base de dados disponível a falha não é reprocessada e a atualização
+// segue para a DLQ pelo outbox. Sem conexão o outbox, gravado na mesma base, também está indisponível: a atualização segue
+// para a fila de atualização, que em último caso é enviada pelo Redis ou gravada no spool(ver producer.produceDB).
+// Quando chamada pela fila a atualização não é publicada novamente, pois a própria mensagem é reentregue.
+func (u *UpdateService) updateFailed(data models.UpdateDbData, noConn, calledAssync bool, mensagem string, err error) {
+	if noConn {
+		if !calledAssync {
+			u.queue.Produce(config.DB_QUEUE, data, config.DB_QUEUE_DELAY)
+		}
+		return
+	}
+
+	var dlqData = models.DLQData{
+		Contexto: "db",
+		Payload:  data,
+		Mensagem: mensagem,
+		Erro:     err.Error(),
+		Time:     time.Now().In(u.loc),
+	}
+	outbox, outboxErr := models.NewOutboxMessage(config.DLQ_QUEUE, dlqData, 0, data.Parcela())
+	if outboxErr != nil {
+		u.queue.Produce(config.DLQ_QUEUE, dlqData, 0)
+		return
+	}
+	saveOutbox(context.Background(), u.logger, u.loc, "update service", u.outboxRepository, u.queue, []models.OutboxMessage{outbox})
+}
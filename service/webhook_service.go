@@ -2,26 +2,38 @@ package service
 
 import (
 	"cobranca-bmp/config"
+	"cobranca-bmp/helpers"
 	"cobranca-bmp/models"
+	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"strconv"
 	"time"
 )
 
 type WebhookClient interface {
-	RequestToWebhook(data any, url string) error
+	RequestToWebhook(data any, url string, scheme string) (models.WebhookDelivery, error)
 }
 
 type WebhookService struct {
-	client WebhookClient
-
-	queue QueueProducer
-	loc   *time.Location
+	client                 WebhookClient
+	deliveryRepository     WebhookDeliveryRepository
+	subscriptionRepository WebhookSubscriptionRepository
+	queue                  QueueProducer
+	historico              *HistoricoService
+	logger                 *slog.Logger
+	loc                    *time.Location
 }
 
-func NewWebhookService(client WebhookClient, loc *time.Location) *WebhookService {
+func NewWebhookService(client WebhookClient, deliveryRepository WebhookDeliveryRepository, subscriptionRepository WebhookSubscriptionRepository,
+	logger *slog.Logger, loc *time.Location) *WebhookService {
 	return &WebhookService{
-		client: client,
-		loc:    loc,
+		client:                 client,
+		deliveryRepository:     deliveryRepository,
+		subscriptionRepository: subscriptionRepository,
+		logger:                 logger,
+		loc:                    loc,
 	}
 }
 
@@ -40,11 +52,41 @@ func (w *WebhookService) SetProducer(q any) error {
 	return nil
 }
 
+// RequestToWebhook realiza a entrega do webhook. Em caso de falha transitória, reenfileira a entrega com backoff exponencial.
+// Falhas definitivas(4xx, exceto 408 e 429) ou entregas que esgotaram a política do destino são enviadas para a DLQ com o histórico de tentativas.
 func (w *WebhookService) RequestToWebhook(data models.WebhookTaskData) error {
 
-	err := w.client.RequestToWebhook(data.Data, data.Url)
+	delivery, err := w.client.RequestToWebhook(data.Data, data.Url, data.Scheme)
+	w.saveDelivery(data, delivery)
+
 	if err != nil {
-		data.SetTry()
+		now := time.Now().In(w.loc)
+		policy := config.GetWebhookRetryPolicy(data.Url)
+		data.SetTry(policy, err, now)
+
+		var whErr models.WebhookError
+		if errors.As(err, &whErr) && whErr.IsPermanent() {
+			w.SendToDLQ(models.DLQData{
+				Payload:  data,
+				Contexto: "webhook",
+				Mensagem: "Erro definitivo na entrega do webhook",
+				Erro:     err.Error(),
+				Time:     now,
+			})
+			return err
+		}
+
+		if policy.Exhausted(data.Retries, data.CreatedAt, now) {
+			w.SendToDLQ(models.DLQData{
+				Payload:  data,
+				Contexto: "webhook",
+				Mensagem: "Tentativas de entrega do webhook esgotadas",
+				Erro:     err.Error(),
+				Time:     now,
+			})
+			return err
+		}
+
 		w.queue.Produce(config.WEBHOOK_QUEUE, data, data.Delay)
 		return err
 	}
@@ -52,6 +94,168 @@ func (w *WebhookService) RequestToWebhook(data models.WebhookTaskData) error {
 
 }
 
+// Outbox retorna as mensagens da fila de webhook para os destinos do convênio.
+// A URL informada na requisição tem prioridade sobre as assinaturas. Sem URL na requisição, o evento é enviado para todas as assinaturas
+// ativas do convênio/securitizadora que assinam o evento e, não havendo nenhuma, para a URL gravada na cobrança.
+// Cada assinatura recebe o payload na versão do schema que fixou; os demais destinos recebem a versão WEBHOOK_SCHEMA_VERSION.
+// As mensagens são gravadas no outbox junto com a alteração que originou o evento e entregues pelo consumer da fila de webhook.
+func (w *WebhookService) Outbox(destino models.WebhookDestino, event models.WebhookEvent, contexto string) ([]models.OutboxMessage, error) {
+	tasks, err := w.webhookTasks(destino, event, contexto)
+	if err != nil {
+		return nil, err
+	}
+
+	var mensagens = make([]models.OutboxMessage, 0, len(tasks))
+	for _, taskData := range tasks {
+		mensagem, err := models.NewOutboxMessage(config.WEBHOOK_QUEUE, taskData, 0, event.IdPropostaParcela)
+		if err != nil {
+			helpers.LogError(context.Background(), w.logger, w.loc, "webhook service", "", "Erro ao serializar webhook para o outbox", err.Error(), taskData)
+			return nil, err
+		}
+		mensagens = append(mensagens, mensagem)
+	}
+	return mensagens, nil
+}
+
+// webhookTasks resolve os destinos do evento e retorna os dados de entrega de cada um.
+func (w *WebhookService) webhookTasks(destino models.WebhookDestino, event models.WebhookEvent, contexto string) ([]models.WebhookTaskData, error) {
+	if destino.Url != "" {
+		taskData := models.NewWebhookTaskData(destino.Url, event.Payload(config.WEBHOOK_SCHEMA_VERSION), contexto)
+		taskData.Version = config.WEBHOOK_SCHEMA_VERSION
+		return []models.WebhookTaskData{taskData}, nil
+	}
+
+	var tasks []models.WebhookTaskData
+	if w.subscriptionRepository != nil && destino.IdConvenio > 0 {
+		subscriptions, err := w.subscriptionRepository.FindAtivas(destino.IdConvenio, destino.IdSecuritizadora)
+		if err != nil {
+			helpers.LogError(context.Background(), w.logger, w.loc, "webhook service", "", "Erro ao buscar assinaturas de webhook", err.Error(), destino)
+		}
+
+		for _, subscription := range subscriptions {
+			if !subscription.Matches(event.Type) {
+				continue
+			}
+			version := subscription.SchemaVersion
+			if version <= 0 {
+				version = config.WEBHOOK_SCHEMA_VERSION
+			}
+			taskData := models.NewWebhookTaskData(subscription.Url, event.Payload(version), contexto)
+			taskData.Scheme = subscription.AuthScheme
+			taskData.Version = version
+			tasks = append(tasks, taskData)
+		}
+	}
+
+	if len(tasks) == 0 {
+		if destino.UrlFallback == "" {
+			helpers.LogError(context.Background(), w.logger, w.loc, "webhook service", "", "Nenhum destino encontrado para o evento", event.Type, destino)
+			return nil, errors.New("nenhum destino encontrado para o evento " + event.Type)
+		}
+		taskData := models.NewWebhookTaskData(destino.UrlFallback, event.Payload(config.WEBHOOK_SCHEMA_VERSION), contexto)
+		taskData.Version = config.WEBHOOK_SCHEMA_VERSION
+		tasks = append(tasks, taskData)
+	}
+
+	return tasks, nil
+}
+
+// FindSubscriptions lista as assinaturas de webhook.
+func (w *WebhookService) FindSubscriptions(filter models.WebhookSubscriptionFilter) ([]models.WebhookSubscription, error) {
+	return w.subscriptionRepository.Find(filter)
+}
+
+// FindSubscription busca uma assinatura de webhook.
+func (w *WebhookService) FindSubscription(id int64) (models.WebhookSubscription, error) {
+	return w.subscriptionRepository.FindById(id)
+}
+
+// CreateSubscription grava uma nova assinatura de webhook.
+func (w *WebhookService) CreateSubscription(data models.WebhookSubscription) (models.WebhookSubscription, error) {
+	now := time.Now().In(w.loc)
+	data.CreatedAt = now
+	data.UpdatedAt = now
+
+	id, err := w.subscriptionRepository.Insert(data)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	data.Id = id
+	return data, nil
+}
+
+// UpdateSubscription atualiza uma assinatura de webhook.
+func (w *WebhookService) UpdateSubscription(data models.WebhookSubscription) (models.WebhookSubscription, error) {
+	current, err := w.subscriptionRepository.FindById(data.Id)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	data.CreatedAt = current.CreatedAt
+	data.UpdatedAt = time.Now().In(w.loc)
+
+	if err := w.subscriptionRepository.Update(data); err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	return data, nil
+}
+
+// DeleteSubscription remove uma assinatura de webhook.
+func (w *WebhookService) DeleteSubscription(id int64) error {
+	return w.subscriptionRepository.Delete(id)
+}
+
+// Registra a tentativa de entrega. Falhas ao registrar não interrompem a entrega do webhook.
+func (w *WebhookService) saveDelivery(data models.WebhookTaskData, delivery models.WebhookDelivery) {
+	delivery.IdPropostaParcela = models.IdPropostaParcelaFromWebhookData(data.Data)
+	delivery.Url = data.Url
+	delivery.Contexto = data.Context
+	delivery.AuthScheme = data.Scheme
+	delivery.SchemaVersion = data.Version
+	delivery.Tentativa = data.Retries + 1
+	delivery.CreatedAt = time.Now().In(w.loc)
+
+	w.recordWebhook(data, delivery)
+	if w.deliveryRepository == nil {
+		return
+	}
+
+	if _, err := w.deliveryRepository.Insert(delivery); err != nil {
+		helpers.LogError(context.Background(), w.logger, w.loc, "webhook service", "", "Erro ao registrar entrega de webhook", err.Error(), delivery)
+	}
+}
+
+// FindDeliveries lista as entregas de webhook registradas.
+func (w *WebhookService) FindDeliveries(filter models.WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	return w.deliveryRepository.Find(filter)
+}
+
+// FindDelivery busca uma entrega de webhook registrada.
+func (w *WebhookService) FindDelivery(id int64) (models.WebhookDelivery, error) {
+	return w.deliveryRepository.FindById(id)
+}
+
+// ResendDelivery reenvia manualmente o payload de uma entrega registrada para a mesma URL, com o esquema de autenticação
+// e a versão do schema da entrega original. O reenvio é registrado como uma nova entrega e segue a política de retentativas do destino.
+func (w *WebhookService) ResendDelivery(id int64) error {
+	delivery, err := w.deliveryRepository.FindById(id)
+	if err != nil {
+		return err
+	}
+	if len(delivery.Payload) == 0 {
+		return errors.New("entrega sem payload registrado")
+	}
+
+	var data any
+	if err := json.Unmarshal(delivery.Payload, &data); err != nil {
+		return err
+	}
+
+	taskData := models.NewWebhookTaskData(delivery.Url, data, "reenvio-manual:"+strconv.FormatInt(delivery.Id, 10))
+	taskData.Scheme = delivery.AuthScheme
+	taskData.Version = delivery.SchemaVersion
+	return w.RequestToWebhook(taskData)
+}
+
 func (w *WebhookService) SendToDLQ(data any) {
 	w.queue.Produce(config.DLQ_QUEUE, data, 0)
 
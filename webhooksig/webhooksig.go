@@ -0,0 +1,120 @@
+// Package webhooksig implementa a assinatura HMAC-SHA256 dos webhooks enviados pelo cobranca-bmp
+// e a verificação dessas assinaturas pelos parceiros que os recebem.
+//
+// A assinatura é calculada sobre "<timestamp>.<body>", onde timestamp é o valor do header
+// X-Signature-Timestamp(segundos desde 1970) e body é o corpo bruto da requisição.
+// O header X-Signature carrega "sha256=<hex>" e X-Signature-Key-Id identifica a chave utilizada,
+// permitindo que mais de uma chave esteja ativa durante uma rotação.
+//
+// Este pacote não depende de nenhum outro pacote do projeto e pode ser copiado ou importado pelos receptores.
+package webhooksig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderKeyId     = "X-Signature-Key-Id"
+
+	signaturePrefix = "sha256="
+
+	// Tolerância padrão entre o timestamp assinado e o horário do receptor.
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrMissingHeaders   = errors.New("webhooksig: headers de assinatura ausentes")
+	ErrUnknownKey       = errors.New("webhooksig: chave desconhecida")
+	ErrInvalidTimestamp = errors.New("webhooksig: timestamp inválido")
+	ErrExpired          = errors.New("webhooksig: timestamp fora da tolerância")
+	ErrInvalidSignature = errors.New("webhooksig: assinatura inválida")
+)
+
+// Sign retorna o valor do header X-Signature para o body e timestamp informados.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetHeaders assina o body com a chave keyId e grava os headers de assinatura em h.
+func SetHeaders(h http.Header, keyId string, secret []byte, body []byte, now time.Time) {
+	timestamp := now.Unix()
+	h.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	h.Set(HeaderKeyId, keyId)
+	h.Set(HeaderSignature, Sign(secret, timestamp, body))
+}
+
+// Verify valida a assinatura de um webhook recebido.
+// keys contém as chaves ativas do receptor, indexadas pelo key id. tolerance <= 0 utiliza DefaultTolerance.
+func Verify(keys map[string][]byte, h http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	signature := h.Get(HeaderSignature)
+	rawTimestamp := h.Get(HeaderTimestamp)
+	keyId := h.Get(HeaderKeyId)
+	if signature == "" || rawTimestamp == "" || keyId == "" {
+		return ErrMissingHeaders
+	}
+
+	secret, ok := keys[keyId]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	diff := now.Sub(time.Unix(timestamp, 0))
+	if diff < -tolerance || diff > tolerance {
+		return ErrExpired
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyRequest lê o body de r e valida sua assinatura. O body lido é retornado para que o receptor possa processá-lo.
+func VerifyRequest(keys map[string][]byte, r *http.Request, tolerance time.Duration) ([]byte, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	return body, Verify(keys, r.Header, body, tolerance, time.Now())
+}
+
+// Lê o body da requisição e o recoloca em r.Body, para que possa ser lido novamente.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
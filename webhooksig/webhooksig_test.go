@@ -0,0 +1,95 @@
+package webhooksig
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	var body = []byte(`{"evento":"cobranca.paga","id_proposta_parcela":1001}`)
+	var now = time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	var keys = map[string][]byte{"k1": []byte("segredo-1")}
+
+	var cases = []struct {
+		name      string
+		keyId     string
+		secret    string
+		signedAt  time.Time
+		body      []byte
+		keys      map[string][]byte
+		tolerance time.Duration
+		edit      func(h http.Header)
+		err       error
+	}{
+		{"assinatura válida", "k1", "segredo-1", now, body, keys, 0, nil, nil},
+		{"body alterado", "k1", "segredo-1", now, []byte(`{"evento":"cobranca.paga","id_proposta_parcela":1002}`), keys, 0, nil, ErrInvalidSignature},
+		{"assinatura com outro segredo", "k1", "segredo-2", now, body, keys, 0, nil, ErrInvalidSignature},
+		{"timestamp alterado", "k1", "segredo-1", now, body, keys, 0, func(h http.Header) {
+			h.Set(HeaderTimestamp, strconv.FormatInt(now.Unix()-1, 10))
+		}, ErrInvalidSignature},
+		{"assinatura sem prefixo", "k1", "segredo-1", now, body, keys, 0, func(h http.Header) {
+			h.Set(HeaderSignature, h.Get(HeaderSignature)[len(signaturePrefix):])
+		}, ErrInvalidSignature},
+		{"dentro da tolerância padrão", "k1", "segredo-1", now.Add(-DefaultTolerance), body, keys, 0, nil, nil},
+		{"expirado na tolerância padrão", "k1", "segredo-1", now.Add(-DefaultTolerance - time.Second), body, keys, 0, nil, ErrExpired},
+		{"timestamp no futuro", "k1", "segredo-1", now.Add(DefaultTolerance + time.Second), body, keys, 0, nil, ErrExpired},
+		{"expirado na tolerância informada", "k1", "segredo-1", now.Add(-31 * time.Second), body, keys, 30 * time.Second, nil, ErrExpired},
+		{"timestamp inválido", "k1", "segredo-1", now, body, keys, 0, func(h http.Header) { h.Set(HeaderTimestamp, "agora") }, ErrInvalidTimestamp},
+		{"sem assinatura", "k1", "segredo-1", now, body, keys, 0, func(h http.Header) { h.Del(HeaderSignature) }, ErrMissingHeaders},
+		{"sem key id", "k1", "segredo-1", now, body, keys, 0, func(h http.Header) { h.Del(HeaderKeyId) }, ErrMissingHeaders},
+		//Rotação: o receptor mantém a chave antiga e a nova até que o emissor passe a assinar com a nova
+		{"chave nova durante a rotação", "k2", "segredo-2", now, body, map[string][]byte{"k1": []byte("segredo-1"), "k2": []byte("segredo-2")}, 0, nil, nil},
+		{"chave nova ainda não cadastrada no receptor", "k2", "segredo-2", now, body, keys, 0, nil, ErrUnknownKey},
+		{"chave antiga já removida do receptor", "k1", "segredo-1", now, body, map[string][]byte{"k2": []byte("segredo-2")}, 0, nil, ErrUnknownKey},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var h = http.Header{}
+			SetHeaders(h, c.keyId, []byte(c.secret), body, c.signedAt)
+			if c.edit != nil {
+				c.edit(h)
+			}
+			if err := Verify(c.keys, h, c.body, c.tolerance, now); !errors.Is(err, c.err) {
+				t.Fatalf("Verify = %v, esperado %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestSignDeterministica(t *testing.T) {
+	var body = []byte(`{}`)
+	if Sign([]byte("segredo"), 1736510400, body) != Sign([]byte("segredo"), 1736510400, body) {
+		t.Fatal("assinaturas diferentes para o mesmo body e timestamp")
+	}
+	if Sign([]byte("segredo"), 1736510400, body) == Sign([]byte("segredo"), 1736510401, body) {
+		t.Fatal("assinatura não depende do timestamp")
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	var body = []byte(`{"evento":"cobranca.registrada"}`)
+	req, err := http.NewRequest(http.MethodPost, "https://parceiro/webhook", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	SetHeaders(req.Header, "k1", []byte("segredo-1"), body, time.Now())
+
+	got, err := VerifyRequest(map[string][]byte{"k1": []byte("segredo-1")}, req, 0)
+	if err != nil {
+		t.Fatalf("VerifyRequest: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("body retornado = %s", got)
+	}
+
+	//O body continua disponível para o receptor após a verificação
+	again, _ := io.ReadAll(req.Body)
+	if !bytes.Equal(again, body) {
+		t.Fatalf("body relido = %s", again)
+	}
+}